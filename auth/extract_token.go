@@ -135,12 +135,15 @@ func extractAndExchangeToken(callbackURL string) error {
 		return err
 	}
 
-	// 格式化输出
+	// 格式化输出（非 us-east-1 区域的凭据以区域名称开头）
 	credentials := fmt.Sprintf("%s:%s:%s",
 		config["client_id"],
 		config["client_secret"],
 		tokens.RefreshToken,
 	)
+	if config["region"] != "" && config["region"] != "us-east-1" {
+		credentials = config["region"] + ":" + credentials
+	}
 
 	fmt.Println("\n" + strings.Repeat("=", 80))
 	fmt.Println("成功获取令牌!")
@@ -152,7 +155,7 @@ func extractAndExchangeToken(callbackURL string) error {
 		fmt.Println("Client Secret: (无)")
 	}
 	fmt.Printf("Refresh Token: %s\n", tokens.RefreshToken)
-	fmt.Println("\n完整凭证 (格式: client_id:client_secret:refresh_token):")
+	fmt.Println("\n完整凭证 (格式: [region:]client_id:client_secret:refresh_token):")
 	fmt.Println(credentials)
 	fmt.Println(strings.Repeat("=", 80))
 
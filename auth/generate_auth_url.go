@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	mathrand "math/rand"
@@ -17,13 +18,16 @@ import (
 	"github.com/google/uuid"
 )
 
-const (
-	// Region AWS 区域
+var (
+	// Region AWS 区域（-region 参数或 AMAZONQ_REGION 环境变量）
 	Region = "us-east-1"
+	// StartURL OIDC 服务起始 URL（-start-url 参数，IAM Identity Center 用户填写自己的起始 URL）
+	StartURL = "https://view.awsapps.com/start"
+)
+
+const (
 	// ClientName OAuth2 客户端名称
 	ClientName = "AWS IDE Extensions for VSCode"
-	// StartURL OIDC 服务起始 URL
-	StartURL = "https://view.awsapps.com/start"
 	// Scopes OAuth2 请求的权限范围
 	Scopes = "codewhisperer:completions,codewhisperer:analysis,codewhisperer:conversations,codewhisperer:transformations,codewhisperer:taskassist"
 )
@@ -198,6 +202,14 @@ func generateAuthURL() error {
 }
 
 func main() {
+	defaultRegion := os.Getenv("AMAZONQ_REGION")
+	if defaultRegion == "" {
+		defaultRegion = Region
+	}
+	flag.StringVar(&Region, "region", defaultRegion, "IAM Identity Center 所在的 AWS 区域，如 eu-central-1")
+	flag.StringVar(&StartURL, "start-url", StartURL, "OIDC 服务起始 URL，如 https://my-org.awsapps.com/start")
+	flag.Parse()
+
 	if err := generateAuthURL(); err != nil {
 		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
 		os.Exit(1)
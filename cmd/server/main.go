@@ -1,37 +1,206 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"amazonq-proxy/internal/api"
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/httpclient"
+	"amazonq-proxy/internal/imaging"
+	"amazonq-proxy/internal/license"
+	"amazonq-proxy/internal/logging"
+	"amazonq-proxy/internal/prompt"
+	"amazonq-proxy/internal/promptcache"
+	"amazonq-proxy/internal/reload"
+	"amazonq-proxy/internal/responsecache"
+	"amazonq-proxy/internal/tracing"
+	"amazonq-proxy/internal/usage"
 
 	"github.com/gin-gonic/gin"
 )
 
+// abortGracePeriod 结束剩余响应后等待连接关闭的时间
+const abortGracePeriod = 5 * time.Second
+
+// traceFlushTimeout 停机时导出剩余 span 的最长等待时间
+const traceFlushTimeout = 5 * time.Second
+
+// healthCheckTimeout -healthcheck 请求的超时时间
+const healthCheckTimeout = 3 * time.Second
+
 // main 主服务入口函数，启动 Amazon Q 代理服务器
 func main() {
-	// 设置生产模式
-	gin.SetMode(gin.ReleaseMode)
+	// 加载配置：默认值 < 配置文件 < 环境变量 < 命令行参数
+	flags, err := config.ParseFlags(os.Args[0], os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	cfg, err := config.Load(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// -healthcheck：容器健康检查
+	if flags.HealthCheck {
+		os.Exit(healthCheck(cfg))
+	}
+
+	// -print-config：输出生效配置（隐藏密钥）后退出
+	if flags.PrintConfig {
+		fmt.Print(cfg.Redacted().YAML())
+		return
+	}
+	config.Set(cfg)
+
+	// 按 logging.level / logging.format 初始化结构化日志
+	logging.Init()
+	// 按 tracing 配置启动 span 导出
+	tracing.Init()
+	if cfg.Logging.Format == "json" {
+		slog.Info("effective configuration", "config", cfg.Redacted())
+	} else {
+		fmt.Printf("Effective configuration:\n%s", cfg.Redacted().YAML())
+	}
+
+	// 设置运行模式
+	if cfg.Logging.Level == "debug" {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// 按加载的配置重建各模块
+	httpclient.Init()
+	imaging.Init()
+	license.Init()
+	promptcache.Init()
+
+	// 加载提示词框架模板
+	if err := prompt.Init(); err != nil {
+		slog.Error("failed to load prompt templates", "error", err)
+		os.Exit(1)
+	}
+
+	// 初始化响应缓存
+	if err := responsecache.Init(); err != nil {
+		slog.Error("failed to initialize response cache", "error", err)
+		os.Exit(1)
+	}
+
+	// 初始化用量账本
+	if err := usage.Init(); err != nil {
+		slog.Error("failed to initialize usage ledger", "error", err)
+		os.Exit(1)
+	}
+
+	// 监听 SIGHUP 和配置文件变化，热重载配置
+	reload.Start(flags)
 
 	// 启动 token 刷新器
-	api.StartTokenRefresher()
+	stopRefresher := api.StartTokenRefresher()
+
+	// 定期写入用量文件
+	stopUsage := usage.Start()
 
 	// 设置路由
 	router := api.SetupRouter()
-
-	// 获取端口配置
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
+	server := &http.Server{
+		Addr:    cfg.Server.Listen,
+		Handler: router,
 	}
 
 	// 启动服务器
-	addr := fmt.Sprintf("0.0.0.0:%s", port)
-	fmt.Printf("Amazon Q Proxy Server starting on %s\n", addr)
+	slog.Info("Amazon Q Proxy Server starting", "listen", cfg.Server.Listen)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
 
-	if err := router.Run(addr); err != nil {
-		fmt.Printf("Failed to start server: %v\n", err)
+	// 等待 SIGTERM/SIGINT 或服务器异常退出
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-serverErr:
+		slog.Error("failed to start server", "error", err)
 		os.Exit(1)
+	case sig := <-stop:
+		slog.Info("received signal, stop accepting connections", "component", "shutdown", "signal", sig.String())
+	}
+
+	shutdown(server, stopRefresher)
+	// 所有响应结束后写入剩余的用量
+	stopUsage()
+}
+
+// shutdown 优雅停机：停止接受新连接，在截止时间内等待进行中的响应结束
+// 超时后向仍未结束的流发送 error 事件并关闭连接
+// 参数 server 为 HTTP 服务器
+// 参数 stopRefresher 为停止 token 刷新器的函数
+func shutdown(server *http.Server, stopRefresher func()) {
+	stopRefresher()
+
+	timeout := config.Current().Server.ShutdownTimeout.Std()
+	slog.Info("waiting for active responses", "component", "shutdown", "timeout", timeout.String(), "active", api.ActiveResponses())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// 停止后导出剩余的 span
+	defer flushTraces()
+
+	if err := server.Shutdown(ctx); err == nil {
+		slog.Info("all responses finished, server stopped", "component", "shutdown")
+		return
+	}
+
+	// 截止时间已到：结束剩余的响应，给它们写出最后一个事件的时间
+	slog.Warn("deadline reached, aborting active responses", "component", "shutdown", "active", api.ActiveResponses())
+	api.AbortResponses()
+
+	ctx, cancel = context.WithTimeout(context.Background(), abortGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("closing remaining connections", "component", "shutdown", "error", err)
+		server.Close()
+	}
+	slog.Info("server stopped", "component", "shutdown")
+}
+
+// flushTraces 导出剩余的 span，最多等待 traceFlushTimeout
+func flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	tracing.Shutdown(ctx)
+}
+
+// healthCheck 请求本机监听地址的 /healthz
+// 参数 cfg 为生效配置
+// 返回进程退出码：服务正常时为 0，否则为 1
+func healthCheck(cfg *config.Config) int {
+	host, port, _ := net.SplitHostPort(cfg.Server.Listen)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	client := &http.Client{Timeout: healthCheckTimeout}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "health check failed: %v\n", err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "health check failed: status %d\n", resp.StatusCode)
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,143 @@
+package accumulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"amazonq-proxy/internal/anthropic"
+)
+
+// MessageAccumulator 将流式事件累积为完整的 Claude 消息
+type MessageAccumulator struct {
+	message     anthropic.Message
+	blocks      []*anthropic.ContentBlock
+	partialJSON map[int]*strings.Builder
+	started     bool
+	stopped     bool
+	err         *anthropic.ErrorEvent
+}
+
+// New 创建消息累积器
+// 返回累积器实例
+func New() *MessageAccumulator {
+	return &MessageAccumulator{
+		partialJSON: make(map[int]*strings.Builder),
+	}
+}
+
+// Add 累积单个流式事件
+// 参数 event 为流式事件
+// 返回事件与当前状态不一致时的错误（出错的事件被忽略，不影响后续累积）
+func (a *MessageAccumulator) Add(event anthropic.StreamEvent) error {
+	switch e := event.(type) {
+	case anthropic.MessageStartEvent:
+		a.message = e.Message
+		a.started = true
+
+	case anthropic.ContentBlockStartEvent:
+		if e.Index < 0 {
+			return fmt.Errorf("content_block_start with negative index %d", e.Index)
+		}
+		for len(a.blocks) <= e.Index {
+			a.blocks = append(a.blocks, nil)
+		}
+		block := e.ContentBlock
+		a.blocks[e.Index] = &block
+		delete(a.partialJSON, e.Index)
+
+	case anthropic.ContentBlockDeltaEvent:
+		block, err := a.block(e.Index)
+		if err != nil {
+			return err
+		}
+		switch e.Delta.Type {
+		case anthropic.DeltaText:
+			block.Text += e.Delta.Text
+		case anthropic.DeltaInputJSON:
+			buf, ok := a.partialJSON[e.Index]
+			if !ok {
+				buf = &strings.Builder{}
+				a.partialJSON[e.Index] = buf
+			}
+			buf.WriteString(e.Delta.PartialJSON)
+		case anthropic.DeltaCitations:
+			if e.Delta.Citation != nil {
+				block.Citations = append(block.Citations, *e.Delta.Citation)
+			}
+		default:
+			return fmt.Errorf("unknown delta type %q at index %d", e.Delta.Type, e.Index)
+		}
+
+	case anthropic.ContentBlockStopEvent:
+		if _, err := a.block(e.Index); err != nil {
+			return err
+		}
+		a.finishBlock(e.Index)
+
+	case anthropic.MessageDeltaEvent:
+		reason := e.StopReason
+		a.message.StopReason = &reason
+		a.message.StopSequence = e.StopSequence
+		a.message.Usage.InputTokens = e.Usage.InputTokens
+		a.message.Usage.CacheCreationInputTokens = e.Usage.CacheCreationInputTokens
+		a.message.Usage.CacheReadInputTokens = e.Usage.CacheReadInputTokens
+		a.message.Usage.OutputTokens = e.Usage.OutputTokens
+
+	case anthropic.MessageStopEvent:
+		a.stopped = true
+
+	case anthropic.ErrorEvent:
+		a.err = &e
+	}
+	return nil
+}
+
+// block 返回指定索引的已开始内容块
+func (a *MessageAccumulator) block(index int) (*anthropic.ContentBlock, error) {
+	if index < 0 || index >= len(a.blocks) || a.blocks[index] == nil {
+		return nil, fmt.Errorf("content block %d has not been started", index)
+	}
+	return a.blocks[index], nil
+}
+
+// finishBlock 结束内容块，解析 tool_use 累积的 JSON 输入
+func (a *MessageAccumulator) finishBlock(index int) {
+	buf, ok := a.partialJSON[index]
+	if !ok {
+		return
+	}
+	delete(a.partialJSON, index)
+
+	var input map[string]interface{}
+	if err := json.Unmarshal([]byte(buf.String()), &input); err == nil {
+		a.blocks[index].Input = input
+	}
+}
+
+// Complete 判断是否已收到 message_start 和 message_stop 且没有收到 error 事件
+func (a *MessageAccumulator) Complete() bool {
+	return a.started && a.stopped && a.err == nil
+}
+
+// Err 返回流中收到的 error 事件（没有时为 nil）
+func (a *MessageAccumulator) Err() *anthropic.ErrorEvent {
+	return a.err
+}
+
+// Message 返回累积的消息，未结束的内容块按已收到的内容结束
+// 返回完整消息
+func (a *MessageAccumulator) Message() anthropic.Message {
+	for index := range a.partialJSON {
+		a.finishBlock(index)
+	}
+
+	msg := a.message
+	msg.Content = make([]*anthropic.ContentBlock, 0, len(a.blocks))
+	for _, block := range a.blocks {
+		if block != nil {
+			msg.Content = append(msg.Content, block)
+		}
+	}
+	return msg
+}
@@ -0,0 +1,168 @@
+package accumulator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"amazonq-proxy/internal/anthropic"
+)
+
+// textStart 返回文本块的 content_block_start 事件
+func textStart(index int) anthropic.ContentBlockStartEvent {
+	return anthropic.ContentBlockStartEvent{Index: index, ContentBlock: anthropic.ContentBlock{Type: "text"}}
+}
+
+// toolStart 返回工具使用块的 content_block_start 事件
+func toolStart(index int) anthropic.ContentBlockStartEvent {
+	return anthropic.ContentBlockStartEvent{Index: index, ContentBlock: anthropic.ContentBlock{Type: "tool_use", ID: "toolu_1", Name: "search"}}
+}
+
+// textDelta 返回文本增量事件
+func textDelta(index int, text string) anthropic.ContentBlockDeltaEvent {
+	return anthropic.ContentBlockDeltaEvent{Index: index, Delta: anthropic.Delta{Type: anthropic.DeltaText, Text: text}}
+}
+
+// jsonDelta 返回 JSON 输入增量事件
+func jsonDelta(index int, partial string) anthropic.ContentBlockDeltaEvent {
+	return anthropic.ContentBlockDeltaEvent{Index: index, Delta: anthropic.Delta{Type: anthropic.DeltaInputJSON, PartialJSON: partial}}
+}
+
+func TestAddRejectsInconsistentEvents(t *testing.T) {
+	cases := []struct {
+		name    string
+		prepare []anthropic.StreamEvent
+		event   anthropic.StreamEvent
+		want    string
+	}{
+		{"negative start index", nil, textStart(-1), "negative index -1"},
+		{"negative delta index", []anthropic.StreamEvent{textStart(0)}, textDelta(-1, "x"), "content block -1 has not been started"},
+		{"delta index out of range", []anthropic.StreamEvent{textStart(0)}, textDelta(5, "x"), "content block 5 has not been started"},
+		{"stop index out of range", []anthropic.StreamEvent{textStart(0)}, anthropic.ContentBlockStopEvent{Index: 1}, "content block 1 has not been started"},
+		{"delta before content_block_start", nil, textDelta(0, "x"), "content block 0 has not been started"},
+		{"delta for a skipped index", []anthropic.StreamEvent{textStart(2)}, textDelta(1, "x"), "content block 1 has not been started"},
+		{"unknown delta type", []anthropic.StreamEvent{textStart(0)},
+			anthropic.ContentBlockDeltaEvent{Index: 0, Delta: anthropic.Delta{Type: "signature_delta"}}, `unknown delta type "signature_delta"`},
+	}
+	for _, tc := range cases {
+		acc := New()
+		for _, event := range tc.prepare {
+			if err := acc.Add(event); err != nil {
+				t.Fatalf("%s: prepare %T: %v", tc.name, event, err)
+			}
+		}
+		err := acc.Add(tc.event)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Add error = %v, want %q", tc.name, err, tc.want)
+		}
+		// 出错的事件被忽略，不影响后续累积
+		if err := acc.Add(textStart(0)); err != nil {
+			t.Errorf("%s: Add after error: %v", tc.name, err)
+		}
+		if err := acc.Add(textDelta(0, "ok")); err != nil {
+			t.Errorf("%s: Add after error: %v", tc.name, err)
+		}
+		if msg := acc.Message(); len(msg.Content) == 0 || msg.Content[0].Text != "ok" {
+			t.Errorf("%s: content after error = %#v", tc.name, msg.Content)
+		}
+	}
+}
+
+func TestToolInput(t *testing.T) {
+	cases := []struct {
+		name     string
+		partials []string
+		stop     bool
+		want     map[string]interface{}
+	}{
+		{"single delta", []string{`{"q":"go"}`}, true, map[string]interface{}{"q": "go"}},
+		{"split across deltas", []string{`{"q":`, `"go",`, `"n":`, `2}`}, true, map[string]interface{}{"q": "go", "n": float64(2)}},
+		{"split inside a string", []string{`{"q":"hel`, `lo"}`}, true, map[string]interface{}{"q": "hello"}},
+		{"invalid JSON", []string{`{"q":`, `oops}`}, true, nil},
+		{"left open", []string{`{"q":`, `"go"}`}, false, map[string]interface{}{"q": "go"}},
+	}
+	for _, tc := range cases {
+		acc := New()
+		acc.Add(toolStart(0))
+		for _, partial := range tc.partials {
+			if err := acc.Add(jsonDelta(0, partial)); err != nil {
+				t.Fatalf("%s: Add: %v", tc.name, err)
+			}
+		}
+		if tc.stop {
+			if err := acc.Add(anthropic.ContentBlockStopEvent{Index: 0}); err != nil {
+				t.Fatalf("%s: Add stop: %v", tc.name, err)
+			}
+		}
+		msg := acc.Message()
+		if len(msg.Content) != 1 {
+			t.Fatalf("%s: %d content blocks, want 1", tc.name, len(msg.Content))
+		}
+		if got := msg.Content[0].Input; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: input = %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMessageWithOpenBlocks(t *testing.T) {
+	acc := New()
+	events := []anthropic.StreamEvent{
+		anthropic.MessageStartEvent{Message: anthropic.Message{ID: "msg_1", Role: "assistant"}},
+		textStart(0),
+		textDelta(0, "hel"),
+		textDelta(0, "lo"),
+		anthropic.ContentBlockStopEvent{Index: 0},
+		toolStart(1),
+		jsonDelta(1, `{"q":"go"}`),
+		textStart(2),
+		textDelta(2, "still open"),
+	}
+	for _, event := range events {
+		if err := acc.Add(event); err != nil {
+			t.Fatalf("Add %T: %v", event, err)
+		}
+	}
+
+	msg := acc.Message()
+	if msg.ID != "msg_1" || len(msg.Content) != 3 {
+		t.Fatalf("message = %#v, want msg_1 with 3 blocks", msg)
+	}
+	if msg.Content[0].Text != "hello" || msg.Content[2].Text != "still open" {
+		t.Errorf("texts = %q, %q", msg.Content[0].Text, msg.Content[2].Text)
+	}
+	if msg.Content[1].Input["q"] != "go" {
+		t.Errorf("open tool_use input = %#v, want the received JSON", msg.Content[1].Input)
+	}
+	if acc.Complete() {
+		t.Error("Complete() = true without message_stop")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	reason := "end_turn"
+	cases := []struct {
+		name   string
+		events []anthropic.StreamEvent
+		want   bool
+	}{
+		{"start and stop", []anthropic.StreamEvent{anthropic.MessageStartEvent{}, anthropic.MessageDeltaEvent{StopReason: reason}, anthropic.MessageStopEvent{}}, true},
+		{"stop without start", []anthropic.StreamEvent{anthropic.MessageStopEvent{}}, false},
+		{"error event", []anthropic.StreamEvent{anthropic.MessageStartEvent{}, anthropic.ErrorEvent{ErrorType: "api_error", Message: "boom"}}, false},
+		{"stop after error event", []anthropic.StreamEvent{anthropic.MessageStartEvent{}, anthropic.ErrorEvent{ErrorType: "api_error"}, anthropic.MessageStopEvent{}}, false},
+	}
+	for _, tc := range cases {
+		acc := New()
+		for _, event := range tc.events {
+			acc.Add(event)
+		}
+		if got := acc.Complete(); got != tc.want {
+			t.Errorf("%s: Complete() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	acc := New()
+	acc.Add(anthropic.ErrorEvent{ErrorType: "overloaded_error", Message: "busy"})
+	if e := acc.Err(); e == nil || e.ErrorType != "overloaded_error" || e.Message != "busy" {
+		t.Errorf("Err() = %#v, want the error event", e)
+	}
+}
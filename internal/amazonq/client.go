@@ -6,22 +6,44 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
-	"amazonq-proxy/internal/config"
-	"amazonq-proxy/internal/utils"
+	"amazonq-proxy/internal/anthropic"
+	"amazonq-proxy/internal/httpclient"
+	"amazonq-proxy/internal/logging"
+	"amazonq-proxy/internal/metrics"
+	"amazonq-proxy/internal/tracing"
 
 	"github.com/google/uuid"
 )
 
+// TransientError 重试用尽后仍然失败的临时性上游错误（连接失败、429/5xx、可重试的流异常等）
+type TransientError struct {
+	Err error // 最后一次尝试的错误
+}
+
+// Error 返回最后一次尝试的错误说明
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap 返回最后一次尝试的错误
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
 // SendChatRequest 发送聊天请求到 Amazon Q API
 // 参数 ctx 为上下文
+// 参数 endpoint 为 Amazon Q API 端点
+// 参数 profile 为上游请求头配置名称
 // 参数 accessToken 为 Amazon Q access token
 // 参数 rawPayload 为 Claude API 转换后的请求体
 // 参数 stream 表示是否流式响应
-// 返回事件通道和可能的错误
-func SendChatRequest(ctx context.Context, accessToken string, rawPayload map[string]interface{}, stream bool) (chan *EventStreamMessage, error) {
+// 返回事件通道和可能的错误（临时性错误在重试用尽后以 *TransientError 返回）
+func SendChatRequest(ctx context.Context, endpoint string, profile string, accessToken string, rawPayload map[string]interface{}, stream bool) (chan *EventStreamMessage, error) {
 	// 确保 conversationId 已设置
 	if convState, ok := rawPayload["conversationState"].(map[string]interface{}); ok {
 		if _, exists := convState["conversationId"]; !exists {
@@ -35,51 +57,181 @@ func SendChatRequest(ctx context.Context, accessToken string, rawPayload map[str
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
+	ctx, span := tracing.Start(ctx, "amazonq.send_chat", tracing.KindInternal,
+		tracing.String("server.address", endpoint),
+		tracing.String("amazonq.header_profile", profile),
+		tracing.Int("amazonq.payload_bytes", len(payloadBytes)),
+	)
+	defer span.End()
+
+	// 同一请求的所有尝试共用请求头和调用 ID
+	headers := mergeHeaders(ProfileHeaders(profile), accessToken)
+	// 调用 ID 使用代理的请求 ID，便于关联两端的日志
+	if id := logging.RequestID(ctx); id != "" {
+		headers["amz-sdk-invocation-id"] = id
+	}
+
+	// 在收到第一个事件之前失败时按重试策略重试
+	policy := httpclient.RetryPolicyFromConfig()
+	startTime := time.Now()
+	for attempt := 1; ; attempt++ {
+		headers["amz-sdk-request"] = policy.AttemptHeader(attempt)
+		eventChan, retryable, err := sendChatAttempt(ctx, endpoint, payloadBytes, headers, attempt)
+		if err == nil {
+			span.SetAttributes(
+				tracing.Int("amazonq.attempts", attempt),
+				tracing.Float("amazonq.time_to_first_event_ms", float64(time.Since(startTime).Microseconds())/1000),
+			)
+			return eventChan, nil
+		}
+		if !retryable {
+			span.SetAttributes(tracing.Int("amazonq.attempts", attempt))
+			span.RecordError(err)
+			return nil, err
+		}
+
+		delay, ok := policy.Next(attempt, time.Since(startTime))
+		if !ok {
+			span.SetAttributes(tracing.Int("amazonq.attempts", attempt))
+			span.RecordError(err)
+			return nil, &TransientError{Err: err}
+		}
+		slog.WarnContext(ctx, "upstream attempt failed, retrying", "component", "amazonq", "attempt", attempt, "max_attempts", policy.Attempts(), "error", err, "delay", delay.Round(time.Millisecond))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			span.RecordError(ctx.Err())
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// sendChatAttempt 发送一次聊天请求并等待第一个事件
+// 参数 ctx 为上下文
+// 参数 endpoint 为 Amazon Q API 端点
+// 参数 payloadBytes 为序列化后的请求体
+// 参数 headers 为请求头
+// 参数 attempt 为尝试序号（从 1 开始）
+// 返回事件通道（第一个事件已放回通道头部）、失败时是否可以重试和可能的错误
+func sendChatAttempt(ctx context.Context, endpoint string, payloadBytes []byte, headers map[string]string, attempt int) (chan *EventStreamMessage, bool, error) {
+	ctx, span := tracing.Start(ctx, "HTTP POST", tracing.KindClient,
+		tracing.String("http.request.method", "POST"),
+		tracing.String("url.full", endpoint),
+		tracing.Int("http.request.resend_count", attempt-1),
+	)
+	eventChan, retryable, err := doChatAttempt(ctx, span, endpoint, payloadBytes, headers)
+	span.RecordError(err)
+	span.End()
+	return eventChan, retryable, err
+}
+
+// doChatAttempt 执行 sendChatAttempt 的请求，并在 span 中记录状态码和上游请求 ID
+// 参数 ctx 为上下文
+// 参数 span 为本次尝试的 span
+// 参数 endpoint 为 Amazon Q API 端点
+// 参数 payloadBytes 为序列化后的请求体
+// 参数 headers 为请求头
+// 返回事件通道、失败时是否可以重试和可能的错误
+func doChatAttempt(ctx context.Context, span *tracing.Span, endpoint string, payloadBytes []byte, headers map[string]string) (chan *EventStreamMessage, bool, error) {
 	// 构建请求
-	req, err := http.NewRequestWithContext(ctx, "POST", config.AmazonQAPIURL, bytes.NewReader(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(payloadBytes))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// 设置请求头
-	headers := mergeHeaders(config.DefaultHeaders, accessToken)
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
 
-	// 创建 HTTP 客户端
-	client := &http.Client{
-		Transport: utils.CreateProxyTransport(),
-		Timeout:   5 * time.Minute,
-	}
-
-	// 发送请求
-	resp, err := client.Do(req)
+	// 发送请求（共享的上游客户端复用连接池和 TLS 会话）
+	resp, err := httpclient.Upstream().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		if ctx.Err() == nil {
+			metrics.UpstreamErrors.Inc("network")
+		}
+		return nil, httpclient.RetryableError(ctx, err), fmt.Errorf("failed to send request: %w", err)
 	}
 
+	// 记录上游请求 ID，便于与 AWS 支持排查
+	upstreamID := resp.Header.Get("x-amzn-requestid")
+	span.SetAttributes(
+		tracing.Int("http.response.status_code", resp.StatusCode),
+		tracing.String("amazonq.upstream_request_id", upstreamID),
+	)
+	slog.DebugContext(ctx, "upstream response", "component", "amazonq", "endpoint", endpoint, "status", resp.StatusCode, "upstream_request_id", upstreamID)
+
 	// 检查响应状态
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("upstream error %d: %s", resp.StatusCode, string(body))
+		metrics.UpstreamErrors.Inc(errorType(resp))
+		return nil, httpclient.RetryableStatus(resp.StatusCode), fmt.Errorf("upstream error %d (x-amzn-requestid %s): %s", resp.StatusCode, upstreamID, string(body))
 	}
 
-	// 创建事件通道
-	eventChan := make(chan *EventStreamMessage, 100)
-
 	// 在后台解析流
+	messages := make(chan *EventStreamMessage, 100)
+	parseErr := make(chan error, 1)
 	go func() {
 		defer resp.Body.Close()
-		err := ParseStream(resp.Body, eventChan)
-		if err != nil {
-			// 记录错误但不中断流
-			fmt.Printf("stream parsing error: %v\n", err)
+		parseErr <- ParseStream(resp.Body, messages)
+	}()
+
+	// 等待第一个事件：流在此之前中断或以可重试的异常开始时重试
+	first, ok := <-messages
+	if !ok {
+		err := <-parseErr
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		if ctx.Err() == nil {
+			metrics.UpstreamErrors.Inc("network")
+		}
+		return nil, httpclient.RetryableError(ctx, err), fmt.Errorf("upstream stream ended before first event: %w", err)
+	}
+	if event, err := DecodeEvent(first); err == nil {
+		if exception, ok := event.(ExceptionEvent); ok && httpclient.RetryableException(exception.ExceptionType) {
+			// 不可重试的异常交给流处理器，在那里计数
+			metrics.UpstreamErrors.Inc(exception.ExceptionType)
+			resp.Body.Close()
+			go func() {
+				for range messages {
+				}
+			}()
+			return nil, ctx.Err() == nil, fmt.Errorf("upstream exception %s (x-amzn-requestid %s): %s", exception.ExceptionType, upstreamID, exception.Message)
+		}
+	}
+
+	span.AddEvent("first_event")
+
+	// 创建事件通道，先放回第一个事件再转发其余事件
+	eventChan := make(chan *EventStreamMessage, 100)
+	go func() {
+		defer close(eventChan)
+		eventChan <- first
+		for message := range messages {
+			eventChan <- message
+		}
+		if err := <-parseErr; err != nil {
+			// 流被截断：将错误交给流处理器，以 error 事件结束响应
+			slog.ErrorContext(ctx, "stream parsing error", "component", "amazonq", "error", err)
+			eventChan <- &EventStreamMessage{Err: err}
 		}
 	}()
 
-	return eventChan, nil
+	return eventChan, false, nil
+}
+
+// errorType 返回上游错误响应的异常类型
+// 优先使用 x-amzn-errortype 响应头（形如 ThrottlingException:http://...），没有时为 http_<状态码>
+// 参数 resp 为上游错误响应
+// 返回异常类型
+func errorType(resp *http.Response) string {
+	if errorType, _, _ := strings.Cut(resp.Header.Get("x-amzn-errortype"), ":"); errorType != "" {
+		return errorType
+	}
+	return fmt.Sprintf("http_%d", resp.StatusCode)
 }
 
 // mergeHeaders 合并并更新请求头
@@ -107,32 +259,58 @@ func mergeHeaders(baseHeaders map[string]string, bearerToken string) map[string]
 	return headers
 }
 
-// ProcessEventStream 处理事件流并生成 Claude SSE 事件
+// ProcessEventStream 处理事件流并生成 Claude 流式事件
 // 参数 eventChan 为事件消息通道
 // 参数 handler 为流处理器
-// 返回 SSE 事件字符串通道
-func ProcessEventStream(eventChan chan *EventStreamMessage, handler *ClaudeStreamHandler) chan string {
-	sseChan := make(chan string, 100)
+// 返回流式事件通道
+func ProcessEventStream(eventChan chan *EventStreamMessage, handler *ClaudeStreamHandler) chan anthropic.StreamEvent {
+	streamChan := make(chan anthropic.StreamEvent, 100)
+
+	ctx := handler.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracing.Start(ctx, "amazonq.stream", tracing.KindInternal, tracing.String("gen_ai.request.model", handler.Model))
 
 	go func() {
-		defer close(sseChan)
+		defer close(streamChan)
+		defer span.End()
 
+		events := 0
 		for message := range eventChan {
-			eventInfo := ExtractEventInfo(message)
-			if eventInfo != nil && eventInfo.EventType != "" {
-				sseEvents := handler.HandleEvent(eventInfo.EventType, eventInfo.Payload)
-				for _, event := range sseEvents {
-					sseChan <- event
+			if message.Err != nil {
+				for _, claudeEvent := range handler.HandleStreamError(message.Err) {
+					streamChan <- claudeEvent
 				}
+				continue
+			}
+			events++
+			event, err := DecodeEvent(message)
+			if err != nil {
+				slog.WarnContext(handler.Context, "failed to decode event", "component", "amazonq", "error", err)
+				continue
+			}
+			for _, claudeEvent := range handler.HandleEvent(event) {
+				streamChan <- claudeEvent
 			}
 		}
 
 		// 发送最终事件
 		finalEvents := handler.Finish()
 		for _, event := range finalEvents {
-			sseChan <- event
+			streamChan <- event
+		}
+
+		span.SetAttributes(
+			tracing.Int("amazonq.event_count", events),
+			tracing.Int("amazonq.tool_calls", len(handler.ToolNames)),
+			tracing.Bool("amazonq.license_blocked", handler.Blocked),
+		)
+		if handler.Exception != nil {
+			span.SetAttributes(tracing.String("amazonq.exception_type", handler.Exception.ExceptionType))
+			span.SetStatus(tracing.StatusError, handler.Exception.Message)
 		}
 	}()
 
-	return sseChan
+	return streamChan
 }
@@ -0,0 +1,55 @@
+package amazonq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"amazonq-proxy/internal/config"
+)
+
+func TestSendChatRequestNumbersAttempts(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []string
+	var invocations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts = append(attempts, r.Header.Get("amz-sdk-request"))
+		invocations = append(invocations, r.Header.Get("amz-sdk-invocation-id"))
+		mu.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	previous := config.Current()
+	cfg := config.Default()
+	cfg.Upstream.Retry.MaxAttempts = 3
+	cfg.Upstream.Retry.BaseDelay = config.Duration(time.Millisecond)
+	cfg.Upstream.Retry.MaxDelay = config.Duration(time.Millisecond)
+	config.Set(cfg)
+	defer config.Set(previous)
+
+	_, err := SendChatRequest(context.Background(), server.URL, "", "token", map[string]interface{}{}, true)
+	var transientErr *TransientError
+	if !errors.As(err, &transientErr) {
+		t.Fatalf("SendChatRequest error = %v, want *TransientError", err)
+	}
+
+	want := []string{"attempt=1; max=3", "attempt=2; max=3", "attempt=3; max=3"}
+	if len(attempts) != len(want) {
+		t.Fatalf("amz-sdk-request headers = %q, want %q", attempts, want)
+	}
+	for i := range want {
+		if attempts[i] != want[i] {
+			t.Errorf("attempt %d: amz-sdk-request = %q, want %q", i+1, attempts[i], want[i])
+		}
+		// 同一请求的所有尝试共用调用 ID
+		if invocations[i] == "" || invocations[i] != invocations[0] {
+			t.Errorf("attempt %d: amz-sdk-invocation-id = %q, want %q", i+1, invocations[i], invocations[0])
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package amazonq
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// GenerateAssistantResponse 事件类型
+const (
+	EventInitialResponse       = "initial-response"
+	EventMessageMetadata       = "messageMetadataEvent"
+	EventAssistantResponse     = "assistantResponseEvent"
+	EventAssistantResponseEnd  = "assistantResponseEnd"
+	EventToolUse               = "toolUseEvent"
+	EventCodeReference         = "codeReferenceEvent"
+	EventSupplementaryWebLinks = "supplementaryWebLinksEvent"
+	EventFollowupPrompt        = "followupPromptEvent"
+	EventCitation              = "citationEvent"
+	EventCode                  = "codeEvent"
+	EventIntents               = "intentsEvent"
+	EventInteractionComponents = "interactionComponentsEvent"
+	EventInvalidState          = "invalidStateEvent"
+	EventMetering              = "meteringEvent"
+	EventContextUsage          = "contextUsageEvent"
+	EventDryRunSucceed         = "dryRunSucceedEvent"
+	EventToolResult            = "toolResultEvent"
+	EventReasoningContent      = "reasoningContentEvent"
+)
+
+// Event 已解码的 Amazon Q 事件
+type Event interface {
+	// EventType 返回事件类型（:event-type 头）
+	EventType() string
+}
+
+// InitialResponseEvent 响应开始事件
+type InitialResponseEvent struct {
+	ConversationID string `json:"conversationId"` // 会话 ID
+}
+
+// MessageMetadataEvent 消息元数据事件
+type MessageMetadataEvent struct {
+	ConversationID string `json:"conversationId"` // 会话 ID
+	UtteranceID    string `json:"utteranceId"`    // 本轮回复 ID
+}
+
+// AssistantResponseEvent 助手文本增量事件
+type AssistantResponseEvent struct {
+	Content string `json:"content"` // 文本增量
+	ModelID string `json:"modelId"` // 实际使用的模型
+}
+
+// AssistantResponseEndEvent 助手响应结束事件
+type AssistantResponseEndEvent struct{}
+
+// ToolUseEvent 工具调用事件，同一 toolUseId 的多个事件拼接为完整输入
+type ToolUseEvent struct {
+	ToolUseID string          `json:"toolUseId"` // 工具使用 ID
+	Name      string          `json:"name"`      // 工具名称
+	Input     json.RawMessage `json:"input"`     // 输入片段（JSON 字符串片段或完整对象）
+	Stop      bool            `json:"stop"`      // 是否为最后一个片段
+}
+
+// InputFragment 返回输入片段文本：字符串原样返回，对象序列化为 JSON
+func (e ToolUseEvent) InputFragment() string {
+	if len(e.Input) == 0 || string(e.Input) == "null" {
+		return ""
+	}
+	var fragment string
+	if err := json.Unmarshal(e.Input, &fragment); err == nil {
+		return fragment
+	}
+	return string(e.Input)
+}
+
+// Span 文本区间（字符偏移，左闭右开）
+type Span struct {
+	Start int `json:"start"` // 起始偏移
+	End   int `json:"end"`   // 结束偏移
+}
+
+// CodeReference 生成代码引用的开源代码信息
+type CodeReference struct {
+	LicenseName                   string         `json:"licenseName"`                             // 许可证名称
+	Repository                    string         `json:"repository"`                              // 代码仓库
+	URL                           string         `json:"url"`                                     // 代码地址
+	Information                   string         `json:"information"`                             // 附加说明
+	RecommendationContentSpan     *Span          `json:"recommendationContentSpan"`               // 引用内容在回复中的区间
+	MostRelevantMissedAlternative *CodeReference `json:"mostRelevantMissedAlternative,omitempty"` // 最相关的候选引用
+}
+
+// CodeReferenceEvent 代码引用事件
+type CodeReferenceEvent struct {
+	References []CodeReference `json:"references"` // 引用列表
+}
+
+// SupplementaryWebLink 补充网页链接
+type SupplementaryWebLink struct {
+	URL     string   `json:"url"`     // 链接地址
+	Title   string   `json:"title"`   // 标题
+	Snippet string   `json:"snippet"` // 摘要
+	Score   *float64 `json:"score"`   // 相关度
+}
+
+// SupplementaryWebLinksEvent 补充网页链接事件
+type SupplementaryWebLinksEvent struct {
+	SupplementaryWebLinks []SupplementaryWebLink `json:"supplementaryWebLinks"` // 链接列表
+}
+
+// FollowupPrompt 推荐的后续提问
+type FollowupPrompt struct {
+	Content    string `json:"content"`    // 提问内容
+	UserIntent string `json:"userIntent"` // 意图
+}
+
+// FollowupPromptEvent 后续提问事件
+type FollowupPromptEvent struct {
+	FollowupPrompt FollowupPrompt `json:"followupPrompt"` // 推荐的后续提问
+}
+
+// CitationTarget 引用在回复中的位置
+type CitationTarget struct {
+	Location *int  `json:"location"` // 插入位置
+	Range    *Span `json:"range"`    // 引用区间
+}
+
+// CitationEvent 引用事件
+type CitationEvent struct {
+	Target       CitationTarget `json:"target"`       // 引用位置
+	CitationText string         `json:"citationText"` // 引用文本
+	CitationLink string         `json:"citationLink"` // 引用链接
+}
+
+// CodeEvent 代码增量事件
+type CodeEvent struct {
+	Content string `json:"content"` // 代码内容
+}
+
+// IntentsEvent 意图事件
+type IntentsEvent struct {
+	Intents map[string]interface{} `json:"intents"` // 意图映射
+}
+
+// InteractionComponentsEvent 交互组件事件
+type InteractionComponentsEvent struct {
+	InteractionComponentEntries []map[string]interface{} `json:"interactionComponentEntries"` // 组件列表
+}
+
+// InvalidStateEvent 会话状态无效事件
+type InvalidStateEvent struct {
+	Reason  string `json:"reason"`  // 原因代码
+	Message string `json:"message"` // 说明
+}
+
+// MeteringEvent 计量事件
+type MeteringEvent struct {
+	Unit       string  `json:"unit"`       // 计量单位
+	UnitPlural string  `json:"unitPlural"` // 计量单位复数形式
+	Usage      float64 `json:"usage"`      // 用量
+}
+
+// ContextUsageEvent 上下文用量事件
+type ContextUsageEvent struct {
+	ContextUsagePercentage float64 `json:"contextUsagePercentage"` // 上下文窗口占用百分比
+}
+
+// DryRunSucceedEvent 试运行成功事件
+type DryRunSucceedEvent struct{}
+
+// ToolResultEvent 服务端工具结果事件
+type ToolResultEvent struct {
+	ToolResult map[string]interface{} `json:"toolResult"` // 工具结果
+}
+
+// ReasoningContentEvent 推理内容事件
+type ReasoningContentEvent struct {
+	Text            string `json:"text"`            // 推理文本
+	Signature       string `json:"signature"`       // 签名
+	RedactedContent string `json:"redactedContent"` // 脱敏内容
+}
+
+// ExceptionEvent 上游在流中返回的异常（:message-type 为 exception 或 error）
+type ExceptionEvent struct {
+	ExceptionType string `json:"-"`       // 异常类型（:exception-type 或 :error-code 头）
+	Message       string `json:"message"` // 异常说明
+}
+
+// UnknownEvent 未注册解码器的事件，保留原始数据
+type UnknownEvent struct {
+	Type    string          // 事件类型
+	Payload json.RawMessage // 原始负载
+}
+
+func (InitialResponseEvent) EventType() string       { return EventInitialResponse }
+func (MessageMetadataEvent) EventType() string       { return EventMessageMetadata }
+func (AssistantResponseEvent) EventType() string     { return EventAssistantResponse }
+func (AssistantResponseEndEvent) EventType() string  { return EventAssistantResponseEnd }
+func (ToolUseEvent) EventType() string               { return EventToolUse }
+func (CodeReferenceEvent) EventType() string         { return EventCodeReference }
+func (SupplementaryWebLinksEvent) EventType() string { return EventSupplementaryWebLinks }
+func (FollowupPromptEvent) EventType() string        { return EventFollowupPrompt }
+func (CitationEvent) EventType() string              { return EventCitation }
+func (CodeEvent) EventType() string                  { return EventCode }
+func (IntentsEvent) EventType() string               { return EventIntents }
+func (InteractionComponentsEvent) EventType() string { return EventInteractionComponents }
+func (InvalidStateEvent) EventType() string          { return EventInvalidState }
+func (MeteringEvent) EventType() string              { return EventMetering }
+func (ContextUsageEvent) EventType() string          { return EventContextUsage }
+func (DryRunSucceedEvent) EventType() string         { return EventDryRunSucceed }
+func (ToolResultEvent) EventType() string            { return EventToolResult }
+func (ReasoningContentEvent) EventType() string      { return EventReasoningContent }
+func (e ExceptionEvent) EventType() string           { return "exception" }
+func (e UnknownEvent) EventType() string             { return e.Type }
+
+// EventDecoder 将事件负载解码为类型化事件
+type EventDecoder func(payload []byte) (Event, error)
+
+var (
+	// eventDecoders 事件类型 -> 解码器
+	eventDecoders = make(map[string]EventDecoder)
+	// eventDecodersMutex 解码器注册表互斥锁
+	eventDecodersMutex sync.RWMutex
+)
+
+func init() {
+	registerJSON[InitialResponseEvent](EventInitialResponse)
+	registerJSON[MessageMetadataEvent](EventMessageMetadata)
+	registerJSON[AssistantResponseEvent](EventAssistantResponse)
+	registerJSON[AssistantResponseEndEvent](EventAssistantResponseEnd)
+	registerJSON[ToolUseEvent](EventToolUse)
+	registerJSON[CodeReferenceEvent](EventCodeReference)
+	registerJSON[SupplementaryWebLinksEvent](EventSupplementaryWebLinks)
+	registerJSON[FollowupPromptEvent](EventFollowupPrompt)
+	registerJSON[CitationEvent](EventCitation)
+	registerJSON[CodeEvent](EventCode)
+	registerJSON[IntentsEvent](EventIntents)
+	registerJSON[InteractionComponentsEvent](EventInteractionComponents)
+	registerJSON[InvalidStateEvent](EventInvalidState)
+	registerJSON[MeteringEvent](EventMetering)
+	registerJSON[ContextUsageEvent](EventContextUsage)
+	registerJSON[DryRunSucceedEvent](EventDryRunSucceed)
+	registerJSON[ToolResultEvent](EventToolResult)
+	registerJSON[ReasoningContentEvent](EventReasoningContent)
+}
+
+// registerJSON 注册按 JSON 直接解码的事件类型
+func registerJSON[T Event](eventType string) {
+	RegisterEventDecoder(eventType, func(payload []byte) (Event, error) {
+		var event T
+		if len(payload) == 0 {
+			return event, nil
+		}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+		return event, nil
+	})
+}
+
+// RegisterEventDecoder 注册（或替换）事件类型的解码器
+// 参数 eventType 为事件类型
+// 参数 decoder 为解码器
+func RegisterEventDecoder(eventType string, decoder EventDecoder) {
+	eventDecodersMutex.Lock()
+	defer eventDecodersMutex.Unlock()
+	eventDecoders[eventType] = decoder
+}
+
+// DecodeEvent 将事件流消息解码为类型化事件
+// 异常消息解码为 ExceptionEvent，未注册的事件类型解码为 UnknownEvent
+// 参数 message 为事件流消息
+// 返回事件和可能的解码错误
+func DecodeEvent(message *EventStreamMessage) (Event, error) {
+	info := ExtractEventInfo(message)
+
+	if info.MessageType == "exception" || info.MessageType == "error" {
+		event := ExceptionEvent{ExceptionType: message.Headers[":exception-type"]}
+		if event.ExceptionType == "" {
+			event.ExceptionType = message.Headers[":error-code"]
+		}
+		if err := json.Unmarshal(info.Payload, &event); err != nil {
+			event.Message = string(info.Payload)
+		}
+		return event, nil
+	}
+
+	eventDecodersMutex.RLock()
+	decoder, ok := eventDecoders[info.EventType]
+	eventDecodersMutex.RUnlock()
+	if !ok {
+		return UnknownEvent{Type: info.EventType, Payload: info.Payload}, nil
+	}
+
+	event, err := decoder(info.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", info.EventType, err)
+	}
+	return event, nil
+}
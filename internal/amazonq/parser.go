@@ -4,9 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
+
+	"amazonq-proxy/internal/anthropic"
 )
 
 // GenerateMessageID 生成符合 Anthropic 标准的消息 ID
@@ -20,8 +21,9 @@ func GenerateMessageID() string {
 // EventStreamMessage 表示事件流中的单个消息
 type EventStreamMessage struct {
 	Headers     map[string]string
-	Payload     interface{}
+	Payload     []byte // 原始负载（通常为 JSON），由 DecodeEvent 解码
 	TotalLength uint32
+	Err         error // 流的读取或解析错误，仅出现在事件通道的最后一个消息中
 }
 
 // EventInfo 存储解析后的事件信息
@@ -29,7 +31,7 @@ type EventInfo struct {
 	EventType   string
 	ContentType string
 	MessageType string
-	Payload     interface{}
+	Payload     []byte
 }
 
 // SSEEvent 表示 Server-Sent Events 事件结构
@@ -103,20 +105,17 @@ func ParseMessage(data []byte) (*EventStreamMessage, error) {
 		return nil, fmt.Errorf("incomplete message: expected %d bytes, got %d", totalLength, len(data))
 	}
 
+	if totalLength < 16 || 12+headersLength > totalLength-4 {
+		return nil, fmt.Errorf("invalid message: headers length %d exceeds total length %d", headersLength, totalLength)
+	}
+
 	headersData := data[12 : 12+headersLength]
 	headers := ParseHeaders(headersData)
 
 	payloadStart := 12 + headersLength
 	payloadEnd := totalLength - 4
-	payloadData := data[payloadStart:payloadEnd]
-
-	var payload interface{}
-	if len(payloadData) > 0 {
-		err := json.Unmarshal(payloadData, &payload)
-		if err != nil {
-			payload = string(payloadData)
-		}
-	}
+	payload := make([]byte, payloadEnd-payloadStart)
+	copy(payload, data[payloadStart:payloadEnd])
 
 	return &EventStreamMessage{
 		Headers:     headers,
@@ -203,188 +202,130 @@ func ExtractEventInfo(message *EventStreamMessage) *EventInfo {
 	}
 }
 
-// FormatSSE 将事件格式化为 Server-Sent Events 格式
-// 参数 eventType 为事件类型
-// 参数 data 为事件数据
-// 返回 SSE 格式字符串，data 行格式为 data: {"type":...} 以确保兼容性
-func FormatSSE(eventType string, data interface{}) string {
-	dataMap, ok := data.(map[string]interface{})
-	if !ok {
-		jsonData, _ := json.Marshal(data)
-		return fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, string(jsonData))
-	}
-
-	// 确保 type 字段在 JSON 最前面
-	typeValue, hasType := dataMap["type"]
-	if !hasType {
-		jsonData, _ := json.Marshal(data)
-		return fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, string(jsonData))
-	}
-
-	// 手动构建 JSON，确保 type 在最前
-	typeJSON, _ := json.Marshal(typeValue)
-	delete(dataMap, "type")
-	restJSON, _ := json.Marshal(dataMap)
-	dataMap["type"] = typeValue // 恢复原 map
-
-	// 合并: {"type":xxx, ...rest}
-	var jsonData string
-	if len(restJSON) > 2 { // 不是空对象 "{}"
-		jsonData = fmt.Sprintf(`{"type":%s,%s`, string(typeJSON), string(restJSON)[1:])
-	} else {
-		jsonData = fmt.Sprintf(`{"type":%s}`, string(typeJSON))
-	}
-
-	return fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, jsonData)
+// CacheUsage 提示词缓存的 token 使用量
+type CacheUsage struct {
+	CreationInputTokens    int // 写入缓存的 token 数（cache_creation_input_tokens）
+	ReadInputTokens        int // 命中缓存的 token 数（cache_read_input_tokens）
+	Ephemeral5mInputTokens int // 以 5 分钟 TTL 写入的 token 数
+	Ephemeral1hInputTokens int // 以 1 小时 TTL 写入的 token 数
 }
 
-// BuildMessageStart 构建 message_start SSE 事件
+// BuildMessageStart 构建 message_start 事件
 // 参数 model 为模型名称
-// 参数 inputTokens 为输入 token 数量
-// 返回 SSE 格式的事件字符串和生成的消息 ID
-func BuildMessageStart(model string, inputTokens int) (string, string) {
+// 参数 inputTokens 为输入 token 数量（不含缓存部分）
+// 参数 cache 为提示词缓存使用量
+// 返回事件和生成的消息 ID
+func BuildMessageStart(model string, inputTokens int, cache CacheUsage) (anthropic.MessageStartEvent, string) {
 	messageID := GenerateMessageID()
-	data := map[string]interface{}{
-		"type": "message_start",
-		"message": map[string]interface{}{
-			"model":         model,
-			"id":            messageID,
-			"type":          "message",
-			"role":          "assistant",
-			"content":       []interface{}{},
-			"stop_reason":   nil,
-			"stop_sequence": nil,
-			"usage": map[string]interface{}{
-				"input_tokens":                inputTokens,
-				"cache_creation_input_tokens": 0,
-				"cache_read_input_tokens":     0,
-				"cache_creation": map[string]int{
-					"ephemeral_5m_input_tokens": 0,
-					"ephemeral_1h_input_tokens": 0,
-				},
-				"output_tokens": 1,
-				"service_tier":  "standard",
-			},
+	message := anthropic.NewMessage(messageID, model, anthropic.Usage{
+		InputTokens:              inputTokens,
+		CacheCreationInputTokens: cache.CreationInputTokens,
+		CacheReadInputTokens:     cache.ReadInputTokens,
+		CacheCreation: &anthropic.CacheCreation{
+			Ephemeral5mInputTokens: cache.Ephemeral5mInputTokens,
+			Ephemeral1hInputTokens: cache.Ephemeral1hInputTokens,
 		},
-	}
-	return FormatSSE("message_start", data), messageID
+		OutputTokens: 1,
+		ServiceTier:  "standard",
+	})
+	return anthropic.MessageStartEvent{Message: message}, messageID
 }
 
-// BuildContentBlockStart 构建 content_block_start SSE 事件
+// BuildContentBlockStart 构建 content_block_start 事件
 // 参数 index 为内容块索引
 // 参数 blockType 为内容块类型（如 "text", "thinking" 或 "tool_use"）
-// 返回 SSE 格式的事件字符串
-func BuildContentBlockStart(index int, blockType string) string {
-	contentBlock := map[string]interface{}{"type": blockType}
-	if blockType == "text" || blockType == "thinking" {
-		contentBlock["text"] = ""
-	}
-
-	data := map[string]interface{}{
-		"type":          "content_block_start",
-		"index":         index,
-		"content_block": contentBlock,
+// 返回事件
+func BuildContentBlockStart(index int, blockType string) anthropic.ContentBlockStartEvent {
+	return anthropic.ContentBlockStartEvent{
+		Index:        index,
+		ContentBlock: anthropic.ContentBlock{Type: blockType},
 	}
-	return FormatSSE("content_block_start", data)
 }
 
-// BuildContentBlockDelta 构建 content_block_delta SSE 事件（文本增量）
+// BuildContentBlockDelta 构建 content_block_delta 事件（文本增量）
 // 参数 index 为内容块索引
 // 参数 text 为增量文本内容
-// 返回 SSE 格式的事件字符串
-func BuildContentBlockDelta(index int, text string) string {
-	data := map[string]interface{}{
-		"type":  "content_block_delta",
-		"index": index,
-		"delta": map[string]string{
-			"type": "text_delta",
-			"text": text,
-		},
+// 返回事件
+func BuildContentBlockDelta(index int, text string) anthropic.ContentBlockDeltaEvent {
+	return anthropic.ContentBlockDeltaEvent{
+		Index: index,
+		Delta: anthropic.Delta{Type: anthropic.DeltaText, Text: text},
 	}
-	return FormatSSE("content_block_delta", data)
 }
 
-// BuildContentBlockStop 构建 content_block_stop SSE 事件
-// 参数 index 为内容块索引
-// 返回 SSE 格式的事件字符串
-func BuildContentBlockStop(index int) string {
-	data := map[string]interface{}{
-		"type":  "content_block_stop",
-		"index": index,
+// BuildCitationDelta 构建 content_block_delta 事件（citations_delta）
+// 参数 index 为文本块索引
+// 参数 citation 为引用
+// 返回事件
+func BuildCitationDelta(index int, citation anthropic.Citation) anthropic.ContentBlockDeltaEvent {
+	return anthropic.ContentBlockDeltaEvent{
+		Index: index,
+		Delta: anthropic.Delta{Type: anthropic.DeltaCitations, Citation: &citation},
 	}
-	return FormatSSE("content_block_stop", data)
 }
 
-// BuildPing 构建 ping SSE 事件，用于保持连接活跃
-// 返回 SSE 格式的 ping 事件字符串
-func BuildPing() string {
-	data := map[string]string{"type": "ping"}
-	return FormatSSE("ping", data)
+// BuildContentBlockStop 构建 content_block_stop 事件
+// 参数 index 为内容块索引
+// 返回事件
+func BuildContentBlockStop(index int) anthropic.ContentBlockStopEvent {
+	return anthropic.ContentBlockStopEvent{Index: index}
 }
 
-// BuildMessageStop 构建 message_delta 和 message_stop SSE 事件
-// 参数 inputTokens 为输入 token 数量
+// BuildPing 构建 ping 事件，用于保持连接活跃
+// 返回事件
+func BuildPing() anthropic.PingEvent {
+	return anthropic.PingEvent{}
+}
+
+// BuildMessageStop 构建 message_delta 和 message_stop 事件
+// 参数 inputTokens 为输入 token 数量（不含缓存部分）
 // 参数 outputTokens 为输出 token 数量
+// 参数 cache 为提示词缓存使用量
 // 参数 stopReason 为停止原因（可为 nil）
-// 返回组合的 SSE 格式事件字符串
-func BuildMessageStop(inputTokens int, outputTokens int, stopReason *string) string {
+// 返回按顺序排列的两个事件
+func BuildMessageStop(inputTokens int, outputTokens int, cache CacheUsage, stopReason *string) []anthropic.StreamEvent {
 	reason := "end_turn"
 	if stopReason != nil {
 		reason = *stopReason
 	}
 
-	deltaData := map[string]interface{}{
-		"type": "message_delta",
-		"delta": map[string]interface{}{
-			"stop_reason":   reason,
-			"stop_sequence": nil,
-		},
-		"usage": map[string]interface{}{
-			"input_tokens":                inputTokens,
-			"cache_creation_input_tokens": 0,
-			"cache_read_input_tokens":     0,
-			"output_tokens":               outputTokens,
+	return []anthropic.StreamEvent{
+		anthropic.MessageDeltaEvent{
+			StopReason: reason,
+			Usage: anthropic.Usage{
+				InputTokens:              inputTokens,
+				CacheCreationInputTokens: cache.CreationInputTokens,
+				CacheReadInputTokens:     cache.ReadInputTokens,
+				OutputTokens:             outputTokens,
+			},
 		},
+		anthropic.MessageStopEvent{},
 	}
-	deltaEvent := FormatSSE("message_delta", deltaData)
-
-	stopData := map[string]string{"type": "message_stop"}
-	stopEvent := FormatSSE("message_stop", stopData)
-
-	return deltaEvent + stopEvent
 }
 
-// BuildToolUseStart 构建 tool_use 类型的 content_block_start SSE 事件
+// BuildToolUseStart 构建 tool_use 类型的 content_block_start 事件
 // 参数 index 为内容块索引
 // 参数 toolUseID 为工具使用 ID
 // 参数 toolName 为工具名称
-// 返回 SSE 格式的事件字符串
-func BuildToolUseStart(index int, toolUseID string, toolName string) string {
-	data := map[string]interface{}{
-		"type":  "content_block_start",
-		"index": index,
-		"content_block": map[string]interface{}{
-			"type":  "tool_use",
-			"id":    toolUseID,
-			"name":  toolName,
-			"input": map[string]interface{}{},
+// 返回事件
+func BuildToolUseStart(index int, toolUseID string, toolName string) anthropic.ContentBlockStartEvent {
+	return anthropic.ContentBlockStartEvent{
+		Index: index,
+		ContentBlock: anthropic.ContentBlock{
+			Type: "tool_use",
+			ID:   toolUseID,
+			Name: toolName,
 		},
 	}
-	return FormatSSE("content_block_start", data)
 }
 
-// BuildToolUseInputDelta 构建 tool_use 的 input_json_delta SSE 事件
+// BuildToolUseInputDelta 构建 tool_use 的 input_json_delta 事件
 // 参数 index 为内容块索引
 // 参数 inputJSONDelta 为 JSON 增量内容
-// 返回 SSE 格式的事件字符串
-func BuildToolUseInputDelta(index int, inputJSONDelta string) string {
-	data := map[string]interface{}{
-		"type":  "content_block_delta",
-		"index": index,
-		"delta": map[string]string{
-			"type":         "input_json_delta",
-			"partial_json": inputJSONDelta,
-		},
+// 返回事件
+func BuildToolUseInputDelta(index int, inputJSONDelta string) anthropic.ContentBlockDeltaEvent {
+	return anthropic.ContentBlockDeltaEvent{
+		Index: index,
+		Delta: anthropic.Delta{Type: anthropic.DeltaInputJSON, PartialJSON: inputJSONDelta},
 	}
-	return FormatSSE("content_block_delta", data)
 }
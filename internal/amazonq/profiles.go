@@ -0,0 +1,81 @@
+package amazonq
+
+import (
+	"strconv"
+
+	"amazonq-proxy/internal/config"
+)
+
+// SelectProfile 按凭据、模型的优先级选择上游请求头配置
+// 参数 model 为模型名称（刷新 token 时为空）
+// 参数 credentialHash 为 Amazon Q 凭据的 SHA256 哈希（可为空）
+// 返回请求头配置名称
+func SelectProfile(model string, credentialHash string) string {
+	upstream := config.Current().Upstream
+	if name, ok := upstream.ProfileKeys[credentialHash]; ok && credentialHash != "" {
+		return name
+	}
+	if name, ok := upstream.ProfileModels[model]; ok {
+		return name
+	}
+	return upstream.Profile
+}
+
+// ProfileHeaders 返回请求头配置对应的 Amazon Q 请求头
+// 以 upstream.headers 为基础，按配置覆盖 user-agent、x-amz-target、optout 等请求头
+// 参数 name 为请求头配置名称，不存在时直接使用 upstream.headers
+// 返回请求头映射（调用方可以修改）
+func ProfileHeaders(name string) map[string]string {
+	upstream := config.Current().Upstream
+	headers := copyHeaders(upstream.Headers)
+
+	profile, ok := upstream.Profiles[name]
+	if !ok {
+		return headers
+	}
+	setHeader(headers, "user-agent", profile.UserAgent)
+	setHeader(headers, "x-amz-user-agent", profile.AmzUserAgent)
+	setHeader(headers, "x-amz-target", profile.Target)
+	if profile.OptOut != nil {
+		headers["x-amzn-codewhisperer-optout"] = strconv.FormatBool(*profile.OptOut)
+	}
+	for k, v := range profile.Headers {
+		if v == "" {
+			delete(headers, k)
+		} else {
+			headers[k] = v
+		}
+	}
+	return headers
+}
+
+// OIDCProfileHeaders 返回请求头配置对应的 OIDC 请求头
+// 以 upstream.oidc_headers 为基础，按配置覆盖 user-agent 和 x-amz-user-agent
+// 参数 name 为请求头配置名称，不存在时直接使用 upstream.oidc_headers
+// 返回请求头映射（调用方可以修改）
+func OIDCProfileHeaders(name string) map[string]string {
+	upstream := config.Current().Upstream
+	headers := copyHeaders(upstream.OIDCHeaders)
+
+	if profile, ok := upstream.Profiles[name]; ok {
+		setHeader(headers, "user-agent", profile.OIDCUserAgent)
+		setHeader(headers, "x-amz-user-agent", profile.OIDCAmzUserAgent)
+	}
+	return headers
+}
+
+// copyHeaders 复制请求头映射
+func copyHeaders(src map[string]string) map[string]string {
+	headers := make(map[string]string, len(src))
+	for k, v := range src {
+		headers[k] = v
+	}
+	return headers
+}
+
+// setHeader 在值非空时设置请求头
+func setHeader(headers map[string]string, key, value string) {
+	if value != "" {
+		headers[key] = value
+	}
+}
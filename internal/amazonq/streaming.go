@@ -1,8 +1,14 @@
 package amazonq
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"log/slog"
 	"strings"
+
+	"amazonq-proxy/internal/anthropic"
+	"amazonq-proxy/internal/httpclient"
+	"amazonq-proxy/internal/metrics"
 )
 
 const (
@@ -12,29 +18,64 @@ const (
 	ThinkingEndTag = "</thinking>"
 )
 
-// ClaudeStreamHandler Claude SSE 流处理器，将 Amazon Q 事件转换为 Claude API 格式
+const (
+	// StreamErrorException 上游流读取或解析失败时使用的异常类型
+	StreamErrorException = "StreamError"
+	// StreamIdleTimeoutException 上游流空闲超时时使用的异常类型
+	StreamIdleTimeoutException = "StreamIdleTimeout"
+)
+
+// ClaudeStreamHandler Claude 流处理器，将 Amazon Q 事件转换为 Claude API 格式
 type ClaudeStreamHandler struct {
-	Model                  string
-	InputTokens            int
-	ResponseBuffer         []string
-	ContentBlockIndex      int
-	ContentBlockStarted    bool
-	ContentBlockStartSent  bool
-	ContentBlockStopSent   bool
-	MessageStartSent       bool
-	ConversationID         string
-	MessageID              string
-	CurrentToolUse         map[string]interface{}
-	ToolInputBuffer        []string
-	ToolUseID              string
-	ToolName               string
-	ProcessedToolUseIDs    map[string]bool
-	AllToolInputs          []string
+	Model                 string
+	InputTokens           int
+	CacheUsage            CacheUsage
+	ResponseBuffer        []string
+	ContentBlockIndex     int
+	ContentBlockStarted   bool
+	ContentBlockStartSent bool
+	ContentBlockStopSent  bool
+	MessageStartSent      bool
+	ConversationID        string
+	MessageID             string
+	CurrentToolUse        map[string]interface{}
+	ToolInputBuffer       []string
+	ToolUseID             string
+	ToolName              string
+	ProcessedToolUseIDs   map[string]bool
+	AllToolInputs         []string
+	// 已调用的工具名称（按调用顺序）
+	ToolNames []string
+	// 是否禁止并行工具调用（仅保留第一个工具调用）
+	DisableParallelToolUse bool
 	// Thinking 相关状态
-	InThinkBlock           bool
-	ThinkBuffer            string
+	InThinkBlock bool
+	ThinkBuffer  string
 	// 用于延迟发送 ping 事件
-	PingPending            bool
+	PingPending bool
+	// 代码引用、补充链接、引用和推荐提问（按接收顺序）
+	CodeReferences  []CodeReference
+	WebLinks        []SupplementaryWebLink
+	Citations       []CitationEvent
+	FollowupPrompts []FollowupPrompt
+	// 上游在流中返回的异常（无异常时为 nil），收到后以 error 事件终止响应
+	Exception *ExceptionEvent
+	// 是否将代码引用和补充链接转换为文本块的 citations
+	CitationsEnabled bool
+	// 许可证策略，返回错误时以 permission_error 终止响应（为 nil 时不检查）
+	LicensePolicy func(licenseName string, repository string) error
+	// 是否已因许可证策略终止响应
+	Blocked bool
+	// 配置许可证策略时暂存的当前文本块事件，收到通过检查的代码引用或文本块结束后发送
+	heldEvents []anthropic.StreamEvent
+	// 是否正在暂存打开的文本块
+	holdingTextBlock bool
+	// 等待附加到文本块的引用（没有打开的文本块时暂存，结束时放入单独的文本块）
+	pendingCitations []anthropic.Citation
+	// 请求上下文，用于在日志中关联请求 ID（可为 nil）
+	Context context.Context
+	// 已记录过日志的未知事件类型
+	unknownEventsLogged map[string]bool
 }
 
 // NewClaudeStreamHandler 创建新的流处理器实例
@@ -43,31 +84,72 @@ type ClaudeStreamHandler struct {
 // 返回初始化的流处理器实例
 func NewClaudeStreamHandler(model string, inputTokens int) *ClaudeStreamHandler {
 	return &ClaudeStreamHandler{
-		Model:                 model,
-		InputTokens:           inputTokens,
-		ResponseBuffer:        []string{},
-		ContentBlockIndex:     -1,
-		ProcessedToolUseIDs:   make(map[string]bool),
-		AllToolInputs:         []string{},
+		Model:               model,
+		InputTokens:         inputTokens,
+		ResponseBuffer:      []string{},
+		ContentBlockIndex:   -1,
+		ProcessedToolUseIDs: make(map[string]bool),
+		AllToolInputs:       []string{},
+		unknownEventsLogged: make(map[string]bool),
+	}
+}
+
+// HandleEvent 处理单个 Amazon Q 事件并生成 Claude 流式事件
+// 代码引用可能在被引用的文本之后才到达，配置许可证策略时暂存当前打开的文本块，
+// 在收到代码引用并通过检查或文本块结束时发送，被拦截时丢弃，保证被禁止许可证的代码不会发送给客户端
+// 参数 event 为已解码的 Amazon Q 事件
+// 返回流式事件列表
+func (h *ClaudeStreamHandler) HandleEvent(event Event) []anthropic.StreamEvent {
+	events := h.handleEvent(event)
+	if h.LicensePolicy == nil || h.Blocked || h.Exception != nil {
+		return events
+	}
+
+	var passed []anthropic.StreamEvent
+	for _, e := range events {
+		if start, ok := e.(anthropic.ContentBlockStartEvent); ok && start.ContentBlock.Type == "text" {
+			h.holdingTextBlock = true
+		}
+		if !h.holdingTextBlock {
+			passed = append(passed, e)
+			continue
+		}
+		h.heldEvents = append(h.heldEvents, e)
+		if _, ok := e.(anthropic.ContentBlockStopEvent); ok {
+			passed = append(passed, h.releaseHeldEvents()...)
+		}
+	}
+	// 代码引用已通过检查，发送暂存的文本
+	if _, ok := event.(CodeReferenceEvent); ok {
+		passed = append(passed, h.releaseHeldEvents()...)
 	}
+	return passed
 }
 
-// HandleEvent 处理单个 Amazon Q 事件并生成 Claude SSE 事件
-// 参数 eventType 为事件类型
-// 参数 payload 为事件数据
-// 返回 SSE 事件字符串列表
-func (h *ClaudeStreamHandler) HandleEvent(eventType string, payload interface{}) []string {
-	var events []string
+// releaseHeldEvents 结束暂存并返回已暂存的事件
+func (h *ClaudeStreamHandler) releaseHeldEvents() []anthropic.StreamEvent {
+	held := h.heldEvents
+	h.heldEvents = nil
+	h.holdingTextBlock = false
+	return held
+}
+
+// handleEvent 将单个 Amazon Q 事件转换为 Claude 流式事件
+// 参数 event 为已解码的 Amazon Q 事件
+// 返回流式事件列表
+func (h *ClaudeStreamHandler) handleEvent(event Event) []anthropic.StreamEvent {
+	var events []anthropic.StreamEvent
 
-	payloadMap, ok := payload.(map[string]interface{})
-	if !ok {
+	// 已因许可证策略或上游异常终止，忽略后续事件
+	if h.Blocked || h.Exception != nil {
 		return events
 	}
 
+	switch e := event.(type) {
 	// 1. 消息开始 (initial-response)
-	if eventType == "initial-response" {
+	case InitialResponseEvent:
 		if !h.MessageStartSent {
-			convID, _ := payloadMap["conversationId"].(string)
+			convID := e.ConversationID
 			if convID == "" {
 				convID = h.ConversationID
 			}
@@ -75,18 +157,17 @@ func (h *ClaudeStreamHandler) HandleEvent(eventType string, payload interface{})
 				convID = "unknown"
 			}
 			h.ConversationID = convID
-			messageStartEvent, messageID := BuildMessageStart(h.Model, h.InputTokens)
+			messageStartEvent, messageID := BuildMessageStart(h.Model, h.InputTokens, h.CacheUsage)
 			h.MessageID = messageID
 			events = append(events, messageStartEvent)
 			h.MessageStartSent = true
 			// 延迟发送 ping，等待第一个 content_block_start 之后
 			h.PingPending = true
 		}
-	}
 
 	// 2. 内容块增量 (assistantResponseEvent)
-	if eventType == "assistantResponseEvent" {
-		content, _ := payloadMap["content"].(string)
+	case AssistantResponseEvent:
+		content := e.Content
 
 		// 关闭任何打开的工具使用块
 		if h.CurrentToolUse != nil && !h.ContentBlockStopSent {
@@ -114,6 +195,7 @@ func (h *ClaudeStreamHandler) HandleEvent(eventType string, payload interface{})
 								events = append(events, BuildContentBlockStart(h.ContentBlockIndex, "text"))
 								h.ContentBlockStartSent = true
 								h.ContentBlockStarted = true
+								h.ContentBlockStopSent = false
 								// 在第一个 content_block_start 之后发送 ping
 								if h.PingPending {
 									events = append(events, BuildPing())
@@ -151,6 +233,7 @@ func (h *ClaudeStreamHandler) HandleEvent(eventType string, payload interface{})
 							events = append(events, BuildContentBlockStart(h.ContentBlockIndex, "text"))
 							h.ContentBlockStartSent = true
 							h.ContentBlockStarted = true
+							h.ContentBlockStopSent = false
 							// 在第一个 content_block_start 之后发送 ping
 							if h.PingPending {
 								events = append(events, BuildPing())
@@ -196,14 +279,17 @@ func (h *ClaudeStreamHandler) HandleEvent(eventType string, payload interface{})
 				h.ThinkBuffer = ""
 			}
 		}
-	}
 
 	// 3. 工具使用 (toolUseEvent)
-	if eventType == "toolUseEvent" {
-		toolUseID, _ := payloadMap["toolUseId"].(string)
-		toolName, _ := payloadMap["name"].(string)
-		toolInput := payloadMap["input"]
-		isStop, _ := payloadMap["stop"].(bool)
+	case ToolUseEvent:
+		toolUseID := e.ToolUseID
+		toolName := e.Name
+		isStop := e.Stop
+
+		// 禁止并行工具调用时忽略第一个之后的工具调用
+		if h.DisableParallelToolUse && toolUseID != "" && len(h.ProcessedToolUseIDs) > 0 && !h.ProcessedToolUseIDs[toolUseID] {
+			return events
+		}
 
 		// 启动新的工具使用
 		if toolUseID != "" && toolName != "" && h.CurrentToolUse == nil {
@@ -214,6 +300,7 @@ func (h *ClaudeStreamHandler) HandleEvent(eventType string, payload interface{})
 			}
 
 			h.ProcessedToolUseIDs[toolUseID] = true
+			h.ToolNames = append(h.ToolNames, toolName)
 			h.ContentBlockIndex++
 
 			events = append(events, BuildToolUseStart(h.ContentBlockIndex, toolUseID, toolName))
@@ -236,15 +323,8 @@ func (h *ClaudeStreamHandler) HandleEvent(eventType string, payload interface{})
 		}
 
 		// 累积输入
-		if h.CurrentToolUse != nil && toolInput != nil {
-			fragment := ""
-			switch v := toolInput.(type) {
-			case string:
-				fragment = v
-			default:
-				jsonBytes, _ := json.Marshal(toolInput)
-				fragment = string(jsonBytes)
-			}
+		if h.CurrentToolUse != nil && len(e.Input) > 0 && string(e.Input) != "null" {
+			fragment := e.InputFragment()
 
 			h.ToolInputBuffer = append(h.ToolInputBuffer, fragment)
 			events = append(events, BuildToolUseInputDelta(h.ContentBlockIndex, fragment))
@@ -263,24 +343,182 @@ func (h *ClaudeStreamHandler) HandleEvent(eventType string, payload interface{})
 			h.ToolName = ""
 			h.ToolInputBuffer = []string{}
 		}
-	}
 
 	// 4. 助手响应结束 (assistantResponseEnd)
-	if eventType == "assistantResponseEnd" {
+	case AssistantResponseEndEvent:
 		// 关闭任何打开的块
 		if h.ContentBlockStarted && !h.ContentBlockStopSent {
 			events = append(events, BuildContentBlockStop(h.ContentBlockIndex))
 			h.ContentBlockStopSent = true
 		}
+
+	// 5. 会话元数据 (messageMetadataEvent)
+	case MessageMetadataEvent:
+		if h.ConversationID == "" {
+			h.ConversationID = e.ConversationID
+		}
+
+	// 6. 附加信息：检查许可证并转换为 citations
+	case CodeReferenceEvent:
+		h.CodeReferences = append(h.CodeReferences, e.References...)
+		for _, ref := range e.References {
+			if h.LicensePolicy == nil {
+				break
+			}
+			if err := h.LicensePolicy(ref.LicenseName, ref.Repository); err != nil {
+				slog.WarnContext(h.Context, "blocked response by license policy", "component", "license", "message_id", h.MessageID, "error", err)
+				h.Blocked = true
+				h.heldEvents = nil
+				events = append(events, anthropic.ErrorEvent{ErrorType: "permission_error", Message: err.Error()})
+				return events
+			}
+		}
+		for _, ref := range e.References {
+			events = append(events, h.addCitation(h.codeReferenceCitation(ref))...)
+		}
+	case SupplementaryWebLinksEvent:
+		h.WebLinks = append(h.WebLinks, e.SupplementaryWebLinks...)
+		for _, link := range e.SupplementaryWebLinks {
+			events = append(events, h.addCitation(anthropic.Citation{
+				Type:      anthropic.CitationWebSearchResult,
+				CitedText: link.Snippet,
+				URL:       link.URL,
+				Title:     link.Title,
+			})...)
+		}
+	case CitationEvent:
+		h.Citations = append(h.Citations, e)
+		events = append(events, h.addCitation(anthropic.Citation{
+			Type:      anthropic.CitationWebSearchResult,
+			CitedText: e.CitationText,
+			URL:       e.CitationLink,
+		})...)
+	case FollowupPromptEvent:
+		h.FollowupPrompts = append(h.FollowupPrompts, e.FollowupPrompt)
+
+	// 7. 上游异常和无效状态
+	case InvalidStateEvent:
+		slog.WarnContext(h.Context, "invalid state", "component", "amazonq", "reason", e.Reason, "message", e.Message)
+	case ExceptionEvent:
+		h.Exception = &e
+		metrics.UpstreamErrors.Inc(e.ExceptionType)
+		slog.ErrorContext(h.Context, "stream exception", "component", "amazonq", "exception", e.ExceptionType, "message", e.Message)
+		// 回答不完整，以 error 事件结束响应，不再发送正常的结束事件；暂存的内容随之丢弃
+		h.heldEvents = nil
+		events = append(events, anthropic.ErrorEvent{ErrorType: exceptionErrorType(e.ExceptionType), Message: exceptionMessage(e)})
+
+	// 8. 已知但无需转换的事件
+	case MeteringEvent, ContextUsageEvent, DryRunSucceedEvent, CodeEvent, IntentsEvent,
+		InteractionComponentsEvent, ToolResultEvent, ReasoningContentEvent:
+
+	// 9. 未知事件：每种类型仅记录一次
+	case UnknownEvent:
+		if !h.unknownEventsLogged[e.Type] {
+			h.unknownEventsLogged[e.Type] = true
+			slog.DebugContext(h.Context, "ignoring unknown event", "component", "amazonq", "event", e.Type, "payload", truncatePayload(e.Payload))
+		}
 	}
 
 	return events
 }
 
+// HandleStreamError 处理上游流的读取或解析错误（连接中断、帧解析失败、空闲超时、请求取消）
+// 与上游异常相同，以 error 事件结束响应，不再发送正常的结束事件
+// 参数 err 为流错误
+// 返回流式事件列表
+func (h *ClaudeStreamHandler) HandleStreamError(err error) []anthropic.StreamEvent {
+	exceptionType := StreamErrorException
+	if errors.Is(err, httpclient.ErrIdleTimeout) {
+		exceptionType = StreamIdleTimeoutException
+	}
+	return h.HandleEvent(ExceptionEvent{ExceptionType: exceptionType, Message: err.Error()})
+}
+
+// exceptionErrorType 返回上游异常对应的 Claude API 错误类型：限流和流空闲超时为 overloaded_error，其余为 api_error
+// 参数 exceptionType 为上游异常类型
+func exceptionErrorType(exceptionType string) string {
+	if exceptionType == StreamIdleTimeoutException || strings.Contains(strings.ToLower(exceptionType), "throttl") {
+		return "overloaded_error"
+	}
+	return "api_error"
+}
+
+// exceptionMessage 返回发送给客户端的异常说明
+// 参数 e 为上游异常事件
+func exceptionMessage(e ExceptionEvent) string {
+	message := "Upstream stream failed"
+	if e.ExceptionType != "" {
+		message += ": " + e.ExceptionType
+	}
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+// textBlockOpen 判断当前是否有打开的文本块
+func (h *ClaudeStreamHandler) textBlockOpen() bool {
+	return h.ContentBlockStartSent && !h.ContentBlockStopSent && !h.InThinkBlock && h.CurrentToolUse == nil
+}
+
+// addCitation 将引用附加到打开的文本块，没有打开的文本块时暂存到结束时发送
+// 参数 citation 为引用
+// 返回流式事件列表
+func (h *ClaudeStreamHandler) addCitation(citation anthropic.Citation) []anthropic.StreamEvent {
+	if !h.CitationsEnabled {
+		return nil
+	}
+	if !h.textBlockOpen() {
+		h.pendingCitations = append(h.pendingCitations, citation)
+		return nil
+	}
+	return []anthropic.StreamEvent{BuildCitationDelta(h.ContentBlockIndex, citation)}
+}
+
+// codeReferenceCitation 将代码引用转换为 code_reference 引用
+// 参数 ref 为代码引用
+// 返回引用，区间有效时附带被引用的回复文本
+func (h *ClaudeStreamHandler) codeReferenceCitation(ref CodeReference) anthropic.Citation {
+	citation := anthropic.Citation{
+		Type:        anthropic.CitationCodeReference,
+		URL:         ref.URL,
+		Title:       ref.Information,
+		LicenseName: ref.LicenseName,
+		Repository:  ref.Repository,
+	}
+	if span := ref.RecommendationContentSpan; span != nil {
+		text := []rune(strings.Join(h.ResponseBuffer, ""))
+		if span.Start >= 0 && span.Start <= span.End && span.End <= len(text) {
+			start, end := span.Start, span.End
+			citation.CitedText = string(text[start:end])
+			citation.StartCharIndex = &start
+			citation.EndCharIndex = &end
+		}
+	}
+	return citation
+}
+
+// truncatePayload 截断过长的事件负载用于日志输出
+func truncatePayload(payload []byte) string {
+	const maxLength = 200
+	if len(payload) > maxLength {
+		return string(payload[:maxLength]) + "..."
+	}
+	return string(payload)
+}
+
 // Finish 发送最终事件，关闭所有未关闭的内容块并计算 token 使用量
-// 返回最终的 SSE 事件列表
-func (h *ClaudeStreamHandler) Finish() []string {
-	var events []string
+// 返回最终的流式事件列表
+func (h *ClaudeStreamHandler) Finish() []anthropic.StreamEvent {
+	var events []anthropic.StreamEvent
+
+	// 已因许可证策略或上游异常终止，不再发送结束事件
+	if h.Blocked || h.Exception != nil {
+		return events
+	}
+
+	// 许可证检查已通过，发送仍在暂存的文本块
+	events = append(events, h.releaseHeldEvents()...)
 
 	// 确保最后一个块已关闭
 	if h.ContentBlockStarted && !h.ContentBlockStopSent {
@@ -288,6 +526,21 @@ func (h *ClaudeStreamHandler) Finish() []string {
 		h.ContentBlockStopSent = true
 	}
 
+	// 没有文本块可附加的引用放入单独的空文本块
+	if len(h.pendingCitations) > 0 {
+		h.ContentBlockIndex++
+		events = append(events, BuildContentBlockStart(h.ContentBlockIndex, "text"))
+		if h.PingPending {
+			events = append(events, BuildPing())
+			h.PingPending = false
+		}
+		for _, citation := range h.pendingCitations {
+			events = append(events, BuildCitationDelta(h.ContentBlockIndex, citation))
+		}
+		events = append(events, BuildContentBlockStop(h.ContentBlockIndex))
+		h.pendingCitations = nil
+	}
+
 	// 计算输出 token（近似）
 	fullText := strings.Join(h.ResponseBuffer, "")
 	fullToolInput := strings.Join(h.AllToolInputs, "")
@@ -297,7 +550,14 @@ func (h *ClaudeStreamHandler) Finish() []string {
 		outputTokens = 1
 	}
 
-	events = append(events, BuildMessageStop(h.InputTokens, outputTokens, nil))
+	// 存在工具调用时停止原因为 tool_use
+	var stopReason *string
+	if len(h.ToolNames) > 0 {
+		reason := "tool_use"
+		stopReason = &reason
+	}
+
+	events = append(events, BuildMessageStop(h.InputTokens, outputTokens, h.CacheUsage, stopReason)...)
 
 	return events
 }
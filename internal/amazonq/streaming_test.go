@@ -0,0 +1,228 @@
+package amazonq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"amazonq-proxy/internal/anthropic"
+	"amazonq-proxy/internal/httpclient"
+)
+
+// gplPolicy 禁止 GPL 许可证的测试策略
+func gplPolicy(licenseName string, repository string) error {
+	if strings.HasPrefix(licenseName, "GPL") {
+		return fmt.Errorf("license %s is not allowed", licenseName)
+	}
+	return nil
+}
+
+// run 依次处理事件并结束，分别返回处理过程中和 Finish 返回的事件
+func run(h *ClaudeStreamHandler, events ...Event) (streamed, finished []anthropic.StreamEvent) {
+	for _, event := range events {
+		streamed = append(streamed, h.HandleEvent(event)...)
+	}
+	return streamed, h.Finish()
+}
+
+// texts 返回事件中的全部文本增量
+func texts(events []anthropic.StreamEvent) string {
+	var b strings.Builder
+	for _, event := range events {
+		if delta, ok := event.(anthropic.ContentBlockDeltaEvent); ok {
+			b.WriteString(delta.Delta.Text)
+		}
+	}
+	return b.String()
+}
+
+func TestLicenseBlockedAfterTextWasStreamed(t *testing.T) {
+	h := NewClaudeStreamHandler("claude-sonnet-4.5", 10)
+	h.Context = context.Background()
+	h.LicensePolicy = gplPolicy
+
+	streamed, finished := run(h,
+		InitialResponseEvent{ConversationID: "c1"},
+		AssistantResponseEvent{Content: "copied "},
+		AssistantResponseEvent{Content: "code"},
+		CodeReferenceEvent{References: []CodeReference{{LicenseName: "GPL-3.0", Repository: "example/repo"}}},
+		AssistantResponseEvent{Content: " more"},
+		AssistantResponseEndEvent{},
+	)
+
+	all := append(streamed, finished...)
+	if text := texts(all); text != "" {
+		t.Errorf("text %q reached the client despite a blocked license", text)
+	}
+	if _, ok := all[0].(anthropic.MessageStartEvent); !ok {
+		t.Errorf("first event = %T, want message_start", all[0])
+	}
+	last, ok := all[len(all)-1].(anthropic.ErrorEvent)
+	if !ok || last.ErrorType != "permission_error" {
+		t.Fatalf("last event = %#v, want permission_error", all[len(all)-1])
+	}
+	for _, event := range all {
+		if _, ok := event.(anthropic.MessageStopEvent); ok {
+			t.Error("blocked response sent message_stop")
+		}
+	}
+}
+
+func TestLicenseHoldsOnlyTheOpenTextBlock(t *testing.T) {
+	h := NewClaudeStreamHandler("claude-sonnet-4.5", 10)
+	h.Context = context.Background()
+	h.LicensePolicy = gplPolicy
+
+	// 打开的文本块在代码引用通过检查前暂存
+	streamed := append(h.HandleEvent(InitialResponseEvent{ConversationID: "c1"}), h.HandleEvent(AssistantResponseEvent{Content: "hello"})...)
+	if len(streamed) != 1 {
+		t.Fatalf("streamed %d events before the code reference, want only message_start", len(streamed))
+	}
+	if _, ok := streamed[0].(anthropic.MessageStartEvent); !ok {
+		t.Fatalf("first event = %T, want message_start", streamed[0])
+	}
+
+	released := h.HandleEvent(CodeReferenceEvent{References: []CodeReference{{LicenseName: "MIT", Repository: "example/repo"}}})
+	if text := texts(released); text != "hello" {
+		t.Errorf("released text = %q, want %q", text, "hello")
+	}
+
+	// 通过检查后同一文本块的后续内容直接发送
+	if text := texts(h.HandleEvent(AssistantResponseEvent{Content: " world"})); text != " world" {
+		t.Errorf("text after the code reference = %q, want %q", text, " world")
+	}
+
+	// 工具调用关闭文本块，工具调用事件不暂存
+	toolEvents := h.HandleEvent(ToolUseEvent{ToolUseID: "t1", Name: "search", Input: []byte(`"{\"q\":1}"`)})
+	var toolStarted bool
+	for _, event := range toolEvents {
+		if start, ok := event.(anthropic.ContentBlockStartEvent); ok && start.ContentBlock.Type == "tool_use" {
+			toolStarted = true
+		}
+	}
+	if !toolStarted {
+		t.Errorf("tool_use events = %#v, want them sent immediately", toolEvents)
+	}
+
+	finished := h.Finish()
+	if _, ok := finished[len(finished)-1].(anthropic.MessageStopEvent); !ok {
+		t.Errorf("last event = %T, want message_stop", finished[len(finished)-1])
+	}
+}
+
+func TestLicenseReleasesTextBlockWhenItCloses(t *testing.T) {
+	h := NewClaudeStreamHandler("claude-sonnet-4.5", 10)
+	h.Context = context.Background()
+	h.LicensePolicy = gplPolicy
+
+	streamed, finished := run(h,
+		InitialResponseEvent{ConversationID: "c1"},
+		AssistantResponseEvent{Content: "hello"},
+		AssistantResponseEndEvent{},
+	)
+	if text := texts(streamed); text != "hello" {
+		t.Errorf("text streamed when the block closed = %q, want %q", text, "hello")
+	}
+	if _, ok := streamed[len(streamed)-1].(anthropic.ContentBlockStopEvent); !ok {
+		t.Errorf("last streamed event = %T, want content_block_stop", streamed[len(streamed)-1])
+	}
+	if text := texts(finished); text != "" {
+		t.Errorf("Finish sent text %q again", text)
+	}
+}
+
+func TestNoLicensePolicyStreamsImmediately(t *testing.T) {
+	h := NewClaudeStreamHandler("claude-sonnet-4.5", 10)
+	h.Context = context.Background()
+
+	streamed, _ := run(h,
+		InitialResponseEvent{ConversationID: "c1"},
+		AssistantResponseEvent{Content: "hello"},
+	)
+	if text := texts(streamed); text != "hello" {
+		t.Errorf("streamed text = %q, want %q", text, "hello")
+	}
+}
+
+func TestExceptionEndsStreamWithError(t *testing.T) {
+	cases := map[string]string{
+		"ThrottlingException":           "overloaded_error",
+		"InternalServerException":       "api_error",
+		"ServiceQuotaExceededException": "api_error",
+	}
+	for exceptionType, want := range cases {
+		h := NewClaudeStreamHandler("claude-sonnet-4.5", 10)
+		h.Context = context.Background()
+		streamed, finished := run(h,
+			InitialResponseEvent{ConversationID: "c1"},
+			AssistantResponseEvent{Content: "partial"},
+			ExceptionEvent{ExceptionType: exceptionType, Message: "boom"},
+			AssistantResponseEvent{Content: " ignored"},
+		)
+
+		if text := texts(streamed); text != "partial" {
+			t.Errorf("%s: streamed text = %q, want %q", exceptionType, text, "partial")
+		}
+		last, ok := streamed[len(streamed)-1].(anthropic.ErrorEvent)
+		if !ok || last.ErrorType != want || !strings.Contains(last.Message, "boom") {
+			t.Errorf("%s: last event = %#v, want %s", exceptionType, streamed[len(streamed)-1], want)
+		}
+		if len(finished) != 0 {
+			t.Errorf("%s: Finish returned %d events after the exception, want none", exceptionType, len(finished))
+		}
+	}
+}
+
+func TestStreamErrorEndsStreamWithError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{io.ErrUnexpectedEOF, "api_error"},
+		{errors.New("connection reset by peer"), "api_error"},
+		{fmt.Errorf("read body: %w", httpclient.ErrIdleTimeout), "overloaded_error"},
+	}
+	for _, tc := range cases {
+		h := NewClaudeStreamHandler("claude-sonnet-4.5", 10)
+		h.Context = context.Background()
+		eventChan := make(chan *EventStreamMessage, 3)
+		eventChan <- assistantMessage(t, "partial")
+		eventChan <- &EventStreamMessage{Err: tc.err}
+		close(eventChan)
+
+		var events []anthropic.StreamEvent
+		for event := range ProcessEventStream(eventChan, h) {
+			events = append(events, event)
+		}
+
+		if text := texts(events); text != "partial" {
+			t.Errorf("%v: streamed text = %q, want %q", tc.err, text, "partial")
+		}
+		last, ok := events[len(events)-1].(anthropic.ErrorEvent)
+		if !ok || last.ErrorType != tc.want || !strings.Contains(last.Message, tc.err.Error()) {
+			t.Errorf("%v: last event = %#v, want %s", tc.err, events[len(events)-1], tc.want)
+		}
+		for _, event := range events {
+			if _, ok := event.(anthropic.MessageStopEvent); ok {
+				t.Errorf("%v: truncated stream ended with message_stop", tc.err)
+			}
+		}
+	}
+}
+
+// assistantMessage 构造一个 assistantResponseEvent 事件流消息
+func assistantMessage(t *testing.T, content string) *EventStreamMessage {
+	t.Helper()
+	payload, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &EventStreamMessage{
+		Headers: map[string]string{":message-type": "event", ":event-type": EventAssistantResponse},
+		Payload: payload,
+	}
+}
@@ -0,0 +1,247 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 流式事件类型
+const (
+	EventMessageStart      = "message_start"
+	EventContentBlockStart = "content_block_start"
+	EventContentBlockDelta = "content_block_delta"
+	EventContentBlockStop  = "content_block_stop"
+	EventMessageDelta      = "message_delta"
+	EventMessageStop       = "message_stop"
+	EventPing              = "ping"
+	EventError             = "error"
+)
+
+// 内容块增量类型
+const (
+	DeltaText      = "text_delta"
+	DeltaInputJSON = "input_json_delta"
+	DeltaCitations = "citations_delta"
+)
+
+// StreamEvent Claude API 流式事件
+type StreamEvent interface {
+	// EventType 返回事件类型（即 SSE 的 event 字段）
+	EventType() string
+}
+
+// MessageStartEvent message_start 事件
+type MessageStartEvent struct {
+	Message Message
+}
+
+// ContentBlockStartEvent content_block_start 事件
+type ContentBlockStartEvent struct {
+	Index        int
+	ContentBlock ContentBlock
+}
+
+// ContentBlockDeltaEvent content_block_delta 事件
+type ContentBlockDeltaEvent struct {
+	Index int
+	Delta Delta
+}
+
+// Delta 内容块增量：text_delta、input_json_delta 或 citations_delta
+type Delta struct {
+	Type        string    // 增量类型
+	Text        string    // 文本增量（text_delta）
+	PartialJSON string    // JSON 片段（input_json_delta）
+	Citation    *Citation // 引用（citations_delta）
+}
+
+// ContentBlockStopEvent content_block_stop 事件
+type ContentBlockStopEvent struct {
+	Index int
+}
+
+// MessageDeltaEvent message_delta 事件
+type MessageDeltaEvent struct {
+	StopReason   string
+	StopSequence *string
+	Usage        Usage
+}
+
+// MessageStopEvent message_stop 事件
+type MessageStopEvent struct{}
+
+// PingEvent ping 事件
+type PingEvent struct{}
+
+// ErrorEvent error 事件，流式响应中途出错时发送，之后不再发送其他事件
+type ErrorEvent struct {
+	ErrorType string // 错误类型，如 api_error、overloaded_error、permission_error
+	Message   string // 错误说明
+}
+
+func (MessageStartEvent) EventType() string      { return EventMessageStart }
+func (ContentBlockStartEvent) EventType() string { return EventContentBlockStart }
+func (ContentBlockDeltaEvent) EventType() string { return EventContentBlockDelta }
+func (ContentBlockStopEvent) EventType() string  { return EventContentBlockStop }
+func (MessageDeltaEvent) EventType() string      { return EventMessageDelta }
+func (MessageStopEvent) EventType() string       { return EventMessageStop }
+func (PingEvent) EventType() string              { return EventPing }
+func (ErrorEvent) EventType() string             { return EventError }
+
+// MarshalJSON 输出 message_start 事件数据
+func (e MessageStartEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type    string  `json:"type"`
+		Message Message `json:"message"`
+	}{EventMessageStart, e.Message})
+}
+
+// MarshalJSON 输出 content_block_start 事件数据
+func (e ContentBlockStartEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type         string       `json:"type"`
+		Index        int          `json:"index"`
+		ContentBlock ContentBlock `json:"content_block"`
+	}{EventContentBlockStart, e.Index, e.ContentBlock})
+}
+
+// MarshalJSON 输出 content_block_delta 事件数据
+func (e ContentBlockDeltaEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type  string `json:"type"`
+		Index int    `json:"index"`
+		Delta Delta  `json:"delta"`
+	}{EventContentBlockDelta, e.Index, e.Delta})
+}
+
+// MarshalJSON 按增量类型输出对应字段
+func (d Delta) MarshalJSON() ([]byte, error) {
+	switch d.Type {
+	case DeltaInputJSON:
+		return json.Marshal(struct {
+			Type        string `json:"type"`
+			PartialJSON string `json:"partial_json"`
+		}{d.Type, d.PartialJSON})
+	case DeltaCitations:
+		return json.Marshal(struct {
+			Type     string    `json:"type"`
+			Citation *Citation `json:"citation"`
+		}{d.Type, d.Citation})
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}{d.Type, d.Text})
+}
+
+// MarshalJSON 输出 content_block_stop 事件数据
+func (e ContentBlockStopEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type  string `json:"type"`
+		Index int    `json:"index"`
+	}{EventContentBlockStop, e.Index})
+}
+
+// MarshalJSON 输出 message_delta 事件数据
+func (e MessageDeltaEvent) MarshalJSON() ([]byte, error) {
+	type delta struct {
+		StopReason   string  `json:"stop_reason"`
+		StopSequence *string `json:"stop_sequence"`
+	}
+	return json.Marshal(struct {
+		Type  string `json:"type"`
+		Delta delta  `json:"delta"`
+		Usage Usage  `json:"usage"`
+	}{EventMessageDelta, delta{e.StopReason, e.StopSequence}, e.Usage})
+}
+
+// MarshalJSON 输出 message_stop 事件数据
+func (e MessageStopEvent) MarshalJSON() ([]byte, error) {
+	return []byte(`{"type":"message_stop"}`), nil
+}
+
+// MarshalJSON 输出 ping 事件数据
+func (e PingEvent) MarshalJSON() ([]byte, error) {
+	return []byte(`{"type":"ping"}`), nil
+}
+
+// MarshalJSON 输出 error 事件数据
+func (e ErrorEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ErrorResponse(e.ErrorType, e.Message))
+}
+
+// ErrorResponse 构建 Claude API 格式的错误响应体
+// 参数 errorType 为错误类型
+// 参数 message 为错误说明
+// 返回错误响应体
+func ErrorResponse(errorType string, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    errorType,
+			"message": message,
+		},
+	}
+}
+
+// FormatSSE 将事件序列化为 Server-Sent Events 格式
+// 参数 event 为流式事件
+// 返回 "event: xxx\ndata: {...}\n\n" 格式的字符串
+func FormatSSE(event StreamEvent) string {
+	data, err := json.Marshal(event)
+	if err != nil {
+		data = []byte(fmt.Sprintf(`{"type":%q}`, event.EventType()))
+	}
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", event.EventType(), data)
+}
+
+// MessageEvents 将完整消息还原为流式事件序列（用于回放缓存的响应）
+// 参数 msg 为完整消息
+// 返回流式事件序列
+func MessageEvents(msg Message) []StreamEvent {
+	start := msg
+	start.Content = []*ContentBlock{}
+	start.StopReason = nil
+	start.StopSequence = nil
+	events := []StreamEvent{MessageStartEvent{Message: start}}
+
+	for i, block := range msg.Content {
+		switch block.Type {
+		case "tool_use":
+			events = append(events, ContentBlockStartEvent{Index: i, ContentBlock: ContentBlock{Type: block.Type, ID: block.ID, Name: block.Name}})
+			if i == 0 {
+				events = append(events, PingEvent{})
+			}
+			input, _ := json.Marshal(block.Input)
+			if block.Input == nil {
+				input = []byte("{}")
+			}
+			events = append(events, ContentBlockDeltaEvent{Index: i, Delta: Delta{Type: DeltaInputJSON, PartialJSON: string(input)}})
+		default:
+			events = append(events, ContentBlockStartEvent{Index: i, ContentBlock: ContentBlock{Type: block.Type}})
+			if i == 0 {
+				events = append(events, PingEvent{})
+			}
+			if block.Text != "" {
+				events = append(events, ContentBlockDeltaEvent{Index: i, Delta: Delta{Type: DeltaText, Text: block.Text}})
+			}
+			for j := range block.Citations {
+				events = append(events, ContentBlockDeltaEvent{Index: i, Delta: Delta{Type: DeltaCitations, Citation: &block.Citations[j]}})
+			}
+		}
+		events = append(events, ContentBlockStopEvent{Index: i})
+	}
+
+	stopReason := "end_turn"
+	if msg.StopReason != nil {
+		stopReason = *msg.StopReason
+	}
+	usage := msg.Usage
+	usage.CacheCreation = nil
+	usage.ServiceTier = ""
+	events = append(events,
+		MessageDeltaEvent{StopReason: stopReason, StopSequence: msg.StopSequence, Usage: usage},
+		MessageStopEvent{},
+	)
+	return events
+}
@@ -0,0 +1,126 @@
+package anthropic
+
+import "encoding/json"
+
+// Message Claude API 的完整助手消息（非流式响应体，也是 message_start 中的消息）
+type Message struct {
+	ID           string          `json:"id"`            // 消息 ID
+	Type         string          `json:"type"`          // 固定为 message
+	Role         string          `json:"role"`          // 固定为 assistant
+	Model        string          `json:"model"`         // 模型名称
+	Content      []*ContentBlock `json:"content"`       // 内容块列表
+	StopReason   *string         `json:"stop_reason"`   // 停止原因
+	StopSequence *string         `json:"stop_sequence"` // 触发停止的序列
+	Usage        Usage           `json:"usage"`         // token 用量
+}
+
+// NewMessage 创建空的助手消息
+// 参数 id 为消息 ID
+// 参数 model 为模型名称
+// 参数 usage 为初始 token 用量
+// 返回消息
+func NewMessage(id string, model string, usage Usage) Message {
+	return Message{
+		ID:      id,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   model,
+		Content: []*ContentBlock{},
+		Usage:   usage,
+	}
+}
+
+// ContentBlock 响应内容块：text、thinking 或 tool_use
+type ContentBlock struct {
+	Type      string                 // 类型
+	Text      string                 // 文本（text、thinking）
+	Citations []Citation             // 引用（text）
+	ID        string                 // 工具使用 ID（tool_use）
+	Name      string                 // 工具名称（tool_use）
+	Input     map[string]interface{} // 工具输入（tool_use）
+}
+
+// Citation 文本块引用
+// web_search_result_location 与 Claude API 一致；code_reference 为代码许可证归属的扩展类型
+type Citation struct {
+	Type           string `json:"type"`                       // 类型：web_search_result_location 或 code_reference
+	CitedText      string `json:"cited_text"`                 // 被引用的文本
+	URL            string `json:"url,omitempty"`              // 来源地址
+	Title          string `json:"title,omitempty"`            // 来源标题
+	EncryptedIndex string `json:"encrypted_index,omitempty"`  // 来源索引（web_search_result_location）
+	LicenseName    string `json:"license_name,omitempty"`     // 许可证名称（code_reference）
+	Repository     string `json:"repository,omitempty"`       // 代码仓库（code_reference）
+	StartCharIndex *int   `json:"start_char_index,omitempty"` // 引用文本在回复中的起始字符偏移（code_reference）
+	EndCharIndex   *int   `json:"end_char_index,omitempty"`   // 引用文本在回复中的结束字符偏移（code_reference）
+}
+
+// 引用类型
+const (
+	CitationWebSearchResult = "web_search_result_location"
+	CitationCodeReference   = "code_reference"
+)
+
+// MarshalJSON 按内容块类型输出对应字段
+func (b ContentBlock) MarshalJSON() ([]byte, error) {
+	switch b.Type {
+	case "text", "thinking":
+		if len(b.Citations) > 0 {
+			return json.Marshal(struct {
+				Type      string     `json:"type"`
+				Text      string     `json:"text"`
+				Citations []Citation `json:"citations"`
+			}{b.Type, b.Text, b.Citations})
+		}
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}{b.Type, b.Text})
+	case "tool_use":
+		input := b.Input
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		return json.Marshal(struct {
+			Type  string                 `json:"type"`
+			ID    string                 `json:"id"`
+			Name  string                 `json:"name"`
+			Input map[string]interface{} `json:"input"`
+		}{b.Type, b.ID, b.Name, input})
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+	}{b.Type})
+}
+
+// UnmarshalJSON 解析任意类型的内容块
+func (b *ContentBlock) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type      string                 `json:"type"`
+		Text      string                 `json:"text"`
+		Citations []Citation             `json:"citations"`
+		ID        string                 `json:"id"`
+		Name      string                 `json:"name"`
+		Input     map[string]interface{} `json:"input"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*b = ContentBlock{Type: raw.Type, Text: raw.Text, Citations: raw.Citations, ID: raw.ID, Name: raw.Name, Input: raw.Input}
+	return nil
+}
+
+// Usage token 用量
+type Usage struct {
+	InputTokens              int            `json:"input_tokens"`                // 输入 token 数（不含缓存部分）
+	CacheCreationInputTokens int            `json:"cache_creation_input_tokens"` // 写入缓存的 token 数
+	CacheReadInputTokens     int            `json:"cache_read_input_tokens"`     // 命中缓存的 token 数
+	CacheCreation            *CacheCreation `json:"cache_creation,omitempty"`    // 按 TTL 细分的缓存写入量
+	OutputTokens             int            `json:"output_tokens"`               // 输出 token 数
+	ServiceTier              string         `json:"service_tier,omitempty"`      // 服务等级
+}
+
+// CacheCreation 按 TTL 细分的缓存写入量
+type CacheCreation struct {
+	Ephemeral5mInputTokens int `json:"ephemeral_5m_input_tokens"` // 以 5 分钟 TTL 写入的 token 数
+	Ephemeral1hInputTokens int `json:"ephemeral_1h_input_tokens"` // 以 1 小时 TTL 写入的 token 数
+}
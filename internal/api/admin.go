@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+
+	"amazonq-proxy/internal/breaker"
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/reload"
+	"amazonq-proxy/internal/responsecache"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlePurgeResponseCache 清空响应缓存
+func handlePurgeResponseCache(c *gin.Context) {
+	cache := responsecache.Current()
+	if cache == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Response cache is disabled",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"purged": cache.Purge(),
+	})
+}
+
+// handleListBreakers 列出所有上游熔断器的状态和累计计数
+func handleListBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  config.Current().Breaker.Enabled,
+		"breakers": breaker.Snapshot(),
+	})
+}
+
+// handleResetBreakers 将所有上游熔断器恢复为关闭状态
+func handleResetBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"reset": breaker.ResetAll(),
+	})
+}
+
+// handleGetConfig 返回当前生效的配置（已隐藏密钥）和重载状态
+func handleGetConfig(c *gin.Context) {
+	response := gin.H{
+		"config": config.Current().Redacted(),
+	}
+	if r := reload.Current(); r != nil {
+		response["reload"] = r.Status()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleReloadConfig 立即重新加载配置，校验失败时返回错误并保留当前配置
+func handleReloadConfig(c *gin.Context) {
+	r := reload.Current()
+	if r == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Configuration reload is not available",
+		})
+		return
+	}
+
+	if err := r.Reload("admin API"); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  err.Error(),
+			"reload": r.Status(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"reload": r.Status(),
+	})
+}
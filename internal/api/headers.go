@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"amazonq-proxy/internal/core"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// headerOperatingSystem 覆盖 envState.operatingSystem
+	headerOperatingSystem = "x-amazonq-operating-system"
+	// headerWorkingDirectory 覆盖 envState.currentWorkingDirectory
+	headerWorkingDirectory = "x-amazonq-working-directory"
+	// headerEnvironment 设置 envState.environmentVariables，格式：KEY=VALUE;KEY2=VALUE2
+	headerEnvironment = "x-amazonq-environment"
+	// headerTimezoneOffset 设置 envState.timezoneOffset（分钟）
+	headerTimezoneOffset = "x-amazonq-timezone-offset"
+	// headerGitStatus 设置 gitState.status
+	headerGitStatus = "x-amazonq-git-status"
+	// headerShell 设置 shellState.shellName
+	headerShell = "x-amazonq-shell"
+)
+
+// parseAmazonQContextHeaders 从 x-amazonq-* 请求头解析消息上下文
+// 参数 c 为 Gin 上下文
+// 返回解析出的上下文（未设置任何请求头时为 nil）和可能的格式错误
+func parseAmazonQContextHeaders(c *gin.Context) (*core.AmazonQContext, error) {
+	ctx := &core.AmazonQContext{
+		OperatingSystem:         c.GetHeader(headerOperatingSystem),
+		CurrentWorkingDirectory: c.GetHeader(headerWorkingDirectory),
+		GitStatus:               c.GetHeader(headerGitStatus),
+		ShellName:               c.GetHeader(headerShell),
+	}
+	found := ctx.OperatingSystem != "" || ctx.CurrentWorkingDirectory != "" || ctx.GitStatus != "" || ctx.ShellName != ""
+
+	if raw := c.GetHeader(headerTimezoneOffset); raw != "" {
+		offset, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %q", headerTimezoneOffset, raw)
+		}
+		ctx.TimezoneOffset = &offset
+		found = true
+	}
+
+	if raw := c.GetHeader(headerEnvironment); raw != "" {
+		ctx.EnvironmentVariables = make(map[string]string)
+		for _, pair := range strings.Split(raw, ";") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+				return nil, fmt.Errorf("invalid %s header entry %q: expected KEY=VALUE", headerEnvironment, pair)
+			}
+			ctx.EnvironmentVariables[strings.TrimSpace(parts[0])] = parts[1]
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return ctx, nil
+}
+
+// applyAmazonQContextHeaders 将 x-amazonq-* 请求头合并到请求 metadata 中（请求头优先）
+// 参数 c 为 Gin 上下文
+// 参数 req 为 Claude 请求
+// 返回可能的格式错误
+func applyAmazonQContextHeaders(c *gin.Context, req *core.ClaudeRequest) error {
+	headerCtx, err := parseAmazonQContextHeaders(c)
+	if err != nil || headerCtx == nil {
+		return err
+	}
+
+	if req.Metadata == nil {
+		req.Metadata = &core.Metadata{}
+	}
+	req.Metadata.AmazonQ = core.MergeAmazonQContext(req.Metadata.AmazonQ, headerCtx)
+	return nil
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"amazonq-proxy/internal/breaker"
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/logging"
+	"amazonq-proxy/internal/metrics"
+	"amazonq-proxy/internal/promptcache"
+	"amazonq-proxy/internal/responsecache"
+	"amazonq-proxy/internal/version"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessRetryInterval 凭据池中没有可用凭据时，两次尝试刷新之间的最短间隔（避免探针频繁请求 OIDC 服务）
+const readinessRetryInterval = 30 * time.Second
+
+// startTime 进程启动时间
+var startTime = time.Now()
+
+var (
+	// readinessMutex 保证同一时间只有一个就绪检查在刷新凭据
+	readinessMutex sync.Mutex
+	// readinessAttempt 上一次尝试刷新凭据池凭据的时间
+	readinessAttempt time.Time
+	// readinessError 上一次尝试的错误
+	readinessError error
+)
+
+// errNoUsableCredential 凭据池中没有可以刷新的凭据
+var errNoUsableCredential = errors.New("no credential in the pools could be refreshed")
+
+// credentialCheck 就绪检查中的凭据状态
+type credentialCheck struct {
+	Mode       string `json:"mode"`            // pool（配置了凭据池）或 passthrough（客户端在请求中提供凭据）
+	Configured int    `json:"configured"`      // 凭据池中的凭据数
+	Usable     int    `json:"usable"`          // 已成功刷新 access token 的凭据数
+	Ready      bool   `json:"ready"`           // 是否至少有一个可用凭据（passthrough 模式下始终为 true）
+	Error      string `json:"error,omitempty"` // 最近一次刷新失败的原因
+}
+
+// upstreamCheck 就绪检查中的上游熔断器状态
+type upstreamCheck struct {
+	Breakers int  `json:"breakers"` // 参与检查的熔断器数量
+	Open     int  `json:"open"`     // 处于打开状态的熔断器数量
+	Ready    bool `json:"ready"`    // 是否至少有一个上游未熔断
+}
+
+// handleRoot 按 server.root_redirect 重定向，未配置时返回服务名称和版本
+func handleRoot(c *gin.Context) {
+	if target := config.Current().Server.RootRedirect; target != "" {
+		c.Redirect(http.StatusFound, target)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"name":    "amazonq-proxy",
+		"version": version.Get(),
+	})
+}
+
+// handleHealthz 存活检查：进程能够处理请求即返回 200
+func handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// handleReadyz 就绪检查：至少有一个凭据可以刷新，且至少有一个上游未熔断时返回 200，否则返回 503
+func handleReadyz(c *gin.Context) {
+	credentials := checkCredentials(c.Request.Context())
+	upstream := checkUpstream()
+
+	status, code := "ready", http.StatusOK
+	if !credentials.Ready || !upstream.Ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"status":      status,
+		"credentials": credentials,
+		"upstream":    upstream,
+	})
+}
+
+// checkCredentials 检查凭据池中是否有可用凭据
+// 没有已刷新的凭据时，按顺序尝试刷新凭据池中的凭据（最多每 readinessRetryInterval 一次），成功的凭据放入 token 缓存
+// 参数 ctx 为请求上下文
+// 返回凭据状态
+func checkCredentials(ctx context.Context) credentialCheck {
+	credentials := poolCredentials()
+	if len(credentials) == 0 {
+		return credentialCheck{Mode: "passthrough", Ready: true}
+	}
+
+	check := credentialCheck{Mode: "pool", Configured: len(credentials)}
+	check.Usable = countCached(credentials)
+	if check.Usable == 0 {
+		if err := warmPoolCredential(ctx, credentials); err != nil {
+			check.Error = logging.Redact(err.Error())
+		}
+		check.Usable = countCached(credentials)
+	}
+	check.Ready = check.Usable > 0
+	return check
+}
+
+// warmPoolCredential 依次刷新凭据池中的凭据，直到有一个成功
+// 参数 ctx 为请求上下文
+// 参数 credentials 为凭据池中的所有凭据
+// 返回所有凭据都刷新失败时的错误
+func warmPoolCredential(ctx context.Context, credentials []string) error {
+	readinessMutex.Lock()
+	defer readinessMutex.Unlock()
+
+	// 等待锁期间其他请求可能已经刷新成功
+	if countCached(credentials) > 0 {
+		return nil
+	}
+	if time.Since(readinessAttempt) < readinessRetryInterval {
+		if readinessError != nil {
+			return readinessError
+		}
+		return errNoUsableCredential
+	}
+
+	readinessAttempt = time.Now()
+	readinessError = errNoUsableCredential
+	for _, credential := range credentials {
+		tokenRegion, clientID, clientSecret, refreshToken := parseBearerToken(credential)
+		if clientID == "" || clientSecret == "" || refreshToken == "" {
+			continue
+		}
+		_, err := cacheCredential(ctx, "readiness", sha256Hash(credential), tokenRegion, clientID, clientSecret, refreshToken)
+		if err == nil {
+			readinessError = nil
+			return nil
+		}
+		readinessError = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return readinessError
+}
+
+// checkUpstream 检查上游熔断器：凭据池模式下只检查池中凭据的熔断器，所有熔断器都打开时视为未就绪
+// 返回熔断器状态
+func checkUpstream() upstreamCheck {
+	check := upstreamCheck{Ready: true}
+	if !config.Current().Breaker.Enabled {
+		return check
+	}
+
+	// 凭据池模式下只关心池中的凭据
+	var labels map[string]bool
+	if credentials := poolCredentials(); len(credentials) > 0 {
+		labels = make(map[string]bool, len(credentials))
+		for _, credential := range credentials {
+			labels[metrics.CredentialLabel(sha256Hash(credential))] = true
+		}
+	}
+
+	for _, status := range breaker.Snapshot() {
+		if labels != nil && !labels[status.Credential] {
+			continue
+		}
+		check.Breakers++
+		if status.State == breaker.StateOpen.String() {
+			check.Open++
+		}
+	}
+	check.Ready = check.Breakers == 0 || check.Open < check.Breakers
+	return check
+}
+
+// poolCredentials 返回所有凭据池中的凭据（去重，按凭据池名称排序）
+func poolCredentials() []string {
+	pools := config.Current().Credentials.Pools
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := make(map[string]bool)
+	var credentials []string
+	for _, name := range names {
+		for _, credential := range pools[name] {
+			if !seen[credential] {
+				seen[credential] = true
+				credentials = append(credentials, credential)
+			}
+		}
+	}
+	return credentials
+}
+
+// countCached 返回 token 缓存中已有 access token 的凭据数
+// 参数 credentials 为凭据列表
+func countCached(credentials []string) int {
+	tokenMutex.RLock()
+	defer tokenMutex.RUnlock()
+	count := 0
+	for _, credential := range credentials {
+		if _, ok := tokenMap[sha256Hash(credential)]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// poolCredentialStatus 凭据池中单个凭据的状态
+type poolCredentialStatus struct {
+	Credential  string     `json:"credential"`             // 凭据 SHA256 哈希前缀（与指标和熔断器一致）
+	Region      string     `json:"region"`                 // 凭据所在区域
+	Cached      bool       `json:"cached"`                 // 是否已有 access token
+	LastRefresh *time.Time `json:"last_refresh,omitempty"` // 最近一次刷新 access token 的时间
+}
+
+// poolStatus 凭据池状态
+type poolStatus struct {
+	Name        string                 `json:"name"`        // 凭据池名称
+	APIKeys     int                    `json:"api_keys"`    // 使用该凭据池的代理 API key 数量
+	Next        int                    `json:"next"`        // 下一次使用的凭据序号
+	Credentials []poolCredentialStatus `json:"credentials"` // 池中的凭据
+}
+
+// handleDebugStatus 返回版本、运行时长、凭据池、缓存、熔断器和运行时状态，用于排查问题
+func handleDebugStatus(c *gin.Context) {
+	cfg := config.Current()
+
+	caches := gin.H{
+		"prompt": gin.H{
+			"enabled": cfg.PromptCache.Enabled,
+			"stats":   promptcache.CurrentStats(),
+		},
+	}
+	if cache := responsecache.Current(); cache != nil {
+		caches["response"] = gin.H{"enabled": true, "backend": cfg.ResponseCache.Backend, "stats": cache.Stats()}
+	} else {
+		caches["response"] = gin.H{"enabled": false}
+	}
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	tokenMutex.RLock()
+	cachedTokens := len(tokenMap)
+	tokenMutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"version":          version.Get(),
+		"go_version":       runtime.Version(),
+		"started_at":       startTime,
+		"uptime_seconds":   int64(time.Since(startTime).Seconds()),
+		"goroutines":       runtime.NumGoroutine(),
+		"heap_alloc_bytes": memory.HeapAlloc,
+		"active_responses": ActiveResponses(),
+		"cached_tokens":    cachedTokens,
+		"pools":            snapshotPools(cfg.Credentials),
+		"caches":           caches,
+		"breakers":         breaker.Snapshot(),
+	})
+}
+
+// snapshotPools 返回所有凭据池的状态（按名称排序）
+// 参数 cfg 为凭据配置
+func snapshotPools(cfg config.CredentialsConfig) []poolStatus {
+	keys := make(map[string]int)
+	for _, key := range cfg.APIKeys {
+		keys[key.Pool]++
+	}
+
+	poolMutex.Lock()
+	cursors := make(map[string]int, len(poolCursors))
+	for name, cursor := range poolCursors {
+		cursors[name] = cursor
+	}
+	poolMutex.Unlock()
+
+	tokenMutex.RLock()
+	defer tokenMutex.RUnlock()
+
+	pools := make([]poolStatus, 0, len(cfg.Pools))
+	for name, credentials := range cfg.Pools {
+		pool := poolStatus{Name: name, APIKeys: keys[name], Credentials: make([]poolCredentialStatus, 0, len(credentials))}
+		if len(credentials) > 0 {
+			pool.Next = cursors[name] % len(credentials)
+		}
+		for _, credential := range credentials {
+			hash := sha256Hash(credential)
+			tokenRegion, _, _, _ := parseBearerToken(credential)
+			status := poolCredentialStatus{Credential: metrics.CredentialLabel(hash), Region: tokenRegion}
+			if cached, ok := tokenMap[hash]; ok {
+				lastRefresh := cached.LastRefresh
+				status.Cached = true
+				status.LastRefresh = &lastRefresh
+			}
+			pool.Credentials = append(pool.Credentials, status)
+		}
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	return pools
+}
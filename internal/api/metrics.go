@@ -0,0 +1,129 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"amazonq-proxy/internal/anthropic"
+	"amazonq-proxy/internal/breaker"
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/metrics"
+	"amazonq-proxy/internal/reload"
+
+	"github.com/gin-gonic/gin"
+)
+
+// breakerStates 熔断器状态在指标中的数值
+var breakerStates = map[string]float64{
+	breaker.StateClosed.String():   0,
+	breaker.StateHalfOpen.String(): 1,
+	breaker.StateOpen.String():     2,
+}
+
+func init() {
+	metrics.NewGaugeFunc("amazonq_proxy_active_responses",
+		"Messages requests currently being handled.",
+		nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(ActiveResponses())}}
+		})
+
+	metrics.NewGaugeFunc("amazonq_proxy_breaker_state",
+		"Upstream circuit breaker state (0 closed, 1 half-open, 2 open).",
+		[]string{"credential", "endpoint"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, status := range breaker.Snapshot() {
+				samples = append(samples, metrics.Sample{Values: []string{status.Credential, status.Endpoint}, Value: breakerStates[status.State]})
+			}
+			return samples
+		})
+	metrics.NewCounterFunc("amazonq_proxy_breaker_trips_total",
+		"Times an upstream circuit breaker opened.",
+		[]string{"credential", "endpoint"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, status := range breaker.Snapshot() {
+				samples = append(samples, metrics.Sample{Values: []string{status.Credential, status.Endpoint}, Value: float64(status.Trips)})
+			}
+			return samples
+		})
+	metrics.NewCounterFunc("amazonq_proxy_breaker_rejections_total",
+		"Requests rejected by an open upstream circuit breaker.",
+		[]string{"credential", "endpoint"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, status := range breaker.Snapshot() {
+				samples = append(samples, metrics.Sample{Values: []string{status.Credential, status.Endpoint}, Value: float64(status.Rejections)})
+			}
+			return samples
+		})
+
+	metrics.NewCounterFunc("amazonq_proxy_config_reloads_total",
+		"Configuration reloads by result (success or failure).",
+		[]string{"result"}, func() []metrics.Sample {
+			r := reload.Current()
+			if r == nil {
+				return nil
+			}
+			status := r.Status()
+			return []metrics.Sample{
+				{Values: []string{"success"}, Value: float64(status.Successes)},
+				{Values: []string{"failure"}, Value: float64(status.Failures)},
+			}
+		})
+}
+
+// handleMetrics 以 Prometheus 文本格式输出指标，server.metrics 关闭时返回 404
+func handleMetrics(c *gin.Context) {
+	if !config.Current().Server.Metrics {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Metrics endpoint is disabled",
+		})
+		return
+	}
+	metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
+
+// observeRequest 记录消息请求的数量和总耗时，在处理器返回时调用
+// 参数 c 为 Gin 上下文
+// 参数 model 为模型名称（请求解析失败时为空）
+// 参数 stream 表示是否流式响应
+// 参数 start 为收到请求的时间
+func observeRequest(c *gin.Context, model string, stream bool, start time.Time) {
+	streamLabel := strconv.FormatBool(stream)
+	metrics.Requests.Inc(model, strconv.Itoa(c.Writer.Status()), streamLabel)
+	metrics.RequestDuration.Observe(time.Since(start).Seconds(), model, streamLabel)
+}
+
+// observeStream 转发上游的流式事件，同时记录首个内容增量的时间、输出 token 数和凭据的 token 用量
+// 参数 streamChan 为源事件通道
+// 参数 model 为模型名称
+// 参数 stream 表示是否流式响应
+// 参数 credentialHash 为 Amazon Q 凭据的 SHA256 哈希
+// 参数 start 为收到请求的时间
+// 返回转发后的事件通道
+func observeStream(streamChan chan anthropic.StreamEvent, model string, stream bool, credentialHash string, start time.Time) chan anthropic.StreamEvent {
+	credential := metrics.CredentialLabel(credentialHash)
+
+	out := make(chan anthropic.StreamEvent, 100)
+	go func() {
+		defer close(out)
+		firstToken := false
+		for event := range streamChan {
+			switch e := event.(type) {
+			case anthropic.MessageStartEvent:
+				usage := e.Message.Usage
+				input := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+				metrics.CredentialTokens.Add(float64(input), credential, "input")
+			case anthropic.ContentBlockDeltaEvent:
+				if !firstToken {
+					firstToken = true
+					metrics.TimeToFirstToken.Observe(time.Since(start).Seconds(), model, strconv.FormatBool(stream))
+				}
+			case anthropic.MessageDeltaEvent:
+				metrics.OutputTokens.Observe(float64(e.Usage.OutputTokens), model)
+				metrics.CredentialTokens.Add(float64(e.Usage.OutputTokens), credential, "output")
+			}
+			out <- event
+		}
+	}()
+	return out
+}
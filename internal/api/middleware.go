@@ -2,17 +2,27 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"amazonq-proxy/internal/amazonq"
 	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/httpclient"
+	"amazonq-proxy/internal/logging"
+	"amazonq-proxy/internal/metrics"
+	"amazonq-proxy/internal/region"
+	"amazonq-proxy/internal/tracing"
+	"amazonq-proxy/internal/usage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -24,9 +34,13 @@ type TokenCache struct {
 	RefreshToken string
 	ClientID     string
 	ClientSecret string
+	Region       string
 	LastRefresh  time.Time
 }
 
+// tokenRefreshTimeout OIDC 刷新请求的超时时间（共享的上游客户端为流式响应设计，可能不限制请求总时长）
+const tokenRefreshTimeout = 60 * time.Second
+
 var (
 	// tokenMap Token 缓存映射
 	tokenMap = make(map[string]*TokenCache)
@@ -42,23 +56,32 @@ func sha256Hash(text string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// parseBearerToken 解析 Bearer token 格式: clientId:clientSecret:refreshToken
+// parseBearerToken 解析 Bearer token 格式: [region:]clientId:clientSecret:refreshToken
+// 以 AWS 区域名称开头时使用该区域，否则使用默认区域
 // 参数 bearerToken 为完整的 token 字符串
-// 返回 clientId, clientSecret, refreshToken
-func parseBearerToken(bearerToken string) (string, string, string) {
+// 返回 region, clientId, clientSecret, refreshToken
+func parseBearerToken(bearerToken string) (string, string, string, string) {
+	tokenRegion := region.Default()
+	if prefix, rest, ok := strings.Cut(bearerToken, ":"); ok && region.Valid(prefix) {
+		tokenRegion, bearerToken = prefix, rest
+	}
+
 	parts := strings.SplitN(bearerToken, ":", 3)
 	if len(parts) < 3 {
-		return "", "", ""
+		return "", "", "", ""
 	}
-	return parts[0], parts[1], parts[2]
+	return tokenRegion, parts[0], parts[1], parts[2]
 }
 
 // handleTokenRefresh 使用 refresh token 获取新的 access token
+// 参数 ctx 为上下文（用于追踪）
+// 参数 tokenRegion 为凭据所在区域
+// 参数 profile 为上游请求头配置名称
 // 参数 clientID 为客户端 ID
 // 参数 clientSecret 为客户端密钥
 // 参数 refreshToken 为刷新令牌
 // 返回新的 access token 和可能的错误
-func handleTokenRefresh(clientID, clientSecret, refreshToken string) (string, error) {
+func handleTokenRefresh(ctx context.Context, tokenRegion, profile, clientID, clientSecret, refreshToken string) (string, error) {
 	payload := map[string]string{
 		"grantType":    "refresh_token",
 		"clientId":     clientID,
@@ -68,27 +91,37 @@ func handleTokenRefresh(clientID, clientSecret, refreshToken string) (string, er
 
 	payloadBytes, _ := json.Marshal(payload)
 
-	req, err := http.NewRequest("POST", config.TokenURL, bytes.NewReader(payloadBytes))
+	ctx, span := tracing.Start(ctx, "oidc.token_refresh", tracing.KindClient, tracing.String("cloud.region", tokenRegion))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, tokenRefreshTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", region.TokenEndpoint(tokenRegion), bytes.NewReader(payloadBytes))
 	if err != nil {
+		span.RecordError(err)
 		return "", err
 	}
 
 	// 设置 OIDC 请求头
-	for k, v := range config.OIDCHeaders {
+	for k, v := range amazonq.OIDCProfileHeaders(profile) {
 		req.Header.Set(k, v)
 	}
 	req.Header.Set("amz-sdk-invocation-id", uuid.New().String())
 
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
+	// 使用共享的上游客户端，与 Amazon Q 请求使用相同的代理、连接超时和连接池
+	resp, err := httpclient.Upstream().Do(req)
 	if err != nil {
+		span.RecordError(err)
 		return "", err
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("token refresh failed: %d - %s", resp.StatusCode, string(body))
+		err := fmt.Errorf("token refresh failed: %d (x-amzn-requestid %s) - %s", resp.StatusCode, resp.Header.Get("x-amzn-requestid"), string(body))
+		span.RecordError(err)
+		return "", err
 	}
 
 	var result map[string]interface{}
@@ -104,87 +137,226 @@ func handleTokenRefresh(clientID, clientSecret, refreshToken string) (string, er
 	return accessToken, nil
 }
 
-// AuthMiddleware 认证中间件，支持 OpenAI Bearer token 和 Claude x-api-key 两种格式
-// 验证通过后会将 access token 等信息存入上下文
-func AuthMiddleware() gin.HandlerFunc {
+// RequestIDMiddleware 为每个请求生成 ID，通过 request-id 响应头返回
+// 该 ID 存入上下文（requestId）并作为上游请求的 amz-sdk-invocation-id，日志中以 request_id 出现
+func RequestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 优先使用 x-api-key（Claude 格式）
-		token := c.GetHeader("x-api-key")
-
-		// 如果没有 x-api-key，尝试从 Authorization header 获取（OpenAI 格式）
-		if token == "" {
-			auth := c.GetHeader("Authorization")
-			if strings.HasPrefix(auth, "Bearer ") {
-				token = strings.TrimPrefix(auth, "Bearer ")
+		id := uuid.New().String()
+		c.Set("requestId", id)
+		c.Header("request-id", id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// probePaths 存活和就绪探针的路径，访问日志使用较低的级别
+var probePaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// AccessLogMiddleware 请求结束后记录访问日志（不包含查询参数和请求头）
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		switch {
+		case probePaths[c.Request.URL.Path]:
+			// 探针请求频繁，成功时仅在 debug 级别记录，未就绪时记录为警告
+			level = slog.LevelDebug
+			if c.Writer.Status() >= 500 {
+				level = slog.LevelWarn
 			}
+		case c.Writer.Status() >= 500:
+			level = slog.LevelError
 		}
+		slog.Log(c.Request.Context(), level, "request",
+			"component", "http",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
 
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Missing authentication. Provide Authorization header or x-api-key",
-			})
-			c.Abort()
-			return
+// TracingMiddleware 为每个请求创建服务端 span，请求携带 W3C traceparent 时加入调用方的 trace
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if parent, ok := tracing.ParseTraceparent(c.GetHeader("traceparent")); ok {
+			ctx = tracing.ContextWithRemote(ctx, parent)
 		}
 
-		tokenHash := sha256Hash(token)
+		route := c.FullPath()
+		name := c.Request.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := tracing.Start(ctx, name, tracing.KindServer,
+			tracing.String("http.request.method", c.Request.Method),
+			tracing.String("url.path", c.Request.URL.Path),
+			tracing.String("http.route", route),
+			tracing.String("request_id", c.GetString("requestId")),
+		)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
 
-		// 检查缓存
-		tokenMutex.RLock()
-		cached, exists := tokenMap[tokenHash]
-		tokenMutex.RUnlock()
+		status := c.Writer.Status()
+		span.SetAttributes(tracing.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(tracing.StatusError, http.StatusText(status))
+		}
+		span.End()
+	}
+}
 
-		if exists {
-			c.Set("accessToken", cached.AccessToken)
-			c.Set("clientId", cached.ClientID)
-			c.Set("clientSecret", cached.ClientSecret)
-			c.Set("refreshToken", cached.RefreshToken)
+// AuthMiddleware 认证中间件，支持 OpenAI Bearer token 和 Claude x-api-key 两种格式
+// 验证通过后会将 access token 等信息存入上下文
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, span := tracing.Start(c.Request.Context(), "auth", tracing.KindInternal)
+		ok := authenticate(c, ctx, span)
+		span.End()
+		if ok {
 			c.Next()
-			return
 		}
+	}
+}
 
-		// 解析 token
-		clientID, clientSecret, refreshToken := parseBearerToken(token)
-		if clientID == "" || clientSecret == "" || refreshToken == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token format. Expected: clientId:clientSecret:refreshToken",
-			})
-			c.Abort()
-			return
+// authenticate 解析请求中的凭据，必要时刷新 access token，并将结果存入上下文
+// 参数 c 为 Gin 上下文
+// 参数 ctx 为 auth span 的上下文
+// 参数 span 为 auth span
+// 返回是否认证通过（失败时已写出错误响应）
+func authenticate(c *gin.Context, ctx context.Context, span *tracing.Span) bool {
+	// 优先使用 x-api-key（Claude 格式）
+	token := c.GetHeader("x-api-key")
+
+	// 如果没有 x-api-key，尝试从 Authorization header 获取（OpenAI 格式）
+	if token == "" {
+		auth := c.GetHeader("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
 		}
+	}
 
-		// 刷新 token
-		accessToken, err := handleTokenRefresh(clientID, clientSecret, refreshToken)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": fmt.Sprintf("Failed to refresh access token: %v", err),
-			})
-			c.Abort()
-			return
-		}
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Missing authentication. Provide Authorization header or x-api-key",
+		})
+		c.Abort()
+		return false
+	}
 
-		// 缓存
-		tokenMutex.Lock()
-		tokenMap[tokenHash] = &TokenCache{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			LastRefresh:  time.Now(),
-		}
-		tokenMutex.Unlock()
+	// apiKeyHash 标识客户端（用于缓存隔离），credentialHash 标识实际使用的 Amazon Q 凭据
+	apiKeyHash := sha256Hash(token)
+	c.Set("apiKeyHash", apiKeyHash)
 
-		c.Set("accessToken", accessToken)
-		c.Set("clientId", clientID)
-		c.Set("clientSecret", clientSecret)
-		c.Set("refreshToken", refreshToken)
-		c.Next()
+	// 配置中的代理 API key 轮流使用对应凭据池中的凭据
+	credential, key := resolvePoolCredential(token)
+	if key != nil {
+		token = credential
+	}
+
+	// usageKey 为用量统计中的 key 名称，quota 为该 key 的配额
+	c.Set("usageKey", usage.KeyName(key, apiKeyHash))
+	c.Set("quota", usage.QuotaFor(key))
+	// promptTemplate 为该 key 指定的提示词框架模板，为空时按模型选择
+	if key != nil {
+		c.Set("promptTemplate", key.Template)
+	}
+
+	tokenHash := sha256Hash(token)
+	c.Set("credentialHash", tokenHash)
+
+	// 检查缓存
+	tokenMutex.RLock()
+	cached, exists := tokenMap[tokenHash]
+	tokenMutex.RUnlock()
+
+	if exists {
+		c.Set("accessToken", cached.AccessToken)
+		c.Set("clientId", cached.ClientID)
+		c.Set("clientSecret", cached.ClientSecret)
+		c.Set("refreshToken", cached.RefreshToken)
+		c.Set("region", cached.Region)
+		span.SetAttributes(tracing.Bool("auth.cached", true))
+		return true
+	}
+
+	// 解析 token
+	tokenRegion, clientID, clientSecret, refreshToken := parseBearerToken(token)
+	if clientID == "" || clientSecret == "" || refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid token format. Expected: [region:]clientId:clientSecret:refreshToken",
+		})
+		c.Abort()
+		return false
+	}
+
+	// 刷新 token
+	entry, err := cacheCredential(ctx, "request", tokenHash, tokenRegion, clientID, clientSecret, refreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": fmt.Sprintf("Failed to refresh access token: %v", err),
+		})
+		c.Abort()
+		return false
+	}
+
+	c.Set("accessToken", entry.AccessToken)
+	c.Set("clientId", clientID)
+	c.Set("clientSecret", clientSecret)
+	c.Set("refreshToken", refreshToken)
+	c.Set("region", tokenRegion)
+	span.SetAttributes(tracing.Bool("auth.cached", false))
+	return true
+}
+
+// cacheCredential 使用 refresh token 获取 access token 并放入 token 缓存
+// 参数 ctx 为上下文
+// 参数 source 为刷新来源（request 或 readiness），用于指标
+// 参数 tokenHash 为凭据的 SHA256 哈希
+// 参数 tokenRegion 为凭据所在区域
+// 参数 clientID 为客户端 ID
+// 参数 clientSecret 为客户端密钥
+// 参数 refreshToken 为 refresh token
+// 返回缓存项和可能的错误
+func cacheCredential(ctx context.Context, source, tokenHash, tokenRegion, clientID, clientSecret, refreshToken string) (*TokenCache, error) {
+	accessToken, err := handleTokenRefresh(ctx, tokenRegion, amazonq.SelectProfile("", tokenHash), clientID, clientSecret, refreshToken)
+	if err != nil {
+		metrics.TokenRefreshes.Inc(source, "failure")
+		return nil, err
+	}
+
+	metrics.TokenRefreshes.Inc(source, "success")
+
+	entry := &TokenCache{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Region:       tokenRegion,
+		LastRefresh:  time.Now(),
+	}
+	tokenMutex.Lock()
+	// 登记有效的凭据，确保之后的任何日志都不会包含这些值；被替换的缓存项在同一把锁内注销，
+	// 保证登记次数与缓存中引用这些值的次数一致
+	logging.RegisterSecret(clientSecret, refreshToken, accessToken)
+	if previous := tokenMap[tokenHash]; previous != nil {
+		logging.ForgetSecret(previous.ClientSecret, previous.RefreshToken, previous.AccessToken)
 	}
+	tokenMap[tokenHash] = entry
+	tokenMutex.Unlock()
+	return entry, nil
 }
 
 // RefreshAllTokens 全局刷新器，遍历并刷新所有缓存的 token
-// 刷新失败的 token 会从缓存中移除
-func RefreshAllTokens() {
+// 刷新失败的 token 会从缓存中移除；上下文取消时中断刷新，尚未刷新和被中断的 token 保留在缓存中
+// 参数 ctx 为上下文，停止刷新器时取消
+func RefreshAllTokens(ctx context.Context) {
 	tokenMutex.RLock()
 	count := len(tokenMap)
 	tokenMutex.RUnlock()
@@ -193,7 +365,7 @@ func RefreshAllTokens() {
 		return
 	}
 
-	fmt.Printf("[Token Refresher] Starting token refresh cycle...\n")
+	slog.Info("starting token refresh cycle", "component", "token_refresher", "tokens", count)
 	refreshCount := 0
 
 	tokenMutex.RLock()
@@ -204,37 +376,99 @@ func RefreshAllTokens() {
 	tokenMutex.RUnlock()
 
 	for hash, cache := range tokens {
-		newToken, err := handleTokenRefresh(cache.ClientID, cache.ClientSecret, cache.RefreshToken)
+		if ctx.Err() != nil {
+			slog.Info("token refresh cycle interrupted", "component", "token_refresher", "refreshed", refreshCount, "tokens", count)
+			return
+		}
+		newToken, err := handleTokenRefresh(ctx, cache.Region, amazonq.SelectProfile("", hash), cache.ClientID, cache.ClientSecret, cache.RefreshToken)
+		if err != nil && ctx.Err() != nil {
+			continue
+		}
 		if err != nil {
-			fmt.Printf("[Token Refresher] Failed to refresh token for hash: %s...: %v, removing from cache\n", hash[:8], err)
+			metrics.TokenRefreshes.Inc("refresher", "failure")
+			slog.Warn("failed to refresh token, removing from cache", "component", "token_refresher", "credential_hash", hash[:8], "error", err)
 			tokenMutex.Lock()
-			delete(tokenMap, hash)
+			// 刷新期间缓存项可能已被请求重新获取的凭据替换，只移除本次刷新的缓存项
+			if tokenMap[hash] == cache {
+				logging.ForgetSecret(cache.ClientSecret, cache.RefreshToken, cache.AccessToken)
+				delete(tokenMap, hash)
+			}
 			tokenMutex.Unlock()
 			continue
 		}
 
+		metrics.TokenRefreshes.Inc("refresher", "success")
 		tokenMutex.Lock()
-		if tokenMap[hash] != nil {
-			tokenMap[hash].AccessToken = newToken
-			tokenMap[hash].LastRefresh = time.Now()
+		// 缓存项已被移除或替换时丢弃新 token，不登记，避免登记后无人注销
+		if tokenMap[hash] == cache {
+			logging.RegisterSecret(newToken)
+			logging.ForgetSecret(cache.AccessToken)
+			cache.AccessToken = newToken
+			cache.LastRefresh = time.Now()
 		}
 		tokenMutex.Unlock()
 
 		refreshCount++
 	}
 
-	fmt.Printf("[Token Refresher] Refreshed %d/%d tokens\n", refreshCount, count)
+	slog.Info("token refresh cycle finished", "component", "token_refresher", "refreshed", refreshCount, "tokens", count)
 }
 
 // StartTokenRefresher 启动定时 token 刷新器
 // 在后台 goroutine 中每 45 分钟自动刷新所有缓存的 token
-func StartTokenRefresher() {
+// 返回停止函数，取消进行中的刷新并等待刷新器退出
+func StartTokenRefresher() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	exited := make(chan struct{})
 	go func() {
+		defer close(exited)
 		ticker := time.NewTicker(45 * time.Minute)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			RefreshAllTokens()
+		for {
+			select {
+			case <-ticker.C:
+				RefreshAllTokens(ctx)
+			case <-done:
+				slog.Info("token refresher stopped", "component", "token_refresher")
+				return
+			}
 		}
 	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			close(done)
+		})
+		<-exited
+	}
+}
+
+// AdminMiddleware 管理端点认证中间件，校验 Authorization: Bearer <ADMIN_TOKEN>
+// 未配置 ADMIN_TOKEN 时管理端点不可用
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminToken := config.Current().Server.AdminToken
+		if adminToken == "" {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Admin endpoints are disabled. Set ADMIN_TOKEN to enable them",
+			})
+			c.Abort()
+			return
+		}
+
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid admin token",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
 }
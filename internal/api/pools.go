@@ -0,0 +1,50 @@
+package api
+
+import (
+	"crypto/subtle"
+	"sync"
+
+	"amazonq-proxy/internal/config"
+)
+
+var (
+	// poolCursors 凭据池名称 -> 下一次使用的凭据序号
+	poolCursors = make(map[string]int)
+	// poolMutex 凭据池游标互斥锁
+	poolMutex sync.Mutex
+)
+
+// resolvePoolCredential 将配置中的代理 API key 映射为凭据池中的 Amazon Q 凭据
+// 同一凭据池中的凭据按请求轮流使用
+// 参数 token 为客户端提供的 API key
+// 返回凭据（[region:]clientId:clientSecret:refreshToken）和匹配到的代理 API key，未匹配时为 nil
+func resolvePoolCredential(token string) (string, *config.APIKeyConfig) {
+	cfg := config.Current().Credentials
+	for i := range cfg.APIKeys {
+		key := &cfg.APIKeys[i]
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) != 1 {
+			continue
+		}
+		creds := cfg.Pools[key.Pool]
+		if len(creds) == 0 {
+			return "", nil
+		}
+
+		poolMutex.Lock()
+		index := poolCursors[key.Pool] % len(creds)
+		poolCursors[key.Pool] = index + 1
+		poolMutex.Unlock()
+		return creds[index], key
+	}
+	return "", nil
+}
+
+// resolveModel 按 models.aliases 将请求中的模型别名替换为发送给 Amazon Q 的模型
+// 参数 model 为请求中的模型名称
+// 返回实际使用的模型名称
+func resolveModel(model string) string {
+	if target, ok := config.Current().Models.Aliases[model]; ok {
+		return target
+	}
+	return model
+}
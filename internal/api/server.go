@@ -3,22 +3,39 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"amazonq-proxy/internal/accumulator"
 	"amazonq-proxy/internal/amazonq"
+	"amazonq-proxy/internal/anthropic"
+	"amazonq-proxy/internal/breaker"
+	"amazonq-proxy/internal/config"
 	"amazonq-proxy/internal/core"
+	"amazonq-proxy/internal/license"
+	"amazonq-proxy/internal/metrics"
+	"amazonq-proxy/internal/prompt"
+	"amazonq-proxy/internal/promptcache"
+	"amazonq-proxy/internal/region"
+	"amazonq-proxy/internal/responsecache"
+	"amazonq-proxy/internal/tracing"
+	"amazonq-proxy/internal/usage"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
 // SetupRouter 设置路由，配置 CORS 中间件和 API 端点
 // 返回配置完成的 Gin 引擎实例
 func SetupRouter() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(RequestIDMiddleware(), TracingMiddleware(), AccessLogMiddleware(), gin.Recovery())
 
 	// CORS 中间件
 	router.Use(func(c *gin.Context) {
@@ -34,21 +51,45 @@ func SetupRouter() *gin.Engine {
 		c.Next()
 	})
 
-	// 根路径重定向
-	router.GET("/", func(c *gin.Context) {
-		c.Redirect(http.StatusFound, "https://www.bilibili.com/video/BV1SMH5zfEwe/?spm_id_from=333.337.search-card.all.click&vd_source=1f3b8eb28230105c578a443fa6481550")
-	})
+	// 根路径：按 server.root_redirect 重定向，未配置时返回服务名称和版本
+	router.GET("/", handleRoot)
+
+	// 存活和就绪检查
+	router.GET("/healthz", handleHealthz)
+	router.GET("/readyz", handleReadyz)
 
 	// Claude 兼容的消息端点
 	router.POST("/v1/messages", AuthMiddleware(), handleClaudeMessages)
 
+	// Prometheus 指标
+	router.GET("/metrics", handleMetrics)
+
+	// 管理端点
+	admin := router.Group("/admin", AdminMiddleware())
+	admin.DELETE("/cache", handlePurgeResponseCache)
+	admin.GET("/breakers", handleListBreakers)
+	admin.DELETE("/breakers", handleResetBreakers)
+	admin.GET("/config", handleGetConfig)
+	admin.POST("/config/reload", handleReloadConfig)
+	admin.GET("/usage", handleAdminUsage)
+	admin.GET("/usage/quotas", handleAdminQuotas)
+
+	// 诊断端点（与管理端点使用同一令牌）
+	debug := router.Group("/debug", AdminMiddleware())
+	debug.GET("/status", handleDebugStatus)
+
 	return router
 }
 
 // handleClaudeMessages 处理 Claude 兼容的消息请求
 // 支持流式和非流式响应，将 Claude 请求转换为 Amazon Q 格式并返回结果
 func handleClaudeMessages(c *gin.Context) {
+	activeResponses.Add(1)
+	defer activeResponses.Add(-1)
+
+	start := time.Now()
 	var req core.ClaudeRequest
+	defer func() { observeRequest(c, req.Model, req.Stream, start) }()
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -57,8 +98,79 @@ func handleClaudeMessages(c *gin.Context) {
 		return
 	}
 
+	// 按 API key 的每日和每月配额限制请求
+	if !checkQuota(c) {
+		return
+	}
+
+	// 合并 x-amazonq-* 请求头中的消息上下文
+	if err := applyAmazonQContextHeaders(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	// 按 models.aliases 替换模型别名
+	req.Model = resolveModel(req.Model)
+
+	// 按 API key 和模型选择提示词框架模板
+	req.PromptTemplate = prompt.Current().SelectName(req.Model, c.GetString("promptTemplate"))
+
+	// 按 cache_control 断点计算输入 token 和提示词缓存用量
+	// 提示词缓存只在上游接受请求后写入
+	inputUsage, commitPromptCache, err := promptcache.Compute(req, c.GetString("apiKeyHash"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	// 响应缓存：命中时直接回放缓存的事件
+	// 请求头 Cache-Control: no-cache 跳过查询但仍写入，no-store 完全跳过缓存
+	cache := responsecache.Current()
+	cacheDirective := strings.ToLower(c.GetHeader("Cache-Control"))
+	var cacheKey string
+	if cache != nil && responsecache.Cacheable(req) && !strings.Contains(cacheDirective, "no-store") {
+		cacheKey = responsecache.Key(req, c.GetString("apiKeyHash"))
+		if !strings.Contains(cacheDirective, "no-cache") {
+			if entry, ok := cache.Get(cacheKey); ok {
+				c.Header("x-cache", "HIT")
+				c.Header("Age", strconv.Itoa(int(time.Since(entry.CreatedAt).Seconds())))
+				msg := entry.Message
+				msg.ID = amazonq.GenerateMessageID()
+				writeClaudeResponse(c, req, replayEvents(anthropic.MessageEvents(msg)))
+				return
+			}
+		}
+		c.Header("x-cache", "MISS")
+	} else if cache != nil {
+		c.Header("x-cache", "BYPASS")
+	}
+
 	// 1. 转换请求
+	_, convertSpan := tracing.Start(c.Request.Context(), "claude.convert", tracing.KindInternal,
+		tracing.String("gen_ai.request.model", req.Model),
+		tracing.Int("claude.message_count", len(req.Messages)),
+		tracing.Int("claude.tool_count", len(req.Tools)),
+	)
 	aqRequest, err := core.ConvertClaudeToAmazonQRequest(req, "")
+	// 图片下载和上游请求在客户端断开或停机截止时间到达时取消，上游响应体随之关闭；
+	// 请求上下文中已有请求 ID 和本请求的 span。图片处理结果在强制工具调用的追加轮次中复用
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	defer context.AfterFunc(responseCtx, cancel)()
+	images := core.NewImagePipeline(ctx)
+	if err == nil {
+		images.Apply(&aqRequest)
+	}
+	convertSpan.SetAttributes(
+		tracing.Int("claude.image_count", core.ImageCount(aqRequest)),
+		tracing.Int("claude.warning_count", len(aqRequest.Warnings)),
+	)
+	convertSpan.RecordError(err)
+	convertSpan.End()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf("Request conversion failed: %v", err),
@@ -66,6 +178,11 @@ func handleClaudeMessages(c *gin.Context) {
 		return
 	}
 
+	// 转换警告（如被丢弃或缩放的图片）通过响应头返回
+	if len(aqRequest.Warnings) > 0 {
+		c.Header("x-amazonq-warning", strings.Join(aqRequest.Warnings, "; "))
+	}
+
 	// 2. 获取 access token
 	accessToken, exists := c.Get("accessToken")
 	if !exists {
@@ -75,24 +192,81 @@ func handleClaudeMessages(c *gin.Context) {
 		return
 	}
 
-	// 将 aqRequest 转换为 map[string]interface{}
-	var rawPayload map[string]interface{}
-	jsonBytes, _ := json.Marshal(aqRequest)
-	json.Unmarshal(jsonBytes, &rawPayload)
-
 	// 3. 发送上游请求
-	ctx := context.Background()
-	eventChan, err := amazonq.SendChatRequest(ctx, accessToken.(string), rawPayload, true)
+	cred := upstreamCredential{
+		AccessToken: accessToken.(string),
+		KeyHash:     c.GetString("credentialHash"),
+		Region:      c.GetString("region"),
+	}
+	cred.HeaderProfile = amazonq.SelectProfile(req.Model, cred.KeyHash)
+	if cred.Region == "" {
+		cred.Region = region.Default()
+	}
+	metrics.CredentialRequests.Inc(metrics.CredentialLabel(cred.KeyHash))
+	handler, streamChan, err := sendAmazonQRequest(ctx, cred, aqRequest, req, inputUsage)
+	var openErr *breaker.OpenError
+	if errors.As(err, &openErr) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(openErr.RetryAfter.Seconds()))))
+		c.JSON(errorStatus("overloaded_error"), anthropic.ErrorResponse("overloaded_error", err.Error()))
+		return
+	}
+	if err != nil && aborted() {
+		c.JSON(errorStatus("overloaded_error"), anthropic.ErrorResponse("overloaded_error", shutdownMessage))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error": fmt.Sprintf("Failed to send request: %v", err),
-		})
+		slog.ErrorContext(ctx, "upstream request failed", "component", "amazonq", "model", req.Model, "error", err)
+		c.JSON(http.StatusBadGateway, anthropic.ErrorResponse("api_error", fmt.Sprintf("Failed to send request: %v", err)))
 		return
 	}
+	commitPromptCache()
 
-	// 4. 流处理器
-	handler := amazonq.NewClaudeStreamHandler(req.Model, 0)
-	sseChan := amazonq.ProcessEventStream(eventChan, handler)
+	// 4. 强制工具调用时检查响应，必要时追加轮次
+	if core.IsToolChoiceForced(req.ToolChoice) {
+		streamChan = enforceToolChoice(ctx, req, handler, streamChan, func(followUp core.ClaudeRequest) (*amazonq.ClaudeStreamHandler, chan anthropic.StreamEvent, error) {
+			aqRequest, err := core.ConvertClaudeToAmazonQRequest(followUp, "")
+			if err != nil {
+				return nil, nil, err
+			}
+			// 追加轮次复用首轮已处理的图片，沿用原始请求的输入 token 和提示词缓存用量
+			images.Apply(&aqRequest)
+			return sendAmazonQRequest(ctx, cred, aqRequest, followUp, inputUsage)
+		})
+	}
+	streamChan = observeStream(streamChan, req.Model, req.Stream, cred.KeyHash, start)
+	streamChan = recordUsage(streamChan, usage.Dimensions{
+		Key:     c.GetString("usageKey"),
+		Account: metrics.CredentialLabel(cred.KeyHash),
+		Model:   req.Model,
+	}, core.ImageCount(aqRequest))
+
+	// 缓存完整的响应；客户端断开或停机中止时响应可能不完整，不写入缓存
+	if cacheKey != "" {
+		streamChan = recordMessage(streamChan, func(acc *accumulator.MessageAccumulator) {
+			if msg := acc.Message(); acc.Complete() && len(msg.Content) > 0 && ctx.Err() == nil {
+				cache.Set(cacheKey, msg)
+			}
+		})
+	}
+
+	writeClaudeResponse(c, req, streamChan)
+}
+
+// writeClaudeResponse 将流式事件写出为 SSE 流或累积为完整的 JSON 响应
+// 参数 c 为 Gin 上下文
+// 参数 req 为原始 Claude 请求
+// 参数 streamChan 为流式事件通道
+func writeClaudeResponse(c *gin.Context, req core.ClaudeRequest, streamChan chan anthropic.StreamEvent) {
+	_, span := tracing.Start(c.Request.Context(), "response.write", tracing.KindInternal, tracing.Bool("claude.stream", req.Stream))
+	defer span.End()
+
+	// 客户端断开或停机提前返回时继续读取剩余事件，使转发事件的 goroutine 在上游请求取消后退出
+	defer func() {
+		go func() {
+			for range streamChan {
+			}
+		}()
+	}()
 
 	if req.Stream {
 		// 流式响应
@@ -100,112 +274,278 @@ func handleClaudeMessages(c *gin.Context) {
 		c.Header("Cache-Control", "no-cache")
 		c.Header("Connection", "keep-alive")
 
+		metrics.ActiveStreams.Inc()
+		defer metrics.ActiveStreams.Dec()
+
 		c.Stream(func(w io.Writer) bool {
-			if event, ok := <-sseChan; ok {
-				// 直接写入，因为 FormatSSE 已经包含了完整的 SSE 格式
-				w.Write([]byte(event))
+			if aborted() {
+				// 停机截止时间已到，告知客户端响应未完成
+				w.Write([]byte(anthropic.FormatSSE(anthropic.ErrorEvent{ErrorType: "overloaded_error", Message: shutdownMessage})))
 				c.Writer.Flush()
-				return true
+				return false
 			}
-			return false
-		})
-	} else {
-		// 非流式：累积响应
-		var finalContent []interface{}
-		usage := map[string]int{"input_tokens": 0, "output_tokens": 0}
-		var stopReason *string
-
-		for sseEvent := range sseChan {
-			// 解析 SSE 事件格式: "event: xxx\ndata: {...}\n\n"
-			lines := strings.Split(sseEvent, "\n")
-			var dataStr string
-			for _, line := range lines {
-				if strings.HasPrefix(line, "data: ") {
-					dataStr = strings.TrimPrefix(line, "data: ")
-					break
+			select {
+			case event, ok := <-streamChan:
+				if !ok {
+					return false
 				}
+				w.Write([]byte(anthropic.FormatSSE(event)))
+			case <-responseCtx.Done():
+				return true
+			case <-c.Request.Context().Done():
+				return false
 			}
+			c.Writer.Flush()
+			return true
+		})
+		return
+	}
 
-			if dataStr == "" || dataStr == "[DONE]" {
-				continue
+	// 非流式：累积响应
+	acc := accumulator.New()
+	for done := false; !done; {
+		select {
+		case event, ok := <-streamChan:
+			if !ok {
+				done = true
+				break
+			}
+			if err := acc.Add(event); err != nil {
+				slog.WarnContext(c.Request.Context(), "ignoring event", "component", "accumulator", "event", event.EventType(), "error", err)
 			}
+		case <-responseCtx.Done():
+			c.JSON(errorStatus("overloaded_error"), anthropic.ErrorResponse("overloaded_error", shutdownMessage))
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+	if e := acc.Err(); e != nil {
+		c.JSON(errorStatus(e.ErrorType), anthropic.ErrorResponse(e.ErrorType, e.Message))
+		return
+	}
+	c.JSON(http.StatusOK, acc.Message())
+}
+
+// errorStatus 返回 Claude API 错误类型对应的 HTTP 状态码
+// 参数 errorType 为错误类型
+// 返回 HTTP 状态码
+func errorStatus(errorType string) int {
+	switch errorType {
+	case "invalid_request_error":
+		return http.StatusBadRequest
+	case "permission_error":
+		return http.StatusForbidden
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "overloaded_error":
+		return 529
+	}
+	return http.StatusInternalServerError
+}
+
+// replayEvents 将已缓冲的事件序列转换为已关闭的事件通道
+// 参数 events 为流式事件序列
+// 返回流式事件通道
+func replayEvents(events []anthropic.StreamEvent) chan anthropic.StreamEvent {
+	replay := make(chan anthropic.StreamEvent, len(events))
+	for _, event := range events {
+		replay <- event
+	}
+	close(replay)
+	return replay
+}
+
+// recordMessage 转发流式事件并同时累积消息，通道关闭后回调累积器
+// 参数 streamChan 为源事件通道
+// 参数 done 为通道关闭后的回调
+// 返回转发后的事件通道
+func recordMessage(streamChan chan anthropic.StreamEvent, done func(acc *accumulator.MessageAccumulator)) chan anthropic.StreamEvent {
+	out := make(chan anthropic.StreamEvent, 100)
+	go func() {
+		defer close(out)
+		acc := accumulator.New()
+		for event := range streamChan {
+			acc.Add(event)
+			out <- event
+		}
+		done(acc)
+	}()
+	return out
+}
+
+// upstreamCredential 发送上游请求所需的凭据信息
+type upstreamCredential struct {
+	AccessToken   string // Amazon Q access token
+	KeyHash       string // Amazon Q 凭据的 SHA256 哈希，用于按凭据选择熔断器
+	Region        string // 凭据所在区域
+	HeaderProfile string // 上游请求头配置名称
+}
+
+// sendAmazonQRequest 发送 Amazon Q 请求并创建流处理器
+// 参数 ctx 为上下文
+// 参数 cred 为上游凭据
+// 参数 aqRequest 为转换后的 Amazon Q 请求
+// 参数 req 为原始 Claude 请求
+// 参数 usage 为输入 token 和提示词缓存用量
+// 返回流处理器、流式事件通道和可能的错误（熔断器打开时为 *breaker.OpenError）
+func sendAmazonQRequest(ctx context.Context, cred upstreamCredential, aqRequest core.AmazonQRequest, req core.ClaudeRequest, usage promptcache.Usage) (*amazonq.ClaudeStreamHandler, chan anthropic.StreamEvent, error) {
+	// 将 aqRequest 转换为 map[string]interface{}
+	var rawPayload map[string]interface{}
+	jsonBytes, _ := json.Marshal(aqRequest)
+	json.Unmarshal(jsonBytes, &rawPayload)
+
+	eventChan, err := sendWithRegionFallback(ctx, cred, rawPayload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handler := amazonq.NewClaudeStreamHandler(req.Model, usage.InputTokens)
+	handler.CacheUsage = amazonq.CacheUsage{
+		CreationInputTokens:    usage.CacheCreationInputTokens,
+		ReadInputTokens:        usage.CacheReadInputTokens,
+		Ephemeral5mInputTokens: usage.CacheCreation5mInputTokens,
+		Ephemeral1hInputTokens: usage.CacheCreation1hInputTokens,
+	}
+	handler.DisableParallelToolUse = core.IsParallelToolUseDisabled(req.ToolChoice)
+	handler.Context = ctx
+	handler.CitationsEnabled = config.Current().Citations.Enabled
+	if policy := license.Current(); !policy.Empty() {
+		handler.LicensePolicy = policy.Check
+	}
+	return handler, amazonq.ProcessEventStream(eventChan, handler), nil
+}
 
-			var data map[string]interface{}
-			if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
+// sendWithRegionFallback 按凭据所在区域和回退区域的顺序发送请求
+// 每个区域端点有独立的熔断器；仅在临时性故障或熔断器打开时回退到下一个区域
+// 参数 ctx 为上下文
+// 参数 cred 为上游凭据
+// 参数 rawPayload 为请求体
+// 返回事件通道和最后一个区域的错误
+func sendWithRegionFallback(ctx context.Context, cred upstreamCredential, rawPayload map[string]interface{}) (chan *amazonq.EventStreamMessage, error) {
+	var lastErr error
+	for i, name := range region.Candidates(cred.Region) {
+		if i > 0 {
+			slog.WarnContext(ctx, "falling back to next region", "component", "region", "region", name, "error", lastErr)
+		}
+		endpoint := region.QEndpoint(name)
+
+		// 熔断器打开时快速失败，否则记录本次请求的结果
+		var report func(breaker.Result)
+		if cb := breaker.Get(cred.KeyHash, endpoint); cb != nil {
+			var err error
+			if report, err = cb.Allow(); err != nil {
+				lastErr = err
 				continue
 			}
+		}
 
-			dtype, _ := data["type"].(string)
-			if dtype == "content_block_start" {
-				idx := int(data["index"].(float64))
-				for len(finalContent) <= idx {
-					finalContent = append(finalContent, nil)
-				}
-				finalContent[idx] = data["content_block"]
-			} else if dtype == "content_block_delta" {
-				idx := int(data["index"].(float64))
-				delta := data["delta"].(map[string]interface{})
-				if finalContent[idx] != nil {
-					block := finalContent[idx].(map[string]interface{})
-					if delta["type"] == "text_delta" {
-						if text, ok := delta["text"].(string); ok {
-							currentText, _ := block["text"].(string)
-							block["text"] = currentText + text
-						}
-					} else if delta["type"] == "input_json_delta" {
-						if partialJSON, ok := delta["partial_json"].(string); ok {
-							currentJSON, _ := block["partial_json"].(string)
-							block["partial_json"] = currentJSON + partialJSON
-						}
-					}
+		eventChan, err := amazonq.SendChatRequest(ctx, endpoint, cred.HeaderProfile, cred.AccessToken, rawPayload, true)
+		if report != nil {
+			report(breakerResult(ctx, err))
+		}
+		if err == nil {
+			return eventChan, nil
+		}
+		lastErr = err
+		if breakerResult(ctx, err) != breaker.ResultFailure {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// breakerResult 将上游请求的错误归类为熔断器结果
+// 参数 ctx 为请求上下文
+// 参数 err 为 SendChatRequest 返回的错误
+// 返回熔断器结果：临时性故障计为失败，客户端取消不计入，其余（包括上游拒绝请求）计为成功
+func breakerResult(ctx context.Context, err error) breaker.Result {
+	var transientErr *amazonq.TransientError
+	switch {
+	case err == nil:
+		return breaker.ResultSuccess
+	case ctx.Err() != nil:
+		return breaker.ResultIgnored
+	case errors.As(err, &transientErr):
+		return breaker.ResultFailure
+	}
+	return breaker.ResultSuccess
+}
+
+// toolChoiceRound 发送强制工具调用的追加轮次请求
+// 参数 followUp 为追加轮次的 Claude 请求
+// 返回追加轮次的流处理器、流式事件通道和可能的错误
+type toolChoiceRound func(followUp core.ClaudeRequest) (*amazonq.ClaudeStreamHandler, chan anthropic.StreamEvent, error)
+
+// enforceToolChoice 在强制工具调用（any/tool）时检查模型是否调用了工具
+// 若模型以纯文本作答则追加轮次重新请求，最多 core.MaxToolChoiceRounds 轮
+// 首轮的 message_start 和 ping 直接转发，其余事件在满足工具选择的 tool_use 内容块开始前暂存，
+// 之后立即转发已暂存的事件并继续流式转发；本轮结束仍未调用工具时丢弃暂存的事件并追加轮次
+// 参数 ctx 为上下文
+// 参数 req 为原始 Claude 请求
+// 参数 handler 为首轮的流处理器
+// 参数 streamChan 为首轮的流式事件通道
+// 参数 nextRound 发送追加轮次的请求
+// 返回转发最终采用轮次事件的通道
+func enforceToolChoice(ctx context.Context, req core.ClaudeRequest, handler *amazonq.ClaudeStreamHandler, streamChan chan anthropic.StreamEvent, nextRound toolChoiceRound) chan anthropic.StreamEvent {
+	out := make(chan anthropic.StreamEvent, 100)
+	go func() {
+		defer close(out)
+		for round := 1; ; round++ {
+			satisfied := false
+			var held []anthropic.StreamEvent
+			for event := range streamChan {
+				if satisfied {
+					out <- event
+					continue
 				}
-			} else if dtype == "content_block_stop" {
-				idx := int(data["index"].(float64))
-				if finalContent[idx] != nil {
-					block := finalContent[idx].(map[string]interface{})
-					if block["type"] == "tool_use" {
-						if partialJSON, ok := block["partial_json"].(string); ok {
-							var input map[string]interface{}
-							if err := json.Unmarshal([]byte(partialJSON), &input); err == nil {
-								block["input"] = input
-							}
-							delete(block, "partial_json")
-						}
+				switch e := event.(type) {
+				case anthropic.MessageStartEvent:
+					// 追加轮次沿用首轮已发送的 message_start
+					if round == 1 {
+						out <- event
 					}
-				}
-			} else if dtype == "message_delta" {
-				if usageData, ok := data["usage"].(map[string]interface{}); ok {
-					for k, v := range usageData {
-						if val, ok := v.(float64); ok {
-							usage[k] = int(val)
+					continue
+				case anthropic.PingEvent:
+					out <- event
+					continue
+				case anthropic.ContentBlockStartEvent:
+					if e.ContentBlock.Type == "tool_use" && core.IsToolChoiceSatisfied(req.ToolChoice, []string{e.ContentBlock.Name}) {
+						satisfied = true
+						for _, heldEvent := range held {
+							out <- heldEvent
 						}
+						held = nil
+						out <- event
+						continue
 					}
 				}
-				if delta, ok := data["delta"].(map[string]interface{}); ok {
-					if sr, ok := delta["stop_reason"].(string); ok {
-						stopReason = &sr
-					}
+				held = append(held, event)
+			}
+
+			if satisfied || handler.Blocked || handler.Exception != nil || round >= core.MaxToolChoiceRounds || core.IsToolChoiceSatisfied(req.ToolChoice, handler.ToolNames) {
+				for _, event := range held {
+					out <- event
 				}
+				return
 			}
-		}
 
-		// 过滤 nil 元素
-		var filteredContent []interface{}
-		for _, item := range finalContent {
-			if item != nil {
-				filteredContent = append(filteredContent, item)
+			slog.InfoContext(ctx, "model answered without calling a tool, starting next round", "component", "tool_choice", "round", round+1, "max_rounds", core.MaxToolChoiceRounds)
+
+			followUp := core.BuildToolChoiceFollowUp(req, strings.Join(handler.ResponseBuffer, ""))
+			nextHandler, nextChan, err := nextRound(followUp)
+			if err == nil {
+				req, handler, streamChan = followUp, nextHandler, nextChan
+				continue
 			}
-		}
+			slog.WarnContext(ctx, "follow-up request failed", "component", "tool_choice", "error", err)
 
-		c.JSON(http.StatusOK, gin.H{
-			"id":            fmt.Sprintf("msg_%s", uuid.New().String()),
-			"type":          "message",
-			"role":          "assistant",
-			"model":         req.Model,
-			"content":       filteredContent,
-			"stop_reason":   stopReason,
-			"stop_sequence": nil,
-			"usage":         usage,
-		})
-	}
+			// 无法追加轮次时采用本轮的回答
+			for _, event := range held {
+				out <- event
+			}
+			return
+		}
+	}()
+	return out
 }
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// shutdownMessage 停机时仍未结束的响应收到的错误说明
+const shutdownMessage = "Server is shutting down, please retry the request"
+
+var (
+	// responseCtx 所有请求发送上游请求和写出响应时使用的上下文，停机截止时间到达后取消
+	responseCtx, abortResponses = context.WithCancel(context.Background())
+	// activeResponses 正在处理的消息请求数量
+	activeResponses atomic.Int64
+)
+
+// AbortResponses 结束所有进行中的请求：尚未开始输出的请求返回 529，流式响应发送 overloaded_error 类型的 error 事件
+// 用于停机时等待超过截止时间后仍未结束的请求
+func AbortResponses() {
+	abortResponses()
+}
+
+// ActiveResponses 返回正在处理的消息请求数量
+func ActiveResponses() int64 {
+	return activeResponses.Load()
+}
+
+// aborted 判断请求是否因停机被中止
+func aborted() bool {
+	return responseCtx.Err() != nil
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"amazonq-proxy/internal/amazonq"
+	"amazonq-proxy/internal/anthropic"
+	"amazonq-proxy/internal/core"
+)
+
+// stubRound 将 Amazon Q 事件交给新的流处理器，返回处理器和已关闭的流式事件通道
+func stubRound(req core.ClaudeRequest, events ...amazonq.Event) (*amazonq.ClaudeStreamHandler, chan anthropic.StreamEvent) {
+	handler := amazonq.NewClaudeStreamHandler("claude-sonnet-4.5", 10)
+	handler.Context = context.Background()
+	handler.DisableParallelToolUse = core.IsParallelToolUseDisabled(req.ToolChoice)
+
+	var out []anthropic.StreamEvent
+	for _, event := range events {
+		out = append(out, handler.HandleEvent(event)...)
+	}
+	out = append(out, handler.Finish()...)
+	return handler, replayEvents(out)
+}
+
+// prose 以纯文本作答的一轮事件
+func prose(text string) []amazonq.Event {
+	return []amazonq.Event{
+		amazonq.InitialResponseEvent{ConversationID: "c1"},
+		amazonq.AssistantResponseEvent{Content: text},
+		amazonq.AssistantResponseEndEvent{},
+	}
+}
+
+// toolCall 先输出文本再调用工具的一轮事件
+func toolCall(text string, names ...string) []amazonq.Event {
+	events := []amazonq.Event{
+		amazonq.InitialResponseEvent{ConversationID: "c1"},
+		amazonq.AssistantResponseEvent{Content: text},
+	}
+	for i, name := range names {
+		id := "tool_" + string(rune('a'+i))
+		events = append(events,
+			amazonq.ToolUseEvent{ToolUseID: id, Name: name, Input: []byte(`"{}"`)},
+			amazonq.ToolUseEvent{ToolUseID: id, Name: name, Stop: true},
+		)
+	}
+	return append(events, amazonq.AssistantResponseEndEvent{})
+}
+
+// collect 读取通道中的全部事件
+func collect(ch chan anthropic.StreamEvent) []anthropic.StreamEvent {
+	var events []anthropic.StreamEvent
+	for event := range ch {
+		events = append(events, event)
+	}
+	return events
+}
+
+// summary 统计事件中的 message_start、message_stop 数量、文本和调用的工具
+func summary(events []anthropic.StreamEvent) (starts int, stops int, text string, tools []string) {
+	var b strings.Builder
+	for _, event := range events {
+		switch e := event.(type) {
+		case anthropic.MessageStartEvent:
+			starts++
+		case anthropic.MessageStopEvent:
+			stops++
+		case anthropic.ContentBlockStartEvent:
+			if e.ContentBlock.Type == "tool_use" {
+				tools = append(tools, e.ContentBlock.Name)
+			}
+		case anthropic.ContentBlockDeltaEvent:
+			b.WriteString(e.Delta.Text)
+		}
+	}
+	return starts, stops, b.String(), tools
+}
+
+// toolChoiceRequest 声明 search 和 fetch 工具的请求
+func toolChoiceRequest(choice *core.ToolChoice) core.ClaudeRequest {
+	return core.ClaudeRequest{
+		Model:      "claude-sonnet-4.5",
+		Messages:   []core.ClaudeMessage{{Role: "user", Content: "find it"}},
+		Tools:      []core.ClaudeTool{{Name: "search"}, {Name: "fetch"}},
+		ToolChoice: choice,
+	}
+}
+
+func TestEnforceToolChoice(t *testing.T) {
+	cases := []struct {
+		name   string
+		choice *core.ToolChoice
+		rounds [][]amazonq.Event // 每轮的上游事件，第一项为首轮
+		fail   bool              // 追加轮次请求是否失败
+		calls  int               // 期望的追加轮次数
+		text   string
+		tools  []string
+	}{
+		{
+			name:   "satisfied in round 1",
+			choice: &core.ToolChoice{Type: core.ToolChoiceAny},
+			rounds: [][]amazonq.Event{toolCall("Searching. ", "search")},
+			text:   "Searching. ",
+			tools:  []string{"search"},
+		},
+		{
+			name:   "prose first then a tool in round 2",
+			choice: &core.ToolChoice{Type: core.ToolChoiceTool, Name: "fetch"},
+			rounds: [][]amazonq.Event{prose("I think it is there."), toolCall("", "fetch")},
+			calls:  1,
+			tools:  []string{"fetch"},
+		},
+		{
+			name:   "wrong tool does not satisfy a named choice",
+			choice: &core.ToolChoice{Type: core.ToolChoiceTool, Name: "fetch"},
+			rounds: [][]amazonq.Event{toolCall("", "search"), toolCall("", "fetch")},
+			calls:  1,
+			tools:  []string{"fetch"},
+		},
+		{
+			name:   "rounds run out and the last round is flushed",
+			choice: &core.ToolChoice{Type: core.ToolChoiceAny},
+			rounds: [][]amazonq.Event{prose("one"), prose("two"), prose("three"), prose("never")},
+			calls:  core.MaxToolChoiceRounds - 1,
+			text:   "three",
+		},
+		{
+			name:   "follow-up request fails",
+			choice: &core.ToolChoice{Type: core.ToolChoiceAny},
+			rounds: [][]amazonq.Event{prose("only answer")},
+			fail:   true,
+			calls:  1,
+			text:   "only answer",
+		},
+		{
+			name:   "disable_parallel_tool_use drops the second tool_use",
+			choice: &core.ToolChoice{Type: core.ToolChoiceAny, DisableParallelToolUse: true},
+			rounds: [][]amazonq.Event{toolCall("", "search", "fetch")},
+			tools:  []string{"search"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := toolChoiceRequest(tc.choice)
+			handler, streamChan := stubRound(req, tc.rounds[0]...)
+
+			var followUps []core.ClaudeRequest
+			nextRound := func(followUp core.ClaudeRequest) (*amazonq.ClaudeStreamHandler, chan anthropic.StreamEvent, error) {
+				followUps = append(followUps, followUp)
+				if tc.fail {
+					return nil, nil, errors.New("upstream unavailable")
+				}
+				next, ch := stubRound(followUp, tc.rounds[len(followUps)]...)
+				return next, ch, nil
+			}
+
+			events := collect(enforceToolChoice(context.Background(), req, handler, streamChan, nextRound))
+			starts, stops, text, tools := summary(events)
+
+			if len(followUps) != tc.calls {
+				t.Errorf("follow-up rounds = %d, want %d", len(followUps), tc.calls)
+			}
+			// 只转发首轮的 message_start，只有采用的轮次发送 message_stop
+			if starts != 1 || stops != 1 {
+				t.Errorf("message_start = %d, message_stop = %d, want 1 each", starts, stops)
+			}
+			if _, ok := events[0].(anthropic.MessageStartEvent); !ok {
+				t.Errorf("first event = %T, want message_start", events[0])
+			}
+			if text != tc.text {
+				t.Errorf("text = %q, want %q", text, tc.text)
+			}
+			if strings.Join(tools, ",") != strings.Join(tc.tools, ",") {
+				t.Errorf("tools = %v, want %v", tools, tc.tools)
+			}
+		})
+	}
+}
+
+func TestEnforceToolChoiceFollowUpRequest(t *testing.T) {
+	req := toolChoiceRequest(&core.ToolChoice{Type: core.ToolChoiceTool, Name: "fetch"})
+	handler, streamChan := stubRound(req, prose("It is on the shelf.")...)
+
+	var followUp core.ClaudeRequest
+	nextRound := func(next core.ClaudeRequest) (*amazonq.ClaudeStreamHandler, chan anthropic.StreamEvent, error) {
+		followUp = next
+		h, ch := stubRound(next, toolCall("", "fetch")...)
+		return h, ch, nil
+	}
+	collect(enforceToolChoice(context.Background(), req, handler, streamChan, nextRound))
+
+	if len(followUp.Messages) != 3 {
+		t.Fatalf("follow-up messages = %d, want 3", len(followUp.Messages))
+	}
+	if got := followUp.Messages[1]; got.Role != "assistant" || got.Content != "It is on the shelf." {
+		t.Errorf("assistant message = %#v, want the previous answer", got)
+	}
+	if got, _ := followUp.Messages[2].Content.(string); !strings.Contains(got, `did not call the tool "fetch"`) {
+		t.Errorf("user message = %q, want a reminder to call fetch", got)
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"amazonq-proxy/internal/anthropic"
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/usage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkQuota 检查当前 API key 的用量配额，已达到配额时返回 429 rate_limit_error，
+// 用量文件无法读取时返回 500 api_error（不按零用量放行）
+// 参数 c 为 Gin 上下文（由 AuthMiddleware 设置 usageKey 和 quota）
+// 返回是否可以继续处理请求
+func checkQuota(c *gin.Context) bool {
+	ledger := usage.Current()
+	if ledger == nil {
+		return true
+	}
+	value, _ := c.Get("quota")
+	quota, _ := value.(config.QuotaConfig)
+	quotaErr, err := ledger.CheckQuota(c.GetString("usageKey"), quota, time.Now())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to check usage quota", "component", "usage", "error", err)
+		c.JSON(errorStatus("api_error"), anthropic.ErrorResponse("api_error", "Usage quota could not be checked"))
+		return false
+	}
+	if quotaErr == nil {
+		return true
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(quotaErr.ResetAt).Seconds()))))
+	c.JSON(errorStatus("rate_limit_error"), anthropic.ErrorResponse("rate_limit_error", quotaErr.Error()))
+	return false
+}
+
+// recordUsage 在事件通道外包装一层，响应结束后将本次请求的用量记入账本
+// 输入 token 优先取自 message_delta（最终用量），没有时取自 message_start；工具调用按 tool_use 内容块计数
+// 参数 streamChan 为流式事件通道
+// 参数 dims 为统计维度
+// 参数 images 为发送给上游的图片数
+// 返回转发全部事件的新通道（未启用用量统计时返回原通道）
+func recordUsage(streamChan chan anthropic.StreamEvent, dims usage.Dimensions, images int) chan anthropic.StreamEvent {
+	if usage.Current() == nil {
+		return streamChan
+	}
+
+	out := make(chan anthropic.StreamEvent, 100)
+	go func() {
+		defer close(out)
+		counts := usage.Counts{Requests: 1, Images: int64(images)}
+		// pending 为已开始但尚未收到 message_delta 的消息的输入用量
+		var pending *anthropic.Usage
+		for event := range streamChan {
+			switch e := event.(type) {
+			case anthropic.MessageStartEvent:
+				start := e.Message.Usage
+				pending = &start
+			case anthropic.ContentBlockStartEvent:
+				if e.ContentBlock.Type == "tool_use" {
+					counts.ToolCalls++
+				}
+			case anthropic.MessageDeltaEvent:
+				input := e.Usage
+				if input.InputTokens == 0 && input.CacheCreationInputTokens == 0 && input.CacheReadInputTokens == 0 && pending != nil {
+					input = *pending
+				}
+				addInputUsage(&counts, input)
+				counts.OutputTokens += int64(e.Usage.OutputTokens)
+				pending = nil
+			}
+			out <- event
+		}
+		if pending != nil {
+			addInputUsage(&counts, *pending)
+		}
+		usage.Record(dims, counts)
+	}()
+	return out
+}
+
+// addInputUsage 累加输入和提示词缓存 token
+// 参数 counts 为用量计数
+// 参数 input 为消息的 token 用量
+func addInputUsage(counts *usage.Counts, input anthropic.Usage) {
+	counts.InputTokens += int64(input.InputTokens)
+	counts.CacheCreationInputTokens += int64(input.CacheCreationInputTokens)
+	counts.CacheReadInputTokens += int64(input.CacheReadInputTokens)
+}
+
+// handleAdminUsage 查询用量
+// 查询参数：from、to（YYYY-MM-DD，默认当月第一天到今天）、key、account、model（过滤）、
+// group_by（逗号分隔的 date、key、account、model，默认 date,key,model）、format（json 或 csv）
+func handleAdminUsage(c *gin.Context) {
+	ledger := usage.Current()
+	if ledger == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usage accounting is disabled"})
+		return
+	}
+
+	now := time.Now().In(ledger.Location())
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, ledger.Location())
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, ledger.Location())
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		date, err := ledger.ParseDate(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s: expected YYYY-MM-DD", name)})
+			return
+		}
+		*target = date
+	}
+
+	groupBy := []string{"date", "key", "model"}
+	if value, ok := c.GetQuery("group_by"); ok {
+		groupBy = nil
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				groupBy = append(groupBy, field)
+			}
+		}
+	}
+
+	filter := usage.Dimensions{Key: c.Query("key"), Account: c.Query("account"), Model: c.Query("model")}
+	rows, err := ledger.Query(from, to, filter, groupBy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s-%s.csv"`, from.Format("20060102"), to.Format("20060102")))
+		c.Status(http.StatusOK)
+		usage.WriteCSV(c.Writer, rows, groupBy)
+	case "json":
+		c.JSON(http.StatusOK, gin.H{
+			"from":     from.Format("2006-01-02"),
+			"to":       to.Format("2006-01-02"),
+			"group_by": groupBy,
+			"data":     rows,
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format: expected json or csv"})
+	}
+}
+
+// quotaStatus 单个 API key 的配额状态
+type quotaStatus struct {
+	Key      string             `json:"key"`                // API key 名称
+	Pool     string             `json:"pool,omitempty"`     // 使用的凭据池（配置中的代理 API key）
+	Quota    config.QuotaConfig `json:"quota"`              // 生效的配额，0 表示不限制
+	Usage    usage.Totals       `json:"usage"`              // 当天和当月的用量
+	Exceeded string             `json:"exceeded,omitempty"` // 已达到的配额
+}
+
+// handleAdminQuotas 返回配置中的代理 API key 和当月有用量的其他 key 的配额和用量
+func handleAdminQuotas(c *gin.Context) {
+	ledger := usage.Current()
+	if ledger == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usage accounting is disabled"})
+		return
+	}
+
+	now := time.Now()
+	status := func(name, pool string, quota config.QuotaConfig) (quotaStatus, error) {
+		totals, err := ledger.Totals(name, now)
+		if err != nil {
+			return quotaStatus{}, err
+		}
+		s := quotaStatus{Key: name, Pool: pool, Quota: quota, Usage: totals}
+		quotaErr, err := ledger.CheckQuota(name, quota, now)
+		if err != nil {
+			return quotaStatus{}, err
+		}
+		if quotaErr != nil {
+			s.Exceeded = quotaErr.Limit
+		}
+		return s, nil
+	}
+
+	monthKeys, err := ledger.MonthKeys(now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read usage: %v", err)})
+		return
+	}
+
+	seen := make(map[string]bool)
+	keys := []quotaStatus{}
+	for _, key := range config.Current().Credentials.APIKeys {
+		name := usage.ConfiguredKeyName(key)
+		seen[name] = true
+		s, err := status(name, key.Pool, usage.QuotaFor(&key))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read usage: %v", err)})
+			return
+		}
+		keys = append(keys, s)
+	}
+	for _, name := range monthKeys {
+		if !seen[name] {
+			seen[name] = true
+			s, err := status(name, "", usage.QuotaFor(nil))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read usage: %v", err)})
+				return
+			}
+			keys = append(keys, s)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys": keys,
+	})
+}
@@ -0,0 +1,236 @@
+package breaker
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// State 熔断器状态
+type State int
+
+// 熔断器状态
+const (
+	StateClosed   State = iota // 关闭：请求正常通过
+	StateOpen                  // 打开：请求快速失败
+	StateHalfOpen              // 半开：仅放行少量探测请求
+)
+
+// String 返回状态名称
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Result 请求结果
+type Result int
+
+// 请求结果
+const (
+	ResultSuccess Result = iota // 上游可用（包括上游返回的非临时性错误）
+	ResultFailure               // 临时性上游故障
+	ResultIgnored               // 与上游状态无关（如客户端取消）
+)
+
+// Settings 熔断器参数
+type Settings struct {
+	FailureThreshold int           // 连续失败多少次后打开
+	OpenDuration     time.Duration // 打开后多久进入半开
+	HalfOpenProbes   int           // 半开状态下同时放行的探测请求数，全部成功后关闭
+}
+
+// OpenError 熔断器打开时的快速失败错误
+type OpenError struct {
+	Key        string        // 熔断器键
+	RetryAfter time.Duration // 距离进入半开状态的剩余时间
+}
+
+// Error 返回错误说明
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("upstream circuit breaker is open, retry after %ds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Breaker 熔断器
+type Breaker struct {
+	key      string
+	settings Settings
+	now      func() time.Time
+
+	mutex               sync.Mutex
+	state               State
+	consecutiveFailures int
+	openedAt            time.Time
+	probesInFlight      int
+	probeSuccesses      int
+
+	// 累计计数
+	successes  int64
+	failures   int64
+	rejections int64
+	trips      int64
+}
+
+// New 创建熔断器
+// 参数 key 为熔断器键
+// 参数 settings 为熔断器参数
+// 返回熔断器实例
+func New(key string, settings Settings) *Breaker {
+	return &Breaker{key: key, settings: normalize(settings), now: time.Now}
+}
+
+// Configure 替换熔断器参数，保留当前状态和累计计数
+// 参数 settings 为新的熔断器参数
+func (b *Breaker) Configure(settings Settings) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.settings = normalize(settings)
+}
+
+// normalize 修正无效的熔断器参数
+func normalize(settings Settings) Settings {
+	if settings.FailureThreshold < 1 {
+		settings.FailureThreshold = 1
+	}
+	if settings.HalfOpenProbes < 1 {
+		settings.HalfOpenProbes = 1
+	}
+	return settings
+}
+
+// Allow 判断请求是否可以通过
+// 返回请求结束后报告结果的回调，或熔断器打开时的 *OpenError
+func (b *Breaker) Allow() (func(result Result), error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == StateOpen {
+		remaining := b.settings.OpenDuration - b.now().Sub(b.openedAt)
+		if remaining > 0 {
+			b.rejections++
+			return nil, &OpenError{Key: b.key, RetryAfter: remaining}
+		}
+		b.setState(StateHalfOpen)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probesInFlight >= b.settings.HalfOpenProbes {
+			b.rejections++
+			return nil, &OpenError{Key: b.key, RetryAfter: time.Second}
+		}
+		b.probesInFlight++
+		return func(result Result) { b.reportProbe(result) }, nil
+	}
+
+	return func(result Result) { b.report(result) }, nil
+}
+
+// report 记录关闭状态下放行的请求结果
+func (b *Breaker) report(result Result) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch result {
+	case ResultSuccess:
+		b.successes++
+		if b.state == StateClosed {
+			b.consecutiveFailures = 0
+		}
+	case ResultFailure:
+		b.failures++
+		if b.state == StateClosed {
+			b.consecutiveFailures++
+			if b.consecutiveFailures >= b.settings.FailureThreshold {
+				b.setState(StateOpen)
+			}
+		}
+	}
+}
+
+// reportProbe 记录半开状态下探测请求的结果
+func (b *Breaker) reportProbe(result Result) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probesInFlight--
+	if b.state != StateHalfOpen {
+		return
+	}
+
+	switch result {
+	case ResultSuccess:
+		b.successes++
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.settings.HalfOpenProbes {
+			b.setState(StateClosed)
+		}
+	case ResultFailure:
+		b.failures++
+		b.setState(StateOpen)
+	}
+}
+
+// setState 切换状态并重置对应的计数（调用方需持有锁）
+func (b *Breaker) setState(state State) {
+	if b.state != state {
+		slog.Warn("breaker state changed", "component", "breaker", "breaker", b.key, "from", b.state.String(), "to", state.String())
+	}
+	b.state = state
+	switch state {
+	case StateClosed:
+		b.consecutiveFailures = 0
+	case StateOpen:
+		b.openedAt = b.now()
+		b.trips++
+	case StateHalfOpen:
+		b.probeSuccesses = 0
+	}
+}
+
+// Reset 将熔断器恢复为关闭状态
+func (b *Breaker) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.setState(StateClosed)
+}
+
+// Status 熔断器状态快照
+type Status struct {
+	Key                 string     `json:"key"`                  // 熔断器键
+	Credential          string     `json:"credential"`           // 凭据标识（API key 哈希前缀）
+	Endpoint            string     `json:"endpoint"`             // 上游端点
+	State               string     `json:"state"`                // 当前状态
+	ConsecutiveFailures int        `json:"consecutive_failures"` // 连续失败次数
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`  // 最近一次打开的时间（关闭状态下省略）
+	Successes           int64      `json:"successes_total"`      // 累计成功次数
+	Failures            int64      `json:"failures_total"`       // 累计失败次数
+	Rejections          int64      `json:"rejections_total"`     // 累计快速失败次数
+	Trips               int64      `json:"trips_total"`          // 累计打开次数
+}
+
+// Status 返回熔断器状态快照
+func (b *Breaker) Status() Status {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := Status{
+		Key:                 b.key,
+		State:               b.state.String(),
+		ConsecutiveFailures: b.consecutiveFailures,
+		Successes:           b.successes,
+		Failures:            b.failures,
+		Rejections:          b.rejections,
+		Trips:               b.trips,
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
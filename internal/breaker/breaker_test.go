@@ -0,0 +1,191 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/metrics"
+)
+
+// testClock 可手动推进的测试时钟
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// newTestBreaker 创建使用测试时钟的熔断器
+func newTestBreaker(settings Settings) (*Breaker, *testClock) {
+	clock := &testClock{now: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+	b := New("test", settings)
+	b.now = clock.Now
+	return b, clock
+}
+
+// allow 放行请求并报告结果
+func allow(t *testing.T, b *Breaker, result Result) {
+	t.Helper()
+	report, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() = %v, want the request to pass", err)
+	}
+	report(result)
+}
+
+// rejected 判断请求是否被快速失败，返回距离半开的剩余时间
+func rejected(t *testing.T, b *Breaker) time.Duration {
+	t.Helper()
+	report, err := b.Allow()
+	var openErr *OpenError
+	if !errors.As(err, &openErr) {
+		if report != nil {
+			report(ResultIgnored)
+		}
+		t.Fatalf("Allow() = %v, want *OpenError", err)
+	}
+	return openErr.RetryAfter
+}
+
+func TestOpensAfterConsecutiveFailures(t *testing.T) {
+	b, _ := newTestBreaker(Settings{FailureThreshold: 3, OpenDuration: 30 * time.Second, HalfOpenProbes: 1})
+
+	allow(t, b, ResultFailure)
+	allow(t, b, ResultFailure)
+	// 成功和与上游无关的结果分别重置和不影响连续失败计数
+	allow(t, b, ResultSuccess)
+	allow(t, b, ResultFailure)
+	allow(t, b, ResultIgnored)
+	allow(t, b, ResultFailure)
+	if state := b.Status().State; state != "closed" {
+		t.Fatalf("state after 2 consecutive failures = %s, want closed", state)
+	}
+
+	allow(t, b, ResultFailure)
+	status := b.Status()
+	if status.State != "open" || status.Trips != 1 || status.OpenedAt == nil {
+		t.Fatalf("status after 3 consecutive failures = %+v, want open with 1 trip", status)
+	}
+	if retryAfter := rejected(t, b); retryAfter != 30*time.Second {
+		t.Errorf("RetryAfter = %v, want 30s", retryAfter)
+	}
+	if rejections := b.Status().Rejections; rejections != 1 {
+		t.Errorf("rejections = %d, want 1", rejections)
+	}
+}
+
+func TestHalfOpenProbes(t *testing.T) {
+	settings := Settings{FailureThreshold: 1, OpenDuration: 30 * time.Second, HalfOpenProbes: 2}
+
+	t.Run("successful probes close the breaker", func(t *testing.T) {
+		b, clock := newTestBreaker(settings)
+		allow(t, b, ResultFailure)
+
+		clock.now = clock.now.Add(20 * time.Second)
+		if retryAfter := rejected(t, b); retryAfter != 10*time.Second {
+			t.Errorf("RetryAfter = %v, want 10s", retryAfter)
+		}
+
+		clock.now = clock.now.Add(10 * time.Second)
+		first, err := b.Allow()
+		if err != nil {
+			t.Fatalf("first probe: %v", err)
+		}
+		second, err := b.Allow()
+		if err != nil {
+			t.Fatalf("second probe: %v", err)
+		}
+		if b.Status().State != "half-open" {
+			t.Fatalf("state = %s, want half-open", b.Status().State)
+		}
+		// 探测请求数已满时快速失败
+		rejected(t, b)
+
+		first(ResultSuccess)
+		if b.Status().State != "half-open" {
+			t.Fatalf("state after 1 of 2 probes = %s, want half-open", b.Status().State)
+		}
+		second(ResultSuccess)
+		if status := b.Status(); status.State != "closed" || status.OpenedAt != nil {
+			t.Fatalf("status after successful probes = %+v, want closed", status)
+		}
+		allow(t, b, ResultSuccess)
+	})
+
+	t.Run("failed probe reopens the breaker", func(t *testing.T) {
+		b, clock := newTestBreaker(settings)
+		allow(t, b, ResultFailure)
+
+		clock.now = clock.now.Add(30 * time.Second)
+		allow(t, b, ResultFailure)
+		status := b.Status()
+		if status.State != "open" || status.Trips != 2 || !status.OpenedAt.Equal(clock.now) {
+			t.Fatalf("status after failed probe = %+v, want reopened now", status)
+		}
+		if retryAfter := rejected(t, b); retryAfter != 30*time.Second {
+			t.Errorf("RetryAfter = %v, want a full open duration", retryAfter)
+		}
+	})
+
+	t.Run("ignored probe frees its slot", func(t *testing.T) {
+		b, clock := newTestBreaker(Settings{FailureThreshold: 1, OpenDuration: 30 * time.Second, HalfOpenProbes: 1})
+		allow(t, b, ResultFailure)
+
+		clock.now = clock.now.Add(30 * time.Second)
+		allow(t, b, ResultIgnored)
+		if b.Status().State != "half-open" {
+			t.Fatalf("state after ignored probe = %s, want half-open", b.Status().State)
+		}
+		allow(t, b, ResultSuccess)
+		if b.Status().State != "closed" {
+			t.Fatalf("state after successful probe = %s, want closed", b.Status().State)
+		}
+	})
+}
+
+func TestLateReportAfterTripIsIgnored(t *testing.T) {
+	b, _ := newTestBreaker(Settings{FailureThreshold: 1, OpenDuration: 30 * time.Second, HalfOpenProbes: 1})
+	late, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow(t, b, ResultFailure)
+
+	// 打开前放行的请求成功时不会关闭熔断器
+	late(ResultSuccess)
+	if state := b.Status().State; state != "open" {
+		t.Errorf("state after late success = %s, want open", state)
+	}
+}
+
+func TestReset(t *testing.T) {
+	b, _ := newTestBreaker(Settings{FailureThreshold: 1, OpenDuration: time.Hour, HalfOpenProbes: 1})
+	allow(t, b, ResultFailure)
+	b.Reset()
+	allow(t, b, ResultSuccess)
+	if state := b.Status().State; state != "closed" {
+		t.Errorf("state after Reset = %s, want closed", state)
+	}
+}
+
+func TestRegistryUsesMetricsCredentialLabel(t *testing.T) {
+	previous := config.Current()
+	cfg := *previous
+	cfg.Breaker.Enabled = true
+	config.Set(&cfg)
+	defer config.Set(previous)
+
+	hash := "0123456789abcdef0123456789abcdef"
+	Get(hash, "https://q.us-east-1.amazonaws.com")
+	// 健康检查按指标的凭据标签在熔断器状态中查找凭据，两者必须一致
+	for _, status := range Snapshot() {
+		if status.Endpoint == "https://q.us-east-1.amazonaws.com" {
+			if status.Credential != metrics.CredentialLabel(hash) {
+				t.Errorf("Credential = %q, want %q", status.Credential, metrics.CredentialLabel(hash))
+			}
+			return
+		}
+	}
+	t.Fatal("breaker missing from Snapshot()")
+}
@@ -0,0 +1,101 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/metrics"
+)
+
+// entry 注册表中的熔断器及其凭据、端点
+type entry struct {
+	breaker    *Breaker
+	credential string
+	endpoint   string
+}
+
+var (
+	// breakers 熔断器键 -> 熔断器
+	breakers = make(map[string]*entry)
+	// breakersMutex 注册表互斥锁
+	breakersMutex sync.Mutex
+)
+
+// SettingsFromConfig 根据配置创建熔断器参数
+// 返回熔断器参数
+func SettingsFromConfig() Settings {
+	cfg := config.Current().Breaker
+	return Settings{
+		FailureThreshold: cfg.FailureThreshold,
+		OpenDuration:     cfg.OpenDuration.Std(),
+		HalfOpenProbes:   cfg.HalfOpenProbes,
+	}
+}
+
+// Get 获取（必要时创建）凭据和端点对应的熔断器
+// 参数 credential 为凭据标识（API key 的 SHA256 哈希）
+// 参数 endpoint 为上游端点
+// 返回熔断器，未启用熔断时返回 nil
+func Get(credential string, endpoint string) *Breaker {
+	if !config.Current().Breaker.Enabled {
+		return nil
+	}
+
+	label := metrics.CredentialLabel(credential)
+	key := label + "@" + endpoint
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+
+	if e, ok := breakers[key]; ok {
+		return e.breaker
+	}
+	e := &entry{
+		breaker:    New(key, SettingsFromConfig()),
+		credential: label,
+		endpoint:   endpoint,
+	}
+	breakers[key] = e
+	return e.breaker
+}
+
+// Snapshot 返回所有熔断器的状态（按键排序）
+func Snapshot() []Status {
+	breakersMutex.Lock()
+	entries := make([]*entry, 0, len(breakers))
+	for _, e := range breakers {
+		entries = append(entries, e)
+	}
+	breakersMutex.Unlock()
+
+	statuses := make([]Status, 0, len(entries))
+	for _, e := range entries {
+		status := e.breaker.Status()
+		status.Credential = e.credential
+		status.Endpoint = e.endpoint
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
+
+// Reconfigure 按当前配置更新所有已创建熔断器的参数
+func Reconfigure() {
+	settings := SettingsFromConfig()
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	for _, e := range breakers {
+		e.breaker.Configure(settings)
+	}
+}
+
+// ResetAll 将所有熔断器恢复为关闭状态
+// 返回重置的熔断器数量
+func ResetAll() int {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	for _, e := range breakers {
+		e.breaker.Reset()
+	}
+	return len(breakers)
+}
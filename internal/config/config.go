@@ -0,0 +1,345 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Config 服务配置
+// 按 默认值 < 配置文件 < 环境变量 < 命令行参数 的优先级加载，通过 Current 读取当前生效的配置
+type Config struct {
+	Server        ServerConfig        `yaml:"server" toml:"server" json:"server"`
+	Upstream      UpstreamConfig      `yaml:"upstream" toml:"upstream" json:"upstream"`
+	Breaker       BreakerConfig       `yaml:"breaker" toml:"breaker" json:"breaker"`
+	Models        ModelsConfig        `yaml:"models" toml:"models" json:"models"`
+	Credentials   CredentialsConfig   `yaml:"credentials" toml:"credentials" json:"credentials"`
+	Logging       LoggingConfig       `yaml:"logging" toml:"logging" json:"logging"`
+	Limits        LimitsConfig        `yaml:"limits" toml:"limits" json:"limits"`
+	Context       ContextConfig       `yaml:"context" toml:"context" json:"context"`
+	Prompt        PromptConfig        `yaml:"prompt" toml:"prompt" json:"prompt"`
+	PromptCache   PromptCacheConfig   `yaml:"prompt_cache" toml:"prompt_cache" json:"prompt_cache"`
+	ResponseCache ResponseCacheConfig `yaml:"response_cache" toml:"response_cache" json:"response_cache"`
+	Citations     CitationsConfig     `yaml:"citations" toml:"citations" json:"citations"`
+	Tracing       TracingConfig       `yaml:"tracing" toml:"tracing" json:"tracing"`
+	Usage         UsageConfig         `yaml:"usage" toml:"usage" json:"usage"`
+}
+
+// ServerConfig 服务监听配置
+type ServerConfig struct {
+	Listen          string   `yaml:"listen" toml:"listen" json:"listen"`                               // 监听地址（PORT 仅覆盖端口，修改后需重启）
+	AdminToken      string   `yaml:"admin_token" toml:"admin_token" json:"admin_token"`                // 管理端点的 Bearer 令牌，为空时禁用管理端点（ADMIN_TOKEN）
+	WatchInterval   Duration `yaml:"watch_interval" toml:"watch_interval" json:"watch_interval"`       // 检查配置文件和凭据文件变化的间隔，0 表示仅在 SIGHUP 时重载（CONFIG_WATCH_INTERVAL）
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" json:"shutdown_timeout"` // 停机时等待进行中的响应结束的最长时间，超时后结束仍未完成的流（SHUTDOWN_TIMEOUT）
+	Metrics         bool     `yaml:"metrics" toml:"metrics" json:"metrics"`                            // 是否提供 Prometheus 格式的 /metrics 端点（METRICS_ENABLED）
+	RootRedirect    string   `yaml:"root_redirect" toml:"root_redirect" json:"root_redirect"`          // GET / 重定向的地址，为空时返回服务名称和版本（ROOT_REDIRECT）
+}
+
+// UpstreamConfig 上游（Amazon Q 和 OIDC）配置
+type UpstreamConfig struct {
+	Region          string                   `yaml:"region" toml:"region" json:"region"`                                              // 凭据未指定区域时使用的区域（AMAZONQ_REGION）
+	FallbackRegions []string                 `yaml:"fallback_regions" toml:"fallback_regions" json:"fallback_regions"`                // 临时性故障时依次回退的区域（AMAZONQ_FALLBACK_REGIONS）
+	Endpoint        string                   `yaml:"endpoint" toml:"endpoint" json:"endpoint"`                                        // 覆盖所有区域的 Amazon Q API 端点（AMAZONQ_ENDPOINT）
+	OIDCEndpoint    string                   `yaml:"oidc_endpoint" toml:"oidc_endpoint" json:"oidc_endpoint"`                         // 覆盖所有区域的 OIDC 服务基础 URL（OIDC_ENDPOINT）
+	Proxy           string                   `yaml:"proxy" toml:"proxy" json:"proxy"`                                                 // 代理地址，支持 http、https、socks5（HTTP_PROXY/HTTPS_PROXY）
+	NoProxy         string                   `yaml:"no_proxy" toml:"no_proxy" json:"no_proxy"`                                        // 直连不走代理的主机（NO_PROXY）
+	Headers         map[string]string        `yaml:"headers" toml:"headers" json:"headers"`                                           // Amazon Q API 请求头
+	OIDCHeaders     map[string]string        `yaml:"oidc_headers" toml:"oidc_headers" json:"oidc_headers"`                            // OIDC 认证请求头
+	Profile         string                   `yaml:"header_profile" toml:"header_profile" json:"header_profile"`                      // 默认请求头配置（HEADER_PROFILE）
+	Profiles        map[string]HeaderProfile `yaml:"header_profiles" toml:"header_profiles" json:"header_profiles"`                   // 请求头配置名称 -> 请求头配置
+	ProfileModels   map[string]string        `yaml:"header_profile_models" toml:"header_profile_models" json:"header_profile_models"` // 按模型选择请求头配置（HEADER_PROFILE_MODELS）
+	ProfileKeys     map[string]string        `yaml:"header_profile_keys" toml:"header_profile_keys" json:"header_profile_keys"`       // 按凭据的 SHA256 哈希选择请求头配置（HEADER_PROFILE_KEYS）
+	HTTP2           bool                     `yaml:"http2" toml:"http2" json:"http2"`                                                 // 是否尝试使用 HTTP/2（UPSTREAM_HTTP2）
+	Timeouts        TimeoutsConfig           `yaml:"timeouts" toml:"timeouts" json:"timeouts"`
+	Pool            PoolConfig               `yaml:"pool" toml:"pool" json:"pool"`
+	Retry           RetryConfig              `yaml:"retry" toml:"retry" json:"retry"`
+}
+
+// HeaderProfile 上游请求头配置，在 upstream.headers / upstream.oidc_headers 的基础上覆盖，未设置的字段保留基础值
+type HeaderProfile struct {
+	UserAgent        string            `yaml:"user_agent,omitempty" toml:"user_agent" json:"user_agent,omitempty"`                            // Amazon Q 请求的 user-agent
+	AmzUserAgent     string            `yaml:"amz_user_agent,omitempty" toml:"amz_user_agent" json:"amz_user_agent,omitempty"`                // Amazon Q 请求的 x-amz-user-agent
+	Target           string            `yaml:"target,omitempty" toml:"target" json:"target,omitempty"`                                        // x-amz-target 操作，如 AmazonCodeWhispererStreamingService.GenerateAssistantResponse
+	OptOut           *bool             `yaml:"optout,omitempty" toml:"optout" json:"optout,omitempty"`                                        // x-amzn-codewhisperer-optout，true 表示不允许 AWS 使用请求内容改进服务
+	OIDCUserAgent    string            `yaml:"oidc_user_agent,omitempty" toml:"oidc_user_agent" json:"oidc_user_agent,omitempty"`             // OIDC 请求的 user-agent
+	OIDCAmzUserAgent string            `yaml:"oidc_amz_user_agent,omitempty" toml:"oidc_amz_user_agent" json:"oidc_amz_user_agent,omitempty"` // OIDC 请求的 x-amz-user-agent
+	Headers          map[string]string `yaml:"headers,omitempty" toml:"headers" json:"headers,omitempty"`                                     // Amazon Q 请求的其他请求头，值为空表示删除
+}
+
+// TimeoutsConfig 上游超时配置
+type TimeoutsConfig struct {
+	Request        Duration `yaml:"request" toml:"request" json:"request"`                         // 请求总超时，包含读取整个流式响应，0 表示不限制（UPSTREAM_TIMEOUT）
+	Dial           Duration `yaml:"dial" toml:"dial" json:"dial"`                                  // 建立 TCP 连接超时（UPSTREAM_DIAL_TIMEOUT）
+	TLSHandshake   Duration `yaml:"tls_handshake" toml:"tls_handshake" json:"tls_handshake"`       // TLS 握手超时（UPSTREAM_TLS_HANDSHAKE_TIMEOUT）
+	ResponseHeader Duration `yaml:"response_header" toml:"response_header" json:"response_header"` // 等待响应头超时，0 表示不限制（UPSTREAM_RESPONSE_HEADER_TIMEOUT）
+	IdleStream     Duration `yaml:"idle_stream" toml:"idle_stream" json:"idle_stream"`             // 流式响应两次收到数据之间的最大间隔，0 表示不限制（UPSTREAM_IDLE_STREAM_TIMEOUT）
+	KeepAlive      Duration `yaml:"keepalive" toml:"keepalive" json:"keepalive"`                   // TCP keepalive 探测间隔，负数表示禁用（UPSTREAM_KEEPALIVE）
+	IdleConn       Duration `yaml:"idle_conn" toml:"idle_conn" json:"idle_conn"`                   // 空闲连接保留时间（UPSTREAM_IDLE_CONN_TIMEOUT）
+}
+
+// PoolConfig 上游连接池配置
+type PoolConfig struct {
+	MaxIdleConns        int `yaml:"max_idle_conns" toml:"max_idle_conns" json:"max_idle_conns"`                            // 最大空闲连接数（UPSTREAM_MAX_IDLE_CONNS）
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host" toml:"max_idle_conns_per_host" json:"max_idle_conns_per_host"` // 每个主机的最大空闲连接数（UPSTREAM_MAX_IDLE_CONNS_PER_HOST）
+	MaxConnsPerHost     int `yaml:"max_conns_per_host" toml:"max_conns_per_host" json:"max_conns_per_host"`                // 每个主机的最大连接数，0 表示不限制（UPSTREAM_MAX_CONNS_PER_HOST）
+}
+
+// RetryConfig 上游重试配置
+type RetryConfig struct {
+	MaxAttempts int      `yaml:"max_attempts" toml:"max_attempts" json:"max_attempts"` // 最大尝试次数，包含首次请求（UPSTREAM_MAX_ATTEMPTS）
+	BaseDelay   Duration `yaml:"base_delay" toml:"base_delay" json:"base_delay"`       // 首次重试的退避上限，之后每次翻倍（UPSTREAM_RETRY_BASE_DELAY_MS）
+	MaxDelay    Duration `yaml:"max_delay" toml:"max_delay" json:"max_delay"`          // 单次重试的退避上限（UPSTREAM_RETRY_MAX_DELAY_MS）
+	Budget      Duration `yaml:"budget" toml:"budget" json:"budget"`                   // 单个请求用于重试的总时间，0 表示不限制（UPSTREAM_RETRY_BUDGET）
+}
+
+// BreakerConfig 上游熔断配置
+type BreakerConfig struct {
+	Enabled          bool     `yaml:"enabled" toml:"enabled" json:"enabled"`                               // 是否启用（BREAKER_ENABLED）
+	FailureThreshold int      `yaml:"failure_threshold" toml:"failure_threshold" json:"failure_threshold"` // 连续多少次临时性故障后打开（BREAKER_FAILURE_THRESHOLD）
+	OpenDuration     Duration `yaml:"open_duration" toml:"open_duration" json:"open_duration"`             // 打开后多久进入半开状态（BREAKER_OPEN_SECONDS）
+	HalfOpenProbes   int      `yaml:"half_open_probes" toml:"half_open_probes" json:"half_open_probes"`    // 半开状态下放行的探测请求数（BREAKER_HALF_OPEN_PROBES）
+}
+
+// ModelsConfig 模型配置
+type ModelsConfig struct {
+	Aliases map[string]string `yaml:"aliases" toml:"aliases" json:"aliases"` // 模型别名 -> 发送给 Amazon Q 的模型（MODEL_ALIASES，格式 alias=model,...）
+}
+
+// CredentialsConfig 服务端凭据池配置
+type CredentialsConfig struct {
+	Pools   map[string][]string `yaml:"pools" toml:"pools" json:"pools"`          // 凭据池名称 -> Amazon Q 凭据列表（[region:]clientId:clientSecret:refreshToken）
+	Files   map[string]string   `yaml:"files" toml:"files" json:"files"`          // 凭据池名称 -> 凭据文件（每行一个凭据，# 开头为注释），追加到同名凭据池
+	APIKeys []APIKeyConfig      `yaml:"api_keys" toml:"api_keys" json:"api_keys"` // 使用凭据池的代理 API key
+}
+
+// APIKeyConfig 使用凭据池的代理 API key
+type APIKeyConfig struct {
+	Key      string       `yaml:"key" toml:"key" json:"key"`                                              // 客户端使用的 API key
+	Pool     string       `yaml:"pool" toml:"pool" json:"pool"`                                           // 轮流使用的凭据池名称
+	Name     string       `yaml:"name,omitempty" toml:"name,omitempty" json:"name,omitempty"`             // 用量统计中显示的名称，为空时使用 key 的 SHA256 哈希前缀
+	Quota    *QuotaConfig `yaml:"quota,omitempty" toml:"quota,omitempty" json:"quota,omitempty"`          // 用量配额，为空时使用 usage.default_quota
+	Template string       `yaml:"template,omitempty" toml:"template,omitempty" json:"template,omitempty"` // 提示词框架模板名称，为空时按 prompt.models 和 prompt.template 选择
+}
+
+// LoggingConfig 日志配置
+type LoggingConfig struct {
+	Level  string `yaml:"level" toml:"level" json:"level"`    // 日志级别：debug、info、warn、error（LOG_LEVEL）
+	Format string `yaml:"format" toml:"format" json:"format"` // 日志格式：text 或 json（LOG_FORMAT）
+}
+
+// LimitsConfig 请求限制配置
+type LimitsConfig struct {
+	MaxImagesPerRequest int `yaml:"max_images_per_request" toml:"max_images_per_request" json:"max_images_per_request"` // 单个请求最多发送的图片数量，0 表示不限制（IMAGE_MAX_PER_REQUEST）
+	MaxImageBytes       int `yaml:"max_image_bytes" toml:"max_image_bytes" json:"max_image_bytes"`                      // 单张图片最大字节数（IMAGE_MAX_BYTES）
+	MaxImageDimension   int `yaml:"max_image_dimension" toml:"max_image_dimension" json:"max_image_dimension"`          // 图片长边最大像素数（IMAGE_MAX_DIMENSION）
+	MaxImageFetchBytes  int `yaml:"max_image_fetch_bytes" toml:"max_image_fetch_bytes" json:"max_image_fetch_bytes"`    // 通过 URL 获取图片时的最大下载字节数（IMAGE_MAX_FETCH_BYTES）
+}
+
+// ContextConfig 发送给 Amazon Q 的默认消息上下文
+type ContextConfig struct {
+	OperatingSystem  string `yaml:"operating_system" toml:"operating_system" json:"operating_system"`    // 默认操作系统（AMAZONQ_OPERATING_SYSTEM）
+	WorkingDirectory string `yaml:"working_directory" toml:"working_directory" json:"working_directory"` // 默认工作目录（AMAZONQ_WORKING_DIRECTORY）
+}
+
+// PromptConfig 提示词框架模板配置
+type PromptConfig struct {
+	Template string            `yaml:"template" toml:"template" json:"template"` // 默认模板名称（PROMPT_TEMPLATE）
+	Dir      string            `yaml:"dir" toml:"dir" json:"dir"`                // 自定义模板目录（PROMPT_TEMPLATE_DIR）
+	Models   map[string]string `yaml:"models" toml:"models" json:"models"`       // 按模型选择模板（PROMPT_TEMPLATE_MODELS）
+	Timezone string            `yaml:"timezone" toml:"timezone" json:"timezone"` // 模板注入时间使用的时区（PROMPT_TIMEZONE）
+}
+
+// PromptCacheConfig 本地提示词缓存模拟配置
+type PromptCacheConfig struct {
+	Enabled    bool `yaml:"enabled" toml:"enabled" json:"enabled"`             // 是否启用（PROMPT_CACHE_ENABLED）
+	MaxEntries int  `yaml:"max_entries" toml:"max_entries" json:"max_entries"` // 最大条目数（PROMPT_CACHE_MAX_ENTRIES）
+	MinTokens  int  `yaml:"min_tokens" toml:"min_tokens" json:"min_tokens"`    // 可缓存前缀的最小 token 数（PROMPT_CACHE_MIN_TOKENS）
+}
+
+// ResponseCacheConfig 响应缓存配置
+type ResponseCacheConfig struct {
+	Enabled             bool     `yaml:"enabled" toml:"enabled" json:"enabled"`                                           // 是否启用（RESPONSE_CACHE_ENABLED）
+	Backend             string   `yaml:"backend" toml:"backend" json:"backend"`                                           // 后端：memory 或 disk（RESPONSE_CACHE_BACKEND）
+	Dir                 string   `yaml:"dir" toml:"dir" json:"dir"`                                                       // 磁盘后端的缓存目录（RESPONSE_CACHE_DIR）
+	TTL                 Duration `yaml:"ttl" toml:"ttl" json:"ttl"`                                                       // 有效期（RESPONSE_CACHE_TTL）
+	MaxEntries          int      `yaml:"max_entries" toml:"max_entries" json:"max_entries"`                               // 最大条目数（RESPONSE_CACHE_MAX_ENTRIES）
+	MaxBytes            int      `yaml:"max_bytes" toml:"max_bytes" json:"max_bytes"`                                     // 总字节数上限（RESPONSE_CACHE_MAX_BYTES）
+	MaxEntryBytes       int      `yaml:"max_entry_bytes" toml:"max_entry_bytes" json:"max_entry_bytes"`                   // 单条响应最大字节数（RESPONSE_CACHE_MAX_ENTRY_BYTES）
+	ZeroTemperatureOnly bool     `yaml:"zero_temperature_only" toml:"zero_temperature_only" json:"zero_temperature_only"` // 是否仅缓存 temperature 为 0 的请求（RESPONSE_CACHE_ZERO_TEMPERATURE_ONLY）
+}
+
+// CitationsConfig 引用与许可证策略配置
+type CitationsConfig struct {
+	Enabled          bool     `yaml:"enabled" toml:"enabled" json:"enabled"`                               // 是否转换为 citations，默认关闭（CITATIONS_ENABLED）
+	LicenseBlocklist []string `yaml:"license_blocklist" toml:"license_blocklist" json:"license_blocklist"` // 禁止的许可证，支持 * 后缀通配（LICENSE_BLOCKLIST）
+}
+
+// TracingConfig 链路追踪配置
+type TracingConfig struct {
+	Exporter    string            `yaml:"exporter" toml:"exporter" json:"exporter"`             // 导出方式：none、otlp（OTLP/HTTP JSON）或 stdout（TRACING_EXPORTER）
+	Endpoint    string            `yaml:"endpoint" toml:"endpoint" json:"endpoint"`             // OTLP/HTTP traces 端点（OTEL_EXPORTER_OTLP_TRACES_ENDPOINT）
+	Headers     map[string]string `yaml:"headers" toml:"headers" json:"headers"`                // 发送到 OTLP 端点的额外请求头（如认证）
+	ServiceName string            `yaml:"service_name" toml:"service_name" json:"service_name"` // service.name 资源属性（OTEL_SERVICE_NAME）
+	SampleRatio float64           `yaml:"sample_ratio" toml:"sample_ratio" json:"sample_ratio"` // 没有上游 traceparent 时的采样比例，0 到 1（TRACING_SAMPLE_RATIO）
+}
+
+// UsageConfig 用量统计和配额配置
+type UsageConfig struct {
+	Enabled       bool        `yaml:"enabled" toml:"enabled" json:"enabled"`                      // 是否记录用量并执行配额（USAGE_ENABLED）
+	Dir           string      `yaml:"dir" toml:"dir" json:"dir"`                                  // 用量文件目录，每天一个 JSON 文件（USAGE_DIR）
+	FlushInterval Duration    `yaml:"flush_interval" toml:"flush_interval" json:"flush_interval"` // 写入用量文件的间隔（USAGE_FLUSH_INTERVAL）
+	Timezone      string      `yaml:"timezone" toml:"timezone" json:"timezone"`                   // 按天和按月统计使用的时区（IANA 名称，为空时使用本地时区，USAGE_TIMEZONE）
+	DefaultQuota  QuotaConfig `yaml:"default_quota" toml:"default_quota" json:"default_quota"`    // 未单独配置配额的 API key（包括直接使用 Amazon Q 凭据的客户端）的配额
+}
+
+// QuotaConfig 单个 API key 的用量配额，0 表示不限制
+// token 数包括输入、缓存读写和输出 token
+type QuotaConfig struct {
+	DailyRequests   int `yaml:"daily_requests" toml:"daily_requests" json:"daily_requests"`       // 每天的请求数（USAGE_DAILY_REQUESTS）
+	DailyTokens     int `yaml:"daily_tokens" toml:"daily_tokens" json:"daily_tokens"`             // 每天的 token 数（USAGE_DAILY_TOKENS）
+	MonthlyRequests int `yaml:"monthly_requests" toml:"monthly_requests" json:"monthly_requests"` // 每月的请求数（USAGE_MONTHLY_REQUESTS）
+	MonthlyTokens   int `yaml:"monthly_tokens" toml:"monthly_tokens" json:"monthly_tokens"`       // 每月的 token 数（USAGE_MONTHLY_TOKENS）
+}
+
+// Limited 判断是否设置了任一配额
+func (q QuotaConfig) Limited() bool {
+	return q.DailyRequests > 0 || q.DailyTokens > 0 || q.MonthlyRequests > 0 || q.MonthlyTokens > 0
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Listen:          "0.0.0.0:8000",
+			WatchInterval:   Duration(5 * time.Second),
+			ShutdownTimeout: Duration(30 * time.Second),
+			Metrics:         true,
+		},
+		Upstream: UpstreamConfig{
+			Region:      "us-east-1",
+			Headers:     copyMap(defaultHeaders),
+			OIDCHeaders: copyMap(defaultOIDCHeaders),
+			HTTP2:       true,
+			Profile:     DefaultHeaderProfile,
+			Profiles: map[string]HeaderProfile{
+				DefaultHeaderProfile: {},
+			},
+			ProfileModels: map[string]string{},
+			ProfileKeys:   map[string]string{},
+			Timeouts: TimeoutsConfig{
+				Request:        Duration(300 * time.Second),
+				Dial:           Duration(10 * time.Second),
+				TLSHandshake:   Duration(10 * time.Second),
+				ResponseHeader: Duration(60 * time.Second),
+				IdleStream:     Duration(120 * time.Second),
+				KeepAlive:      Duration(30 * time.Second),
+				IdleConn:       Duration(90 * time.Second),
+			},
+			Pool: PoolConfig{
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 10,
+			},
+			Retry: RetryConfig{
+				MaxAttempts: 3,
+				BaseDelay:   Duration(250 * time.Millisecond),
+				MaxDelay:    Duration(5 * time.Second),
+				Budget:      Duration(30 * time.Second),
+			},
+		},
+		Breaker: BreakerConfig{
+			Enabled:          true,
+			FailureThreshold: 5,
+			OpenDuration:     Duration(30 * time.Second),
+			HalfOpenProbes:   1,
+		},
+		Models: ModelsConfig{
+			Aliases: map[string]string{},
+		},
+		Credentials: CredentialsConfig{
+			Pools: map[string][]string{},
+			Files: map[string]string{},
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Limits: LimitsConfig{
+			MaxImagesPerRequest: 10,
+			MaxImageBytes:       3750000,
+			MaxImageDimension:   8000,
+			MaxImageFetchBytes:  20 << 20,
+		},
+		Context: ContextConfig{
+			OperatingSystem:  "macos",
+			WorkingDirectory: "/",
+		},
+		Prompt: PromptConfig{
+			Template: "default",
+			Models:   map[string]string{},
+		},
+		PromptCache: PromptCacheConfig{
+			Enabled:    true,
+			MaxEntries: 10000,
+			MinTokens:  1024,
+		},
+		ResponseCache: ResponseCacheConfig{
+			Backend:             "memory",
+			Dir:                 "data/response-cache",
+			TTL:                 Duration(time.Hour),
+			MaxEntries:          1000,
+			MaxBytes:            256 << 20,
+			MaxEntryBytes:       4 << 20,
+			ZeroTemperatureOnly: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318/v1/traces",
+			Headers:     map[string]string{},
+			ServiceName: "amazonq-proxy",
+			SampleRatio: 1,
+		},
+		Usage: UsageConfig{
+			Dir:           "data/usage",
+			FlushInterval: Duration(10 * time.Second),
+		},
+	}
+}
+
+// DefaultHeaderProfile 内置的请求头配置名称，直接使用 upstream.headers 和 upstream.oidc_headers
+const DefaultHeaderProfile = "default"
+
+// Duration 配置文件中的时长，格式如 30s、1m30s、250ms
+type Duration time.Duration
+
+// Std 返回 time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// MarshalText 输出 time.Duration 格式的字符串
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText 解析 time.Duration 格式的字符串（0 可省略单位）
+func (d *Duration) UnmarshalText(text []byte) error {
+	if n, err := strconv.Atoi(string(text)); err == nil && n == 0 {
+		*d = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: expected a value such as 30s, 1m or 250ms", text)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// copyMap 复制字符串映射
+func copyMap(m map[string]string) map[string]string {
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,615 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// regionPattern AWS 区域名称格式，如 us-east-1、eu-central-1、us-gov-west-1
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+// ValidRegion 判断字符串是否为 AWS 区域名称
+// 参数 name 为待检查的字符串
+// 返回是否为区域名称
+func ValidRegion(name string) bool {
+	return regionPattern.MatchString(name)
+}
+
+// current 当前生效的配置
+var current atomic.Pointer[Config]
+
+// init 使用默认值和环境变量初始化配置，保证未调用 Load 时各模块也能读取到配置
+func init() {
+	cfg := Default()
+	applyEnv(cfg)
+	current.Store(cfg)
+}
+
+// Current 返回当前生效的配置，调用方不应修改返回值
+func Current() *Config {
+	return current.Load()
+}
+
+// Set 替换当前生效的配置
+// 参数 cfg 为新的配置
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
+
+// Flags 命令行参数
+type Flags struct {
+	ConfigFile  string   // 配置文件路径（-config，未指定时读取 CONFIG_FILE 环境变量）
+	Listen      string   // 监听地址（-listen）
+	Region      string   // 默认区域（-region）
+	LogLevel    string   // 日志级别（-log-level）
+	Sets        []string // 按点分路径覆盖的配置项，如 upstream.retry.max_attempts=5（-set，可重复）
+	PrintConfig bool     // 输出生效配置后退出（-print-config）
+	HealthCheck bool     // 请求本机的 /healthz 后退出，用于没有 curl 的容器镜像（-healthcheck）
+}
+
+// setFlag 可重复的 -set 参数
+type setFlag struct {
+	values *[]string
+}
+
+// String 返回参数的字符串形式
+func (s setFlag) String() string {
+	if s.values == nil {
+		return ""
+	}
+	return strings.Join(*s.values, ",")
+}
+
+// Set 追加一个 key=value
+func (s setFlag) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	*s.values = append(*s.values, value)
+	return nil
+}
+
+// ParseFlags 解析命令行参数
+// 参数 name 为程序名称
+// 参数 args 为不含程序名称的命令行参数
+// 返回解析结果和可能的错误
+func ParseFlags(name string, args []string) (*Flags, error) {
+	flags := &Flags{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&flags.ConfigFile, "config", os.Getenv("CONFIG_FILE"), "配置文件路径（.yaml、.yml 或 .toml）")
+	fs.StringVar(&flags.Listen, "listen", "", "监听地址，如 0.0.0.0:8000")
+	fs.StringVar(&flags.Region, "region", "", "凭据未指定区域时使用的 AWS 区域")
+	fs.StringVar(&flags.LogLevel, "log-level", "", "日志级别：debug、info、warn、error")
+	fs.Var(setFlag{&flags.Sets}, "set", "按点分路径覆盖配置项，如 -set upstream.retry.max_attempts=5（可重复）")
+	fs.BoolVar(&flags.PrintConfig, "print-config", false, "输出生效配置（已隐藏密钥）后退出")
+	fs.BoolVar(&flags.HealthCheck, "healthcheck", false, "请求本机的 /healthz，服务正常时以 0 退出，否则以 1 退出")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return flags, nil
+}
+
+// Load 按 默认值 < 配置文件 < 环境变量 < 命令行参数 的优先级加载并校验配置
+// 参数 flags 为命令行参数，可为 nil
+// 返回加载后的配置和可能的错误（包含所有格式错误和校验失败的字段）
+func Load(flags *Flags) (*Config, error) {
+	if flags == nil {
+		flags = &Flags{}
+	}
+
+	cfg := Default()
+	if flags.ConfigFile != "" {
+		if err := loadFile(flags.ConfigFile, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	errs := applyEnv(cfg)
+
+	if flags.Listen != "" {
+		cfg.Server.Listen = flags.Listen
+	}
+	if flags.Region != "" {
+		cfg.Upstream.Region = flags.Region
+	}
+	if flags.LogLevel != "" {
+		cfg.Logging.Level = flags.LogLevel
+	}
+	for _, set := range flags.Sets {
+		if err := applySet(cfg, set); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, loadCredentialFiles(cfg)...)
+
+	// 配置文件中的请求头按键覆盖默认值，未列出的默认请求头保留
+	cfg.Upstream.Headers = mergeHeaders(defaultHeaders, cfg.Upstream.Headers)
+	cfg.Upstream.OIDCHeaders = mergeHeaders(defaultOIDCHeaders, cfg.Upstream.OIDCHeaders)
+
+	errs = append(errs, cfg.Validate()...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n  %w", joinErrors(errs))
+	}
+	return cfg, nil
+}
+
+// loadFile 从 YAML 或 TOML 文件读取配置，按扩展名选择格式，文件中未出现的字段保留原值
+// 参数 path 为配置文件路径
+// 参数 cfg 为待覆盖的配置
+// 返回可能的错误
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(cfg); err != nil {
+			var strict *toml.StrictMissingError
+			if errors.As(err, &strict) {
+				return fmt.Errorf("parse %s: %s", path, strict.String())
+			}
+			var decodeErr *toml.DecodeError
+			if errors.As(err, &decodeErr) {
+				row, col := decodeErr.Position()
+				return fmt.Errorf("parse %s: line %d column %d: %s", path, row, col, decodeErr.Error())
+			}
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported extension %q (use .yaml, .yml or .toml)", path, filepath.Ext(path))
+	}
+	return nil
+}
+
+// setLinePrefix -set 解析错误中无意义的行号前缀
+var setLinePrefix = regexp.MustCompile(`line \d+: `)
+
+// loadCredentialFiles 读取凭据文件并追加到同名凭据池
+// 参数 cfg 为待补充的配置
+// 返回读取失败的凭据文件
+func loadCredentialFiles(cfg *Config) []error {
+	var errs []error
+	for _, name := range sortedKeys(cfg.Credentials.Files) {
+		path := cfg.Credentials.Files[name]
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("credentials.files.%s: %v", name, err))
+			continue
+		}
+
+		// 复制切片，避免修改默认配置或配置文件中共享的底层数组
+		creds := append([]string(nil), cfg.Credentials.Pools[name]...)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			creds = append(creds, line)
+		}
+		if cfg.Credentials.Pools == nil {
+			cfg.Credentials.Pools = make(map[string][]string)
+		}
+		cfg.Credentials.Pools[name] = creds
+	}
+	return errs
+}
+
+// applySet 按点分路径覆盖单个配置项，值按 YAML 解析，如 upstream.fallback_regions=[us-west-2,eu-west-1]
+// 参数 cfg 为待覆盖的配置
+// 参数 set 为 key=value 形式的覆盖项
+// 返回可能的错误
+func applySet(cfg *Config, set string) error {
+	key, value, _ := strings.Cut(set, "=")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return fmt.Errorf("-set %q: missing key", set)
+	}
+
+	var valueNode yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &valueNode); err != nil {
+		return fmt.Errorf("-set %s: %v", key, err)
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if len(valueNode.Content) > 0 {
+		node = valueNode.Content[0]
+	}
+
+	// 由内向外构造只包含该路径的文档
+	parts := strings.Split(key, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		node = &yaml.Node{
+			Kind:    yaml.MappingNode,
+			Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: parts[i]}, node},
+		}
+	}
+	doc, err := yaml.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("-set %s: %v", key, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(doc))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		message := strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n  ")
+		return fmt.Errorf("-set %s: %s", key, setLinePrefix.ReplaceAllString(message, ""))
+	}
+	return nil
+}
+
+// Validate 校验配置
+// 返回所有校验失败的字段，为空表示配置有效
+func (c *Config) Validate() []error {
+	var errs []error
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if _, port, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		fail("server.listen", "%q is not host:port", c.Server.Listen)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		fail("server.listen", "invalid port %q", port)
+	}
+	if redirect := c.Server.RootRedirect; redirect != "" && !validURL(redirect, "http", "https") && (!strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//")) {
+		fail("server.root_redirect", "%q is not an http(s) URL or absolute path", redirect)
+	}
+
+	up := c.Upstream
+	if !regionPattern.MatchString(up.Region) {
+		fail("upstream.region", "%q is not an AWS region", up.Region)
+	}
+	for i, name := range up.FallbackRegions {
+		if !regionPattern.MatchString(name) {
+			fail(fmt.Sprintf("upstream.fallback_regions[%d]", i), "%q is not an AWS region", name)
+		}
+	}
+	if up.Endpoint != "" && !validURL(up.Endpoint, "http", "https") {
+		fail("upstream.endpoint", "%q is not an http(s) URL", up.Endpoint)
+	}
+	if up.OIDCEndpoint != "" && !validURL(up.OIDCEndpoint, "http", "https") {
+		fail("upstream.oidc_endpoint", "%q is not an http(s) URL", up.OIDCEndpoint)
+	}
+	if up.Proxy != "" && !validURL(up.Proxy, "http", "https", "socks5", "socks5h") {
+		fail("upstream.proxy", "%q is not an http, https or socks5 URL", redactURL(up.Proxy))
+	}
+
+	timeouts := map[string]Duration{
+		"server.watch_interval":             c.Server.WatchInterval,
+		"server.shutdown_timeout":           c.Server.ShutdownTimeout,
+		"upstream.timeouts.request":         up.Timeouts.Request,
+		"upstream.timeouts.dial":            up.Timeouts.Dial,
+		"upstream.timeouts.tls_handshake":   up.Timeouts.TLSHandshake,
+		"upstream.timeouts.response_header": up.Timeouts.ResponseHeader,
+		"upstream.timeouts.idle_stream":     up.Timeouts.IdleStream,
+		"upstream.timeouts.idle_conn":       up.Timeouts.IdleConn,
+		"upstream.retry.base_delay":         up.Retry.BaseDelay,
+		"upstream.retry.max_delay":          up.Retry.MaxDelay,
+		"upstream.retry.budget":             up.Retry.Budget,
+		"breaker.open_duration":             c.Breaker.OpenDuration,
+		"response_cache.ttl":                c.ResponseCache.TTL,
+		"usage.flush_interval":              c.Usage.FlushInterval,
+	}
+	for _, field := range sortedKeys(timeouts) {
+		if timeouts[field] < 0 {
+			fail(field, "must not be negative")
+		}
+	}
+
+	counts := map[string]int{
+		"upstream.pool.max_idle_conns":          up.Pool.MaxIdleConns,
+		"upstream.pool.max_idle_conns_per_host": up.Pool.MaxIdleConnsPerHost,
+		"upstream.pool.max_conns_per_host":      up.Pool.MaxConnsPerHost,
+		"limits.max_images_per_request":         c.Limits.MaxImagesPerRequest,
+		"prompt_cache.max_entries":              c.PromptCache.MaxEntries,
+		"prompt_cache.min_tokens":               c.PromptCache.MinTokens,
+		"response_cache.max_entries":            c.ResponseCache.MaxEntries,
+		"response_cache.max_bytes":              c.ResponseCache.MaxBytes,
+		"response_cache.max_entry_bytes":        c.ResponseCache.MaxEntryBytes,
+	}
+	for _, field := range sortedKeys(counts) {
+		if counts[field] < 0 {
+			fail(field, "must not be negative")
+		}
+	}
+	positives := map[string]int{
+		"upstream.retry.max_attempts":  up.Retry.MaxAttempts,
+		"breaker.failure_threshold":    c.Breaker.FailureThreshold,
+		"breaker.half_open_probes":     c.Breaker.HalfOpenProbes,
+		"limits.max_image_bytes":       c.Limits.MaxImageBytes,
+		"limits.max_image_dimension":   c.Limits.MaxImageDimension,
+		"limits.max_image_fetch_bytes": c.Limits.MaxImageFetchBytes,
+	}
+	for _, field := range sortedKeys(positives) {
+		if positives[field] < 1 {
+			fail(field, "must be at least 1, got %d", positives[field])
+		}
+	}
+	if up.Retry.BaseDelay > up.Retry.MaxDelay {
+		fail("upstream.retry.base_delay", "%s is greater than upstream.retry.max_delay %s", up.Retry.BaseDelay.Std(), up.Retry.MaxDelay.Std())
+	}
+
+	if _, ok := up.Profiles[up.Profile]; !ok {
+		fail("upstream.header_profile", "header profile %q is not defined in upstream.header_profiles", up.Profile)
+	}
+	for _, model := range sortedKeys(up.ProfileModels) {
+		if _, ok := up.Profiles[up.ProfileModels[model]]; !ok {
+			fail("upstream.header_profile_models."+model, "header profile %q is not defined in upstream.header_profiles", up.ProfileModels[model])
+		}
+	}
+	for _, hash := range sortedKeys(up.ProfileKeys) {
+		if _, ok := up.Profiles[up.ProfileKeys[hash]]; !ok {
+			fail("upstream.header_profile_keys."+hash, "header profile %q is not defined in upstream.header_profiles", up.ProfileKeys[hash])
+		}
+	}
+	for _, name := range sortedKeys(up.Profiles) {
+		if target := up.Profiles[name].Target; target != "" && !strings.Contains(target, ".") {
+			fail("upstream.header_profiles."+name+".target", "%q is not in Service.Operation form", target)
+		}
+	}
+
+	for _, alias := range sortedKeys(c.Models.Aliases) {
+		if strings.TrimSpace(c.Models.Aliases[alias]) == "" {
+			fail("models.aliases."+alias, "target model is empty")
+		}
+	}
+
+	for _, name := range sortedKeys(c.Credentials.Pools) {
+		for i, cred := range c.Credentials.Pools[name] {
+			if strings.Count(cred, ":") < 2 {
+				fail(fmt.Sprintf("credentials.pools.%s[%d]", name, i), "expected [region:]clientId:clientSecret:refreshToken")
+			}
+		}
+	}
+	seenKeys := make(map[string]bool)
+	seenNames := make(map[string]bool)
+	quotas := c.Usage.DefaultQuota.Limited()
+	for i, key := range c.Credentials.APIKeys {
+		field := fmt.Sprintf("credentials.api_keys[%d]", i)
+		switch {
+		case key.Key == "":
+			fail(field+".key", "must not be empty")
+		case seenKeys[key.Key]:
+			fail(field+".key", "duplicate API key")
+		}
+		seenKeys[key.Key] = true
+		if len(c.Credentials.Pools[key.Pool]) == 0 {
+			fail(field+".pool", "pool %q is not defined or has no credentials", key.Pool)
+		}
+		if key.Name != "" {
+			if seenNames[key.Name] {
+				fail(field+".name", "duplicate name %q", key.Name)
+			}
+			seenNames[key.Name] = true
+		}
+		if key.Quota != nil {
+			validateQuota(field+".quota", *key.Quota, fail)
+			quotas = quotas || key.Quota.Limited()
+		}
+	}
+	validateQuota("usage.default_quota", c.Usage.DefaultQuota, fail)
+	if quotas && !c.Usage.Enabled {
+		fail("usage.enabled", "must be true to enforce quotas")
+	}
+	if c.Usage.Enabled && c.Usage.Dir == "" {
+		fail("usage.dir", "must not be empty")
+	}
+	if c.Usage.Enabled && c.Usage.FlushInterval.Std() < time.Second {
+		fail("usage.flush_interval", "must be at least 1s when usage is enabled")
+	}
+	if c.Usage.Timezone != "" {
+		if _, err := time.LoadLocation(c.Usage.Timezone); err != nil {
+			fail("usage.timezone", "%v", err)
+		}
+	}
+
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("logging.level", "%q must be one of debug, info, warn, error", c.Logging.Level)
+	}
+	switch c.Logging.Format {
+	case "text", "json":
+	default:
+		fail("logging.format", "%q must be text or json", c.Logging.Format)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if !validURL(c.Tracing.Endpoint, "http", "https") {
+			fail("tracing.endpoint", "%q is not an http(s) URL", c.Tracing.Endpoint)
+		}
+	default:
+		fail("tracing.exporter", "%q must be none, otlp or stdout", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "%v must be between 0 and 1", c.Tracing.SampleRatio)
+	}
+
+	switch c.ResponseCache.Backend {
+	case "memory", "disk":
+	default:
+		fail("response_cache.backend", "%q must be memory or disk", c.ResponseCache.Backend)
+	}
+
+	return errs
+}
+
+// validateQuota 校验配额不为负数
+// 参数 field 为配额所在的字段路径
+// 参数 quota 为配额
+// 参数 fail 为记录校验错误的函数
+func validateQuota(field string, quota QuotaConfig, fail func(field, format string, args ...interface{})) {
+	limits := map[string]int{
+		"daily_requests":   quota.DailyRequests,
+		"daily_tokens":     quota.DailyTokens,
+		"monthly_requests": quota.MonthlyRequests,
+		"monthly_tokens":   quota.MonthlyTokens,
+	}
+	for _, name := range sortedKeys(limits) {
+		if limits[name] < 0 {
+			fail(field+"."+name, "must not be negative")
+		}
+	}
+}
+
+// Redacted 返回隐藏了密钥的配置副本，用于输出生效配置
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if redacted.Server.AdminToken != "" {
+		redacted.Server.AdminToken = redactedValue
+	}
+	redacted.Upstream.Proxy = redactURL(c.Upstream.Proxy)
+
+	redacted.Credentials.Pools = make(map[string][]string, len(c.Credentials.Pools))
+	for name, creds := range c.Credentials.Pools {
+		masked := make([]string, len(creds))
+		for i, cred := range creds {
+			masked[i] = redactCredential(cred)
+		}
+		redacted.Credentials.Pools[name] = masked
+	}
+	redacted.Credentials.APIKeys = make([]APIKeyConfig, len(c.Credentials.APIKeys))
+	for i, key := range c.Credentials.APIKeys {
+		redacted.Credentials.APIKeys[i] = APIKeyConfig{Key: redactSecret(key.Key), Pool: key.Pool, Name: key.Name, Quota: key.Quota, Template: key.Template}
+	}
+	redacted.Tracing.Headers = make(map[string]string, len(c.Tracing.Headers))
+	for name, value := range c.Tracing.Headers {
+		redacted.Tracing.Headers[name] = redactSecret(value)
+	}
+	return &redacted
+}
+
+// YAML 返回配置的 YAML 表示
+func (c *Config) YAML() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("# failed to encode config: %v\n", err)
+	}
+	return string(data)
+}
+
+// redactedValue 隐藏后的密钥
+const redactedValue = "<redacted>"
+
+// redactSecret 隐藏密钥，仅保留前 4 个字符用于辨认
+// 参数 secret 为密钥
+// 返回隐藏后的字符串
+func redactSecret(secret string) string {
+	if len(secret) <= 8 {
+		return redactedValue
+	}
+	return secret[:4] + "…" + redactedValue
+}
+
+// redactCredential 隐藏凭据中的 clientSecret 和 refreshToken，保留区域和 clientId
+// 参数 cred 为 [region:]clientId:clientSecret:refreshToken 格式的凭据
+// 返回隐藏后的字符串
+func redactCredential(cred string) string {
+	prefix := ""
+	if name, rest, ok := strings.Cut(cred, ":"); ok && regionPattern.MatchString(name) {
+		prefix, cred = name+":", rest
+	}
+	clientID, _, ok := strings.Cut(cred, ":")
+	if !ok {
+		return redactedValue
+	}
+	return prefix + clientID + ":" + redactedValue + ":" + redactedValue
+}
+
+// redactURL 隐藏 URL 中的密码
+// 参数 raw 为 URL
+// 返回隐藏密码后的 URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// validURL 判断字符串是否为指定协议的 URL
+// 参数 raw 为待检查的字符串
+// 参数 schemes 为允许的协议
+// 返回是否有效
+func validURL(raw string, schemes ...string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeHeaders 合并请求头，override 中的值覆盖 base，值为空表示删除该请求头
+// 参数 base 为默认请求头
+// 参数 override 为配置的请求头
+// 返回合并后的新映射（键统一为小写）
+func mergeHeaders(base, override map[string]string) map[string]string {
+	result := copyMap(base)
+	for k, v := range override {
+		k = strings.ToLower(k)
+		if v == "" {
+			delete(result, k)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// joinErrors 将多个错误合并为逐行列出的单个错误
+// 参数 errs 为错误列表
+// 返回合并后的错误
+func joinErrors(errs []error) error {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return errors.New(strings.Join(messages, "\n  "))
+}
+
+// sortedKeys 返回按字典序排列的映射键，保证错误输出顺序稳定
+// 参数 m 为映射
+// 返回排序后的键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
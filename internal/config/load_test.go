@@ -0,0 +1,234 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig 在临时目录中写入配置文件并返回路径
+func writeConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearEnv 清空测试涉及的环境变量，避免受运行环境影响
+func clearEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		t.Setenv(key, "")
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t, "AMAZONQ_REGION", "LOG_LEVEL", "UPSTREAM_MAX_ATTEMPTS", "UPSTREAM_TIMEOUT", "LOG_FORMAT")
+
+	files := map[string]string{
+		"config.yaml": `
+upstream:
+  region: us-west-2
+  retry:
+    max_attempts: 2
+  timeouts:
+    request: 45s
+logging:
+  level: warn
+  format: json
+`,
+		"config.toml": `
+[upstream]
+region = "us-west-2"
+
+[upstream.retry]
+max_attempts = 2
+
+[upstream.timeouts]
+request = "45s"
+
+[logging]
+level = "warn"
+format = "json"
+`,
+	}
+	for name, content := range files {
+		path := writeConfig(t, name, content)
+
+		// 只有配置文件
+		cfg, err := Load(&Flags{ConfigFile: path})
+		if err != nil {
+			t.Fatalf("%s: Load: %v", name, err)
+		}
+		if cfg.Upstream.Region != "us-west-2" || cfg.Upstream.Retry.MaxAttempts != 2 || cfg.Logging.Level != "warn" {
+			t.Errorf("%s: file values not applied: region=%q attempts=%d level=%q", name, cfg.Upstream.Region, cfg.Upstream.Retry.MaxAttempts, cfg.Logging.Level)
+		}
+		if cfg.Upstream.Timeouts.Request.Std() != 45*time.Second {
+			t.Errorf("%s: timeouts.request = %s, want 45s", name, cfg.Upstream.Timeouts.Request.Std())
+		}
+		// 文件中未出现的字段保留默认值
+		if cfg.Upstream.Timeouts.Dial != Default().Upstream.Timeouts.Dial {
+			t.Errorf("%s: timeouts.dial = %s, want the default", name, cfg.Upstream.Timeouts.Dial.Std())
+		}
+
+		// 环境变量覆盖配置文件，命令行参数覆盖环境变量
+		t.Setenv("AMAZONQ_REGION", "eu-west-1")
+		t.Setenv("LOG_LEVEL", "error")
+		t.Setenv("UPSTREAM_MAX_ATTEMPTS", "4")
+		cfg, err = Load(&Flags{
+			ConfigFile: path,
+			Region:     "ap-northeast-1",
+			Sets:       []string{"upstream.retry.max_attempts=5"},
+		})
+		if err != nil {
+			t.Fatalf("%s: Load: %v", name, err)
+		}
+		if cfg.Upstream.Region != "ap-northeast-1" {
+			t.Errorf("%s: region = %q, want the -region flag", name, cfg.Upstream.Region)
+		}
+		if cfg.Logging.Level != "error" {
+			t.Errorf("%s: logging.level = %q, want LOG_LEVEL", name, cfg.Logging.Level)
+		}
+		if cfg.Upstream.Retry.MaxAttempts != 5 {
+			t.Errorf("%s: max_attempts = %d, want the -set value", name, cfg.Upstream.Retry.MaxAttempts)
+		}
+		if cfg.Logging.Format != "json" {
+			t.Errorf("%s: logging.format = %q, want the file value", name, cfg.Logging.Format)
+		}
+		clearEnv(t, "AMAZONQ_REGION", "LOG_LEVEL", "UPSTREAM_MAX_ATTEMPTS")
+	}
+}
+
+func TestLoadEnvErrors(t *testing.T) {
+	t.Setenv("UPSTREAM_MAX_ATTEMPTS", "three")
+	t.Setenv("UPSTREAM_TIMEOUT", "soon")
+	_, err := Load(nil)
+	if err == nil {
+		t.Fatal("Load accepted malformed environment variables")
+	}
+	for _, want := range []string{"UPSTREAM_MAX_ATTEMPTS", "UPSTREAM_TIMEOUT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestParseFlagsSet(t *testing.T) {
+	flags, err := ParseFlags("proxy", []string{
+		"-set", "upstream.retry.max_attempts=5",
+		"-set", "upstream.fallback_regions=[us-west-2,eu-west-1]",
+		"-set", "models.aliases.fast=claude-haiku-4.5",
+		"-listen", "127.0.0.1:9000",
+	})
+	if err != nil {
+		t.Fatalf("ParseFlags: %v", err)
+	}
+	if len(flags.Sets) != 3 {
+		t.Fatalf("Sets = %v, want 3 values", flags.Sets)
+	}
+
+	clearEnv(t, "PORT", "UPSTREAM_MAX_ATTEMPTS", "AMAZONQ_FALLBACK_REGIONS", "MODEL_ALIASES")
+	cfg, err := Load(flags)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Upstream.Retry.MaxAttempts != 5 {
+		t.Errorf("max_attempts = %d, want 5", cfg.Upstream.Retry.MaxAttempts)
+	}
+	if want := []string{"us-west-2", "eu-west-1"}; !reflect.DeepEqual(cfg.Upstream.FallbackRegions, want) {
+		t.Errorf("fallback_regions = %v, want %v", cfg.Upstream.FallbackRegions, want)
+	}
+	if cfg.Models.Aliases["fast"] != "claude-haiku-4.5" {
+		t.Errorf("models.aliases = %v, want fast -> claude-haiku-4.5", cfg.Models.Aliases)
+	}
+	if cfg.Server.Listen != "127.0.0.1:9000" {
+		t.Errorf("listen = %q, want the -listen flag", cfg.Server.Listen)
+	}
+
+	// 后出现的 -set 覆盖先出现的
+	cfg, err = Load(&Flags{Sets: []string{"upstream.retry.max_attempts=5", "upstream.retry.max_attempts=7"}})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Upstream.Retry.MaxAttempts != 7 {
+		t.Errorf("max_attempts = %d, want the last -set value", cfg.Upstream.Retry.MaxAttempts)
+	}
+
+	if _, err := ParseFlags("proxy", []string{"-set", "upstream.region"}); err == nil || !strings.Contains(err.Error(), "expected key=value") {
+		t.Errorf("ParseFlags without = error = %v", err)
+	}
+	if _, err := ParseFlags("proxy", []string{"extra"}); err == nil || !strings.Contains(err.Error(), "unexpected arguments") {
+		t.Errorf("ParseFlags with a positional argument error = %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	cases := []struct {
+		name  string
+		flags *Flags
+		want  string
+	}{
+		{"yaml", &Flags{ConfigFile: writeConfig(t, "config.yaml", "upstream:\n  regoin: us-west-2\n")}, "field regoin not found"},
+		{"toml", &Flags{ConfigFile: writeConfig(t, "config.toml", "[upstream]\nregoin = \"us-west-2\"\n")}, "regoin"},
+		{"-set", &Flags{Sets: []string{"upstream.retry.attempts=5"}}, "-set upstream.retry.attempts: field attempts not found"},
+		{"-set type", &Flags{Sets: []string{"upstream.retry.max_attempts=many"}}, "-set upstream.retry.max_attempts"},
+		{"-set missing key", &Flags{Sets: []string{"=5"}}, "missing key"},
+		{"extension", &Flags{ConfigFile: writeConfig(t, "config.json", "{}")}, "unsupported extension"},
+	}
+	for _, tc := range cases {
+		if _, err := Load(tc.flags); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Load error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   string // 期望的错误字段，为空表示配置有效
+	}{
+		{"defaults", func(cfg *Config) {}, ""},
+		{"listen without port", func(cfg *Config) { cfg.Server.Listen = "localhost" }, "server.listen"},
+		{"listen port out of range", func(cfg *Config) { cfg.Server.Listen = ":70000" }, "server.listen"},
+		{"root redirect", func(cfg *Config) { cfg.Server.RootRedirect = "//evil.example" }, "server.root_redirect"},
+		{"region", func(cfg *Config) { cfg.Upstream.Region = "mars" }, "upstream.region"},
+		{"fallback region", func(cfg *Config) { cfg.Upstream.FallbackRegions = []string{"us-west-2", "moon"} }, "upstream.fallback_regions[1]"},
+		{"proxy scheme", func(cfg *Config) { cfg.Upstream.Proxy = "ftp://proxy:21" }, "upstream.proxy"},
+		{"negative timeout", func(cfg *Config) { cfg.Upstream.Timeouts.Dial = Duration(-time.Second) }, "upstream.timeouts.dial"},
+		{"zero attempts", func(cfg *Config) { cfg.Upstream.Retry.MaxAttempts = 0 }, "upstream.retry.max_attempts"},
+		{"base delay above max delay", func(cfg *Config) { cfg.Upstream.Retry.BaseDelay = cfg.Upstream.Retry.MaxDelay + 1 }, "upstream.retry.base_delay"},
+		{"undefined header profile", func(cfg *Config) { cfg.Upstream.Profile = "missing" }, "upstream.header_profile"},
+		{"credential format", func(cfg *Config) { cfg.Credentials.Pools = map[string][]string{"default": {"only-one-part"}} }, "credentials.pools.default[0]"},
+		{"api key pool", func(cfg *Config) { cfg.Credentials.APIKeys = []APIKeyConfig{{Key: "sk-1", Pool: "missing"}} }, "credentials.api_keys[0].pool"},
+		{"duplicate api key", func(cfg *Config) {
+			cfg.Credentials.Pools = map[string][]string{"default": {"id:secret:token"}}
+			cfg.Credentials.APIKeys = []APIKeyConfig{{Key: "sk-1", Pool: "default"}, {Key: "sk-1", Pool: "default"}}
+		}, "credentials.api_keys[1].key"},
+		{"quota without usage", func(cfg *Config) { cfg.Usage.DefaultQuota.DailyRequests = 10 }, "usage.enabled"},
+		{"negative quota", func(cfg *Config) { cfg.Usage.DefaultQuota.MonthlyTokens = -1 }, "usage.default_quota.monthly_tokens"},
+		{"log level", func(cfg *Config) { cfg.Logging.Level = "verbose" }, "logging.level"},
+		{"otlp endpoint", func(cfg *Config) { cfg.Tracing.Exporter, cfg.Tracing.Endpoint = "otlp", "collector:4318" }, "tracing.endpoint"},
+		{"sample ratio", func(cfg *Config) { cfg.Tracing.SampleRatio = 1.5 }, "tracing.sample_ratio"},
+		{"cache backend", func(cfg *Config) { cfg.ResponseCache.Backend = "redis" }, "response_cache.backend"},
+	}
+	for _, tc := range cases {
+		cfg := Default()
+		tc.modify(cfg)
+		errs := cfg.Validate()
+		if tc.want == "" {
+			if len(errs) > 0 {
+				t.Errorf("%s: Validate() = %v, want no errors", tc.name, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), tc.want+": ") {
+			t.Errorf("%s: Validate() = %v, want one error for %s", tc.name, errs, tc.want)
+		}
+	}
+}
@@ -1,28 +1,258 @@
 package config
 
-// AmazonQAPIURL Amazon Q API 服务端点地址
-const AmazonQAPIURL = "https://q.us-east-1.amazonaws.com/"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
-// OIDCBaseURL OIDC 认证服务基础 URL
-const OIDCBaseURL = "https://oidc.us-east-1.amazonaws.com"
-
-// TokenURL OIDC Token 获取端点
-var TokenURL = OIDCBaseURL + "/token"
-
-// DefaultHeaders Amazon Q API 默认请求头
-var DefaultHeaders = map[string]string{
+// defaultHeaders Amazon Q API 默认请求头（配置文件中的 upstream.headers 按键覆盖）
+var defaultHeaders = map[string]string{
 	"content-type":                "application/x-amz-json-1.0",
 	"x-amz-target":                "AmazonCodeWhispererStreamingService.GenerateAssistantResponse",
 	"user-agent":                  "aws-sdk-rust/1.3.9 ua/2.1 api/codewhispererstreaming/0.1.11582 os/windows lang/rust/1.87.0 md/appVersion-1.19.4 app/AmazonQ-For-CLI",
 	"x-amz-user-agent":            "aws-sdk-rust/1.3.9 ua/2.1 api/codewhispererstreaming/0.1.11582 os/windows lang/rust/1.87.0 m/F app/AmazonQ-For-CLI",
 	"x-amzn-codewhisperer-optout": "false",
-	"amz-sdk-request":             "attempt=1; max=3",
+	"amz-sdk-request":             "attempt=1; max=3", // 每次尝试时按重试策略改写
 }
 
-// OIDCHeaders OIDC 认证请求头
-var OIDCHeaders = map[string]string{
-	"content-type":   "application/json",
-	"user-agent":     "aws-sdk-rust/1.3.9 os/windows lang/rust/1.87.0",
+// defaultOIDCHeaders OIDC 认证默认请求头（配置文件中的 upstream.oidc_headers 按键覆盖）
+var defaultOIDCHeaders = map[string]string{
+	"content-type":     "application/json",
+	"user-agent":       "aws-sdk-rust/1.3.9 os/windows lang/rust/1.87.0",
 	"x-amz-user-agent": "aws-sdk-rust/1.3.9 ua/2.1 api/ssooidc/1.88.0 os/windows lang/rust/1.87.0 m/E app/AmazonQ-For-CLI",
-	"amz-sdk-request": "attempt=1; max=3",
+	"amz-sdk-request":  "attempt=1; max=3",
+}
+
+// applyEnv 使用环境变量覆盖配置，未设置的环境变量保留原值
+// 参数 cfg 为待覆盖的配置
+// 返回格式错误的环境变量
+func applyEnv(cfg *Config) []error {
+	env := &envReader{}
+
+	if port := os.Getenv("PORT"); port != "" {
+		cfg.Server.Listen = "0.0.0.0:" + port
+	}
+	env.str("ADMIN_TOKEN", &cfg.Server.AdminToken)
+	env.seconds("CONFIG_WATCH_INTERVAL", &cfg.Server.WatchInterval)
+	env.seconds("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	env.bool("METRICS_ENABLED", &cfg.Server.Metrics)
+	env.str("ROOT_REDIRECT", &cfg.Server.RootRedirect)
+
+	env.str("AMAZONQ_REGION", &cfg.Upstream.Region)
+	env.list("AMAZONQ_FALLBACK_REGIONS", &cfg.Upstream.FallbackRegions)
+	env.str("AMAZONQ_ENDPOINT", &cfg.Upstream.Endpoint)
+	env.str("OIDC_ENDPOINT", &cfg.Upstream.OIDCEndpoint)
+	env.str("HTTPS_PROXY", &cfg.Upstream.Proxy)
+	env.str("HTTP_PROXY", &cfg.Upstream.Proxy)
+	env.str("no_proxy", &cfg.Upstream.NoProxy)
+	env.str("NO_PROXY", &cfg.Upstream.NoProxy)
+	env.str("HEADER_PROFILE", &cfg.Upstream.Profile)
+	env.mapping("HEADER_PROFILE_MODELS", &cfg.Upstream.ProfileModels)
+	env.mapping("HEADER_PROFILE_KEYS", &cfg.Upstream.ProfileKeys)
+	env.bool("UPSTREAM_HTTP2", &cfg.Upstream.HTTP2)
+	env.seconds("UPSTREAM_TIMEOUT", &cfg.Upstream.Timeouts.Request)
+	env.seconds("UPSTREAM_DIAL_TIMEOUT", &cfg.Upstream.Timeouts.Dial)
+	env.seconds("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", &cfg.Upstream.Timeouts.TLSHandshake)
+	env.seconds("UPSTREAM_RESPONSE_HEADER_TIMEOUT", &cfg.Upstream.Timeouts.ResponseHeader)
+	env.seconds("UPSTREAM_IDLE_STREAM_TIMEOUT", &cfg.Upstream.Timeouts.IdleStream)
+	env.seconds("UPSTREAM_KEEPALIVE", &cfg.Upstream.Timeouts.KeepAlive)
+	env.seconds("UPSTREAM_IDLE_CONN_TIMEOUT", &cfg.Upstream.Timeouts.IdleConn)
+	env.int("UPSTREAM_MAX_IDLE_CONNS", &cfg.Upstream.Pool.MaxIdleConns)
+	env.int("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", &cfg.Upstream.Pool.MaxIdleConnsPerHost)
+	env.int("UPSTREAM_MAX_CONNS_PER_HOST", &cfg.Upstream.Pool.MaxConnsPerHost)
+	env.int("UPSTREAM_MAX_ATTEMPTS", &cfg.Upstream.Retry.MaxAttempts)
+	env.millis("UPSTREAM_RETRY_BASE_DELAY_MS", &cfg.Upstream.Retry.BaseDelay)
+	env.millis("UPSTREAM_RETRY_MAX_DELAY_MS", &cfg.Upstream.Retry.MaxDelay)
+	env.seconds("UPSTREAM_RETRY_BUDGET", &cfg.Upstream.Retry.Budget)
+
+	env.bool("BREAKER_ENABLED", &cfg.Breaker.Enabled)
+	env.int("BREAKER_FAILURE_THRESHOLD", &cfg.Breaker.FailureThreshold)
+	env.seconds("BREAKER_OPEN_SECONDS", &cfg.Breaker.OpenDuration)
+	env.int("BREAKER_HALF_OPEN_PROBES", &cfg.Breaker.HalfOpenProbes)
+
+	env.mapping("MODEL_ALIASES", &cfg.Models.Aliases)
+
+	env.str("LOG_LEVEL", &cfg.Logging.Level)
+	env.str("LOG_FORMAT", &cfg.Logging.Format)
+
+	env.int("IMAGE_MAX_PER_REQUEST", &cfg.Limits.MaxImagesPerRequest)
+	env.int("IMAGE_MAX_BYTES", &cfg.Limits.MaxImageBytes)
+	env.int("IMAGE_MAX_DIMENSION", &cfg.Limits.MaxImageDimension)
+	env.int("IMAGE_MAX_FETCH_BYTES", &cfg.Limits.MaxImageFetchBytes)
+
+	env.str("AMAZONQ_OPERATING_SYSTEM", &cfg.Context.OperatingSystem)
+	env.str("AMAZONQ_WORKING_DIRECTORY", &cfg.Context.WorkingDirectory)
+
+	env.str("PROMPT_TEMPLATE", &cfg.Prompt.Template)
+	env.str("PROMPT_TEMPLATE_DIR", &cfg.Prompt.Dir)
+	env.mapping("PROMPT_TEMPLATE_MODELS", &cfg.Prompt.Models)
+	env.str("PROMPT_TIMEZONE", &cfg.Prompt.Timezone)
+
+	env.bool("PROMPT_CACHE_ENABLED", &cfg.PromptCache.Enabled)
+	env.int("PROMPT_CACHE_MAX_ENTRIES", &cfg.PromptCache.MaxEntries)
+	env.int("PROMPT_CACHE_MIN_TOKENS", &cfg.PromptCache.MinTokens)
+
+	env.bool("RESPONSE_CACHE_ENABLED", &cfg.ResponseCache.Enabled)
+	env.str("RESPONSE_CACHE_BACKEND", &cfg.ResponseCache.Backend)
+	env.str("RESPONSE_CACHE_DIR", &cfg.ResponseCache.Dir)
+	env.seconds("RESPONSE_CACHE_TTL", &cfg.ResponseCache.TTL)
+	env.int("RESPONSE_CACHE_MAX_ENTRIES", &cfg.ResponseCache.MaxEntries)
+	env.int("RESPONSE_CACHE_MAX_BYTES", &cfg.ResponseCache.MaxBytes)
+	env.int("RESPONSE_CACHE_MAX_ENTRY_BYTES", &cfg.ResponseCache.MaxEntryBytes)
+	env.bool("RESPONSE_CACHE_ZERO_TEMPERATURE_ONLY", &cfg.ResponseCache.ZeroTemperatureOnly)
+
+	env.bool("CITATIONS_ENABLED", &cfg.Citations.Enabled)
+	env.list("LICENSE_BLOCKLIST", &cfg.Citations.LicenseBlocklist)
+
+	env.str("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	env.str("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", &cfg.Tracing.Endpoint)
+	env.str("OTEL_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	env.float("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	env.bool("USAGE_ENABLED", &cfg.Usage.Enabled)
+	env.str("USAGE_DIR", &cfg.Usage.Dir)
+	env.seconds("USAGE_FLUSH_INTERVAL", &cfg.Usage.FlushInterval)
+	env.str("USAGE_TIMEZONE", &cfg.Usage.Timezone)
+	env.int("USAGE_DAILY_REQUESTS", &cfg.Usage.DefaultQuota.DailyRequests)
+	env.int("USAGE_DAILY_TOKENS", &cfg.Usage.DefaultQuota.DailyTokens)
+	env.int("USAGE_MONTHLY_REQUESTS", &cfg.Usage.DefaultQuota.MonthlyRequests)
+	env.int("USAGE_MONTHLY_TOKENS", &cfg.Usage.DefaultQuota.MonthlyTokens)
+
+	return env.errs
+}
+
+// envReader 读取环境变量并记录格式错误
+type envReader struct {
+	errs []error
+}
+
+// str 读取字符串类型的环境变量
+// 参数 key 为环境变量名
+// 参数 target 为设置了环境变量时写入的字段
+func (e *envReader) str(key string, target *string) {
+	if value := os.Getenv(key); value != "" {
+		*target = value
+	}
+}
+
+// int 读取整数类型的环境变量
+// 参数 key 为环境变量名
+// 参数 target 为设置了环境变量时写入的字段
+func (e *envReader) int(key string, target *int) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not an integer", key, value))
+		return
+	}
+	*target = n
+}
+
+// float 读取浮点数类型的环境变量
+// 参数 key 为环境变量名
+// 参数 target 为设置了环境变量时写入的字段
+func (e *envReader) float(key string, target *float64) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a number", key, value))
+		return
+	}
+	*target = f
+}
+
+// bool 读取布尔类型的环境变量
+// 参数 key 为环境变量名
+// 参数 target 为设置了环境变量时写入的字段
+func (e *envReader) bool(key string, target *bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a boolean", key, value))
+		return
+	}
+	*target = b
+}
+
+// seconds 读取以秒为单位的时长环境变量
+// 参数 key 为环境变量名
+// 参数 target 为设置了环境变量时写入的字段
+func (e *envReader) seconds(key string, target *Duration) {
+	e.duration(key, time.Second, target)
+}
+
+// millis 读取以毫秒为单位的时长环境变量
+// 参数 key 为环境变量名
+// 参数 target 为设置了环境变量时写入的字段
+func (e *envReader) millis(key string, target *Duration) {
+	e.duration(key, time.Millisecond, target)
+}
+
+// duration 读取整数时长环境变量
+// 参数 key 为环境变量名
+// 参数 unit 为整数值的单位
+// 参数 target 为设置了环境变量时写入的字段
+func (e *envReader) duration(key string, unit time.Duration, target *Duration) {
+	n := int(target.Std() / unit)
+	before := len(e.errs)
+	e.int(key, &n)
+	if len(e.errs) == before && os.Getenv(key) != "" {
+		*target = Duration(time.Duration(n) * unit)
+	}
+}
+
+// list 读取逗号分隔的列表类型环境变量
+// 参数 key 为环境变量名
+// 参数 target 为设置了环境变量时写入的字段（去除空白和空项）
+func (e *envReader) list(key string, target *[]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	*target = result
+}
+
+// mapping 读取 key=value,key2=value2 格式的环境变量
+// 参数 key 为环境变量名
+// 参数 target 为设置了环境变量时写入的字段（与原有映射合并）
+func (e *envReader) mapping(key string, target *map[string]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	if *target == nil {
+		*target = make(map[string]string)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not in key=value form", key, strings.TrimSpace(pair)))
+			continue
+		}
+		k, v := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if k != "" && v != "" {
+			(*target)[k] = v
+		}
+	}
 }
@@ -7,16 +7,17 @@ import (
 	"strings"
 	"time"
 
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/document"
+	"amazonq-proxy/internal/prompt"
+
 	"github.com/google/uuid"
 )
 
 // GetCurrentTimestamp 获取 Amazon Q 格式的当前时间戳
 // 返回格式为 "Weekday, ISO8601" 的时间字符串
 func GetCurrentTimestamp() string {
-	now := time.Now()
-	weekday := now.Format("Monday")
-	isoTime := now.Format("2006-01-02T15:04:05.000Z07:00")
-	return fmt.Sprintf("%s, %s", weekday, isoTime)
+	return prompt.FormatTimestamp(time.Now())
 }
 
 // IsThinkingModeEnabled 检测是否启用了 thinking 模式
@@ -84,7 +85,6 @@ func ContainsToolContent(content interface{}) bool {
 	return false
 }
 
-
 // ExtractTextFromContent 从 Claude 消息内容中提取纯文本
 // 参数 content 为 Claude 消息内容（可能是字符串或内容块数组）
 // 返回提取的文本内容
@@ -100,6 +100,9 @@ func ExtractTextFromContent(content interface{}) string {
 					if text, ok := blockMap["text"].(string); ok {
 						parts = append(parts, text)
 					}
+				} else if blockMap["type"] == "document" {
+					text, _ := ProcessDocumentBlock(blockMap)
+					parts = append(parts, text)
 				}
 			}
 		}
@@ -108,6 +111,21 @@ func ExtractTextFromContent(content interface{}) string {
 	return ""
 }
 
+// ProcessDocumentBlock 处理 document 内容块（PDF、纯文本或 content 来源）
+// 参数 block 为 document 类型的内容块
+// 返回带分隔标记的文档文本，以及 content 来源中包含的图片
+func ProcessDocumentBlock(block map[string]interface{}) (string, []AmazonQImage) {
+	text, imageBlocks := document.Render(block)
+
+	var images []AmazonQImage
+	for _, imageBlock := range imageBlocks {
+		if image, ok := ConvertImageBlock(imageBlock); ok {
+			images = append(images, image)
+		}
+	}
+	return text, images
+}
+
 // ExtractImagesFromContent 从 Claude 内容中提取图片并转换为 Amazon Q 格式
 // 参数 content 为 Claude 消息内容
 // 返回 Amazon Q 格式的图片列表
@@ -125,48 +143,89 @@ func ExtractImagesFromContent(content interface{}) []AmazonQImage {
 			continue
 		}
 
-		if blockMap["type"] == "image" {
-			source, ok := blockMap["source"].(map[string]interface{})
-			if !ok {
-				continue
-			}
+		if image, ok := ConvertImageBlock(blockMap); ok {
+			images = append(images, image)
+		}
+	}
 
-			if source["type"] == "base64" {
-				mediaType := "image/png"
-				if mt, ok := source["media_type"].(string); ok {
-					mediaType = mt
-				}
+	return images
+}
 
-				format := "png"
-				if strings.Contains(mediaType, "/") {
-					parts := strings.Split(mediaType, "/")
-					format = parts[len(parts)-1]
-				}
+// ConvertImageBlock 将单个 Claude image 内容块转换为 Amazon Q 图片
+// 参数 block 为内容块
+// 返回 Amazon Q 格式的图片以及是否转换成功
+func ConvertImageBlock(block map[string]interface{}) (AmazonQImage, bool) {
+	if block["type"] != "image" {
+		return AmazonQImage{}, false
+	}
 
-				data := ""
-				if d, ok := source["data"].(string); ok {
-					data = d
-				}
+	source, ok := block["source"].(map[string]interface{})
+	if !ok {
+		return AmazonQImage{}, false
+	}
 
-				images = append(images, AmazonQImage{
-					Format: format,
-					Source: ImageBytes{Bytes: data},
-				})
-			}
+	// url 来源由图片处理流程下载并识别格式
+	if source["type"] == "url" {
+		imageURL, _ := source["url"].(string)
+		if imageURL == "" {
+			return AmazonQImage{}, false
 		}
+		return AmazonQImage{SourceURL: imageURL}, true
 	}
 
-	return images
+	if source["type"] != "base64" {
+		return AmazonQImage{}, false
+	}
+
+	mediaType := "image/png"
+	if mt, ok := source["media_type"].(string); ok {
+		mediaType = mt
+	}
+
+	format := "png"
+	if strings.Contains(mediaType, "/") {
+		parts := strings.Split(mediaType, "/")
+		format = parts[len(parts)-1]
+	}
+
+	data := ""
+	if d, ok := source["data"].(string); ok {
+		data = d
+	}
+
+	return AmazonQImage{
+		Format: format,
+		Source: ImageBytes{Bytes: data},
+	}, true
+}
+
+// RenderToolResultValue 将非文本的工具结果渲染为文本
+// 参数 value 为工具结果中的任意 JSON 值
+// 返回文本表示
+func RenderToolResultValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	jsonBytes, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(jsonBytes)
 }
 
 // ProcessToolResultBlock 处理单个 tool_result 块，提取内容并添加到结果列表
 // 参数 block 为 tool_result 类型的内容块
 // 参数 toolResults 为用于存储处理结果的列表指针
-func ProcessToolResultBlock(block map[string]interface{}, toolResults *[]ToolResult) {
+// 返回 tool_result 中包含的图片（需附加到承载 toolResults 的用户消息上）
+func ProcessToolResultBlock(block map[string]interface{}, toolResults *[]ToolResult) []AmazonQImage {
 	toolUseID, _ := block["tool_use_id"].(string)
 	rawContent := block["content"]
 
 	var aqContent []ToolResultContent
+	var images []AmazonQImage
 
 	switch content := rawContent.(type) {
 	case string:
@@ -179,13 +238,28 @@ func ProcessToolResultBlock(block map[string]interface{}, toolResults *[]ToolRes
 					if text, ok := v["text"].(string); ok {
 						aqContent = append(aqContent, ToolResultContent{Text: text})
 					}
+				} else if v["type"] == "image" {
+					if image, ok := ConvertImageBlock(v); ok {
+						images = append(images, image)
+					}
+				} else if v["type"] == "document" {
+					text, docImages := ProcessDocumentBlock(v)
+					aqContent = append(aqContent, ToolResultContent{Text: text})
+					images = append(images, docImages...)
 				} else if text, ok := v["text"].(string); ok {
 					aqContent = append(aqContent, ToolResultContent{Text: text})
+				} else {
+					aqContent = append(aqContent, ToolResultContent{Text: RenderToolResultValue(v)})
 				}
 			case string:
 				aqContent = append(aqContent, ToolResultContent{Text: v})
+			default:
+				aqContent = append(aqContent, ToolResultContent{Text: RenderToolResultValue(v)})
 			}
 		}
+	case nil:
+	default:
+		aqContent = []ToolResultContent{{Text: RenderToolResultValue(content)}}
 	}
 
 	// 检查是否有非空内容
@@ -197,11 +271,22 @@ func ProcessToolResultBlock(block map[string]interface{}, toolResults *[]ToolRes
 		}
 	}
 
+	// 空结果发送空文本；只有 is_error 且没有内容时才视为用户取消了工具调用
+	isError, _ := block["is_error"].(bool)
 	if !hasContent {
-		aqContent = []ToolResultContent{{Text: "Tool use was cancelled by the user"}}
+		if len(images) > 0 {
+			aqContent = []ToolResultContent{{Text: fmt.Sprintf("Tool returned %d image(s), attached to this message.", len(images))}}
+		} else if isError {
+			aqContent = []ToolResultContent{{Text: "Tool use was cancelled by the user"}}
+		} else {
+			aqContent = []ToolResultContent{{Text: ""}}
+		}
 	}
 
 	status := "success"
+	if isError {
+		status = "error"
+	}
 	if s, ok := block["status"].(string); ok {
 		status = s
 	}
@@ -223,6 +308,8 @@ func ProcessToolResultBlock(block map[string]interface{}, toolResults *[]ToolRes
 			Status:    status,
 		})
 	}
+
+	return images
 }
 
 // ConvertTool 将 Claude 工具定义转换为 Amazon Q 工具格式
@@ -245,7 +332,8 @@ func ConvertTool(tool ClaudeTool) AmazonQTool {
 	}
 }
 
-// MergeUserMessages 合并连续的用户消息，仅保留最后 2 条消息的图片
+// MergeUserMessages 合并连续的用户消息
+// 图片全部保留，数量限制由 ImagePipeline 在整个请求范围内统一处理
 // 参数 messages 为用户消息列表
 // 返回合并后的单条消息
 func MergeUserMessages(messages []UserInputMessage) UserInputMessage {
@@ -257,7 +345,7 @@ func MergeUserMessages(messages []UserInputMessage) UserInputMessage {
 	var baseContext UserInputMessageContext
 	var baseOrigin string
 	var baseModelID string
-	var allImages [][]AmazonQImage
+	var allImages []AmazonQImage
 
 	for i, msg := range messages {
 		if i == 0 {
@@ -270,9 +358,7 @@ func MergeUserMessages(messages []UserInputMessage) UserInputMessage {
 			allContents = append(allContents, msg.Content)
 		}
 
-		if len(msg.Images) > 0 {
-			allImages = append(allImages, msg.Images)
-		}
+		allImages = append(allImages, msg.Images...)
 	}
 
 	result := UserInputMessage{
@@ -282,19 +368,8 @@ func MergeUserMessages(messages []UserInputMessage) UserInputMessage {
 		ModelID:                 baseModelID,
 	}
 
-	// 仅保留最后 2 条消息的图片
 	if len(allImages) > 0 {
-		var keptImages []AmazonQImage
-		startIdx := len(allImages) - 2
-		if startIdx < 0 {
-			startIdx = 0
-		}
-		for i := startIdx; i < len(allImages); i++ {
-			keptImages = append(keptImages, allImages[i]...)
-		}
-		if len(keptImages) > 0 {
-			result.Images = keptImages
-		}
+		result.Images = allImages
 	}
 
 	return result
@@ -304,8 +379,9 @@ func MergeUserMessages(messages []UserInputMessage) UserInputMessage {
 // 参数 messages 为 Claude 消息列表
 // 参数 thinkingEnabled 为是否启用 thinking 模式
 // 参数 thinkingHint 为 thinking 提示词
+// 参数 envState 为历史消息使用的环境状态
 // 返回 Amazon Q 格式的历史消息列表
-func ProcessHistory(messages []ClaudeMessage, thinkingEnabled bool, thinkingHint string) []HistoryEntry {
+func ProcessHistory(messages []ClaudeMessage, thinkingEnabled bool, thinkingHint string, envState EnvState) []HistoryEntry {
 	var history []HistoryEntry
 	seenToolUseIDs := make(map[string]bool)
 	var rawHistory []HistoryEntry
@@ -328,8 +404,12 @@ func ProcessHistory(messages []ClaudeMessage, thinkingEnabled bool, thinkingHint
 							if text, ok := blockMap["text"].(string); ok {
 								textParts = append(textParts, text)
 							}
+						} else if btype == "document" {
+							text, docImages := ProcessDocumentBlock(blockMap)
+							textParts = append(textParts, text)
+							images = append(images, docImages...)
 						} else if btype == "tool_result" {
-							ProcessToolResultBlock(blockMap, &toolResults)
+							images = append(images, ProcessToolResultBlock(blockMap, &toolResults)...)
 						}
 					}
 				}
@@ -343,10 +423,7 @@ func ProcessHistory(messages []ClaudeMessage, thinkingEnabled bool, thinkingHint
 			}
 
 			userCtx := UserInputMessageContext{
-				EnvState: EnvState{
-					OperatingSystem:         "macos",
-					CurrentWorkingDirectory: "/",
-				},
+				EnvState: envState,
 			}
 			if len(toolResults) > 0 {
 				userCtx.ToolResults = toolResults
@@ -431,6 +508,7 @@ func ProcessHistory(messages []ClaudeMessage, thinkingEnabled bool, thinkingHint
 }
 
 // ConvertClaudeToAmazonQRequest 将 Claude API 请求转换为 Amazon Q API 请求体
+// 图片保持原样（base64 数据或 URL），由调用方通过 ImagePipeline 统一处理
 // 参数 req 为 Claude API 请求对象
 // 参数 conversationID 为会话 ID（可选，为空时自动生成）
 // 返回 Amazon Q API 请求体和可能的错误
@@ -444,11 +522,27 @@ func ConvertClaudeToAmazonQRequest(req ClaudeRequest, conversationID string) (Am
 	budgetTokens := GetThinkingBudgetTokens(req.Thinking)
 	thinkingHint := BuildThinkingHint(budgetTokens)
 
-	// 1. 工具转换
+	// 校验工具选择策略
+	if err := ValidateToolChoice(req.ToolChoice, req.Tools); err != nil {
+		return AmazonQRequest{}, err
+	}
+
+	// 解析消息上下文（配置默认值 + metadata.amazonq + x-amazonq-* 请求头）
+	msgCtx, err := ResolveAmazonQContext(req)
+	if err != nil {
+		return AmazonQRequest{}, err
+	}
+
+	// 1. 工具转换（tool_choice 为 none 时不传递工具）
 	var aqTools []AmazonQTool
 	var longDescTools []map[string]string
 
-	for _, t := range req.Tools {
+	tools := req.Tools
+	if IsToolChoiceNone(req.ToolChoice) {
+		tools = nil
+	}
+
+	for _, t := range tools {
 		if len(t.Description) > 10240 {
 			longDescTools = append(longDescTools, map[string]string{
 				"name":             t.Name,
@@ -482,9 +576,13 @@ func ConvertClaudeToAmazonQRequest(req ClaudeRequest, conversationID string) (Am
 						if text, ok := blockMap["text"].(string); ok {
 							textParts = append(textParts, text)
 						}
+					} else if btype == "document" {
+						text, docImages := ProcessDocumentBlock(blockMap)
+						textParts = append(textParts, text)
+						images = append(images, docImages...)
 					} else if btype == "tool_result" {
 						hasToolResult = true
-						ProcessToolResultBlock(blockMap, &toolResults)
+						images = append(images, ProcessToolResultBlock(blockMap, &toolResults)...)
 					}
 				}
 			}
@@ -499,13 +597,11 @@ func ConvertClaudeToAmazonQRequest(req ClaudeRequest, conversationID string) (Am
 		promptContent = AppendThinkingHint(promptContent, thinkingHint)
 	}
 
+	// 注入工具选择提示（Amazon Q 不支持 tool_choice，通过指令模拟）
+	promptContent = AppendToolChoiceHint(promptContent, BuildToolChoiceHint(req.ToolChoice))
+
 	// 3. 上下文构建
-	userCtx := UserInputMessageContext{
-		EnvState: EnvState{
-			OperatingSystem:         "macos",
-			CurrentWorkingDirectory: "/",
-		},
-	}
+	userCtx := BuildCurrentMessageContext(msgCtx)
 	if len(aqTools) > 0 {
 		userCtx.Tools = aqTools
 	}
@@ -513,59 +609,66 @@ func ConvertClaudeToAmazonQRequest(req ClaudeRequest, conversationID string) (Am
 		userCtx.ToolResults = toolResults
 	}
 
-	// 4. 格式化内容
-	formattedContent := ""
-	if hasToolResult && promptContent == "" {
-		formattedContent = ""
-	} else {
-		formattedContent = fmt.Sprintf(
-			"--- CONTEXT ENTRY BEGIN ---\nCurrent time: %s\n--- CONTEXT ENTRY END ---\n\n--- USER MESSAGE BEGIN ---\n%s\n--- USER MESSAGE END ---",
-			GetCurrentTimestamp(),
-			promptContent,
-		)
+	// 4. 工具文档与模板选择
+	var toolDocs []prompt.ToolDoc
+	for _, info := range longDescTools {
+		toolDocs = append(toolDocs, prompt.ToolDoc{Name: info["name"], Description: info["full_description"]})
 	}
 
-	if len(longDescTools) > 0 {
-		var docs []string
-		for _, info := range longDescTools {
-			docs = append(docs, fmt.Sprintf("Tool: %s\nFull Description:\n%s\n", info["name"], info["full_description"]))
-		}
-		formattedContent = fmt.Sprintf(
-			"--- TOOL DOCUMENTATION BEGIN ---\n%s--- TOOL DOCUMENTATION END ---\n\n%s",
-			strings.Join(docs, ""),
-			formattedContent,
-		)
-	}
-
-	if req.System != nil && formattedContent != "" {
-		sysText := ""
-		switch sys := req.System.(type) {
-		case string:
-			sysText = sys
-		case []interface{}:
-			var parts []string
-			for _, b := range sys {
-				if blockMap, ok := b.(map[string]interface{}); ok {
-					if blockMap["type"] == "text" {
-						if text, ok := blockMap["text"].(string); ok {
-							parts = append(parts, text)
-						}
-					}
-				}
-			}
-			sysText = strings.Join(parts, "\n")
-		}
+	includeUserMessage := !(hasToolResult && promptContent == "")
+
+	sysText := ""
+	if req.System != nil {
+		sysText = ExtractSystemText(req.System)
+	}
 
-		if sysText != "" {
-			formattedContent = fmt.Sprintf(
-				"--- SYSTEM PROMPT BEGIN ---\n%s\n--- SYSTEM PROMPT END ---\n\n%s",
-				sysText,
-				formattedContent,
-			)
+	registry := prompt.Current()
+	tmpl := selectPromptTemplate(registry, req)
+	now := registry.Now()
+
+	// 5. 历史消息处理
+	var historyMsgs []ClaudeMessage
+	if len(req.Messages) > 1 {
+		historyMsgs = req.Messages[:len(req.Messages)-1]
+	}
+	aqHistory := ProcessHistory(historyMsgs, thinkingEnabled, thinkingHint, BuildHistoryEnvState(msgCtx))
+
+	// 6. 按模板格式化内容
+	// 纯 tool_result 轮次没有用户消息段，系统提示和工具文档固定到第一条历史消息，
+	// 保证长时间的 agent 循环中每个请求都携带系统提示
+	data := prompt.Data{
+		SystemPrompt:       sysText,
+		ToolDocs:           toolDocs,
+		UserMessage:        promptContent,
+		IncludeUserMessage: includeUserMessage,
+		Now:                now,
+		Model:              req.Model,
+	}
+	if sysText != "" && strings.Contains(promptContent, sysText) {
+		// 用户消息中已包含系统提示，避免重复
+		data.SystemPrompt = ""
+	}
+	if !includeUserMessage && len(aqHistory) > 0 && (sysText != "" || len(toolDocs) > 0) {
+		preamble, err := tmpl.Execute(prompt.Data{
+			SystemPrompt: sysText,
+			ToolDocs:     toolDocs,
+			Now:          now,
+			Model:        req.Model,
+		})
+		if err != nil {
+			return AmazonQRequest{}, err
 		}
+		aqHistory = PinPreamble(aqHistory, preamble, sysText, msgCtx)
+		data.SystemPrompt = ""
+		data.ToolDocs = nil
+	}
+
+	formattedContent, err := tmpl.Execute(data)
+	if err != nil {
+		return AmazonQRequest{}, err
 	}
 
-	// 5. 用户输入消息
+	// 7. 用户输入消息
 	userInputMsg := UserInputMessage{
 		Content:                 formattedContent,
 		UserInputMessageContext: userCtx,
@@ -576,15 +679,8 @@ func ConvertClaudeToAmazonQRequest(req ClaudeRequest, conversationID string) (Am
 		userInputMsg.Images = images
 	}
 
-	// 6. 历史消息处理
-	var historyMsgs []ClaudeMessage
-	if len(req.Messages) > 1 {
-		historyMsgs = req.Messages[:len(req.Messages)-1]
-	}
-	aqHistory := ProcessHistory(historyMsgs, thinkingEnabled, thinkingHint)
-
-	// 7. 最终请求体
-	return AmazonQRequest{
+	// 8. 最终请求体
+	aqRequest := AmazonQRequest{
 		ConversationState: ConversationState{
 			ConversationID: conversationID,
 			History:        aqHistory,
@@ -593,7 +689,84 @@ func ConvertClaudeToAmazonQRequest(req ClaudeRequest, conversationID string) (Am
 			},
 			ChatTriggerType: "MANUAL",
 		},
-	}, nil
+	}
+
+	return aqRequest, nil
+}
+
+// selectPromptTemplate 选择请求使用的提示词框架模板
+// 参数 registry 为模板注册表
+// 参数 req 为 Claude 请求（PromptTemplate 为空时按模型选择）
+// 返回模板
+func selectPromptTemplate(registry *prompt.Registry, req ClaudeRequest) *prompt.Template {
+	templateName := req.PromptTemplate
+	if templateName == "" {
+		templateName = registry.SelectName(req.Model, "")
+	}
+	return registry.Get(templateName)
+}
+
+// ConversionSettings 返回影响请求转换结果的设置摘要
+// 包括提示词框架模板（名称和内容，决定用户消息和固定到历史的前导内容）、历史消息携带的环境状态
+// （配置默认值合并 metadata.amazonq 后的操作系统、工作目录和时区偏移）和图片处理限制，
+// 供提示词缓存模拟计算前缀哈希，设置变化后不再命中按旧设置转换的前缀
+// 参数 req 为 Claude 请求
+// 返回设置摘要
+func ConversionSettings(req ClaudeRequest) string {
+	tmpl := selectPromptTemplate(prompt.Current(), req)
+	cfg := config.Current()
+	envState := EnvState{
+		OperatingSystem:         cfg.Context.OperatingSystem,
+		CurrentWorkingDirectory: cfg.Context.WorkingDirectory,
+	}
+	if msgCtx, err := ResolveAmazonQContext(req); err == nil {
+		envState = BuildHistoryEnvState(msgCtx)
+	}
+	env, _ := json.Marshal(envState)
+	return fmt.Sprintf("template=%q:%q;env=%s;images=%d:%d:%d:%d",
+		tmpl.Name, tmpl.Source(), env,
+		cfg.Limits.MaxImagesPerRequest, cfg.Limits.MaxImageBytes, cfg.Limits.MaxImageDimension, cfg.Limits.MaxImageFetchBytes)
+}
+
+// PinPreamble 将系统提示和工具文档固定到第一条历史用户消息的开头
+// 第一条历史消息已包含相同系统提示时不重复添加；第一条历史为助手消息时插入一对新的用户/助手消息以保持交替顺序
+// 参数 history 为 Amazon Q 历史消息
+// 参数 preamble 为渲染后的系统提示和工具文档
+// 参数 sysText 为系统提示原文（用于去重）
+// 参数 msgCtx 为消息上下文
+// 返回处理后的历史消息
+func PinPreamble(history []HistoryEntry, preamble string, sysText string, msgCtx *AmazonQContext) []HistoryEntry {
+	preamble = strings.TrimSpace(preamble)
+	if preamble == "" {
+		return history
+	}
+
+	if len(history) > 0 && history[0].UserInputMessage != nil {
+		first := *history[0].UserInputMessage
+		if strings.Contains(first.Content, preamble) || (sysText != "" && strings.Contains(first.Content, sysText)) {
+			return history
+		}
+		if first.Content == "" {
+			first.Content = preamble
+		} else {
+			first.Content = fmt.Sprintf("%s\n\n%s", preamble, first.Content)
+		}
+		pinned := append([]HistoryEntry{{UserInputMessage: &first}}, history[1:]...)
+		return pinned
+	}
+
+	pinned := []HistoryEntry{
+		{UserInputMessage: &UserInputMessage{
+			Content:                 preamble,
+			UserInputMessageContext: UserInputMessageContext{EnvState: BuildHistoryEnvState(msgCtx)},
+			Origin:                  "CLI",
+		}},
+		{AssistantResponseMessage: &AssistantResponseMessage{
+			MessageID: uuid.New().String(),
+			Content:   "Understood.",
+		}},
+	}
+	return append(pinned, history...)
 }
 
 // ExtractSystemText 从系统提示中提取文本内容
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/imaging"
+)
+
+// maxConcurrentImages 同时下载和处理的最大图片数
+const maxConcurrentImages = 4
+
+// ImagePipeline 单个请求的图片处理流程，缓存每张图片的处理结果
+// 强制工具调用追加轮次时重新转换的请求包含相同的图片，复用结果避免重复下载、解码和重新编码
+type ImagePipeline struct {
+	ctx     context.Context
+	mutex   sync.Mutex
+	results map[imageKey]imageResult
+}
+
+// imageKey 图片来源：URL 或 base64 数据
+type imageKey struct {
+	url  string
+	data string
+}
+
+// imageResult 单张图片的处理结果
+type imageResult struct {
+	image   AmazonQImage
+	changed bool
+	err     error
+}
+
+// NewImagePipeline 创建请求的图片处理流程
+// 参数 ctx 为请求上下文，客户端断开或停机时取消进行中的图片下载
+// 返回图片处理流程
+func NewImagePipeline(ctx context.Context) *ImagePipeline {
+	return &ImagePipeline{ctx: ctx, results: make(map[imageKey]imageResult)}
+}
+
+// Apply 对请求中的所有图片执行校验、下载、缩放、转码和数量限制
+// 超出数量预算时优先保留当前消息和最近历史中的图片，被丢弃或修改的图片会记录到 req.Warnings
+// 参数 req 为待处理的 Amazon Q 请求
+func (p *ImagePipeline) Apply(req *AmazonQRequest) {
+	// 按从新到旧的顺序收集所有携带图片的消息
+	messages := []*UserInputMessage{&req.ConversationState.CurrentMessage.UserInputMessage}
+	history := req.ConversationState.History
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].UserInputMessage != nil {
+			messages = append(messages, history[i].UserInputMessage)
+		}
+	}
+
+	type slot struct {
+		message int
+		index   int
+	}
+	var candidates []slot
+	for m, msg := range messages {
+		for i := range msg.Images {
+			candidates = append(candidates, slot{m, i})
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	cfg := config.Current().Limits
+	limits := imaging.Limits{
+		MaxBytes:     cfg.MaxImageBytes,
+		MaxDimension: cfg.MaxImageDimension,
+	}
+
+	// 按从新到旧的顺序分批处理，每批只处理预算内剩余数量的图片，无效图片不占用预算
+	budget := cfg.MaxImagesPerRequest
+	kept := make(map[slot]AmazonQImage)
+	dropped, modified, invalid := 0, 0, 0
+	for next := 0; next < len(candidates); {
+		batch := len(candidates) - next
+		if budget > 0 {
+			if len(kept) >= budget {
+				dropped = len(candidates) - next
+				break
+			}
+			batch = min(batch, budget-len(kept))
+		}
+
+		images := make([]AmazonQImage, batch)
+		for i, s := range candidates[next : next+batch] {
+			images[i] = messages[s.message].Images[s.index]
+		}
+		results := p.processAll(images, limits)
+		for i, result := range results {
+			if result.err != nil {
+				slog.Warn("dropping invalid image", "component", "image", "error", result.err)
+				invalid++
+				continue
+			}
+			if result.changed {
+				modified++
+			}
+			kept[candidates[next+i]] = result.image
+		}
+		next += batch
+	}
+
+	for m, msg := range messages {
+		var images []AmazonQImage
+		for i := range msg.Images {
+			if image, ok := kept[slot{m, i}]; ok {
+				images = append(images, image)
+			}
+		}
+		if len(images) > 0 {
+			msg.Images = images
+		} else {
+			msg.Images = nil
+		}
+	}
+
+	if dropped > 0 {
+		req.Warnings = append(req.Warnings, fmt.Sprintf("%d image(s) dropped: exceeds per-request budget of %d (oldest images dropped first)", dropped, budget))
+	}
+	if invalid > 0 {
+		req.Warnings = append(req.Warnings, fmt.Sprintf("%d image(s) dropped: invalid or unreachable image data", invalid))
+	}
+	if modified > 0 {
+		req.Warnings = append(req.Warnings, fmt.Sprintf("%d image(s) resized or transcoded to fit Amazon Q limits", modified))
+	}
+}
+
+// processAll 并发处理一批图片（最多同时处理 maxConcurrentImages 张），已处理过的图片直接使用缓存的结果
+// 参数 images 为待处理的图片
+// 参数 limits 为图片大小限制
+// 返回与 images 一一对应的处理结果
+func (p *ImagePipeline) processAll(images []AmazonQImage, limits imaging.Limits) []imageResult {
+	results := make([]imageResult, len(images))
+	semaphore := make(chan struct{}, maxConcurrentImages)
+	var wg sync.WaitGroup
+	for i, image := range images {
+		key := imageKey{url: image.SourceURL}
+		if key.url == "" {
+			key.data = image.Source.Bytes
+		}
+		p.mutex.Lock()
+		cached, ok := p.results[key]
+		p.mutex.Unlock()
+		if ok {
+			results[i] = cached
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, image AmazonQImage, key imageKey) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			processed, changed, err := processImage(p.ctx, image, limits)
+			result := imageResult{image: processed, changed: changed, err: err}
+			results[i] = result
+			// 请求被取消导致的失败不缓存
+			if p.ctx.Err() == nil {
+				p.mutex.Lock()
+				p.results[key] = result
+				p.mutex.Unlock()
+			}
+		}(i, image, key)
+	}
+	wg.Wait()
+	return results
+}
+
+// ImageCount 返回请求中所有用户消息（历史和当前消息）携带的图片数量
+// 参数 req 为 Amazon Q 请求
+// 返回图片数量
+func ImageCount(req AmazonQRequest) int {
+	count := len(req.ConversationState.CurrentMessage.UserInputMessage.Images)
+	for _, entry := range req.ConversationState.History {
+		if entry.UserInputMessage != nil {
+			count += len(entry.UserInputMessage.Images)
+		}
+	}
+	return count
+}
+
+// processImage 获取并规范化单张图片
+// 参数 ctx 为请求上下文
+// 参数 image 为待处理的图片
+// 参数 limits 为图片大小限制
+// 返回处理后的图片、是否被修改（缩放、转码或格式更正）以及可能的错误
+func processImage(ctx context.Context, image AmazonQImage, limits imaging.Limits) (AmazonQImage, bool, error) {
+	var data []byte
+	var err error
+
+	if image.SourceURL != "" {
+		data, err = imaging.Fetch(ctx, image.SourceURL, config.Current().Limits.MaxImageFetchBytes)
+	} else {
+		data, err = base64.StdEncoding.DecodeString(image.Source.Bytes)
+	}
+	if err != nil {
+		return image, false, err
+	}
+
+	result, err := imaging.Process(data, limits)
+	if err != nil {
+		return image, false, err
+	}
+
+	// base64 来源未修改且格式声明正确时直接复用原数据
+	if !result.Modified && image.SourceURL == "" && imaging.FormatFromMediaType(image.Format) == result.Format {
+		return AmazonQImage{Format: result.Format, Source: image.Source}, false, nil
+	}
+
+	return AmazonQImage{
+		Format: result.Format,
+		Source: ImageBytes{Bytes: base64.StdEncoding.EncodeToString(result.Data)},
+	}, result.Modified, nil
+}
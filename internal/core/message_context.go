@@ -0,0 +1,177 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"amazonq-proxy/internal/config"
+)
+
+const (
+	// maxWorkingDirectoryLength 工作目录最大长度
+	maxWorkingDirectoryLength = 256
+	// maxEnvironmentVariables 环境变量最大数量
+	maxEnvironmentVariables = 100
+	// maxEnvironmentKeyLength 环境变量名最大长度
+	maxEnvironmentKeyLength = 256
+	// maxEnvironmentValueLength 环境变量值最大长度
+	maxEnvironmentValueLength = 1024
+	// maxGitStatusLength git status 最大长度
+	maxGitStatusLength = 4096
+	// maxShellNameLength Shell 名称最大长度
+	maxShellNameLength = 32
+	// minTimezoneOffset 时区偏移下限（分钟）
+	minTimezoneOffset = -720
+	// maxTimezoneOffset 时区偏移上限（分钟）
+	maxTimezoneOffset = 840
+)
+
+// supportedOperatingSystems Amazon Q 支持的操作系统取值
+var supportedOperatingSystems = map[string]bool{
+	"macos":   true,
+	"linux":   true,
+	"windows": true,
+}
+
+// environmentKeyPattern 环境变量名格式
+var environmentKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// MergeAmazonQContext 合并两个消息上下文，override 中非空的字段覆盖 base
+// 参数 base 为基础上下文（可为 nil）
+// 参数 override 为覆盖上下文（可为 nil）
+// 返回合并后的新上下文
+func MergeAmazonQContext(base, override *AmazonQContext) *AmazonQContext {
+	merged := &AmazonQContext{}
+	for _, src := range []*AmazonQContext{base, override} {
+		if src == nil {
+			continue
+		}
+		if src.OperatingSystem != "" {
+			merged.OperatingSystem = src.OperatingSystem
+		}
+		if src.CurrentWorkingDirectory != "" {
+			merged.CurrentWorkingDirectory = src.CurrentWorkingDirectory
+		}
+		if src.TimezoneOffset != nil {
+			offset := *src.TimezoneOffset
+			merged.TimezoneOffset = &offset
+		}
+		if src.GitStatus != "" {
+			merged.GitStatus = src.GitStatus
+		}
+		if src.ShellName != "" {
+			merged.ShellName = src.ShellName
+		}
+		if len(src.EnvironmentVariables) > 0 {
+			if merged.EnvironmentVariables == nil {
+				merged.EnvironmentVariables = make(map[string]string)
+			}
+			for k, v := range src.EnvironmentVariables {
+				merged.EnvironmentVariables[k] = v
+			}
+		}
+	}
+	return merged
+}
+
+// ResolveAmazonQContext 以配置默认值为基础合并请求级上下文并校验
+// 参数 req 为 Claude 请求
+// 返回最终生效的消息上下文和可能的校验错误
+func ResolveAmazonQContext(req ClaudeRequest) (*AmazonQContext, error) {
+	defaults := &AmazonQContext{
+		OperatingSystem:         config.Current().Context.OperatingSystem,
+		CurrentWorkingDirectory: config.Current().Context.WorkingDirectory,
+	}
+
+	var override *AmazonQContext
+	if req.Metadata != nil {
+		override = req.Metadata.AmazonQ
+	}
+
+	resolved := MergeAmazonQContext(defaults, override)
+	resolved.OperatingSystem = strings.ToLower(resolved.OperatingSystem)
+	if err := ValidateAmazonQContext(resolved); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// ValidateAmazonQContext 校验消息上下文是否满足 Amazon Q 的约束
+// 参数 ctx 为消息上下文
+// 返回校验错误（合法时为 nil）
+func ValidateAmazonQContext(ctx *AmazonQContext) error {
+	if !supportedOperatingSystems[ctx.OperatingSystem] {
+		return fmt.Errorf("invalid operating_system %q: expected macos, linux or windows", ctx.OperatingSystem)
+	}
+	if ctx.CurrentWorkingDirectory == "" || len(ctx.CurrentWorkingDirectory) > maxWorkingDirectoryLength {
+		return fmt.Errorf("current_working_directory must be 1-%d characters", maxWorkingDirectoryLength)
+	}
+	if ctx.TimezoneOffset != nil && (*ctx.TimezoneOffset < minTimezoneOffset || *ctx.TimezoneOffset > maxTimezoneOffset) {
+		return fmt.Errorf("timezone_offset must be between %d and %d minutes", minTimezoneOffset, maxTimezoneOffset)
+	}
+	if len(ctx.EnvironmentVariables) > maxEnvironmentVariables {
+		return fmt.Errorf("at most %d environment_variables are allowed", maxEnvironmentVariables)
+	}
+	for k, v := range ctx.EnvironmentVariables {
+		if len(k) > maxEnvironmentKeyLength || !environmentKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid environment variable name %q", k)
+		}
+		if len(v) > maxEnvironmentValueLength {
+			return fmt.Errorf("environment variable %q exceeds %d characters", k, maxEnvironmentValueLength)
+		}
+	}
+	if len(ctx.GitStatus) > maxGitStatusLength {
+		return fmt.Errorf("git_status exceeds %d characters", maxGitStatusLength)
+	}
+	if len(ctx.ShellName) > maxShellNameLength {
+		return fmt.Errorf("shell_name exceeds %d characters", maxShellNameLength)
+	}
+	return nil
+}
+
+// BuildHistoryEnvState 构建历史消息使用的环境状态（仅包含操作系统、工作目录和时区）
+// 参数 ctx 为消息上下文
+// 返回环境状态
+func BuildHistoryEnvState(ctx *AmazonQContext) EnvState {
+	envState := EnvState{
+		OperatingSystem:         ctx.OperatingSystem,
+		CurrentWorkingDirectory: ctx.CurrentWorkingDirectory,
+	}
+	if ctx.TimezoneOffset != nil {
+		offset := *ctx.TimezoneOffset
+		envState.TimezoneOffset = &offset
+	}
+	return envState
+}
+
+// BuildCurrentMessageContext 构建当前消息的完整上下文（包含环境变量、Git 和 Shell 状态）
+// 参数 ctx 为消息上下文
+// 返回用户输入消息上下文
+func BuildCurrentMessageContext(ctx *AmazonQContext) UserInputMessageContext {
+	userCtx := UserInputMessageContext{
+		EnvState: BuildHistoryEnvState(ctx),
+	}
+
+	if len(ctx.EnvironmentVariables) > 0 {
+		keys := make([]string, 0, len(ctx.EnvironmentVariables))
+		for k := range ctx.EnvironmentVariables {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			userCtx.EnvState.EnvironmentVariables = append(userCtx.EnvState.EnvironmentVariables, EnvironmentVariable{
+				Key:   k,
+				Value: ctx.EnvironmentVariables[k],
+			})
+		}
+	}
+	if ctx.GitStatus != "" {
+		userCtx.GitState = &GitState{Status: ctx.GitStatus}
+	}
+	if ctx.ShellName != "" {
+		userCtx.ShellState = &ShellState{ShellName: ctx.ShellName}
+	}
+	return userCtx
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ToolChoiceAuto 由模型自行决定是否调用工具
+	ToolChoiceAuto = "auto"
+	// ToolChoiceAny 必须调用任意一个工具
+	ToolChoiceAny = "any"
+	// ToolChoiceTool 必须调用指定的工具
+	ToolChoiceTool = "tool"
+	// ToolChoiceNone 禁止调用工具
+	ToolChoiceNone = "none"
+
+	// MaxToolChoiceRounds 强制工具调用时最多发起的请求轮数（含首轮）
+	MaxToolChoiceRounds = 3
+)
+
+// ValidateToolChoice 校验工具选择策略是否合法
+// 参数 choice 为工具选择策略（可为 nil）
+// 参数 tools 为请求中声明的工具列表
+// 返回校验错误（合法时为 nil）
+func ValidateToolChoice(choice *ToolChoice, tools []ClaudeTool) error {
+	if choice == nil {
+		return nil
+	}
+
+	switch choice.Type {
+	case ToolChoiceAuto, ToolChoiceNone:
+		return nil
+	case ToolChoiceAny:
+		if len(tools) == 0 {
+			return fmt.Errorf("tool_choice.type \"any\" requires at least one tool")
+		}
+		return nil
+	case ToolChoiceTool:
+		if choice.Name == "" {
+			return fmt.Errorf("tool_choice.name is required when tool_choice.type is \"tool\"")
+		}
+		for _, t := range tools {
+			if t.Name == choice.Name {
+				return nil
+			}
+		}
+		return fmt.Errorf("tool_choice.name %q does not match any provided tool", choice.Name)
+	}
+
+	return fmt.Errorf("invalid tool_choice.type %q", choice.Type)
+}
+
+// IsToolChoiceNone 检测是否禁止调用工具
+// 参数 choice 为工具选择策略
+// 返回是否为 none
+func IsToolChoiceNone(choice *ToolChoice) bool {
+	return choice != nil && choice.Type == ToolChoiceNone
+}
+
+// IsToolChoiceForced 检测是否强制模型调用工具
+// 参数 choice 为工具选择策略
+// 返回是否为 any 或 tool
+func IsToolChoiceForced(choice *ToolChoice) bool {
+	return choice != nil && (choice.Type == ToolChoiceAny || choice.Type == ToolChoiceTool)
+}
+
+// IsParallelToolUseDisabled 检测是否禁止并行调用多个工具
+// 参数 choice 为工具选择策略
+// 返回是否禁止并行工具调用
+func IsParallelToolUseDisabled(choice *ToolChoice) bool {
+	return choice != nil && choice.DisableParallelToolUse && choice.Type != ToolChoiceNone
+}
+
+// BuildToolChoiceHint 构建强制工具调用的提示词
+// Amazon Q 不支持 tool_choice 参数，因此通过在用户消息中追加指令来模拟
+// 参数 choice 为工具选择策略
+// 返回提示词（无需提示时为空字符串）
+func BuildToolChoiceHint(choice *ToolChoice) string {
+	if choice == nil {
+		return ""
+	}
+
+	var hints []string
+	switch choice.Type {
+	case ToolChoiceAny:
+		hints = append(hints, "You MUST respond by calling one of the available tools. Do not answer in plain text.")
+	case ToolChoiceTool:
+		hints = append(hints, fmt.Sprintf("You MUST respond by calling the tool \"%s\". Do not call any other tool and do not answer in plain text.", choice.Name))
+	}
+
+	if IsParallelToolUseDisabled(choice) {
+		hints = append(hints, "Call at most one tool in this response.")
+	}
+
+	if len(hints) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("<tool_choice>%s</tool_choice>", strings.Join(hints, " "))
+}
+
+// AppendToolChoiceHint 在文本末尾追加工具选择提示
+// 参数 text 为原始文本
+// 参数 hint 为工具选择提示
+// 返回追加后的文本
+func AppendToolChoiceHint(text string, hint string) string {
+	if hint == "" || strings.Contains(text, hint) {
+		return text
+	}
+	if strings.TrimSpace(text) == "" {
+		return hint
+	}
+	return fmt.Sprintf("%s\n\n%s", text, hint)
+}
+
+// IsToolChoiceSatisfied 检查模型响应是否满足强制工具调用要求
+// 参数 choice 为工具选择策略
+// 参数 toolNames 为响应中调用的工具名称列表
+// 返回是否满足要求
+func IsToolChoiceSatisfied(choice *ToolChoice, toolNames []string) bool {
+	if !IsToolChoiceForced(choice) {
+		return true
+	}
+	if choice.Type == ToolChoiceAny {
+		return len(toolNames) > 0
+	}
+	for _, name := range toolNames {
+		if name == choice.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildToolChoiceFollowUp 在模型以纯文本作答时构建追加轮次的请求
+// 将模型的文本回答作为助手消息，并追加一条要求调用工具的用户消息
+// 参数 req 为上一轮的 Claude 请求
+// 参数 assistantText 为模型上一轮输出的文本
+// 返回追加轮次的 Claude 请求
+func BuildToolChoiceFollowUp(req ClaudeRequest, assistantText string) ClaudeRequest {
+	if strings.TrimSpace(assistantText) == "" {
+		assistantText = "(no response)"
+	}
+
+	reminder := "Your previous response did not call a tool."
+	if req.ToolChoice != nil && req.ToolChoice.Type == ToolChoiceTool {
+		reminder = fmt.Sprintf("Your previous response did not call the tool \"%s\".", req.ToolChoice.Name)
+	}
+
+	messages := make([]ClaudeMessage, 0, len(req.Messages)+2)
+	messages = append(messages, req.Messages...)
+	messages = append(messages,
+		ClaudeMessage{Role: "assistant", Content: assistantText},
+		ClaudeMessage{Role: "user", Content: AppendToolChoiceHint(reminder, BuildToolChoiceHint(req.ToolChoice))},
+	)
+
+	followUp := req
+	followUp.Messages = messages
+	return followUp
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"amazonq-proxy/internal/config"
+)
+
+// choiceTools 测试用的工具列表
+var choiceTools = []ClaudeTool{{Name: "search"}, {Name: "fetch"}}
+
+func TestValidateToolChoice(t *testing.T) {
+	cases := []struct {
+		name   string
+		choice *ToolChoice
+		tools  []ClaudeTool
+		want   string // 期望错误包含的内容，为空表示合法
+	}{
+		{"nil", nil, nil, ""},
+		{"auto", &ToolChoice{Type: ToolChoiceAuto}, nil, ""},
+		{"none", &ToolChoice{Type: ToolChoiceNone}, choiceTools, ""},
+		{"any", &ToolChoice{Type: ToolChoiceAny}, choiceTools, ""},
+		{"any without tools", &ToolChoice{Type: ToolChoiceAny}, nil, `"any" requires at least one tool`},
+		{"tool", &ToolChoice{Type: ToolChoiceTool, Name: "fetch"}, choiceTools, ""},
+		{"tool without name", &ToolChoice{Type: ToolChoiceTool}, choiceTools, "tool_choice.name is required"},
+		{"tool not declared", &ToolChoice{Type: ToolChoiceTool, Name: "delete"}, choiceTools, `"delete" does not match any provided tool`},
+		{"invalid type", &ToolChoice{Type: "required"}, choiceTools, `invalid tool_choice.type "required"`},
+	}
+	for _, tc := range cases {
+		err := ValidateToolChoice(tc.choice, tc.tools)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("%s: ValidateToolChoice error = %v, want nil", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: ValidateToolChoice error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestBuildToolChoiceFollowUp(t *testing.T) {
+	cases := []struct {
+		name      string
+		choice    *ToolChoice
+		text      string
+		assistant string
+		reminder  []string
+	}{
+		{
+			name:      "any",
+			choice:    &ToolChoice{Type: ToolChoiceAny},
+			text:      "It is probably on the shelf.",
+			assistant: "It is probably on the shelf.",
+			reminder:  []string{"did not call a tool.", "calling one of the available tools"},
+		},
+		{
+			name:      "named tool",
+			choice:    &ToolChoice{Type: ToolChoiceTool, Name: "fetch"},
+			text:      "Let me think.",
+			assistant: "Let me think.",
+			reminder:  []string{`did not call the tool "fetch".`, `calling the tool "fetch"`},
+		},
+		{
+			name:      "empty answer",
+			choice:    &ToolChoice{Type: ToolChoiceAny},
+			text:      " \n",
+			assistant: "(no response)",
+			reminder:  []string{"did not call a tool."},
+		},
+		{
+			name:      "parallel tool use disabled",
+			choice:    &ToolChoice{Type: ToolChoiceAny, DisableParallelToolUse: true},
+			text:      "ok",
+			assistant: "ok",
+			reminder:  []string{"Call at most one tool in this response."},
+		},
+	}
+	for _, tc := range cases {
+		original := []ClaudeMessage{{Role: "user", Content: "find it"}}
+		req := ClaudeRequest{Model: "claude-sonnet-4.5", Messages: original, Tools: choiceTools, ToolChoice: tc.choice}
+
+		followUp := BuildToolChoiceFollowUp(req, tc.text)
+		if len(followUp.Messages) != 3 {
+			t.Fatalf("%s: %d messages, want 3", tc.name, len(followUp.Messages))
+		}
+		if got := followUp.Messages[1]; got.Role != "assistant" || got.Content != tc.assistant {
+			t.Errorf("%s: assistant message = %#v, want %q", tc.name, got, tc.assistant)
+		}
+		user, _ := followUp.Messages[2].Content.(string)
+		if followUp.Messages[2].Role != "user" {
+			t.Errorf("%s: last message role = %q, want user", tc.name, followUp.Messages[2].Role)
+		}
+		for _, want := range tc.reminder {
+			if !strings.Contains(user, want) {
+				t.Errorf("%s: user message = %q, want it to contain %q", tc.name, user, want)
+			}
+		}
+		// 工具、策略和原始消息保持不变
+		if followUp.ToolChoice != tc.choice || len(followUp.Tools) != len(choiceTools) {
+			t.Errorf("%s: follow-up changed tools or tool_choice", tc.name)
+		}
+		if len(req.Messages) != 1 || len(original) != 1 || original[0].Content != "find it" {
+			t.Errorf("%s: original messages were modified: %#v", tc.name, req.Messages)
+		}
+	}
+}
+
+func TestConvertToolChoice(t *testing.T) {
+	previous := config.Current()
+	config.Set(config.Default())
+	defer config.Set(previous)
+
+	cases := []struct {
+		name   string
+		choice *ToolChoice
+		tools  int
+		hint   string
+	}{
+		{"auto keeps tools", &ToolChoice{Type: ToolChoiceAuto}, 2, ""},
+		{"none strips tools", &ToolChoice{Type: ToolChoiceNone}, 0, ""},
+		{"any adds a hint", &ToolChoice{Type: ToolChoiceAny}, 2, "calling one of the available tools"},
+		{"tool names the tool", &ToolChoice{Type: ToolChoiceTool, Name: "search"}, 2, `calling the tool "search"`},
+	}
+	for _, tc := range cases {
+		req := ClaudeRequest{
+			Model:      "claude-sonnet-4.5",
+			Messages:   []ClaudeMessage{{Role: "user", Content: "find it"}},
+			Tools:      choiceTools,
+			ToolChoice: tc.choice,
+		}
+		aqReq, err := ConvertClaudeToAmazonQRequest(req, "conv")
+		if err != nil {
+			t.Fatalf("%s: ConvertClaudeToAmazonQRequest: %v", tc.name, err)
+		}
+		msg := aqReq.ConversationState.CurrentMessage.UserInputMessage
+		if got := len(msg.UserInputMessageContext.Tools); got != tc.tools {
+			t.Errorf("%s: %d tools, want %d", tc.name, got, tc.tools)
+		}
+		if hasHint := strings.Contains(msg.Content, "<tool_choice>"); hasHint != (tc.hint != "") || !strings.Contains(msg.Content, tc.hint) {
+			t.Errorf("%s: content = %q, want hint %q", tc.name, msg.Content, tc.hint)
+		}
+	}
+
+	// 非法的策略在转换时被拒绝
+	req := ClaudeRequest{Messages: []ClaudeMessage{{Role: "user", Content: "hi"}}, ToolChoice: &ToolChoice{Type: ToolChoiceAny}}
+	if _, err := ConvertClaudeToAmazonQRequest(req, "conv"); err == nil {
+		t.Error("ConvertClaudeToAmazonQRequest accepted tool_choice any without tools")
+	}
+}
@@ -8,28 +8,37 @@ type ClaudeMessage struct {
 
 // ContentBlock 消息内容块，可以是文本、图片或工具使用
 type ContentBlock struct {
-	Type      string                 `json:"type"`              // 类型：text, image, tool_use, tool_result
-	Text      string                 `json:"text,omitempty"`    // 文本内容
-	Source    *ImageSource           `json:"source,omitempty"`  // 图片源
-	ID        string                 `json:"id,omitempty"`      // 工具使用 ID
-	Name      string                 `json:"name,omitempty"`    // 工具名称
-	Input     map[string]interface{} `json:"input,omitempty"`   // 工具输入
-	ToolUseID string                 `json:"tool_use_id,omitempty"` // 工具结果对应的工具使用 ID
-	Status    string                 `json:"status,omitempty"`  // 工具执行状态
+	Type         string                 `json:"type"`                    // 类型：text, image, tool_use, tool_result
+	Text         string                 `json:"text,omitempty"`          // 文本内容
+	Source       *ImageSource           `json:"source,omitempty"`        // 图片源
+	ID           string                 `json:"id,omitempty"`            // 工具使用 ID
+	Name         string                 `json:"name,omitempty"`          // 工具名称
+	Input        map[string]interface{} `json:"input,omitempty"`         // 工具输入
+	ToolUseID    string                 `json:"tool_use_id,omitempty"`   // 工具结果对应的工具使用 ID
+	Status       string                 `json:"status,omitempty"`        // 工具执行状态
+	CacheControl *CacheControl          `json:"cache_control,omitempty"` // 提示词缓存断点
+}
+
+// CacheControl 提示词缓存断点（符合 Claude API 规范）
+type CacheControl struct {
+	Type string `json:"type"`          // 类型：ephemeral
+	TTL  string `json:"ttl,omitempty"` // 缓存时长：5m（默认）或 1h
 }
 
 // ImageSource 图片源定义
 type ImageSource struct {
-	Type      string `json:"type"`                  // 类型：base64
-	MediaType string `json:"media_type,omitempty"`  // MIME 类型
-	Data      string `json:"data,omitempty"`        // Base64 编码的图片数据
+	Type      string `json:"type"`                 // 类型：base64 或 url
+	MediaType string `json:"media_type,omitempty"` // MIME 类型
+	Data      string `json:"data,omitempty"`       // Base64 编码的图片数据
+	URL       string `json:"url,omitempty"`        // 图片地址（url 来源）
 }
 
 // ClaudeTool Claude 工具定义
 type ClaudeTool struct {
-	Name        string                 `json:"name"`                  // 工具名称
-	Description string                 `json:"description,omitempty"` // 工具描述
-	InputSchema map[string]interface{} `json:"input_schema"`          // JSON Schema 格式的输入定义
+	Name         string                 `json:"name"`                    // 工具名称
+	Description  string                 `json:"description,omitempty"`   // 工具描述
+	InputSchema  map[string]interface{} `json:"input_schema"`            // JSON Schema 格式的输入定义
+	CacheControl *CacheControl          `json:"cache_control,omitempty"` // 提示词缓存断点
 }
 
 // ThinkingConfig Thinking 配置结构（符合 Claude API 规范）
@@ -48,6 +57,33 @@ type ClaudeRequest struct {
 	Stream      bool            `json:"stream"`                // 是否流式响应
 	System      interface{}     `json:"system,omitempty"`      // 系统提示：string 或 []SystemBlock
 	Thinking    *ThinkingConfig `json:"thinking,omitempty"`    // Thinking 配置
+	ToolChoice  *ToolChoice     `json:"tool_choice,omitempty"` // 工具选择策略
+	Metadata    *Metadata       `json:"metadata,omitempty"`    // 请求元数据
+
+	PromptTemplate string `json:"-"` // 提示词框架模板名称（由 API 层按 API key 选择，为空时按模型选择）
+}
+
+// Metadata 请求元数据（符合 Claude API 规范，额外支持 amazonq 扩展字段）
+type Metadata struct {
+	UserID  string          `json:"user_id,omitempty"` // 终端用户标识
+	AmazonQ *AmazonQContext `json:"amazonq,omitempty"` // Amazon Q 消息上下文覆盖
+}
+
+// AmazonQContext 请求级 Amazon Q 消息上下文，可通过配置、metadata.amazonq 或 x-amazonq-* 请求头设置
+type AmazonQContext struct {
+	OperatingSystem         string            `json:"operating_system,omitempty"`          // 操作系统：macos, linux, windows
+	CurrentWorkingDirectory string            `json:"current_working_directory,omitempty"` // 当前工作目录
+	EnvironmentVariables    map[string]string `json:"environment_variables,omitempty"`     // 环境变量
+	TimezoneOffset          *int              `json:"timezone_offset,omitempty"`           // 时区偏移（分钟）
+	GitStatus               string            `json:"git_status,omitempty"`                // git status 输出
+	ShellName               string            `json:"shell_name,omitempty"`                // Shell 名称
+}
+
+// ToolChoice 工具选择策略（符合 Claude API 规范）
+type ToolChoice struct {
+	Type                   string `json:"type"`                                // 类型：auto, any, tool, none
+	Name                   string `json:"name,omitempty"`                      // 指定的工具名称（仅 type 为 tool 时有效）
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"` // 是否禁止并行调用多个工具
 }
 
 // SystemBlock 系统提示块
@@ -59,19 +95,20 @@ type SystemBlock struct {
 // AmazonQRequest Amazon Q API 请求结构
 type AmazonQRequest struct {
 	ConversationState ConversationState `json:"conversationState"` // 会话状态
+	Warnings          []string          `json:"-"`                 // 转换过程中产生的警告（通过响应头返回给客户端）
 }
 
 // ConversationState 会话状态信息
 type ConversationState struct {
-	ConversationID  string          `json:"conversationId"`  // 会话 ID
-	History         []HistoryEntry  `json:"history"`         // 历史消息
-	CurrentMessage  CurrentMessage  `json:"currentMessage"`  // 当前消息
-	ChatTriggerType string          `json:"chatTriggerType"` // 触发类型：MANUAL
+	ConversationID  string         `json:"conversationId"`  // 会话 ID
+	History         []HistoryEntry `json:"history"`         // 历史消息
+	CurrentMessage  CurrentMessage `json:"currentMessage"`  // 当前消息
+	ChatTriggerType string         `json:"chatTriggerType"` // 触发类型：MANUAL
 }
 
 // HistoryEntry 历史记录条目，可以是用户消息或助手响应
 type HistoryEntry struct {
-	UserInputMessage        *UserInputMessage        `json:"userInputMessage,omitempty"`        // 用户消息
+	UserInputMessage         *UserInputMessage         `json:"userInputMessage,omitempty"`         // 用户消息
 	AssistantResponseMessage *AssistantResponseMessage `json:"assistantResponseMessage,omitempty"` // 助手响应
 }
 
@@ -82,7 +119,7 @@ type CurrentMessage struct {
 
 // UserInputMessage 用户输入消息结构
 type UserInputMessage struct {
-	Content                 string                  `json:"content"`                  // 消息内容
+	Content                 string                  `json:"content"`                 // 消息内容
 	UserInputMessageContext UserInputMessageContext `json:"userInputMessageContext"` // 消息上下文
 	Origin                  string                  `json:"origin"`                  // 来源：CLI
 	ModelID                 string                  `json:"modelId,omitempty"`       // 模型 ID
@@ -91,15 +128,35 @@ type UserInputMessage struct {
 
 // UserInputMessageContext 用户输入消息上下文
 type UserInputMessageContext struct {
-	EnvState    EnvState       `json:"envState"`              // 环境状态
-	Tools       []AmazonQTool  `json:"tools,omitempty"`       // 可用工具
-	ToolResults []ToolResult   `json:"toolResults,omitempty"` // 工具执行结果
+	EnvState    EnvState      `json:"envState"`              // 环境状态
+	GitState    *GitState     `json:"gitState,omitempty"`    // Git 状态
+	ShellState  *ShellState   `json:"shellState,omitempty"`  // Shell 状态
+	Tools       []AmazonQTool `json:"tools,omitempty"`       // 可用工具
+	ToolResults []ToolResult  `json:"toolResults,omitempty"` // 工具执行结果
 }
 
 // EnvState 环境状态信息
 type EnvState struct {
-	OperatingSystem         string `json:"operatingSystem"`         // 操作系统
-	CurrentWorkingDirectory string `json:"currentWorkingDirectory"` // 当前工作目录
+	OperatingSystem         string                `json:"operatingSystem"`                // 操作系统
+	CurrentWorkingDirectory string                `json:"currentWorkingDirectory"`        // 当前工作目录
+	EnvironmentVariables    []EnvironmentVariable `json:"environmentVariables,omitempty"` // 环境变量
+	TimezoneOffset          *int                  `json:"timezoneOffset,omitempty"`       // 时区偏移（分钟）
+}
+
+// EnvironmentVariable 环境变量键值对
+type EnvironmentVariable struct {
+	Key   string `json:"key"`   // 变量名
+	Value string `json:"value"` // 变量值
+}
+
+// GitState Git 仓库状态
+type GitState struct {
+	Status string `json:"status"` // git status 输出
+}
+
+// ShellState Shell 状态
+type ShellState struct {
+	ShellName string `json:"shellName"` // Shell 名称
 }
 
 // AmazonQTool Amazon Q 工具定义
@@ -116,9 +173,9 @@ type ToolSpecification struct {
 
 // ToolResult 工具执行结果
 type ToolResult struct {
-	ToolUseID string              `json:"toolUseId"`         // 工具使用 ID
-	Content   []ToolResultContent `json:"content"`           // 结果内容
-	Status    string              `json:"status,omitempty"`  // 执行状态：success/error
+	ToolUseID string              `json:"toolUseId"`        // 工具使用 ID
+	Content   []ToolResultContent `json:"content"`          // 结果内容
+	Status    string              `json:"status,omitempty"` // 执行状态：success/error
 }
 
 // ToolResultContent 工具结果内容
@@ -128,8 +185,9 @@ type ToolResultContent struct {
 
 // AmazonQImage Amazon Q 图片定义
 type AmazonQImage struct {
-	Format string      `json:"format"` // 图片格式：png, jpeg 等
-	Source ImageBytes  `json:"source"` // 图片数据源
+	Format    string     `json:"format"` // 图片格式：png, jpeg 等
+	Source    ImageBytes `json:"source"` // 图片数据源
+	SourceURL string     `json:"-"`      // 图片 URL（url 来源，由图片处理流程下载）
 }
 
 // ImageBytes 图片字节数据
@@ -139,9 +197,9 @@ type ImageBytes struct {
 
 // AssistantResponseMessage 助手响应消息
 type AssistantResponseMessage struct {
-	MessageID string         `json:"messageId"`          // 消息 ID
-	Content   string         `json:"content"`            // 响应内容
-	ToolUses  []ToolUse      `json:"toolUses,omitempty"` // 工具使用列表
+	MessageID string    `json:"messageId"`          // 消息 ID
+	Content   string    `json:"content"`            // 响应内容
+	ToolUses  []ToolUse `json:"toolUses,omitempty"` // 工具使用列表
 }
 
 // ToolUse 工具使用记录
@@ -0,0 +1,156 @@
+package document
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxDocumentBytes 单个文档解码后的最大字节数（与 Anthropic API 的 PDF 上限一致）
+const MaxDocumentBytes = 32 << 20
+
+// Document 从 Claude document 内容块中提取的文档
+type Document struct {
+	Title   string                   // 文档标题
+	Context string                   // 文档上下文说明
+	Pages   []string                 // 文档文本；PDF 每页一项，其余来源为单项或按内容块分段
+	Paged   bool                     // 是否为分页文档（PDF），决定是否输出页码标记
+	Images  []map[string]interface{} // content 来源中包含的 image 内容块
+}
+
+// Parse 解析 Claude document 内容块
+// 支持 source.type 为 base64（PDF 或纯文本）、text 和 content 三种来源
+// 参数 block 为 document 类型的内容块
+// 返回解析后的文档和可能的错误
+func Parse(block map[string]interface{}) (*Document, error) {
+	doc := &Document{}
+	doc.Title, _ = block["title"].(string)
+	doc.Context, _ = block["context"].(string)
+
+	source, ok := block["source"].(map[string]interface{})
+	if !ok {
+		return doc, fmt.Errorf("document source is missing")
+	}
+
+	sourceType, _ := source["type"].(string)
+	mediaType, _ := source["media_type"].(string)
+
+	switch sourceType {
+	case "base64":
+		encoded, _ := source["data"].(string)
+		// 去掉填充后按无填充编码计算解码长度，恰好等于上限的文档不会因填充被误判
+		if base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(encoded, "="))) > MaxDocumentBytes {
+			return doc, fmt.Errorf("document exceeds %d bytes", MaxDocumentBytes)
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return doc, fmt.Errorf("invalid base64 document data: %w", err)
+		}
+
+		if mediaType == "application/pdf" || (mediaType == "" && strings.HasPrefix(string(data), "%PDF-")) {
+			pages, err := ExtractPDFText(data)
+			if err != nil {
+				return doc, err
+			}
+			doc.Pages = pages
+			doc.Paged = true
+			return doc, nil
+		}
+
+		if strings.HasPrefix(mediaType, "text/") || mediaType == "" {
+			if !utf8.Valid(data) {
+				return doc, fmt.Errorf("document data is not valid UTF-8 text")
+			}
+			doc.Pages = []string{string(data)}
+			return doc, nil
+		}
+
+		return doc, fmt.Errorf("unsupported document media type %q", mediaType)
+
+	case "text":
+		data, _ := source["data"].(string)
+		doc.Pages = []string{data}
+		return doc, nil
+
+	case "content":
+		switch content := source["content"].(type) {
+		case string:
+			doc.Pages = []string{content}
+		case []interface{}:
+			for _, item := range content {
+				itemMap, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				switch itemMap["type"] {
+				case "text":
+					if text, ok := itemMap["text"].(string); ok {
+						doc.Pages = append(doc.Pages, text)
+					}
+				case "image":
+					doc.Images = append(doc.Images, itemMap)
+				}
+			}
+		}
+		return doc, nil
+	}
+
+	return doc, fmt.Errorf("unsupported document source type %q", sourceType)
+}
+
+// Format 将文档格式化为带分隔标记的文本段，用于注入 Amazon Q 用户消息
+// 返回格式化后的文本
+func (d *Document) Format() string {
+	var sb strings.Builder
+	sb.WriteString(d.header())
+
+	if d.Paged {
+		for i, page := range d.Pages {
+			fmt.Fprintf(&sb, "--- PAGE %d ---\n", i+1)
+			if page != "" {
+				sb.WriteString(page)
+				sb.WriteString("\n")
+			}
+		}
+	} else {
+		body := strings.Join(d.Pages, "\n\n")
+		if body != "" {
+			sb.WriteString(body)
+			sb.WriteString("\n")
+		}
+	}
+
+	sb.WriteString("--- DOCUMENT END ---")
+	return sb.String()
+}
+
+// header 构建文档段的开始标记及标题、上下文信息
+func (d *Document) header() string {
+	var sb strings.Builder
+	sb.WriteString("--- DOCUMENT BEGIN ---\n")
+	if d.Title != "" {
+		fmt.Fprintf(&sb, "Title: %s\n", d.Title)
+	}
+	if d.Context != "" {
+		fmt.Fprintf(&sb, "Context: %s\n", d.Context)
+	}
+	if d.Paged {
+		fmt.Fprintf(&sb, "Pages: %d\n", len(d.Pages))
+	}
+	return sb.String()
+}
+
+// Render 解析 document 内容块并格式化为文本段
+// 解析失败时返回包含错误说明的文本段，以便模型告知用户文档无法读取
+// 参数 block 为 document 类型的内容块
+// 返回格式化后的文本以及 content 来源中包含的 image 内容块
+func Render(block map[string]interface{}) (string, []map[string]interface{}) {
+	doc, err := Parse(block)
+	if err != nil {
+		slog.Warn("failed to process document", "component", "document", "title", doc.Title, "error", err)
+		return fmt.Sprintf("%s[Document could not be processed: %v]\n--- DOCUMENT END ---", doc.header(), err), nil
+	}
+	return doc.Format(), doc.Images
+}
@@ -0,0 +1,33 @@
+package document
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// base64Block 返回 base64 来源的 document 内容块
+func base64Block(mediaType string, data string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":   "document",
+		"source": map[string]interface{}{"type": "base64", "media_type": mediaType, "data": data},
+	}
+}
+
+func TestParseDocumentSizeLimit(t *testing.T) {
+	// 超过上限的数据在解码前被拒绝
+	over := strings.Repeat("A", base64.StdEncoding.EncodedLen(MaxDocumentBytes+1))
+	if _, err := Parse(base64Block("application/pdf", over)); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("%d bytes: error = %v, want size limit error", MaxDocumentBytes+1, err)
+	}
+
+	// 恰好等于上限的数据可以解析
+	atLimit := base64.StdEncoding.EncodeToString(make([]byte, MaxDocumentBytes))
+	doc, err := Parse(base64Block("text/plain", atLimit))
+	if err != nil {
+		t.Fatalf("%d bytes: unexpected error: %v", MaxDocumentBytes, err)
+	}
+	if len(doc.Pages) != 1 || len(doc.Pages[0]) != MaxDocumentBytes {
+		t.Errorf("%d bytes: got %d pages", MaxDocumentBytes, len(doc.Pages))
+	}
+}
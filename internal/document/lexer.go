@@ -0,0 +1,363 @@
+package document
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// pdfName PDF 名称对象（不含前导斜杠）
+type pdfName string
+
+// pdfKeyword PDF 关键字或内容流操作符
+type pdfKeyword string
+
+// pdfString PDF 字符串对象（字面量或十六进制，已解码为原始字节）
+type pdfString []byte
+
+// pdfRef PDF 间接对象引用
+type pdfRef struct {
+	Num int
+	Gen int
+}
+
+// pdfDict PDF 字典对象
+type pdfDict map[pdfName]interface{}
+
+// pdfArray PDF 数组对象
+type pdfArray []interface{}
+
+// pdfStream PDF 流对象
+type pdfStream struct {
+	Dict pdfDict
+	Data []byte
+}
+
+// 内部使用的分隔符标记
+type pdfDelim string
+
+const (
+	delimDictStart  pdfDelim = "<<"
+	delimDictEnd    pdfDelim = ">>"
+	delimArrayStart pdfDelim = "["
+	delimArrayEnd   pdfDelim = "]"
+	delimProcStart  pdfDelim = "{"
+	delimProcEnd    pdfDelim = "}"
+)
+
+// maxNestingDepth 解析对象时允许的最大嵌套深度
+const maxNestingDepth = 64
+
+// lexer PDF 词法分析器
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+// newLexer 创建词法分析器
+// 参数 data 为待解析的字节数据
+// 返回词法分析器实例
+func newLexer(data []byte) *lexer {
+	return &lexer{data: data}
+}
+
+// isWhitespace 判断字节是否为 PDF 空白字符
+func isWhitespace(b byte) bool {
+	return b == 0 || b == '\t' || b == '\n' || b == '\f' || b == '\r' || b == ' '
+}
+
+// isDelimiter 判断字节是否为 PDF 分隔符
+func isDelimiter(b byte) bool {
+	switch b {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace 跳过空白字符和注释
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		b := l.data[l.pos]
+		if isWhitespace(b) {
+			l.pos++
+			continue
+		}
+		if b == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		break
+	}
+}
+
+// readRegular 读取一段连续的常规字符
+func (l *lexer) readRegular() []byte {
+	start := l.pos
+	for l.pos < len(l.data) && !isWhitespace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return l.data[start:l.pos]
+}
+
+// next 读取下一个词法单元
+// 多余的 ) 和 > 等无法识别的字节直接跳过（循环而非递归，避免大量此类字节导致栈溢出）
+// 返回词法单元（到达末尾时返回 nil 和 false）
+func (l *lexer) next() (interface{}, bool) {
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return nil, false
+		}
+
+		b := l.data[l.pos]
+		switch b {
+		case '/':
+			l.pos++
+			return pdfName(decodeName(l.readRegular())), true
+		case '(':
+			l.pos++
+			return l.readLiteralString(), true
+		case '<':
+			if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+				l.pos += 2
+				return delimDictStart, true
+			}
+			l.pos++
+			return l.readHexString(), true
+		case '>':
+			if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+				l.pos += 2
+				return delimDictEnd, true
+			}
+			l.pos++
+			continue
+		case '[':
+			l.pos++
+			return delimArrayStart, true
+		case ']':
+			l.pos++
+			return delimArrayEnd, true
+		case '{':
+			l.pos++
+			return delimProcStart, true
+		case '}':
+			l.pos++
+			return delimProcEnd, true
+		case ')':
+			l.pos++
+			continue
+		}
+
+		word := l.readRegular()
+		if len(word) == 0 {
+			l.pos++
+			continue
+		}
+		if n, err := strconv.ParseFloat(string(word), 64); err == nil {
+			return n, true
+		}
+		switch string(word) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		case "null":
+			return nil, true
+		}
+		return pdfKeyword(word), true
+	}
+}
+
+// decodeName 解码名称中的 #xx 转义
+func decodeName(raw []byte) string {
+	if bytes.IndexByte(raw, '#') < 0 {
+		return string(raw)
+	}
+	var out []byte
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+2 < len(raw) {
+			if v, err := strconv.ParseUint(string(raw[i+1:i+3]), 16, 8); err == nil {
+				out = append(out, byte(v))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, raw[i])
+	}
+	return string(out)
+}
+
+// readLiteralString 读取字面量字符串（左括号已消费）
+func (l *lexer) readLiteralString() pdfString {
+	var out []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		b := l.data[l.pos]
+		l.pos++
+		switch b {
+		case '(':
+			depth++
+			out = append(out, b)
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+			out = append(out, b)
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// readHexString 读取十六进制字符串（左尖括号已消费）
+func (l *lexer) readHexString() pdfString {
+	var digits []byte
+	for l.pos < len(l.data) {
+		b := l.data[l.pos]
+		l.pos++
+		if b == '>' {
+			break
+		}
+		if isHexDigit(b) {
+			digits = append(digits, b)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		out[i] = hexValue(digits[2*i])<<4 | hexValue(digits[2*i+1])
+	}
+	return out
+}
+
+// isHexDigit 判断是否为十六进制字符
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// hexValue 返回十六进制字符对应的数值
+func hexValue(b byte) byte {
+	switch {
+	case b >= '0' && b <= '9':
+		return b - '0'
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10
+	case b >= 'A' && b <= 'F':
+		return b - 'A' + 10
+	}
+	return 0
+}
+
+// parseObject 解析一个完整的 PDF 对象（数组和字典会递归解析，数字后跟 "gen R" 时识别为引用）
+// 返回解析的对象以及是否成功读取
+func (l *lexer) parseObject() (interface{}, bool) {
+	return l.parseObjectDepth(0)
+}
+
+// parseObjectDepth 带嵌套深度限制的对象解析
+func (l *lexer) parseObjectDepth(depth int) (interface{}, bool) {
+	tok, ok := l.next()
+	if !ok {
+		return nil, false
+	}
+	return l.completeObject(tok, depth)
+}
+
+// completeObject 根据已读取的首个词法单元补全对象
+func (l *lexer) completeObject(tok interface{}, depth int) (interface{}, bool) {
+	if depth > maxNestingDepth {
+		return nil, false
+	}
+
+	switch t := tok.(type) {
+	case float64:
+		// 尝试识别 "num gen R" 引用
+		saved := l.pos
+		if gen, ok := l.next(); ok {
+			if genNum, isNum := gen.(float64); isNum {
+				if kw, ok := l.next(); ok && kw == pdfKeyword("R") {
+					return pdfRef{Num: int(t), Gen: int(genNum)}, true
+				}
+			}
+		}
+		l.pos = saved
+		return t, true
+	case pdfDelim:
+		switch t {
+		case delimDictStart:
+			dict := pdfDict{}
+			for {
+				key, ok := l.next()
+				if !ok || key == delimDictEnd {
+					return dict, true
+				}
+				name, isName := key.(pdfName)
+				if !isName {
+					continue
+				}
+				value, ok := l.parseObjectDepth(depth + 1)
+				if !ok {
+					return dict, true
+				}
+				if value == delimDictEnd {
+					return dict, true
+				}
+				dict[name] = value
+			}
+		case delimArrayStart:
+			var arr pdfArray
+			for {
+				item, ok := l.next()
+				if !ok || item == delimArrayEnd {
+					return arr, true
+				}
+				value, ok := l.completeObject(item, depth+1)
+				if !ok {
+					return arr, true
+				}
+				arr = append(arr, value)
+			}
+		}
+	}
+	return tok, true
+}
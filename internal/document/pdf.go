@@ -0,0 +1,412 @@
+package document
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNotPDF 数据不是 PDF 文件
+	ErrNotPDF = errors.New("data is not a PDF file")
+	// ErrEncryptedPDF PDF 已加密，无法提取文本
+	ErrEncryptedPDF = errors.New("encrypted PDF files are not supported")
+)
+
+// maxDecodedStreamSize 单个流解压后的最大字节数，防止压缩炸弹
+const maxDecodedStreamSize = 64 << 20
+
+// objectHeaderPattern 匹配间接对象头 "num gen obj"
+var objectHeaderPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// pdfFile 已解析的 PDF 文件
+type pdfFile struct {
+	objects map[int]interface{}
+}
+
+// ExtractPDFText 从 PDF 数据中按页提取文本
+// 参数 data 为 PDF 文件原始字节
+// 返回每页的文本和可能的错误
+func ExtractPDFText(data []byte) ([]string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\f\r "), []byte("%PDF-")) {
+		return nil, ErrNotPDF
+	}
+
+	file := parsePDF(data)
+	if file.hasEncryption(data) {
+		return nil, ErrEncryptedPDF
+	}
+
+	pages := file.pages()
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages found in PDF")
+	}
+
+	texts := make([]string, 0, len(pages))
+	for _, page := range pages {
+		texts = append(texts, file.pageText(page))
+	}
+	return texts, nil
+}
+
+// parsePDF 扫描文件中的所有间接对象（不依赖 xref 表，可容忍损坏的文件）
+func parsePDF(data []byte) *pdfFile {
+	file := &pdfFile{objects: make(map[int]interface{})}
+
+	// 后出现的对象覆盖先出现的对象（增量更新）
+	for _, loc := range objectHeaderPattern.FindAllSubmatchIndex(data, -1) {
+		if loc[0] > 0 && !isWhitespace(data[loc[0]-1]) && !isDelimiter(data[loc[0]-1]) {
+			continue
+		}
+		num, err := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		if err != nil {
+			continue
+		}
+		l := newLexer(data)
+		l.pos = loc[1]
+		obj, ok := l.parseObject()
+		if !ok {
+			continue
+		}
+		if dict, isDict := obj.(pdfDict); isDict {
+			save := l.pos
+			if kw, ok := l.next(); ok && kw == pdfKeyword("stream") {
+				obj = &pdfStream{Dict: dict, Data: readStreamData(data, l.pos, dict)}
+			} else {
+				l.pos = save
+			}
+		}
+		file.objects[num] = obj
+	}
+
+	file.expandObjectStreams()
+	return file
+}
+
+// readStreamData 读取 stream 关键字之后的原始流数据
+func readStreamData(data []byte, pos int, dict pdfDict) []byte {
+	// stream 关键字后紧跟 CRLF 或 LF
+	if pos < len(data) && data[pos] == '\r' {
+		pos++
+	}
+	if pos < len(data) && data[pos] == '\n' {
+		pos++
+	}
+	if pos > len(data) {
+		return nil
+	}
+
+	// 先以浮点数校验长度（排除负数、NaN 和超出剩余数据的值），再转换为 int，避免溢出
+	if length, ok := dict["Length"].(float64); ok {
+		if n, valid := floatIndex(length, len(data)-pos); valid {
+			end := pos + n
+			rest := bytes.TrimLeft(data[end:], "\x00\t\n\f\r ")
+			if bytes.HasPrefix(rest, []byte("endstream")) {
+				return data[pos:end]
+			}
+		}
+	}
+
+	// Length 为间接引用或不正确时，退化为查找 endstream
+	end := bytes.Index(data[pos:], []byte("endstream"))
+	if end < 0 {
+		return data[pos:]
+	}
+	return bytes.TrimRight(data[pos:pos+end], "\r\n")
+}
+
+// floatIndex 将文件中的数值转换为 0 到 max 之间的 int
+// 参数 value 为 PDF 数值
+// 参数 max 为允许的最大值
+// 返回转换后的值和是否在范围内（负数、NaN 和大于 max 的值无效）
+func floatIndex(value float64, max int) (int, bool) {
+	if !(value >= 0 && value <= float64(max)) {
+		return 0, false
+	}
+	return int(value), true
+}
+
+// expandObjectStreams 展开对象流（PDF 1.5+ 的 /Type /ObjStm）中压缩存储的对象
+func (f *pdfFile) expandObjectStreams() {
+	nums := make([]int, 0, len(f.objects))
+	for num := range f.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	for _, num := range nums {
+		stream, ok := f.objects[num].(*pdfStream)
+		if !ok || stream.Dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		data, err := f.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		// N 和 First 来自文件内容，转换为 int 前先校验范围
+		count, _ := f.resolve(stream.Dict["N"]).(float64)
+		first, _ := f.resolve(stream.Dict["First"]).(float64)
+		objects, validCount := floatIndex(count, len(data))
+		start, validFirst := floatIndex(first, len(data))
+		if !validCount || !validFirst {
+			continue
+		}
+
+		header := newLexer(data[:start])
+		for i := 0; i < objects; i++ {
+			objNum, ok1 := header.next()
+			offset, ok2 := header.next()
+			n, isNum := objNum.(float64)
+			off, isOff := offset.(float64)
+			if !ok1 || !ok2 || !isNum || !isOff {
+				break
+			}
+			num, validNum := floatIndex(n, math.MaxInt32)
+			relative, validOff := floatIndex(off, len(data)-start-1)
+			if !validNum || !validOff {
+				continue
+			}
+			// 直接定义的对象优先
+			if _, exists := f.objects[num]; exists {
+				continue
+			}
+			l := newLexer(data)
+			l.pos = start + relative
+			if obj, ok := l.parseObject(); ok {
+				f.objects[num] = obj
+			}
+		}
+	}
+}
+
+// hasEncryption 检测文件是否加密
+func (f *pdfFile) hasEncryption(data []byte) bool {
+	idx := bytes.LastIndex(data, []byte("trailer"))
+	if idx >= 0 {
+		l := newLexer(data)
+		l.pos = idx + len("trailer")
+		if obj, ok := l.parseObject(); ok {
+			if dict, isDict := obj.(pdfDict); isDict {
+				if _, encrypted := dict["Encrypt"]; encrypted {
+					return true
+				}
+			}
+		}
+	}
+	// 交叉引用流的字典同样可以声明 /Encrypt
+	for _, obj := range f.objects {
+		if stream, ok := obj.(*pdfStream); ok && stream.Dict["Type"] == pdfName("XRef") {
+			if _, encrypted := stream.Dict["Encrypt"]; encrypted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolve 解析间接引用，返回实际对象
+func (f *pdfFile) resolve(obj interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = f.objects[ref.Num]
+	}
+	return nil
+}
+
+// resolveDict 解析对象并返回字典（流对象返回其字典）
+func (f *pdfFile) resolveDict(obj interface{}) pdfDict {
+	switch v := f.resolve(obj).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.Dict
+	}
+	return nil
+}
+
+// decodeStream 按 /Filter 解码流数据
+func (f *pdfFile) decodeStream(stream *pdfStream) ([]byte, error) {
+	var filters []pdfName
+	switch v := f.resolve(stream.Dict["Filter"]).(type) {
+	case pdfName:
+		filters = []pdfName{v}
+	case pdfArray:
+		for _, item := range v {
+			if name, ok := f.resolve(item).(pdfName); ok {
+				filters = append(filters, name)
+			}
+		}
+	}
+
+	data := stream.Data
+	for _, filter := range filters {
+		var err error
+		switch filter {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+		case "ASCIIHexDecode", "AHx":
+			data = newLexer(append([]byte{}, data...)).readHexString()
+		case "ASCII85Decode", "A85":
+			data, err = decodeASCII85(data)
+		default:
+			return nil, fmt.Errorf("unsupported stream filter %s", filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate 解压 FlateDecode 数据（容忍尾部截断）
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	out, err := io.ReadAll(io.LimitReader(reader, maxDecodedStreamSize))
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeASCII85 解码 ASCII85 数据
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, []byte("<~"))
+	if idx := bytes.Index(data, []byte("~>")); idx >= 0 {
+		data = data[:idx]
+	}
+	out := make([]byte, len(data))
+	n, _, err := ascii85.Decode(out, data, true)
+	if err != nil {
+		return nil, err
+	}
+	return out[:n], nil
+}
+
+// pdfPage 页面对象及其继承的资源
+type pdfPage struct {
+	Dict      pdfDict
+	Resources pdfDict
+}
+
+// pages 按文档顺序返回所有页面
+func (f *pdfFile) pages() []pdfPage {
+	var catalog pdfDict
+	for _, obj := range f.objects {
+		if dict := f.resolveDict(obj); dict != nil && dict["Type"] == pdfName("Catalog") {
+			catalog = dict
+			break
+		}
+	}
+
+	var pages []pdfPage
+	if catalog != nil {
+		visited := make(map[int]bool)
+		f.walkPageTree(catalog["Pages"], nil, visited, &pages)
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	// 页面树缺失时按对象编号顺序收集所有页面
+	nums := make([]int, 0, len(f.objects))
+	for num := range f.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if dict := f.resolveDict(f.objects[num]); dict != nil && dict["Type"] == pdfName("Page") {
+			pages = append(pages, pdfPage{Dict: dict, Resources: f.resolveDict(dict["Resources"])})
+		}
+	}
+	return pages
+}
+
+// walkPageTree 深度优先遍历页面树，子节点继承父节点的 /Resources
+func (f *pdfFile) walkPageTree(node interface{}, inherited pdfDict, visited map[int]bool, pages *[]pdfPage) {
+	if ref, ok := node.(pdfRef); ok {
+		if visited[ref.Num] {
+			return
+		}
+		visited[ref.Num] = true
+	}
+
+	dict := f.resolveDict(node)
+	if dict == nil {
+		return
+	}
+
+	resources := inherited
+	if res := f.resolveDict(dict["Resources"]); res != nil {
+		resources = res
+	}
+
+	if kids, ok := f.resolve(dict["Kids"]).(pdfArray); ok {
+		for _, kid := range kids {
+			f.walkPageTree(kid, resources, visited, pages)
+		}
+		return
+	}
+
+	if dict["Type"] == pdfName("Page") || dict["Contents"] != nil {
+		*pages = append(*pages, pdfPage{Dict: dict, Resources: resources})
+	}
+}
+
+// pageText 提取单页文本
+func (f *pdfFile) pageText(page pdfPage) string {
+	var content []byte
+	switch v := f.resolve(page.Dict["Contents"]).(type) {
+	case *pdfStream:
+		content, _ = f.decodeStream(v)
+	case pdfArray:
+		for _, item := range v {
+			if stream, ok := f.resolve(item).(*pdfStream); ok {
+				if data, err := f.decodeStream(stream); err == nil {
+					content = append(content, data...)
+					content = append(content, '\n')
+				}
+			}
+		}
+	}
+
+	ex := &textExtractor{file: f, fonts: make(map[pdfRef]*fontDecoder)}
+	ex.run(content, page.Resources, 0)
+	return normalizePageText(ex.out.String())
+}
+
+// normalizePageText 清理页面文本：去除行尾空白并合并多余空行
+func normalizePageText(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
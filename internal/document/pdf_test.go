@@ -0,0 +1,145 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildPDF 按顺序拼接间接对象，对象编号从 1 开始
+func buildPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	for i, body := range objects {
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	buf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return buf.Bytes()
+}
+
+// stream 返回带正确 /Length 的流对象
+func stream(dict, data string) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// simplePDF 返回单页、使用 ToUnicode 字体的 PDF
+func simplePDF(content, cmap string) []byte {
+	return buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>",
+		stream("", content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /ToUnicode 6 0 R >>",
+		stream("", cmap),
+	)
+}
+
+// extractWithin 在限定时间内提取文本，超时视为死循环
+func extractWithin(t *testing.T, data []byte) ([]string, error) {
+	t.Helper()
+	type result struct {
+		pages []string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		pages, err := ExtractPDFText(data)
+		done <- result{pages, err}
+	}()
+	select {
+	case r := <-done:
+		return r.pages, r.err
+	case <-time.After(10 * time.Second):
+		t.Fatal("ExtractPDFText did not return within 10s")
+		return nil, nil
+	}
+}
+
+func TestExtractPDFText(t *testing.T) {
+	cmap := "begincmap\n1 begincodespacerange <00> <FF> endcodespacerange\n1 beginbfrange <41> <43> <0061> endbfrange\nendcmap"
+	pages, err := extractWithin(t, simplePDF("BT /F1 12 Tf 72 720 Td (ABC) Tj ET", cmap))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pages) != 1 || strings.TrimSpace(pages[0]) != "abc" {
+		t.Fatalf("pages = %q, want [\"abc\"]", pages)
+	}
+}
+
+func TestExtractPDFTextStrayDelimiters(t *testing.T) {
+	// 大量多余的 ) 和 > 曾导致词法分析器递归过深而栈溢出；限制栈大小后几百 KB 即可复现
+	defer debug.SetMaxStack(debug.SetMaxStack(8 << 20))
+	for _, b := range []string{")", ">"} {
+		data := buildPDF("<< /Type /Catalog /Pages 2 0 R >>", "<< /A "+strings.Repeat(b, 512<<10)+" >>")
+		if _, err := extractWithin(t, data); err == nil {
+			t.Errorf("%q: expected error for PDF without pages", b)
+		}
+	}
+}
+
+func TestExtractPDFTextInvalidStreamLength(t *testing.T) {
+	for _, length := range []string{"1e30", "-1e30", "-5", "9223372036854775807"} {
+		data := buildPDF(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+			"<< /Length "+length+" >>\nstream\nBT (A) Tj ET\nendstream",
+		)
+		if _, err := extractWithin(t, data); err != nil {
+			t.Errorf("Length %s: unexpected error: %v", length, err)
+		}
+	}
+}
+
+func TestExtractPDFTextInvalidObjectStream(t *testing.T) {
+	cases := []struct{ n, first string }{
+		{"1", "-5"},
+		{"1", "1e30"},
+		{"1e30", "4"},
+		{"-1", "4"},
+		{"1", "4"},
+	}
+	for _, c := range cases {
+		// 偏移量同样来自文件内容
+		for _, header := range []string{"7 0 ", "7 -9 ", "7 1e30 ", "-7 0 ", "1e30 0 "} {
+			objStm := stream(fmt.Sprintf("/Type /ObjStm /N %s /First %s", c.n, c.first), header+"<< /Type /Page >>")
+			data := buildPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+				stream("", "BT (A) Tj ET"),
+				objStm,
+			)
+			if _, err := extractWithin(t, data); err != nil {
+				t.Errorf("N %s First %s header %q: unexpected error: %v", c.n, c.first, header, err)
+			}
+		}
+	}
+}
+
+func TestExtractPDFTextCMapRangeAtMaxCode(t *testing.T) {
+	// end 为 0xFFFFFFFF 时 uint32 计数器回绕曾导致死循环
+	for _, cmap := range []string{
+		"1 beginbfrange <FFFFFFF0> <FFFFFFFF> <0041> endbfrange",
+		"1 beginbfrange <FFFFFFF0> <FFFFFFFF> [<0041> <0042>] endbfrange",
+		"1 beginbfrange <00000000> <FFFFFFFF> <0041> endbfrange",
+	} {
+		if _, err := extractWithin(t, simplePDF("BT /F1 12 Tf (A) Tj ET", cmap)); err != nil {
+			t.Errorf("%s: unexpected error: %v", cmap, err)
+		}
+	}
+}
+
+func FuzzExtract(f *testing.F) {
+	f.Add(simplePDF("BT /F1 12 Tf 72 720 Td (ABC) Tj ET", "1 beginbfrange <41> <43> <0061> endbfrange"))
+	f.Add(simplePDF("BT /F1 12 Tf [(A) -300 (B)] TJ ET", "1 beginbfchar <41> <0041> endbfchar"))
+	f.Add(buildPDF("<< /Type /Catalog /Pages 2 0 R >>", "<< /A ))))>>>> >>"))
+	f.Add(buildPDF(stream("/Type /ObjStm /N 1 /First -5", "1 0 << >>")))
+	f.Add([]byte("%PDF-1.4\n1 0 obj << /Length 1e30 >> stream\nabc\nendstream endobj"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ExtractPDFText(data)
+	})
+}
@@ -0,0 +1,493 @@
+package document
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// maxFormDepth Form XObject 最大递归深度
+const maxFormDepth = 8
+
+// maxCMapRange ToUnicode CMap 中单个 bfrange 最多包含的字符码数，超出的范围忽略
+const maxCMapRange = 0x10000
+
+// maxCMapEntries 单个字体的 ToUnicode 映射最多包含的字符码数（防止构造的 CMap 耗尽内存）
+const maxCMapEntries = 1 << 20
+
+// tjSpaceThreshold TJ 数组中大于该字距调整量（千分之一字号）时插入空格
+const tjSpaceThreshold = 200
+
+// wordGapRatio 同一行内两段文本的间距超过字号的该比例时视为单词间隔
+const wordGapRatio = 0.2
+
+// defaultGlyphWidth 缺少字宽信息时使用的默认字宽（千分之一字号）
+const defaultGlyphWidth = 500
+
+// winAnsiSpecials WinAnsiEncoding 中 0x80-0x9F 区间与 Latin-1 不同的字符
+var winAnsiSpecials = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡',
+	0x88: 'ˆ', 0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ', 0x8E: 'Ž',
+	0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–',
+	0x97: '—', 0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›', 0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+}
+
+// fontDecoder 字体编码解码器，基于 /ToUnicode CMap 将字符码映射为 Unicode
+type fontDecoder struct {
+	codeWidth    int                // 字符码字节宽度（1 或 2）
+	mapping      map[uint32]string  // 字符码到 Unicode 的映射
+	composite    bool               // 是否为复合字体（Type0，无 ToUnicode 时无法解码）
+	widths       map[uint32]float64 // 字符码到字宽的映射（千分之一字号）
+	defaultWidth float64            // 未列出字符的默认字宽
+}
+
+// codes 按字符码宽度切分字符串字节
+func (d *fontDecoder) codes(raw []byte) []uint32 {
+	width := 1
+	if d != nil && d.codeWidth > 0 {
+		width = d.codeWidth
+	}
+
+	codes := make([]uint32, 0, len(raw)/width)
+	for i := 0; i+width <= len(raw); i += width {
+		codes = append(codes, bytesToCode(raw[i:i+width]))
+	}
+	return codes
+}
+
+// decode 将字符串字节解码为 Unicode 文本
+func (d *fontDecoder) decode(raw []byte) string {
+	if d == nil {
+		return decodeSimple(raw)
+	}
+	if d.composite && len(d.mapping) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, code := range d.codes(raw) {
+		if text, ok := d.mapping[code]; ok {
+			sb.WriteString(text)
+		} else if !d.composite && code <= 0xFF {
+			sb.WriteString(decodeSimple([]byte{byte(code)}))
+		}
+	}
+	return sb.String()
+}
+
+// advance 估算字符串在文本空间中的水平前进量（单位为字号）
+func (d *fontDecoder) advance(raw []byte) float64 {
+	total := 0.0
+	for _, code := range d.codes(raw) {
+		w := float64(defaultGlyphWidth)
+		if d != nil {
+			if cw, ok := d.widths[code]; ok {
+				w = cw
+			} else if d.defaultWidth > 0 {
+				w = d.defaultWidth
+			}
+		}
+		total += w
+	}
+	return total / 1000
+}
+
+// decodeSimple 按 WinAnsiEncoding 近似解码单字节字符串
+func decodeSimple(raw []byte) string {
+	var sb strings.Builder
+	for _, b := range raw {
+		if r, ok := winAnsiSpecials[b]; ok {
+			sb.WriteRune(r)
+		} else if b >= 0x20 || b == '\n' || b == '\t' {
+			sb.WriteRune(rune(b))
+		}
+	}
+	return sb.String()
+}
+
+// decodeUTF16BE 解码 UTF-16BE 字节序列
+func decodeUTF16BE(raw []byte) string {
+	units := make([]uint16, 0, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// bytesToCode 将字节序列转换为整数字符码
+func bytesToCode(raw []byte) uint32 {
+	var code uint32
+	for _, b := range raw {
+		code = code<<8 | uint32(b)
+	}
+	return code
+}
+
+// parseToUnicode 解析 /ToUnicode CMap
+// 参数 data 为解码后的 CMap 数据
+// 返回字体解码器
+func parseToUnicode(data []byte) *fontDecoder {
+	d := &fontDecoder{codeWidth: 1, mapping: make(map[uint32]string)}
+	parseCMapInto(d, data)
+	return d
+}
+
+// parseCMapInto 解析 CMap 数据并写入解码器
+func parseCMapInto(d *fontDecoder, data []byte) {
+	l := newLexer(data)
+
+	var operands []interface{}
+	for {
+		tok, ok := l.parseObject()
+		if !ok {
+			break
+		}
+		kw, isKeyword := tok.(pdfKeyword)
+		if !isKeyword {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch kw {
+		case "endcodespacerange":
+			if len(operands) > 0 {
+				if lo, ok := operands[0].(pdfString); ok && len(lo) > 0 {
+					d.codeWidth = len(lo)
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					d.mapping[bytesToCode(src)] = decodeUTF16BE(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 {
+					continue
+				}
+				start, end := bytesToCode(lo), bytesToCode(hi)
+				if end < start || end-start >= maxCMapRange || len(d.mapping) >= maxCMapEntries {
+					continue
+				}
+				// 按个数循环（uint64 计数），end 为 0xFFFFFFFF 时 uint32 计数器会回绕导致死循环
+				size := uint64(end-start) + 1
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					base := []rune(decodeUTF16BE(dst))
+					if len(base) == 0 {
+						continue
+					}
+					for offset := uint64(0); offset < size; offset++ {
+						runes := append([]rune{}, base...)
+						runes[len(runes)-1] += rune(offset)
+						d.mapping[start+uint32(offset)] = string(runes)
+					}
+				case pdfArray:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok && uint64(j) < size {
+							d.mapping[start+uint32(j)] = decodeUTF16BE(s)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// textExtractor 内容流文本提取器
+type textExtractor struct {
+	file     *pdfFile
+	fonts    map[pdfRef]*fontDecoder
+	font     *fontDecoder
+	fontSize float64
+	out      strings.Builder
+	last     byte
+	lineX    float64 // 当前行起点的横坐标
+	lineY    float64 // 当前行起点的纵坐标
+	curX     float64 // 当前文本位置的横坐标（估算）
+}
+
+// write 向输出追加文本并记录最后一个字节
+func (e *textExtractor) write(text string) {
+	if text == "" {
+		return
+	}
+	e.out.WriteString(text)
+	e.last = text[len(text)-1]
+}
+
+// newline 在输出中追加换行（避免重复换行）
+func (e *textExtractor) newline() {
+	if e.out.Len() > 0 && e.last != '\n' {
+		e.write("\n")
+	}
+}
+
+// space 在输出中追加空格（避免重复空格）
+func (e *textExtractor) space() {
+	if e.out.Len() > 0 && e.last != ' ' && e.last != '\n' {
+		e.write(" ")
+	}
+}
+
+// show 输出一段文本并推进当前位置
+func (e *textExtractor) show(raw pdfString) {
+	e.write(e.font.decode(raw))
+	e.curX += e.font.advance(raw) * e.fontSize
+}
+
+// moveTo 移动到新的行起点，按位置关系插入换行或空格
+func (e *textExtractor) moveTo(x, y float64) {
+	if y != e.lineY {
+		e.newline()
+	} else if x-e.curX > e.fontSize*wordGapRatio || x < e.curX-e.fontSize {
+		e.space()
+	}
+	e.lineX, e.lineY, e.curX = x, y, x
+}
+
+// loadFont 从资源中加载指定名称的字体解码器
+func (e *textExtractor) loadFont(resources pdfDict, name pdfName) *fontDecoder {
+	fonts := e.file.resolveDict(resources["Font"])
+	if fonts == nil {
+		return nil
+	}
+	entry := fonts[name]
+	ref, isRef := entry.(pdfRef)
+	if isRef {
+		if cached, ok := e.fonts[ref]; ok {
+			return cached
+		}
+	}
+
+	var decoder *fontDecoder
+	if font := e.file.resolveDict(entry); font != nil {
+		if stream, ok := e.file.resolve(font["ToUnicode"]).(*pdfStream); ok {
+			if data, err := e.file.decodeStream(stream); err == nil {
+				decoder = parseToUnicode(data)
+			}
+		}
+		if font["Subtype"] == pdfName("Type0") {
+			if decoder == nil {
+				decoder = &fontDecoder{codeWidth: 2}
+			}
+			decoder.composite = true
+		}
+		if decoder == nil {
+			decoder = &fontDecoder{codeWidth: 1}
+		}
+		e.loadWidths(font, decoder)
+	}
+
+	if isRef {
+		e.fonts[ref] = decoder
+	}
+	return decoder
+}
+
+// loadWidths 读取字体的字宽信息（简单字体的 /Widths 或 CID 字体的 /W）
+func (e *textExtractor) loadWidths(font pdfDict, decoder *fontDecoder) {
+	decoder.widths = make(map[uint32]float64)
+
+	if decoder.composite {
+		descendants, _ := e.file.resolve(font["DescendantFonts"]).(pdfArray)
+		if len(descendants) == 0 {
+			return
+		}
+		cidFont := e.file.resolveDict(descendants[0])
+		if cidFont == nil {
+			return
+		}
+		decoder.defaultWidth = 1000
+		if dw, ok := e.file.resolve(cidFont["DW"]).(float64); ok {
+			decoder.defaultWidth = dw
+		}
+
+		// /W 格式：c [w1 w2 ...] 或 cFirst cLast w
+		w, _ := e.file.resolve(cidFont["W"]).(pdfArray)
+		for i := 0; i < len(w); {
+			first, ok := e.file.resolve(w[i]).(float64)
+			if !ok || i+1 >= len(w) {
+				return
+			}
+			if list, ok := e.file.resolve(w[i+1]).(pdfArray); ok {
+				for j, item := range list {
+					if width, ok := e.file.resolve(item).(float64); ok {
+						decoder.widths[uint32(first)+uint32(j)] = width
+					}
+				}
+				i += 2
+				continue
+			}
+			last, ok1 := e.file.resolve(w[i+1]).(float64)
+			if i+2 >= len(w) || !ok1 {
+				return
+			}
+			width, _ := e.file.resolve(w[i+2]).(float64)
+			for c := uint32(first); c <= uint32(last) && c-uint32(first) <= 0xFFFF; c++ {
+				decoder.widths[c] = width
+			}
+			i += 3
+		}
+		return
+	}
+
+	firstChar, _ := e.file.resolve(font["FirstChar"]).(float64)
+	widths, _ := e.file.resolve(font["Widths"]).(pdfArray)
+	for i, item := range widths {
+		if width, ok := e.file.resolve(item).(float64); ok {
+			decoder.widths[uint32(firstChar)+uint32(i)] = width
+		}
+	}
+	if descriptor := e.file.resolveDict(font["FontDescriptor"]); descriptor != nil {
+		if mw, ok := e.file.resolve(descriptor["MissingWidth"]).(float64); ok && mw > 0 {
+			decoder.defaultWidth = mw
+		}
+	}
+}
+
+// run 执行内容流并收集文本
+// 参数 content 为解码后的内容流
+// 参数 resources 为当前资源字典
+// 参数 depth 为 Form XObject 递归深度
+func (e *textExtractor) run(content []byte, resources pdfDict, depth int) {
+	if depth > maxFormDepth {
+		return
+	}
+
+	l := newLexer(content)
+	var operands []interface{}
+	for {
+		tok, ok := l.parseObject()
+		if !ok {
+			return
+		}
+		op, isOp := tok.(pdfKeyword)
+		if !isOp {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch op {
+		case "BI":
+			skipInlineImage(l)
+		case "BT":
+			e.lineX, e.lineY, e.curX = 0, 0, 0
+		case "ET":
+			e.space()
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[len(operands)-2].(pdfName); ok {
+					e.font = e.loadFont(resources, name)
+				}
+				if size, ok := operands[len(operands)-1].(float64); ok {
+					e.fontSize = size
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				tx, _ := operands[len(operands)-2].(float64)
+				ty, _ := operands[len(operands)-1].(float64)
+				e.moveTo(e.lineX+tx, e.lineY+ty)
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				x, _ := operands[4].(float64)
+				y, _ := operands[5].(float64)
+				e.moveTo(x, y)
+			}
+		case "T*":
+			e.newline()
+			e.curX = e.lineX
+		case "Tj":
+			if len(operands) >= 1 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					e.show(s)
+				}
+			}
+		case "'", "\"":
+			e.newline()
+			e.curX = e.lineX
+			if len(operands) >= 1 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					e.show(s)
+				}
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				if arr, ok := operands[len(operands)-1].(pdfArray); ok {
+					for _, item := range arr {
+						switch v := item.(type) {
+						case pdfString:
+							e.show(v)
+						case float64:
+							if v < -tjSpaceThreshold {
+								e.space()
+							}
+							e.curX -= v / 1000 * e.fontSize
+						}
+					}
+				}
+			}
+		case "Do":
+			if len(operands) >= 1 {
+				if name, ok := operands[len(operands)-1].(pdfName); ok {
+					e.runForm(resources, name, depth)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// runForm 执行 Form XObject 中的内容流
+func (e *textExtractor) runForm(resources pdfDict, name pdfName, depth int) {
+	xobjects := e.file.resolveDict(resources["XObject"])
+	if xobjects == nil {
+		return
+	}
+	stream, ok := e.file.resolve(xobjects[name]).(*pdfStream)
+	if !ok || stream.Dict["Subtype"] != pdfName("Form") {
+		return
+	}
+	data, err := e.file.decodeStream(stream)
+	if err != nil {
+		return
+	}
+
+	formResources := e.file.resolveDict(stream.Dict["Resources"])
+	if formResources == nil {
+		formResources = resources
+	}
+	savedFont, savedSize := e.font, e.fontSize
+	e.run(data, formResources, depth+1)
+	e.font, e.fontSize = savedFont, savedSize
+}
+
+// skipInlineImage 跳过内联图片数据（BI ... ID <binary> EI）
+func skipInlineImage(l *lexer) {
+	for {
+		tok, ok := l.next()
+		if !ok {
+			return
+		}
+		if tok == pdfKeyword("ID") {
+			break
+		}
+	}
+	// ID 后跟一个空白字符，之后为二进制数据，以空白 + EI 结束
+	for l.pos+2 < len(l.data) {
+		if isWhitespace(l.data[l.pos]) && l.data[l.pos+1] == 'E' && l.data[l.pos+2] == 'I' &&
+			(l.pos+3 >= len(l.data) || isWhitespace(l.data[l.pos+3]) || isDelimiter(l.data[l.pos+3])) {
+			l.pos += 3
+			return
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
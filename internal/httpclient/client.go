@@ -0,0 +1,101 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/utils"
+)
+
+// Options 上游 HTTP 客户端参数
+type Options struct {
+	Timeout               time.Duration // 请求总超时（包含读取整个响应体，0 表示不限制）
+	DialTimeout           time.Duration // 建立 TCP 连接超时
+	TLSHandshakeTimeout   time.Duration // TLS 握手超时
+	ResponseHeaderTimeout time.Duration // 等待响应头超时（0 表示不限制）
+	IdleStreamTimeout     time.Duration // 响应体两次读取之间的最大间隔（0 表示不限制）
+	KeepAlive             time.Duration // TCP keepalive 探测间隔（负数表示禁用）
+	IdleConnTimeout       time.Duration // 空闲连接保留时间
+	MaxIdleConns          int           // 最大空闲连接数
+	MaxIdleConnsPerHost   int           // 每个主机的最大空闲连接数
+	MaxConnsPerHost       int           // 每个主机的最大连接数（0 表示不限制）
+	HTTP2                 bool          // 是否尝试使用 HTTP/2
+}
+
+// upstream 共享的上游客户端
+var upstream atomic.Pointer[http.Client]
+
+func init() {
+	Init()
+}
+
+// Init 根据配置重建共享的上游客户端（进行中的请求继续使用原客户端）
+func Init() {
+	upstream.Store(New(OptionsFromConfig()))
+}
+
+// OptionsFromConfig 根据配置创建客户端参数
+// 返回客户端参数
+func OptionsFromConfig() Options {
+	cfg := config.Current().Upstream
+	return Options{
+		Timeout:               cfg.Timeouts.Request.Std(),
+		DialTimeout:           cfg.Timeouts.Dial.Std(),
+		TLSHandshakeTimeout:   cfg.Timeouts.TLSHandshake.Std(),
+		ResponseHeaderTimeout: cfg.Timeouts.ResponseHeader.Std(),
+		IdleStreamTimeout:     cfg.Timeouts.IdleStream.Std(),
+		KeepAlive:             cfg.Timeouts.KeepAlive.Std(),
+		IdleConnTimeout:       cfg.Timeouts.IdleConn.Std(),
+		MaxIdleConns:          cfg.Pool.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.Pool.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.Pool.MaxConnsPerHost,
+		HTTP2:                 cfg.HTTP2,
+	}
+}
+
+// New 创建上游 HTTP 客户端
+// 代理按 upstream.proxy 和 upstream.no_proxy 选择，支持 socks5:// 代理
+// 参数 opts 为客户端参数
+// 返回客户端实例
+func New(opts Options) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 utils.ProxyFunc(),
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     opts.HTTP2,
+	}
+	if !opts.HTTP2 {
+		// 非 nil 的空映射禁用 HTTP/2 协商
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if opts.IdleStreamTimeout > 0 {
+		roundTripper = &idleTimeoutTransport{base: transport, timeout: opts.IdleStreamTimeout}
+	}
+
+	return &http.Client{
+		Transport: roundTripper,
+		Timeout:   opts.Timeout,
+	}
+}
+
+// Upstream 返回共享的上游客户端（连接池和 TLS 会话在请求间复用）
+func Upstream() *http.Client {
+	return upstream.Load()
+}
@@ -0,0 +1,69 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleTimeout 响应体超过空闲超时时间没有新数据
+var ErrIdleTimeout = errors.New("upstream stream idle timeout")
+
+// idleTimeoutTransport 为响应体附加空闲超时的 RoundTripper
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+// RoundTrip 发送请求并包装响应体
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = newIdleTimeoutBody(resp.Body, t.timeout)
+	return resp, nil
+}
+
+// CloseIdleConnections 关闭底层连接池中的空闲连接
+func (t *idleTimeoutTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// idleTimeoutBody 超过空闲时间没有读到数据时关闭的响应体
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// newIdleTimeoutBody 包装响应体并启动空闲计时
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.expired.Store(true)
+		body.Close()
+	})
+	return b
+}
+
+// Read 读取数据，每次读取后重新计时
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.expired.Load() {
+		return n, ErrIdleTimeout
+	}
+	b.timer.Reset(b.timeout)
+	return n, err
+}
+
+// Close 停止计时并关闭响应体
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
@@ -0,0 +1,115 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"amazonq-proxy/internal/config"
+)
+
+// RetryPolicy 上游请求重试策略（指数退避 + 全抖动）
+type RetryPolicy struct {
+	MaxAttempts int           // 最大尝试次数（包含首次请求）
+	BaseDelay   time.Duration // 首次重试的退避上限
+	MaxDelay    time.Duration // 单次退避上限
+	Budget      time.Duration // 单个请求用于重试的总时间预算（0 表示不限制）
+}
+
+// RetryPolicyFromConfig 根据配置创建重试策略
+// 返回重试策略
+func RetryPolicyFromConfig() RetryPolicy {
+	cfg := config.Current().Upstream.Retry
+	return RetryPolicy{
+		MaxAttempts: cfg.MaxAttempts,
+		BaseDelay:   cfg.BaseDelay.Std(),
+		MaxDelay:    cfg.MaxDelay.Std(),
+		Budget:      cfg.Budget.Std(),
+	}
+}
+
+// Attempts 返回最大尝试次数（至少为 1）
+func (p RetryPolicy) Attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Backoff 计算第 attempt 次尝试失败后的退避时间
+// 退避上限为 BaseDelay * 2^(attempt-1)（不超过 MaxDelay），实际时间在 [0, 上限] 内随机
+// 参数 attempt 为已失败的尝试序号（从 1 开始）
+// 返回退避时间
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Next 判断第 attempt 次尝试失败后是否还能重试
+// 参数 attempt 为已失败的尝试序号（从 1 开始）
+// 参数 elapsed 为请求开始以来经过的时间
+// 返回退避时间和是否重试（尝试次数或时间预算用尽时不重试）
+func (p RetryPolicy) Next(attempt int, elapsed time.Duration) (time.Duration, bool) {
+	if attempt >= p.Attempts() {
+		return 0, false
+	}
+	delay := p.Backoff(attempt)
+	if p.Budget > 0 && elapsed+delay > p.Budget {
+		return 0, false
+	}
+	return delay, true
+}
+
+// AttemptHeader 返回 amz-sdk-request 请求头的值
+// 参数 attempt 为当前尝试序号（从 1 开始）
+// 返回形如 "attempt=1; max=3" 的字符串
+func (p RetryPolicy) AttemptHeader(attempt int) string {
+	return fmt.Sprintf("attempt=%d; max=%d", attempt, p.Attempts())
+}
+
+// RetryableStatus 判断上游 HTTP 状态码是否可以重试（429 和 5xx 网关/服务错误）
+// 参数 statusCode 为 HTTP 状态码
+// 返回是否可以重试
+func RetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryableError 判断发送请求或读取响应时的错误是否可以重试
+// 客户端取消或超出截止时间时不重试，其余网络错误（连接重置、超时等）均可重试
+// 参数 ctx 为请求上下文
+// 参数 err 为错误
+// 返回是否可以重试
+func RetryableError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// RetryableException 判断上游在流中返回的异常类型是否可以重试
+// 参数 exceptionType 为异常类型
+// 返回是否可以重试
+func RetryableException(exceptionType string) bool {
+	switch exceptionType {
+	case "InternalServerException", "ServiceUnavailableException", "ThrottlingException":
+		return true
+	}
+	return false
+}
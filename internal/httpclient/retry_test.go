@@ -0,0 +1,137 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoffCeiling(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	cases := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{30, time.Second},
+	}
+	for _, tc := range cases {
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			delay := p.Backoff(tc.attempt)
+			if delay < 0 || delay > tc.ceiling {
+				t.Fatalf("Backoff(%d) = %v, want within [0, %v]", tc.attempt, delay, tc.ceiling)
+			}
+			longest = max(longest, delay)
+		}
+		// 全抖动：1000 次采样中最长的退避应接近上限
+		if longest < tc.ceiling/2 {
+			t.Errorf("Backoff(%d) longest of 1000 samples = %v, want close to %v", tc.attempt, longest, tc.ceiling)
+		}
+	}
+
+	if delay := (RetryPolicy{}).Backoff(3); delay != 0 {
+		t.Errorf("Backoff without base delay = %v, want 0", delay)
+	}
+}
+
+func TestNext(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Budget: time.Second}
+	cases := []struct {
+		name    string
+		attempt int
+		elapsed time.Duration
+		want    bool
+	}{
+		{"first failure", 1, 0, true},
+		{"second failure", 2, 0, true},
+		{"attempts exhausted", 3, 0, false},
+		{"budget exhausted", 1, time.Second, false},
+		// 剩余预算恰好容纳最长的退避（100ms）
+		{"budget left for the longest backoff", 1, 900 * time.Millisecond, true},
+	}
+	for _, tc := range cases {
+		delay, ok := p.Next(tc.attempt, tc.elapsed)
+		if ok != tc.want {
+			t.Errorf("%s: Next(%d, %v) retry = %v, want %v", tc.name, tc.attempt, tc.elapsed, ok, tc.want)
+		}
+		if !ok && delay != 0 {
+			t.Errorf("%s: Next returned delay %v without a retry", tc.name, delay)
+		}
+		if ok && tc.elapsed+delay > p.Budget {
+			t.Errorf("%s: delay %v exceeds the budget", tc.name, delay)
+		}
+	}
+
+	if _, ok := (RetryPolicy{MaxAttempts: 2}).Next(1, time.Hour); !ok {
+		t.Error("Next with no budget stopped retrying")
+	}
+}
+
+func TestAttemptHeader(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3}
+	for attempt, want := range map[int]string{1: "attempt=1; max=3", 2: "attempt=2; max=3", 3: "attempt=3; max=3"} {
+		if got := p.AttemptHeader(attempt); got != want {
+			t.Errorf("AttemptHeader(%d) = %q, want %q", attempt, got, want)
+		}
+	}
+	// 未配置或无效的尝试次数按 1 次计算
+	if got := (RetryPolicy{MaxAttempts: 0}).AttemptHeader(1); got != "attempt=1; max=1" {
+		t.Errorf("AttemptHeader without retries = %q, want %q", got, "attempt=1; max=1")
+	}
+	if _, ok := (RetryPolicy{MaxAttempts: 0}).Next(1, 0); ok {
+		t.Error("Next retried with MaxAttempts 0")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+		http.StatusBadRequest:          false,
+		http.StatusForbidden:           false,
+		http.StatusNotImplemented:      false,
+	} {
+		if got := RetryableStatus(status); got != want {
+			t.Errorf("RetryableStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+
+	for exceptionType, want := range map[string]bool{
+		"ThrottlingException":            true,
+		"InternalServerException":        true,
+		"ServiceUnavailableException":    true,
+		"ValidationException":            false,
+		"ContentLengthExceededException": false,
+	} {
+		if got := RetryableException(exceptionType); got != want {
+			t.Errorf("RetryableException(%q) = %v, want %v", exceptionType, got, want)
+		}
+	}
+
+	ctx := context.Background()
+	if !RetryableError(ctx, io.ErrUnexpectedEOF) {
+		t.Error("RetryableError(io.ErrUnexpectedEOF) = false, want true")
+	}
+	if RetryableError(ctx, nil) {
+		t.Error("RetryableError(nil) = true, want false")
+	}
+	if RetryableError(ctx, errors.Join(errors.New("dial"), context.Canceled)) {
+		t.Error("RetryableError(context.Canceled) = true, want false")
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if RetryableError(canceled, io.ErrUnexpectedEOF) {
+		t.Error("RetryableError after the request was canceled = true, want false")
+	}
+}
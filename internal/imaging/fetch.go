@@ -0,0 +1,209 @@
+package imaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"amazonq-proxy/internal/utils"
+)
+
+// fetchTimeout 通过 URL 获取图片的超时时间
+const fetchTimeout = 30 * time.Second
+
+// maxFetchRedirects 获取图片时最多跟随的重定向次数
+const maxFetchRedirects = 5
+
+// errBlockedAddress 图片地址解析到了内部网络地址
+var errBlockedAddress = errors.New("image url resolves to a loopback, private, link-local or unspecified address")
+
+// blockedNetworks net.IP 方法未覆盖的内部网络地址段
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // 本网络
+	"100.64.0.0/10", // 运营商级 NAT
+	"192.0.0.0/24",  // IETF 协议分配
+	"198.18.0.0/15", // 基准测试
+)
+
+// mustParseCIDRs 解析地址段列表
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// blockedIP 判断是否为不允许获取图片的地址（回环、私有、链路本地、未指定和组播地址）
+// 参数 ip 为解析后的 IP 地址
+func blockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost 解析主机名并检查所有地址（经代理获取时由代理连接目标，只能预先解析检查）
+// 参数 ctx 为上下文
+// 参数 host 为主机名或 IP 地址
+// 返回地址不允许时的错误
+func checkHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if blockedIP(addr.IP) {
+			return errBlockedAddress
+		}
+	}
+	return nil
+}
+
+// fetchClient 共享的图片获取客户端
+var fetchClient atomic.Pointer[http.Client]
+
+func init() {
+	Init()
+}
+
+// Init 根据代理配置重建共享的图片获取客户端（进行中的下载继续使用原客户端）
+func Init() {
+	fetchClient.Store(newFetchClient())
+}
+
+// newFetchClient 创建获取图片的 HTTP 客户端，拒绝连接内部网络地址（防止 SSRF）
+// 直连时在 DNS 解析之后按实际连接的地址检查；经代理时在发出请求（包括每次重定向）前解析目标主机并检查
+// 配置的代理本身可以位于内部网络
+func newFetchClient() *http.Client {
+	transport := utils.CreateProxyTransport()
+
+	proxyAddr := ""
+	if proxy, err := url.Parse(utils.GetProxy()); err == nil && proxy.Host != "" {
+		port := proxy.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[proxy.Scheme]
+		}
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), port)
+	}
+
+	if proxyFunc := transport.Proxy; proxyFunc != nil {
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			proxyURL, err := proxyFunc(req)
+			if err != nil || proxyURL == nil {
+				return proxyURL, err
+			}
+			if err := checkHost(req.Context(), req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+			return proxyURL, nil
+		}
+	}
+
+	guarded := &net.Dialer{
+		Timeout:   fetchTimeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		},
+	}
+	direct := &net.Dialer{Timeout: fetchTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if proxyAddr != "" && addr == proxyAddr {
+			return direct.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unsupported redirect to %q", req.URL.Redacted())
+			}
+			// 重定向目标同样经过 DialContext 或 Proxy 中的地址检查；IP 字面量在这里提前拒绝
+			if ip := net.ParseIP(req.URL.Hostname()); ip != nil && blockedIP(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		},
+	}
+}
+
+// Fetch 通过 HTTP(S) URL 下载图片
+// 不允许访问回环、私有、链路本地和未指定地址（包括重定向目标）
+// 参数 ctx 为上下文
+// 参数 rawURL 为图片地址
+// 参数 maxBytes 为允许下载的最大字节数
+// 返回图片数据和可能的错误
+func Fetch(ctx context.Context, rawURL string, maxBytes int) ([]byte, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("unsupported image url %q", rawURL)
+	}
+	if ip := net.ParseIP(parsed.Hostname()); ip != nil && blockedIP(ip) {
+		return nil, errBlockedAddress
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accept", "image/*")
+
+	resp, err := fetchClient.Load().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("failed to fetch image: status %d", resp.StatusCode)
+	}
+	if maxBytes > 0 && resp.ContentLength > int64(maxBytes) {
+		return nil, fmt.Errorf("image exceeds %d bytes", maxBytes)
+	}
+
+	reader := io.Reader(resp.Body)
+	if maxBytes > 0 {
+		reader = io.LimitReader(resp.Body, int64(maxBytes)+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if maxBytes > 0 && len(data) > maxBytes {
+		return nil, fmt.Errorf("image exceeds %d bytes", maxBytes)
+	}
+	return data, nil
+}
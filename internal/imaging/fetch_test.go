@@ -0,0 +1,92 @@
+package imaging
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"amazonq-proxy/internal/config"
+)
+
+func TestBlockedIP(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"0.0.0.0":         true,
+		"100.64.0.1":      true,
+		"::1":             true,
+		"::":              true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"::ffff:10.0.0.1": true,
+		"8.8.8.8":         false,
+		"2001:4860::8888": false,
+	}
+	for addr, want := range cases {
+		if got := blockedIP(net.ParseIP(addr)); got != want {
+			t.Errorf("blockedIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestFetchRejectsInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	for _, rawURL := range []string{
+		server.URL,
+		"http://localhost:" + port + "/",
+		"http://[::ffff:127.0.0.1]:" + port + "/",
+		"http://169.254.169.254/latest/meta-data/",
+	} {
+		if _, err := Fetch(context.Background(), rawURL, 1024); !errors.Is(err, errBlockedAddress) {
+			t.Errorf("Fetch(%s) error = %v, want %v", rawURL, err, errBlockedAddress)
+		}
+	}
+}
+
+func TestFetchRejectsRedirectToInternalAddress(t *testing.T) {
+	client := newFetchClient()
+	for _, target := range []string{"http://127.0.0.1/", "http://[::1]/", "file:///etc/passwd"} {
+		req := httptest.NewRequest("GET", target, nil)
+		if err := client.CheckRedirect(req, []*http.Request{httptest.NewRequest("GET", "http://example.com/", nil)}); err == nil {
+			t.Errorf("redirect to %s was allowed", target)
+		}
+	}
+}
+
+func TestInitRebuildsFetchClientForProxyChanges(t *testing.T) {
+	previous := config.Current()
+	defer func() {
+		config.Set(previous)
+		Init()
+	}()
+
+	cfg := *previous
+	cfg.Upstream.Proxy = ""
+	config.Set(&cfg)
+	Init()
+	client := fetchClient.Load()
+	if client.Transport.(*http.Transport).Proxy != nil {
+		t.Fatal("fetch client uses a proxy without upstream.proxy")
+	}
+	if fetchClient.Load() != client {
+		t.Fatal("fetch client is not reused between fetches")
+	}
+
+	cfg.Upstream.Proxy = "http://proxy.internal:3128"
+	config.Set(&cfg)
+	Init()
+	if fetchClient.Load() == client || fetchClient.Load().Transport.(*http.Transport).Proxy == nil {
+		t.Error("Init did not rebuild the fetch client with the configured proxy")
+	}
+}
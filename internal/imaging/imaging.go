@@ -0,0 +1,228 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// maxDecodePixels 允许完整解码的最大像素数，防止解压炸弹
+const maxDecodePixels = 100_000_000
+
+// defaultJPEGQuality 重新编码 JPEG 时的初始质量
+const defaultJPEGQuality = 85
+
+// minJPEGQuality 为满足大小限制而降低质量时的下限
+const minJPEGQuality = 40
+
+// supportedFormats Amazon Q 接受的图片格式
+var supportedFormats = map[string]bool{
+	"png":  true,
+	"jpeg": true,
+	"gif":  true,
+	"webp": true,
+}
+
+// ErrNotImage 数据不是可识别的图片
+var ErrNotImage = errors.New("data is not a recognized image")
+
+// Limits 图片处理限制
+type Limits struct {
+	MaxBytes     int // 单张图片的最大字节数
+	MaxDimension int // 长边的最大像素数
+}
+
+// Result 图片处理结果
+type Result struct {
+	Data     []byte // 处理后的图片数据
+	Format   string // Amazon Q 图片格式：png, jpeg, gif, webp
+	Modified bool   // 是否经过缩放或转码
+}
+
+// SniffFormat 根据文件头识别图片格式
+// 参数 data 为图片原始字节
+// 返回格式名称（png, jpeg, gif, webp, bmp, tiff），无法识别时返回空字符串
+func SniffFormat(data []byte) string {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "tiff"
+	}
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return "png"
+	case "image/jpeg":
+		return "jpeg"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	case "image/bmp":
+		return "bmp"
+	}
+	return ""
+}
+
+// FormatFromMediaType 将 MIME 类型转换为格式名称
+// 参数 mediaType 为 MIME 类型（如 image/png）
+// 返回格式名称
+func FormatFromMediaType(mediaType string) string {
+	format := strings.ToLower(mediaType)
+	if idx := strings.LastIndex(format, "/"); idx >= 0 {
+		format = format[idx+1:]
+	}
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	return format
+}
+
+// Process 校验并规范化图片，使其满足 Amazon Q 的格式和尺寸要求
+// 不支持的格式会转码为 PNG，超出尺寸或大小限制的图片会等比缩小并重新编码
+// 参数 data 为图片原始字节
+// 参数 limits 为处理限制
+// 返回处理结果和可能的错误
+func Process(data []byte, limits Limits) (*Result, error) {
+	format := SniffFormat(data)
+	if format == "" {
+		return nil, ErrNotImage
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s image: %w", format, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("invalid %s image: empty dimensions", format)
+	}
+
+	withinDimension := limits.MaxDimension <= 0 || (cfg.Width <= limits.MaxDimension && cfg.Height <= limits.MaxDimension)
+	withinBytes := limits.MaxBytes <= 0 || len(data) <= limits.MaxBytes
+	if supportedFormats[format] && withinDimension && withinBytes {
+		return &Result{Data: data, Format: format}, nil
+	}
+
+	if cfg.Width*cfg.Height > maxDecodePixels {
+		return nil, fmt.Errorf("image too large to process: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image: %w", format, err)
+	}
+
+	img = fitWithin(img, limits.MaxDimension)
+
+	// JPEG 保持 JPEG，其余格式转为 PNG 以保留透明度
+	outFormat := "png"
+	if format == "jpeg" {
+		outFormat = "jpeg"
+	}
+
+	out, outFormat, err := encodeWithinLimit(img, outFormat, limits.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Data: out, Format: outFormat, Modified: true}, nil
+}
+
+// fitWithin 将图片等比缩小到长边不超过 maxDimension
+func fitWithin(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if maxDimension <= 0 || (w <= maxDimension && h <= maxDimension) {
+		return img
+	}
+
+	scale := float64(maxDimension) / float64(w)
+	if h > w {
+		scale = float64(maxDimension) / float64(h)
+	}
+	return resize(img, scale)
+}
+
+// resize 按比例缩放图片
+func resize(img image.Image, scale float64) image.Image {
+	bounds := img.Bounds()
+	w := int(float64(bounds.Dx())*scale + 0.5)
+	h := int(float64(bounds.Dy())*scale + 0.5)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Src, nil)
+	return dst
+}
+
+// encodeWithinLimit 编码图片并在超出大小限制时逐步降低质量和尺寸
+// 返回编码后的数据、实际使用的格式和可能的错误
+func encodeWithinLimit(img image.Image, format string, maxBytes int) ([]byte, string, error) {
+	for attempt := 0; attempt < 8; attempt++ {
+		if format == "png" {
+			data, err := encode(img, "png", 0)
+			if err != nil {
+				return nil, "", err
+			}
+			if maxBytes <= 0 || len(data) <= maxBytes {
+				return data, "png", nil
+			}
+			// PNG 过大时改用 JPEG（透明区域填充白色）
+			img = flatten(img)
+			format = "jpeg"
+		}
+
+		for quality := defaultJPEGQuality; quality >= minJPEGQuality; quality -= 15 {
+			data, err := encode(img, "jpeg", quality)
+			if err != nil {
+				return nil, "", err
+			}
+			if maxBytes <= 0 || len(data) <= maxBytes {
+				return data, "jpeg", nil
+			}
+		}
+
+		img = resize(img, 0.75)
+	}
+	return nil, "", fmt.Errorf("unable to compress image below %d bytes", maxBytes)
+}
+
+// encode 按指定格式编码图片
+func encode(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s image: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten 将带透明通道的图片合成到白色背景上
+func flatten(img image.Image) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,92 @@
+package license
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"amazonq-proxy/internal/config"
+)
+
+// Policy 代码引用许可证策略
+type Policy struct {
+	exact    map[string]bool // 完整匹配的许可证名称（小写）
+	prefixes []string        // 前缀匹配的许可证名称（小写，来自 * 后缀通配）
+}
+
+// BlockedError 响应引用了被禁止的许可证
+type BlockedError struct {
+	License    string // 许可证名称
+	Repository string // 代码仓库
+}
+
+// Error 返回错误说明
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("response references code licensed under %s (%s), which is blocked by the license policy", e.License, e.Repository)
+}
+
+// current 当前生效的策略
+var current atomic.Pointer[Policy]
+
+func init() {
+	Init()
+}
+
+// Init 根据配置重建许可证策略
+func Init() {
+	current.Store(NewPolicy(config.Current().Citations.LicenseBlocklist))
+}
+
+// NewPolicy 创建许可证策略
+// 参数 blocklist 为禁止的许可证名称列表（不区分大小写，支持 * 后缀通配）
+// 返回策略
+func NewPolicy(blocklist []string) *Policy {
+	p := &Policy{exact: make(map[string]bool)}
+	for _, name := range blocklist {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			p.prefixes = append(p.prefixes, prefix)
+		} else if name != "" {
+			p.exact[name] = true
+		}
+	}
+	return p
+}
+
+// Current 返回当前生效的策略
+func Current() *Policy {
+	return current.Load()
+}
+
+// Set 替换当前生效的策略
+// 参数 policy 为新的策略
+func Set(policy *Policy) {
+	current.Store(policy)
+}
+
+// Empty 判断策略是否未禁止任何许可证
+func (p *Policy) Empty() bool {
+	return len(p.exact) == 0 && len(p.prefixes) == 0
+}
+
+// Check 检查代码引用的许可证是否被允许
+// 参数 licenseName 为许可证名称
+// 参数 repository 为代码仓库
+// 返回被禁止时的 *BlockedError，允许时为 nil
+func (p *Policy) Check(licenseName string, repository string) error {
+	name := strings.ToLower(strings.TrimSpace(licenseName))
+	if name == "" {
+		return nil
+	}
+	blocked := p.exact[name]
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			blocked = true
+			break
+		}
+	}
+	if blocked {
+		return &BlockedError{License: licenseName, Repository: repository}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"amazonq-proxy/internal/config"
+)
+
+// requestIDKey 上下文中请求 ID 的键
+type requestIDKey struct{}
+
+// Init 按 logging.level 和 logging.format 重建默认日志记录器
+// 所有输出都经过脱敏，并自动附带上下文中的请求 ID
+func Init() {
+	current := config.Current()
+	setConfigSecrets(current)
+	cfg := current.Logging
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, options)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// WithRequestID 返回携带请求 ID 的上下文，使用该上下文记录的日志都会附带 request_id
+// 参数 ctx 为父上下文
+// 参数 id 为请求 ID（为空时原样返回）
+// 返回新的上下文
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID 返回上下文中的请求 ID，不存在时返回空字符串
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler 从上下文中取出请求 ID 附加到每条日志
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle 附加请求 ID 后交给下层处理器
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs 返回附加属性后的处理器
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup 返回带分组的处理器
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/region"
+)
+
+// redacted 替换敏感内容的占位符
+const redacted = "[REDACTED]"
+
+// sensitiveKeys 值总是被隐藏的日志属性名（不区分大小写，- 与 _ 等价）
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"x_api_key":     true,
+	"api_key":       true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"client_secret": true,
+	"secret":        true,
+	"password":      true,
+}
+
+// secretPatterns 日志文本中按格式识别的凭据
+var secretPatterns = []*regexp.Regexp{
+	// Authorization 请求头
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=:-]+`),
+	// Amazon Q access token（aoa）和 refresh token（aor）
+	regexp.MustCompile(`\bao[ar][A-Za-z0-9._~+/=:-]{20,}`),
+	// JWT 格式的 client secret
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}(\.[A-Za-z0-9_-]+){0,2}`),
+}
+
+var (
+	// secrets 已知的凭据（refresh token、client secret、access token）-> 登记次数，出现在日志任意位置都会被替换
+	secrets = make(map[string]int)
+	// configSecrets 配置中的密钥（管理令牌、代理 API key、凭据池），每次 Init 时整体替换
+	configSecrets []string
+	secretsMutex  sync.RWMutex
+)
+
+// minSecretLength 注册为已知凭据的最短长度，避免替换掉普通的短字符串
+const minSecretLength = 8
+
+// RegisterSecret 登记已知的凭据，此后日志中出现的这些值都会被替换为 [REDACTED]
+// 同一个值可以多次登记，对应次数的 ForgetSecret 之后才会移除
+// 参数 values 为凭据值，过短的值会被忽略
+func RegisterSecret(values ...string) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	for _, value := range values {
+		if len(value) >= minSecretLength {
+			secrets[value]++
+		}
+	}
+}
+
+// ForgetSecret 移除不再使用的凭据（如被替换的 access token）
+// 参数 values 为凭据值
+func ForgetSecret(values ...string) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	for _, value := range values {
+		if secrets[value] <= 1 {
+			delete(secrets, value)
+		} else {
+			secrets[value]--
+		}
+	}
+}
+
+// setConfigSecrets 登记配置中的密钥，替换上一次登记的值
+// 参数 cfg 为当前配置
+func setConfigSecrets(cfg *config.Config) {
+	var values []string
+	add := func(value string) {
+		if len(value) >= minSecretLength {
+			values = append(values, value)
+		}
+	}
+	add(cfg.Server.AdminToken)
+	for _, key := range cfg.Credentials.APIKeys {
+		add(key.Key)
+	}
+	for _, pool := range cfg.Credentials.Pools {
+		for _, credential := range pool {
+			// [region:]clientId:clientSecret:refreshToken，区域和 clientId 不是密钥，不登记
+			if prefix, rest, ok := strings.Cut(credential, ":"); ok && region.Valid(prefix) {
+				credential = rest
+			}
+			if parts := strings.SplitN(credential, ":", 3); len(parts) == 3 {
+				add(parts[1])
+				add(parts[2])
+			}
+		}
+	}
+	// OTLP collector 的认证请求头
+	for _, value := range cfg.Tracing.Headers {
+		add(value)
+	}
+
+	secretsMutex.Lock()
+	configSecrets = values
+	secretsMutex.Unlock()
+}
+
+// Redact 替换文本中已知的凭据和按格式识别的 token
+// 参数 text 为待脱敏的文本
+// 返回脱敏后的文本
+func Redact(text string) string {
+	secretsMutex.RLock()
+	for secret := range secrets {
+		if strings.Contains(text, secret) {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	for _, secret := range configSecrets {
+		if strings.Contains(text, secret) {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	secretsMutex.RUnlock()
+
+	for _, pattern := range secretPatterns {
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if strings.HasPrefix(strings.ToLower(match), "bearer") {
+				return match[:len("bearer")] + " " + redacted
+			}
+			return redacted
+		})
+	}
+	return text
+}
+
+// redactAttr 日志处理器的 ReplaceAttr：隐藏敏感属性，并对消息、字符串和错误做脱敏
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ReplaceAll(strings.ToLower(attr.Key), "-", "_")
+	if sensitiveKeys[key] {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, Redact(value.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, Redact(value.String()))
+		case []byte:
+			return slog.String(attr.Key, Redact(string(value)))
+		}
+	}
+	return attr
+}
@@ -0,0 +1,31 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+
+	"amazonq-proxy/internal/config"
+)
+
+func TestConfigSecretsSkipRegionAndClientID(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Credentials.Pools = map[string][]string{
+		"default": {
+			"us-east-1:client-id-0001:client-secret-0001:refresh-token-0001",
+			"client-id-0002:client-secret-0002:refresh-token-0002",
+		},
+	}
+	setConfigSecrets(cfg)
+	defer setConfigSecrets(&config.Config{})
+
+	got := Redact("region=us-east-1 url=https://q.us-east-1.amazonaws.com client=client-id-0001 client=client-id-0002")
+	if got != "region=us-east-1 url=https://q.us-east-1.amazonaws.com client=client-id-0001 client=client-id-0002" {
+		t.Errorf("Redact mangled non-secret values: %q", got)
+	}
+
+	for _, secret := range []string{"client-secret-0001", "refresh-token-0001", "client-secret-0002", "refresh-token-0002"} {
+		if got := Redact("value=" + secret); strings.Contains(got, secret) {
+			t.Errorf("Redact(%q) = %q, secret not redacted", secret, got)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxSeries 每个指标最多的标签组合数量，超过后新的组合记入所有标签均为 other 的序列，避免客户端传入的模型名称撑爆内存
+const maxSeries = 1000
+
+// overflowValue 超过 maxSeries 后使用的标签值
+const overflowValue = "other"
+
+// collector 可以输出为 Prometheus 文本格式的指标
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	// registry 按注册顺序排列的所有指标
+	registry      []collector
+	registryMutex sync.Mutex
+)
+
+// register 注册指标
+func register(c collector) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry = append(registry, c)
+}
+
+// Write 以 Prometheus 文本格式输出所有指标
+// 参数 w 为输出目标
+// 返回可能的写入错误
+func Write(w io.Writer) error {
+	registryMutex.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMutex.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	return buf.Flush()
+}
+
+// Handler 返回输出所有指标的 HTTP 处理器
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// desc 指标的名称、说明、类型和标签名
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// writeHeader 输出 HELP 和 TYPE 行
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+// writeSample 输出一个样本行
+// 参数 suffix 为指标名后缀（如 _bucket、_sum）
+// 参数 values 为标签值，与 d.labels 一一对应
+// 参数 extra 为额外的标签（如 le），格式为 name="value"
+// 参数 value 为样本值
+func (d *desc) writeSample(w *bufio.Writer, suffix string, values []string, extra string, value float64) {
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	if len(values) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, label := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=%q", label, escapeLabel(values[i]))
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// escapeLabel 去掉标签值中的控制字符（%q 负责转义引号和反斜杠）
+func escapeLabel(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, value)
+}
+
+// formatFloat 按 Prometheus 文本格式输出数值
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// seriesKey 返回标签值组合的键
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// series 一组标签值对应的数值
+type series struct {
+	values []string
+	value  float64
+}
+
+// vec 按标签值分组的计数器或仪表
+type vec struct {
+	desc
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+// get 返回标签值对应的序列，不存在时创建（调用方需持有锁）
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := seriesKey(values)
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+	if len(v.series) >= maxSeries {
+		values = overflowValues(len(values))
+		key = seriesKey(values)
+		if s, ok := v.series[key]; ok {
+			return s
+		}
+	}
+	s := &series{values: append([]string(nil), values...)}
+	v.series[key] = s
+	return s
+}
+
+// write 输出所有序列（按标签值排序）
+func (v *vec) write(w *bufio.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.writeHeader(w)
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		v.writeSample(w, "", s.values, "", s.value)
+	}
+}
+
+// Counter 只增不减的计数器
+type Counter struct {
+	vec
+}
+
+// NewCounter 创建并注册计数器
+// 参数 name 为指标名称
+// 参数 help 为指标说明
+// 参数 labels 为标签名
+// 返回计数器
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec{desc: desc{name, help, "counter", labels}, series: make(map[string]*series)}}
+	register(c)
+	return c
+}
+
+// Inc 将标签值对应的计数加一
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add 将标签值对应的计数增加 delta（负数被忽略）
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mutex.Lock()
+	c.get(values).value += delta
+	c.mutex.Unlock()
+}
+
+// Gauge 可增可减的仪表
+type Gauge struct {
+	vec
+}
+
+// NewGauge 创建并注册仪表
+// 参数 name 为指标名称
+// 参数 help 为指标说明
+// 参数 labels 为标签名
+// 返回仪表
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec{desc: desc{name, help, "gauge", labels}, series: make(map[string]*series)}}
+	register(g)
+	return g
+}
+
+// Set 设置标签值对应的数值
+func (g *Gauge) Set(value float64, values ...string) {
+	g.mutex.Lock()
+	g.get(values).value = value
+	g.mutex.Unlock()
+}
+
+// Add 将标签值对应的数值增加 delta
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.mutex.Lock()
+	g.get(values).value += delta
+	g.mutex.Unlock()
+}
+
+// Inc 将标签值对应的数值加一
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+// Dec 将标签值对应的数值减一
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+// histogramSeries 一组标签值对应的分布
+type histogramSeries struct {
+	values []string
+	counts []uint64 // 每个桶的计数（非累积）
+	sum    float64
+	count  uint64
+}
+
+// Histogram 按桶统计数值分布
+type Histogram struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogram 创建并注册直方图
+// 参数 name 为指标名称
+// 参数 help 为指标说明
+// 参数 buckets 为递增的桶上限（+Inf 桶自动添加）
+// 参数 labels 为标签名
+// 返回直方图
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, "histogram", labels},
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*histogramSeries),
+	}
+	sort.Float64s(h.buckets)
+	register(h)
+	return h
+}
+
+// Observe 记录一个观测值
+// 参数 value 为观测值
+// 参数 values 为标签值
+func (h *Histogram) Observe(value float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.name, len(h.labels), len(values)))
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := seriesKey(values)
+	s, ok := h.series[key]
+	if !ok {
+		if len(h.series) >= maxSeries {
+			values = overflowValues(len(values))
+			key = seriesKey(values)
+			s, ok = h.series[key]
+		}
+		if !ok {
+			s = &histogramSeries{values: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+			h.series[key] = s
+		}
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+// write 输出所有序列的桶、总和和计数
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			h.writeSample(w, "_bucket", s.values, fmt.Sprintf("le=%q", formatFloat(upper)), float64(cumulative))
+		}
+		h.writeSample(w, "_bucket", s.values, `le="+Inf"`, float64(s.count))
+		h.writeSample(w, "_sum", s.values, "", s.sum)
+		h.writeSample(w, "_count", s.values, "", float64(s.count))
+	}
+}
+
+// Sample 采集函数返回的一个样本
+type Sample struct {
+	Values []string // 标签值，与指标的标签名一一对应
+	Value  float64  // 样本值
+}
+
+// funcCollector 输出时调用函数采集样本的指标，用于已在其他模块中统计的数据（熔断器、配置重载等）
+type funcCollector struct {
+	desc
+	collect func() []Sample
+}
+
+// NewGaugeFunc 注册在输出时采集的仪表
+// 参数 name 为指标名称
+// 参数 help 为指标说明
+// 参数 labels 为标签名
+// 参数 collect 为采集函数
+func NewGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	register(&funcCollector{desc{name, help, "gauge", labels}, collect})
+}
+
+// NewCounterFunc 注册在输出时采集的计数器，采集函数需返回累计值
+// 参数 name 为指标名称
+// 参数 help 为指标说明
+// 参数 labels 为标签名
+// 参数 collect 为采集函数
+func NewCounterFunc(name, help string, labels []string, collect func() []Sample) {
+	register(&funcCollector{desc{name, help, "counter", labels}, collect})
+}
+
+// write 调用采集函数并输出样本
+func (f *funcCollector) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	for _, sample := range f.collect() {
+		f.writeSample(w, "", sample.Values, "", sample.Value)
+	}
+}
+
+// overflowValues 返回超过 maxSeries 后使用的标签值
+func overflowValues(n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = overflowValue
+	}
+	return values
+}
+
+// sortedKeys 返回按字典序排列的键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,64 @@
+package metrics
+
+// latencyBuckets 时延直方图的桶（秒）
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+// tokenBuckets 输出 token 数直方图的桶
+var tokenBuckets = []float64{16, 64, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+
+// 代理的请求、上游和凭据指标
+var (
+	// Requests 消息请求数（按模型、HTTP 状态码、是否流式）
+	Requests = NewCounter("amazonq_proxy_requests_total",
+		"Messages requests by model, HTTP status and stream mode.",
+		"model", "status", "stream")
+
+	// RequestDuration 消息请求的总耗时（包括读取完整的流式响应）
+	RequestDuration = NewHistogram("amazonq_proxy_request_duration_seconds",
+		"Total messages request latency in seconds, including the full streamed response.",
+		latencyBuckets, "model", "stream")
+
+	// TimeToFirstToken 从收到请求到上游返回第一个内容增量的时间
+	TimeToFirstToken = NewHistogram("amazonq_proxy_time_to_first_token_seconds",
+		"Time from receiving the request to the first content delta from upstream in seconds.",
+		latencyBuckets, "model", "stream")
+
+	// OutputTokens 每个响应的输出 token 数
+	OutputTokens = NewHistogram("amazonq_proxy_output_tokens",
+		"Output tokens per upstream response.",
+		tokenBuckets, "model")
+
+	// ActiveStreams 正在输出的 SSE 流数量
+	ActiveStreams = NewGauge("amazonq_proxy_active_streams",
+		"Server-sent event streams currently being written.")
+
+	// UpstreamErrors 上游错误数（按异常类型，连接失败为 network，无异常类型的 HTTP 错误为 http_<状态码>）
+	UpstreamErrors = NewCounter("amazonq_proxy_upstream_errors_total",
+		"Upstream errors by exception type (network for connection failures, http_<status> when no type is given).",
+		"type")
+
+	// TokenRefreshes token 刷新次数（来源为 request、refresher 或 readiness，结果为 success 或 failure）
+	TokenRefreshes = NewCounter("amazonq_proxy_token_refreshes_total",
+		"Access token refreshes by source (request, refresher or readiness) and result (success or failure).",
+		"source", "result")
+
+	// CredentialRequests 每个 Amazon Q 凭据发送的上游请求数（凭据为 SHA256 哈希前缀）
+	CredentialRequests = NewCounter("amazonq_proxy_credential_requests_total",
+		"Upstream requests per Amazon Q credential (SHA256 hash prefix).",
+		"credential")
+
+	// CredentialTokens 每个 Amazon Q 凭据消耗的 token 数（类型为 input 或 output）
+	CredentialTokens = NewCounter("amazonq_proxy_credential_tokens_total",
+		"Tokens per Amazon Q credential (SHA256 hash prefix) by type (input or output).",
+		"credential", "type")
+)
+
+// CredentialLabel 返回凭据哈希在指标和熔断器状态中使用的前缀，健康检查据此将两者对应起来
+// 参数 hash 为凭据的 SHA256 哈希
+// 返回哈希前缀
+func CredentialLabel(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
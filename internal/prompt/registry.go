@@ -0,0 +1,176 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"amazonq-proxy/internal/config"
+)
+
+// templateFileExt 模板文件扩展名
+const templateFileExt = ".tmpl"
+
+// Registry 模板注册表，保存所有可用模板及其选择规则
+type Registry struct {
+	templates   map[string]*Template
+	defaultName string
+	byModel     map[string]string // 模型名称 -> 模板名称
+	location    *time.Location    // 注入时间使用的时区
+}
+
+// current 当前生效的注册表
+var current atomic.Pointer[Registry]
+
+func init() {
+	registry, err := NewRegistry(Options{})
+	if err != nil {
+		panic(err)
+	}
+	current.Store(registry)
+}
+
+// Options 注册表构建选项
+type Options struct {
+	Dir         string            // 模板文件目录（*.tmpl，文件名即模板名，可覆盖内置模板）
+	DefaultName string            // 默认模板名称
+	ByModel     map[string]string // 按模型选择模板
+	ByKey       map[string]string // API key 指定的模板（credentials.api_keys[] 字段路径 -> 模板名称），只用于校验模板存在
+	Timezone    string            // 注入时间使用的时区（IANA 名称，为空时使用本地时区）
+}
+
+// NewRegistry 构建模板注册表并校验所有选择规则引用的模板均存在
+// 参数 opts 为构建选项
+// 返回注册表和可能的错误
+func NewRegistry(opts Options) (*Registry, error) {
+	r := &Registry{
+		templates:   make(map[string]*Template),
+		defaultName: opts.DefaultName,
+		byModel:     opts.ByModel,
+		location:    time.Local,
+	}
+	if r.defaultName == "" {
+		r.defaultName = DefaultTemplateName
+	}
+
+	for name, text := range builtinTemplates {
+		tmpl, err := Parse(name, text)
+		if err != nil {
+			return nil, err
+		}
+		r.templates[name] = tmpl
+	}
+
+	if opts.Dir != "" {
+		paths, err := filepath.Glob(filepath.Join(opts.Dir, "*"+templateFileExt))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read prompt template %s: %w", path, err)
+			}
+			name := strings.TrimSuffix(filepath.Base(path), templateFileExt)
+			tmpl, err := Parse(name, string(content))
+			if err != nil {
+				return nil, err
+			}
+			r.templates[name] = tmpl
+		}
+	}
+
+	if opts.Timezone != "" {
+		loc, err := time.LoadLocation(opts.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt timezone %q: %w", opts.Timezone, err)
+		}
+		r.location = loc
+	}
+
+	if _, ok := r.templates[r.defaultName]; !ok {
+		return nil, fmt.Errorf("default prompt template %q not found", r.defaultName)
+	}
+	for _, rules := range []map[string]string{r.byModel, opts.ByKey} {
+		for selector, name := range rules {
+			if _, ok := r.templates[name]; !ok {
+				return nil, fmt.Errorf("prompt template %q selected for %q not found", name, selector)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// Init 根据配置加载模板并替换当前注册表
+// 返回可能的加载错误（出错时保留原注册表）
+func Init() error {
+	registry, err := Build(config.Current())
+	if err != nil {
+		return err
+	}
+	current.Store(registry)
+	return nil
+}
+
+// Build 根据提示词配置和 API key 指定的模板构建注册表，不替换当前注册表
+// 参数 cfg 为配置
+// 返回注册表和可能的加载错误
+func Build(cfg *config.Config) (*Registry, error) {
+	byKey := make(map[string]string)
+	for i, key := range cfg.Credentials.APIKeys {
+		if key.Template != "" {
+			byKey[fmt.Sprintf("credentials.api_keys[%d].template", i)] = key.Template
+		}
+	}
+	return NewRegistry(Options{
+		Dir:         cfg.Prompt.Dir,
+		DefaultName: cfg.Prompt.Template,
+		ByModel:     cfg.Prompt.Models,
+		ByKey:       byKey,
+		Timezone:    cfg.Prompt.Timezone,
+	})
+}
+
+// Current 返回当前生效的注册表
+func Current() *Registry {
+	return current.Load()
+}
+
+// Set 替换当前生效的注册表
+// 参数 registry 为新的注册表
+func Set(registry *Registry) {
+	current.Store(registry)
+}
+
+// SelectName 按 API key、模型的优先级选择模板名称
+// 参数 model 为模型名称
+// 参数 keyTemplate 为 API key 指定的模板名称（credentials.api_keys[].template，可为空）
+// 返回模板名称
+func (r *Registry) SelectName(model string, keyTemplate string) string {
+	if _, ok := r.templates[keyTemplate]; ok && keyTemplate != "" {
+		return keyTemplate
+	}
+	if name, ok := r.byModel[model]; ok {
+		return name
+	}
+	return r.defaultName
+}
+
+// Get 按名称获取模板，名称为空或不存在时返回默认模板
+// 参数 name 为模板名称
+// 返回模板
+func (r *Registry) Get(name string) *Template {
+	if tmpl, ok := r.templates[name]; ok {
+		return tmpl
+	}
+	return r.templates[r.defaultName]
+}
+
+// Now 返回配置时区下的当前时间
+func (r *Registry) Now() time.Time {
+	return time.Now().In(r.location)
+}
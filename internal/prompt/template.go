@@ -0,0 +1,172 @@
+package prompt
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// DefaultTemplateName 默认模板名称
+const DefaultTemplateName = "default"
+
+// ToolDoc 描述过长、需要在消息中附带完整文档的工具
+type ToolDoc struct {
+	Name        string // 工具名称
+	Description string // 完整描述
+}
+
+// Data 模板渲染数据
+type Data struct {
+	SystemPrompt       string    // 系统提示（为空时不输出）
+	ToolDocs           []ToolDoc // 工具完整文档
+	UserMessage        string    // 用户消息文本
+	IncludeUserMessage bool      // 是否输出用户消息段（纯 tool_result 轮次为 false）
+	Now                time.Time // 当前时间（已转换到配置的时区）
+	Model              string    // 请求的模型名称
+}
+
+// Template 已解析的提示词框架模板
+type Template struct {
+	Name   string
+	source string
+	tmpl   *template.Template
+}
+
+// Source 返回模板的原始文本
+func (t *Template) Source() string {
+	return t.source
+}
+
+// Execute 渲染模板
+// 参数 data 为模板数据
+// 返回渲染后的文本和可能的错误
+func (t *Template) Execute(data Data) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template %q: %w", t.Name, err)
+	}
+	return buf.String(), nil
+}
+
+// FormatTimestamp 格式化为 Amazon Q 使用的时间戳
+// 参数 t 为时间
+// 返回格式为 "Weekday, ISO8601" 的时间字符串
+func FormatTimestamp(t time.Time) string {
+	return fmt.Sprintf("%s, %s", t.Format("Monday"), t.Format("2006-01-02T15:04:05.000Z07:00"))
+}
+
+// funcs 模板可用的函数
+var funcs = template.FuncMap{
+	// timestamp 输出 Amazon Q 格式的时间戳
+	"timestamp": FormatTimestamp,
+	// formatTime 按 Go 时间格式输出时间
+	"formatTime": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	// inZone 将时间转换到指定时区（如 Asia/Shanghai），时区无效时返回原时间
+	"inZone": func(name string, t time.Time) time.Time {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return t
+		}
+		return t.In(loc)
+	},
+}
+
+// Parse 解析模板文本
+// 参数 name 为模板名称
+// 参数 text 为 text/template 格式的模板内容
+// 返回解析后的模板和可能的错误
+func Parse(name string, text string) (*Template, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt template %q: %w", name, err)
+	}
+	return &Template{Name: name, source: text, tmpl: tmpl}, nil
+}
+
+// builtinTemplates 内置模板
+var builtinTemplates = map[string]string{
+	// default 与 Amazon Q CLI 一致的分段框架，包含当前时间
+	DefaultTemplateName: `
+{{- if .SystemPrompt}}--- SYSTEM PROMPT BEGIN ---
+{{.SystemPrompt}}
+--- SYSTEM PROMPT END ---
+
+{{end}}
+{{- if .ToolDocs}}--- TOOL DOCUMENTATION BEGIN ---
+{{range .ToolDocs}}Tool: {{.Name}}
+Full Description:
+{{.Description}}
+{{end}}--- TOOL DOCUMENTATION END ---
+
+{{end}}
+{{- if .IncludeUserMessage}}--- CONTEXT ENTRY BEGIN ---
+Current time: {{timestamp .Now}}
+--- CONTEXT ENTRY END ---
+
+--- USER MESSAGE BEGIN ---
+{{.UserMessage}}
+--- USER MESSAGE END ---{{end}}`,
+
+	// no-timestamp 与 default 相同的分段框架，但不注入当前时间
+	"no-timestamp": `
+{{- if .SystemPrompt}}--- SYSTEM PROMPT BEGIN ---
+{{.SystemPrompt}}
+--- SYSTEM PROMPT END ---
+
+{{end}}
+{{- if .ToolDocs}}--- TOOL DOCUMENTATION BEGIN ---
+{{range .ToolDocs}}Tool: {{.Name}}
+Full Description:
+{{.Description}}
+{{end}}--- TOOL DOCUMENTATION END ---
+
+{{end}}
+{{- if .IncludeUserMessage}}--- USER MESSAGE BEGIN ---
+{{.UserMessage}}
+--- USER MESSAGE END ---{{end}}`,
+
+	// xml 使用 XML 标签分隔各段，适合对 Claude 风格提示敏感的场景
+	"xml": `
+{{- if .SystemPrompt}}<system_prompt>
+{{.SystemPrompt}}
+</system_prompt>
+
+{{end}}
+{{- if .ToolDocs}}<tool_documentation>
+{{range .ToolDocs}}<tool name="{{.Name}}">
+{{.Description}}
+</tool>
+{{end}}</tool_documentation>
+
+{{end}}
+{{- if .IncludeUserMessage}}<context>
+Current time: {{timestamp .Now}}
+</context>
+
+<user_message>
+{{.UserMessage}}
+</user_message>{{end}}`,
+
+	// plain 不添加任何分隔标记和时间，各段之间以空行分隔
+	"plain": `
+{{- if .SystemPrompt}}{{.SystemPrompt}}
+
+{{end}}
+{{- range .ToolDocs}}Tool: {{.Name}}
+{{.Description}}
+
+{{end}}
+{{- if .IncludeUserMessage}}{{.UserMessage}}{{end}}`,
+}
+
+// BuiltinNames 返回所有内置模板名称
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtinTemplates))
+	for name := range builtinTemplates {
+		names = append(names, name)
+	}
+	return names
+}
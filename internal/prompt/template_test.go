@@ -0,0 +1,167 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"amazonq-proxy/internal/config"
+)
+
+// testNow 渲染测试使用的固定时间
+var testNow = time.Date(2025, 1, 2, 3, 4, 5, 6000000, time.UTC)
+
+// render 使用内置模板渲染
+func render(t *testing.T, name string, data Data) string {
+	t.Helper()
+	registry, err := NewRegistry(Options{})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	tmpl := registry.Get(name)
+	if tmpl.Name != name {
+		t.Fatalf("Get(%q) returned template %q", name, tmpl.Name)
+	}
+	out, err := tmpl.Execute(data)
+	if err != nil {
+		t.Fatalf("Execute(%q): %v", name, err)
+	}
+	return out
+}
+
+func TestBuiltinNames(t *testing.T) {
+	names := BuiltinNames()
+	sort.Strings(names)
+	want := []string{"default", "no-timestamp", "plain", "xml"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("BuiltinNames() = %v, want %v", names, want)
+	}
+}
+
+func TestBuiltinTemplates(t *testing.T) {
+	full := Data{
+		SystemPrompt:       "Be brief.",
+		ToolDocs:           []ToolDoc{{Name: "search", Description: "Search the web."}},
+		UserMessage:        "Hello",
+		IncludeUserMessage: true,
+		Now:                testNow,
+	}
+	userOnly := Data{UserMessage: "Hello", IncludeUserMessage: true, Now: testNow}
+	// 纯 tool_result 轮次不输出用户消息段和时间
+	toolResult := Data{SystemPrompt: "Be brief.", UserMessage: "ignored", IncludeUserMessage: false, Now: testNow}
+
+	cases := []struct {
+		template string
+		data     Data
+		want     string
+	}{
+		{"default", full, "--- SYSTEM PROMPT BEGIN ---\nBe brief.\n--- SYSTEM PROMPT END ---\n\n" +
+			"--- TOOL DOCUMENTATION BEGIN ---\nTool: search\nFull Description:\nSearch the web.\n--- TOOL DOCUMENTATION END ---\n\n" +
+			"--- CONTEXT ENTRY BEGIN ---\nCurrent time: Thursday, 2025-01-02T03:04:05.006Z\n--- CONTEXT ENTRY END ---\n\n" +
+			"--- USER MESSAGE BEGIN ---\nHello\n--- USER MESSAGE END ---"},
+		{"default", userOnly, "--- CONTEXT ENTRY BEGIN ---\nCurrent time: Thursday, 2025-01-02T03:04:05.006Z\n--- CONTEXT ENTRY END ---\n\n" +
+			"--- USER MESSAGE BEGIN ---\nHello\n--- USER MESSAGE END ---"},
+		{"default", toolResult, "--- SYSTEM PROMPT BEGIN ---\nBe brief.\n--- SYSTEM PROMPT END ---\n\n"},
+
+		{"no-timestamp", full, "--- SYSTEM PROMPT BEGIN ---\nBe brief.\n--- SYSTEM PROMPT END ---\n\n" +
+			"--- TOOL DOCUMENTATION BEGIN ---\nTool: search\nFull Description:\nSearch the web.\n--- TOOL DOCUMENTATION END ---\n\n" +
+			"--- USER MESSAGE BEGIN ---\nHello\n--- USER MESSAGE END ---"},
+		{"no-timestamp", userOnly, "--- USER MESSAGE BEGIN ---\nHello\n--- USER MESSAGE END ---"},
+		{"no-timestamp", toolResult, "--- SYSTEM PROMPT BEGIN ---\nBe brief.\n--- SYSTEM PROMPT END ---\n\n"},
+
+		{"xml", full, "<system_prompt>\nBe brief.\n</system_prompt>\n\n" +
+			"<tool_documentation>\n<tool name=\"search\">\nSearch the web.\n</tool>\n</tool_documentation>\n\n" +
+			"<context>\nCurrent time: Thursday, 2025-01-02T03:04:05.006Z\n</context>\n\n" +
+			"<user_message>\nHello\n</user_message>"},
+		{"xml", userOnly, "<context>\nCurrent time: Thursday, 2025-01-02T03:04:05.006Z\n</context>\n\n<user_message>\nHello\n</user_message>"},
+		{"xml", toolResult, "<system_prompt>\nBe brief.\n</system_prompt>\n\n"},
+
+		{"plain", full, "Be brief.\n\nTool: search\nSearch the web.\n\nHello"},
+		{"plain", userOnly, "Hello"},
+		{"plain", toolResult, "Be brief.\n\n"},
+	}
+	for _, c := range cases {
+		if got := render(t, c.template, c.data); got != c.want {
+			t.Errorf("%s with %+v:\ngot  %q\nwant %q", c.template, c.data, got, c.want)
+		}
+	}
+}
+
+func TestXMLTemplateToolNamesUnescaped(t *testing.T) {
+	// text/template 不做 HTML 转义，工具名称原样输出
+	data := Data{ToolDocs: []ToolDoc{{Name: "a<b>", Description: "x"}, {Name: "second", Description: "y"}}}
+	got := render(t, "xml", data)
+	want := "<tool_documentation>\n<tool name=\"a<b>\">\nx\n</tool>\n<tool name=\"second\">\ny\n</tool>\n</tool_documentation>\n\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRegistryRejectsUnknownTemplate(t *testing.T) {
+	cases := map[string]Options{
+		"default":  {DefaultName: "missing"},
+		"by model": {ByModel: map[string]string{"claude-sonnet-4": "missing"}},
+		"by key":   {ByKey: map[string]string{"credentials.api_keys[0].template": "missing"}},
+	}
+	for name, opts := range cases {
+		if _, err := NewRegistry(opts); err == nil || !strings.Contains(err.Error(), `"missing"`) {
+			t.Errorf("%s: NewRegistry error = %v, want template \"missing\" not found", name, err)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.Credentials.APIKeys = []config.APIKeyConfig{{Key: "sk-a", Template: "xml"}, {Key: "sk-b", Template: "missing"}}
+	if _, err := Build(cfg); err == nil || !strings.Contains(err.Error(), `"missing" selected for "credentials.api_keys[1].template"`) {
+		t.Errorf("Build error = %v, want the api key template to be rejected", err)
+	}
+}
+
+func TestRegistrySelectAndOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "plain.tmpl"), []byte("custom {{.UserMessage}}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := NewRegistry(Options{
+		Dir:         dir,
+		DefaultName: "xml",
+		ByModel:     map[string]string{"claude-haiku-4.5": "no-timestamp"},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+
+	for _, c := range []struct{ model, key, want string }{
+		{"claude-sonnet-4.5", "", "xml"},
+		{"claude-haiku-4.5", "", "no-timestamp"},
+		{"claude-haiku-4.5", "plain", "plain"},
+		{"claude-sonnet-4.5", "missing", "xml"},
+	} {
+		if got := registry.SelectName(c.model, c.key); got != c.want {
+			t.Errorf("SelectName(%q, %q) = %q, want %q", c.model, c.key, got, c.want)
+		}
+	}
+	if got := registry.Get("unknown").Name; got != "xml" {
+		t.Errorf("Get(unknown) = %q, want default template xml", got)
+	}
+
+	out, err := registry.Get("plain").Execute(Data{UserMessage: "hi"})
+	if err != nil || out != "custom hi" {
+		t.Errorf("overridden plain template = %q, %v, want \"custom hi\"", out, err)
+	}
+}
+
+func TestParseRejectsInvalidTemplate(t *testing.T) {
+	if _, err := Parse("broken", "{{if .SystemPrompt}}"); err == nil {
+		t.Error("Parse accepted an unterminated template")
+	}
+	tmpl, err := Parse("unknown-field", "{{.Missing}}")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if _, err := tmpl.Execute(Data{}); err == nil {
+		t.Error("Execute accepted a reference to an unknown field")
+	}
+}
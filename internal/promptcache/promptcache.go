@@ -0,0 +1,388 @@
+package promptcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/core"
+)
+
+const (
+	// TTL5m 默认缓存时长
+	TTL5m = 5 * time.Minute
+	// TTL1h 扩展缓存时长
+	TTL1h = time.Hour
+	// MaxBreakpoints 单个请求允许的最大 cache_control 断点数
+	MaxBreakpoints = 4
+	// charsPerToken 估算 token 时每个 token 对应的字符数
+	charsPerToken = 4
+	// imageTokens 单张图片估算的 token 数
+	imageTokens = 1600
+)
+
+// Usage 请求的输入 token 用量（符合 Claude API usage 字段语义）
+type Usage struct {
+	InputTokens                int // 未命中也未写入缓存的输入 token 数
+	CacheCreationInputTokens   int // 本次写入缓存的 token 数
+	CacheReadInputTokens       int // 本次命中缓存的 token 数
+	CacheCreation5mInputTokens int // 以 5 分钟 TTL 写入的 token 数
+	CacheCreation1hInputTokens int // 以 1 小时 TTL 写入的 token 数
+}
+
+// Breakpoint 请求中的缓存断点
+type Breakpoint struct {
+	Hash   string        // 从请求开头到断点处（含）的前缀哈希
+	Tokens int           // 从请求开头到断点处（含）的估算 token 数
+	TTL    time.Duration // 缓存时长
+}
+
+// Prefix 请求的前缀分析结果
+type Prefix struct {
+	Breakpoints []Breakpoint // 按出现顺序排列的断点
+	TotalTokens int          // 整个请求的估算输入 token 数
+}
+
+// entry 缓存条目
+type entry struct {
+	tokens  int
+	expires time.Time
+}
+
+// Cache 提示词前缀缓存，仅记录前缀哈希和过期时间，不保存内容
+type Cache struct {
+	mu         sync.Mutex
+	entries    map[string]entry
+	maxEntries int
+	minTokens  int
+	now        func() time.Time
+	hits       int64
+	misses     int64
+}
+
+// Stats 提示词缓存统计
+type Stats struct {
+	Entries int   `json:"entries"` // 当前缓存的前缀数
+	Hits    int64 `json:"hits"`    // 累计命中缓存的请求数
+	Misses  int64 `json:"misses"`  // 累计有可缓存断点但未命中的请求数
+}
+
+// defaultCache 全局提示词缓存
+var defaultCache atomic.Pointer[Cache]
+
+func init() {
+	Init()
+}
+
+// Init 根据配置重建全局提示词缓存（已缓存的前缀会被清空）
+func Init() {
+	cfg := config.Current().PromptCache
+	defaultCache.Store(New(cfg.MaxEntries, cfg.MinTokens))
+}
+
+// New 创建提示词前缀缓存
+// 参数 maxEntries 为最大条目数（<= 0 表示不限制）
+// 参数 minTokens 为可缓存前缀的最小 token 数
+// 返回缓存实例
+func New(maxEntries int, minTokens int) *Cache {
+	return &Cache{
+		entries:    make(map[string]entry),
+		maxEntries: maxEntries,
+		minTokens:  minTokens,
+		now:        time.Now,
+	}
+}
+
+// Compute 使用全局缓存计算请求的输入 token 用量
+// 未启用缓存模拟时仅返回估算的输入 token 数
+// 参数 req 为 Claude 请求
+// 参数 keyHash 为 API key 的 SHA256 哈希，用于隔离不同 key 的缓存
+// 返回 token 用量、写入缓存的函数（上游成功响应后调用）和可能的 cache_control 校验错误
+func Compute(req core.ClaudeRequest, keyHash string) (Usage, func(), error) {
+	prefix, err := Analyze(req, keyHash)
+	if err != nil {
+		return Usage{}, nil, err
+	}
+	if !config.Current().PromptCache.Enabled {
+		return Usage{InputTokens: prefix.TotalTokens}, func() {}, nil
+	}
+	usage, commit := defaultCache.Load().Apply(prefix)
+	return usage, commit, nil
+}
+
+// CurrentStats 返回全局提示词缓存的统计
+func CurrentStats() Stats {
+	return defaultCache.Load().Stats()
+}
+
+// Stats 返回缓存的条目数和累计命中统计
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+}
+
+// Apply 按断点查询缓存，计算 token 用量
+// 最靠后的命中断点计为缓存读取，其后的断点计为缓存创建；缓存只在调用返回的 commit 后更新，
+// 失败或被拒绝的请求不会写入前缀，重试时也就不会报告并不存在的缓存读取
+// 参数 prefix 为请求的前缀分析结果
+// 返回 token 用量和 commit 函数：刷新命中断点的过期时间、写入其后的断点并记录命中统计（多次调用只生效一次）
+func (c *Cache) Apply(prefix Prefix) (Usage, func()) {
+	var eligible []Breakpoint
+	for _, bp := range prefix.Breakpoints {
+		if bp.Tokens >= c.minTokens {
+			eligible = append(eligible, bp)
+		}
+	}
+	if len(eligible) == 0 {
+		return Usage{InputTokens: prefix.TotalTokens}, func() {}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	usage := Usage{}
+
+	// 从后向前查找最长的命中前缀
+	hit := -1
+	for i := len(eligible) - 1; i >= 0; i-- {
+		bp := eligible[i]
+		if e, ok := c.entries[bp.Hash]; ok && now.Before(e.expires) {
+			hit = i
+			usage.CacheReadInputTokens = bp.Tokens
+			break
+		}
+	}
+
+	// 命中位置之后的断点计为缓存创建，按各段所属断点的 TTL 统计创建量
+	cached := usage.CacheReadInputTokens
+	for _, bp := range eligible[hit+1:] {
+		segment := bp.Tokens - cached
+		if segment < 0 {
+			segment = 0
+		}
+		if bp.TTL == TTL1h {
+			usage.CacheCreation1hInputTokens += segment
+		} else {
+			usage.CacheCreation5mInputTokens += segment
+		}
+		cached = bp.Tokens
+	}
+	usage.CacheCreationInputTokens = usage.CacheCreation5mInputTokens + usage.CacheCreation1hInputTokens
+
+	usage.InputTokens = prefix.TotalTokens - cached
+	if usage.InputTokens < 0 {
+		usage.InputTokens = 0
+	}
+
+	var once sync.Once
+	return usage, func() {
+		once.Do(func() { c.commit(eligible, hit) })
+	}
+}
+
+// commit 刷新命中断点的过期时间，写入其后的断点并记录命中统计
+// 参数 eligible 为达到最小 token 数的断点
+// 参数 hit 为命中断点的下标（未命中时为 -1）
+func (c *Cache) commit(eligible []Breakpoint, hit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if hit >= 0 {
+		c.hits++
+	} else {
+		c.misses++
+	}
+
+	// 命中断点和其后的断点写入缓存；命中断点保留较晚的过期时间
+	start := hit
+	if start < 0 {
+		start = 0
+	}
+	for i, bp := range eligible[start:] {
+		expires := now.Add(bp.TTL)
+		if e, ok := c.entries[bp.Hash]; ok && i == 0 && hit >= 0 && e.expires.After(expires) {
+			expires = e.expires
+		}
+		c.put(bp.Hash, entry{tokens: bp.Tokens, expires: expires}, now)
+	}
+}
+
+// put 写入缓存条目，超出容量时先清理过期条目，再淘汰最早过期的条目
+// 调用方需持有锁
+func (c *Cache) put(key string, e entry, now time.Time) {
+	if _, exists := c.entries[key]; !exists && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		for k, v := range c.entries {
+			if !now.Before(v.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			var oldestKey string
+			var oldest time.Time
+			for k, v := range c.entries {
+				if oldestKey == "" || v.expires.Before(oldest) {
+					oldestKey, oldest = k, v.expires
+				}
+			}
+			delete(c.entries, oldestKey)
+		}
+	}
+	c.entries[key] = e
+}
+
+// Analyze 按 tools、system、messages 的顺序计算请求前缀哈希和断点
+// 哈希包含模型名称、API key 哈希和转换设置（提示词模板、历史消息的环境状态、图片处理限制），
+// 相同的 Claude 内容按不同设置转换后发送给 Amazon Q 的内容不同，不共享前缀；内容序列化时去除 cache_control 字段。
+// 哈希基于 Claude 内容而非转换后的 Amazon Q 历史：断点位于 Claude 内容块上，转换后系统提示和工具文档被合并进用户消息，
+// 无法逐块对应；模板注入的当前时间也不计入，否则每分钟都会失效。转换设置之外的差异
+// （仅出现在当前消息的环境变量、Git 和 Shell 状态，以及代理升级带来的转换逻辑变化）不会使前缀失效
+// 参数 req 为 Claude 请求
+// 参数 keyHash 为 API key 的 SHA256 哈希
+// 返回前缀分析结果和可能的 cache_control 校验错误
+func Analyze(req core.ClaudeRequest, keyHash string) (Prefix, error) {
+	w := &prefixWriter{hash: sha256.New()}
+	w.write("model", req.Model, 0)
+	w.write("key", keyHash, 0)
+	w.write("settings", core.ConversionSettings(req), 0)
+
+	for _, tool := range req.Tools {
+		cacheControl := tool.CacheControl
+		tool.CacheControl = nil
+		data, _ := json.Marshal(tool)
+		w.write("tool", string(data), estimateText(string(data)))
+		if err := w.breakpoint(cacheControl); err != nil {
+			return Prefix{}, err
+		}
+	}
+
+	if err := w.content("system", req.System); err != nil {
+		return Prefix{}, err
+	}
+
+	for _, msg := range req.Messages {
+		if err := w.content(msg.Role, msg.Content); err != nil {
+			return Prefix{}, err
+		}
+	}
+
+	return Prefix{Breakpoints: w.breakpoints, TotalTokens: w.tokens}, nil
+}
+
+// prefixWriter 增量计算前缀哈希和 token 数
+type prefixWriter struct {
+	hash        hash.Hash
+	tokens      int
+	breakpoints []Breakpoint
+}
+
+// write 写入一个带类型标签的片段
+func (w *prefixWriter) write(kind string, text string, tokens int) {
+	fmt.Fprintf(w.hash, "%s:%d:", kind, len(text))
+	w.hash.Write([]byte(text))
+	w.tokens += tokens
+}
+
+// content 写入 system 或消息内容（string 或内容块数组）
+func (w *prefixWriter) content(role string, content interface{}) error {
+	switch v := content.(type) {
+	case nil:
+		return nil
+	case string:
+		w.write(role, v, estimateText(v))
+		return nil
+	case []interface{}:
+		w.write(role, "", 0)
+		for _, item := range v {
+			block, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			stripped := make(map[string]interface{}, len(block))
+			for k, val := range block {
+				if k != "cache_control" {
+					stripped[k] = val
+				}
+			}
+			data, _ := json.Marshal(stripped)
+			w.write("block", string(data), estimateBlock(stripped, string(data)))
+			if err := w.breakpoint(parseCacheControl(block["cache_control"])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// parseCacheControl 解析内容块中的 cache_control 字段
+func parseCacheControl(value interface{}) *core.CacheControl {
+	cc, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cacheType, _ := cc["type"].(string)
+	ttl, _ := cc["ttl"].(string)
+	return &core.CacheControl{Type: cacheType, TTL: ttl}
+}
+
+// breakpoint 在当前位置记录断点（cacheControl 为 nil 时忽略）
+func (w *prefixWriter) breakpoint(cacheControl *core.CacheControl) error {
+	if cacheControl == nil {
+		return nil
+	}
+	if cacheControl.Type != "ephemeral" {
+		return fmt.Errorf("invalid cache_control type %q: expected ephemeral", cacheControl.Type)
+	}
+
+	ttl := TTL5m
+	switch cacheControl.TTL {
+	case "", "5m":
+	case "1h":
+		ttl = TTL1h
+	default:
+		return fmt.Errorf("invalid cache_control ttl %q: expected 5m or 1h", cacheControl.TTL)
+	}
+
+	if len(w.breakpoints) >= MaxBreakpoints {
+		return fmt.Errorf("a maximum of %d blocks with cache_control may be provided", MaxBreakpoints)
+	}
+	// 1 小时缓存必须出现在 5 分钟缓存之前
+	if ttl == TTL1h && len(w.breakpoints) > 0 && w.breakpoints[len(w.breakpoints)-1].TTL == TTL5m {
+		return fmt.Errorf("cache_control with ttl 1h must come before ttl 5m")
+	}
+
+	sum := w.hash.Sum(nil)
+	w.breakpoints = append(w.breakpoints, Breakpoint{
+		Hash:   hex.EncodeToString(sum),
+		Tokens: w.tokens,
+		TTL:    ttl,
+	})
+	return nil
+}
+
+// estimateText 按字符数估算文本的 token 数
+func estimateText(text string) int {
+	return (len([]rune(text)) + charsPerToken - 1) / charsPerToken
+}
+
+// estimateBlock 估算内容块的 token 数，图片按固定值计算，其余按文本或序列化长度估算
+func estimateBlock(block map[string]interface{}, serialized string) int {
+	switch block["type"] {
+	case "image":
+		return imageTokens
+	case "text":
+		if text, ok := block["text"].(string); ok {
+			return estimateText(text)
+		}
+	}
+	return estimateText(serialized)
+}
@@ -0,0 +1,272 @@
+package promptcache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/core"
+	"amazonq-proxy/internal/prompt"
+)
+
+// testClock 可手动推进的测试时钟
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// newTestCache 创建使用测试时钟的缓存
+func newTestCache(maxEntries int, minTokens int) (*Cache, *testClock) {
+	clock := &testClock{now: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+	c := New(maxEntries, minTokens)
+	c.now = clock.Now
+	return c, clock
+}
+
+// bp 构造断点
+func bp(hash string, tokens int, ttl time.Duration) Breakpoint {
+	return Breakpoint{Hash: hash, Tokens: tokens, TTL: ttl}
+}
+
+func TestApply(t *testing.T) {
+	cases := []struct {
+		name   string
+		seed   []Breakpoint // 事先写入缓存的断点
+		prefix Prefix
+		want   Usage
+	}{
+		{
+			name:   "miss writes every breakpoint",
+			prefix: Prefix{Breakpoints: []Breakpoint{bp("a", 2000, TTL5m)}, TotalTokens: 3000},
+			want:   Usage{InputTokens: 1000, CacheCreationInputTokens: 2000, CacheCreation5mInputTokens: 2000},
+		},
+		{
+			name:   "hit on the last breakpoint",
+			seed:   []Breakpoint{bp("a", 2000, TTL5m), bp("b", 3000, TTL5m)},
+			prefix: Prefix{Breakpoints: []Breakpoint{bp("a", 2000, TTL5m), bp("b", 3000, TTL5m)}, TotalTokens: 3500},
+			want:   Usage{InputTokens: 500, CacheReadInputTokens: 3000},
+		},
+		{
+			name:   "partial hit writes the breakpoints after the hit",
+			seed:   []Breakpoint{bp("a", 2000, TTL5m)},
+			prefix: Prefix{Breakpoints: []Breakpoint{bp("a", 2000, TTL5m), bp("b", 3000, TTL5m)}, TotalTokens: 3500},
+			want:   Usage{InputTokens: 500, CacheReadInputTokens: 2000, CacheCreationInputTokens: 1000, CacheCreation5mInputTokens: 1000},
+		},
+		{
+			name:   "creation split by breakpoint ttl",
+			prefix: Prefix{Breakpoints: []Breakpoint{bp("a", 2000, TTL1h), bp("b", 3000, TTL5m)}, TotalTokens: 3500},
+			want: Usage{
+				InputTokens:                500,
+				CacheCreationInputTokens:   3000,
+				CacheCreation1hInputTokens: 2000,
+				CacheCreation5mInputTokens: 1000,
+			},
+		},
+		{
+			name:   "breakpoints below min_tokens are ignored",
+			prefix: Prefix{Breakpoints: []Breakpoint{bp("a", 500, TTL5m), bp("b", 2000, TTL5m)}, TotalTokens: 2500},
+			want:   Usage{InputTokens: 500, CacheCreationInputTokens: 2000, CacheCreation5mInputTokens: 2000},
+		},
+		{
+			name:   "only breakpoints below min_tokens",
+			prefix: Prefix{Breakpoints: []Breakpoint{bp("a", 500, TTL5m)}, TotalTokens: 2500},
+			want:   Usage{InputTokens: 2500},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := newTestCache(0, 1024)
+			if len(tc.seed) > 0 {
+				_, commit := c.Apply(Prefix{Breakpoints: tc.seed})
+				commit()
+			}
+			got, _ := c.Apply(tc.prefix)
+			if got != tc.want {
+				t.Errorf("Apply() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplyWritesOnlyAfterCommit(t *testing.T) {
+	c, _ := newTestCache(0, 1024)
+	prefix := Prefix{Breakpoints: []Breakpoint{bp("a", 2000, TTL5m)}, TotalTokens: 2000}
+
+	_, commit := c.Apply(prefix)
+	if got, _ := c.Apply(prefix); got.CacheReadInputTokens != 0 {
+		t.Fatalf("uncommitted prefix was read from the cache: %+v", got)
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("Stats() before commit = %+v, want zero", stats)
+	}
+
+	commit()
+	commit()
+	if stats := c.Stats(); stats.Entries != 1 || stats.Misses != 1 {
+		t.Fatalf("Stats() after commit = %+v, want 1 entry and 1 miss", stats)
+	}
+	if got, _ := c.Apply(prefix); got.CacheReadInputTokens != 2000 {
+		t.Errorf("committed prefix was not read from the cache: %+v", got)
+	}
+}
+
+func TestApplyExpiry(t *testing.T) {
+	c, clock := newTestCache(0, 1024)
+	prefix := Prefix{Breakpoints: []Breakpoint{bp("a", 2000, TTL5m)}, TotalTokens: 2000}
+	_, commit := c.Apply(prefix)
+	commit()
+
+	// 命中并提交后刷新过期时间
+	clock.now = clock.now.Add(4 * time.Minute)
+	got, commit := c.Apply(prefix)
+	if got.CacheReadInputTokens != 2000 {
+		t.Fatalf("Apply() after 4m = %+v, want a cache read", got)
+	}
+	commit()
+
+	clock.now = clock.now.Add(4 * time.Minute)
+	if got, _ := c.Apply(prefix); got.CacheReadInputTokens != 2000 {
+		t.Errorf("Apply() 4m after refresh = %+v, want a cache read", got)
+	}
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	if got, _ := c.Apply(prefix); got.CacheReadInputTokens != 0 {
+		t.Errorf("Apply() after expiry = %+v, want a miss", got)
+	}
+}
+
+// cached 返回带 cache_control 的文本内容块
+func cached(text string, ttl string) map[string]interface{} {
+	cacheControl := map[string]interface{}{"type": "ephemeral"}
+	if ttl != "" {
+		cacheControl["ttl"] = ttl
+	}
+	return map[string]interface{}{"type": "text", "text": text, "cache_control": cacheControl}
+}
+
+func TestAnalyze(t *testing.T) {
+	base := core.ClaudeRequest{
+		Model:  "claude-sonnet-4.5",
+		System: []interface{}{cached(strings.Repeat("s", 400), "1h")},
+		Messages: []core.ClaudeMessage{
+			{Role: "user", Content: []interface{}{cached(strings.Repeat("u", 400), "")}},
+		},
+	}
+	prefix, err := Analyze(base, "key")
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if len(prefix.Breakpoints) != 2 || prefix.Breakpoints[0].TTL != TTL1h || prefix.Breakpoints[1].TTL != TTL5m {
+		t.Fatalf("breakpoints = %+v, want 1h then 5m", prefix.Breakpoints)
+	}
+	if prefix.Breakpoints[0].Tokens != 100 || prefix.Breakpoints[1].Tokens != 200 {
+		t.Errorf("breakpoint tokens = %d, %d, want 100, 200", prefix.Breakpoints[0].Tokens, prefix.Breakpoints[1].Tokens)
+	}
+
+	// 后续消息不同的请求共享前缀，不同 API key 不共享
+	next := base
+	next.Messages = append(append([]core.ClaudeMessage{}, base.Messages...), core.ClaudeMessage{Role: "assistant", Content: "ok"})
+	if other, _ := Analyze(next, "key"); other.Breakpoints[1].Hash != prefix.Breakpoints[1].Hash {
+		t.Error("appending a message changed the prefix hash")
+	}
+	if other, _ := Analyze(base, "other-key"); other.Breakpoints[0].Hash == prefix.Breakpoints[0].Hash {
+		t.Error("different API keys share a prefix hash")
+	}
+}
+
+func TestAnalyzeConversionSettings(t *testing.T) {
+	previousConfig, previousRegistry := config.Current(), prompt.Current()
+	defer func() {
+		config.Set(previousConfig)
+		prompt.Set(previousRegistry)
+	}()
+
+	base := core.ClaudeRequest{
+		Model:    "claude-sonnet-4.5",
+		Messages: []core.ClaudeMessage{{Role: "user", Content: []interface{}{cached(strings.Repeat("u", 400), "")}}},
+	}
+	hash := func(req core.ClaudeRequest) string {
+		prefix, err := Analyze(req, "key")
+		if err != nil {
+			t.Fatalf("Analyze: %v", err)
+		}
+		return prefix.Breakpoints[0].Hash
+	}
+	withMetadata := func(ctx *core.AmazonQContext) core.ClaudeRequest {
+		req := base
+		req.Metadata = &core.Metadata{AmazonQ: ctx}
+		return req
+	}
+	offset := 540
+
+	cfg := config.Default()
+	config.Set(cfg)
+	original := hash(base)
+
+	// 历史消息携带的环境状态变化后前缀失效
+	moved := *cfg
+	moved.Context.WorkingDirectory = "/srv/other"
+	config.Set(&moved)
+	if hash(base) == original {
+		t.Error("changing the default working directory kept the prefix hash")
+	}
+	config.Set(cfg)
+	if hash(withMetadata(&core.AmazonQContext{OperatingSystem: "windows"})) == original {
+		t.Error("metadata.amazonq operating_system kept the prefix hash")
+	}
+	if hash(withMetadata(&core.AmazonQContext{TimezoneOffset: &offset})) == original {
+		t.Error("metadata.amazonq timezone_offset kept the prefix hash")
+	}
+
+	// 只出现在当前消息的上下文不影响前缀
+	if hash(withMetadata(&core.AmazonQContext{GitStatus: "M main.go", ShellName: "zsh"})) != original {
+		t.Error("git status and shell name changed the prefix hash")
+	}
+
+	// 同名模板内容变化后前缀失效
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, prompt.DefaultTemplateName+".tmpl"), []byte("{{.UserMessage}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := prompt.NewRegistry(prompt.Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	prompt.Set(registry)
+	if hash(base) == original {
+		t.Error("changing the template content kept the prefix hash")
+	}
+	prompt.Set(previousRegistry)
+	if hash(base) != original {
+		t.Error("restoring the settings did not restore the prefix hash")
+	}
+}
+
+func TestAnalyzeErrors(t *testing.T) {
+	five := make([]interface{}, MaxBreakpoints+1)
+	for i := range five {
+		five[i] = cached("text", "")
+	}
+
+	cases := []struct {
+		name    string
+		content []interface{}
+		want    string
+	}{
+		{"1h after 5m", []interface{}{cached("a", "5m"), cached("b", "1h")}, "ttl 1h must come before ttl 5m"},
+		{"too many breakpoints", five, "a maximum of 4 blocks"},
+		{"invalid ttl", []interface{}{cached("a", "10m")}, "invalid cache_control ttl"},
+		{"invalid type", []interface{}{map[string]interface{}{"type": "text", "text": "a", "cache_control": map[string]interface{}{"type": "persistent"}}}, "invalid cache_control type"},
+	}
+	for _, tc := range cases {
+		req := core.ClaudeRequest{Messages: []core.ClaudeMessage{{Role: "user", Content: tc.content}}}
+		if _, err := Analyze(req, "key"); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Analyze error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package region
+
+import (
+	"fmt"
+	"strings"
+
+	"amazonq-proxy/internal/config"
+)
+
+// Valid 判断字符串是否为 AWS 区域名称
+// 参数 name 为待检查的字符串
+// 返回是否为区域名称
+func Valid(name string) bool {
+	return config.ValidRegion(name)
+}
+
+// Default 返回未在凭据中指定区域时使用的默认区域
+func Default() string {
+	return config.Current().Upstream.Region
+}
+
+// QEndpoint 返回区域的 Amazon Q API 端点（配置了 upstream.endpoint 时始终使用该地址）
+// 参数 name 为区域名称
+// 返回端点 URL
+func QEndpoint(name string) string {
+	if endpoint := config.Current().Upstream.Endpoint; endpoint != "" {
+		return endpoint
+	}
+	return fmt.Sprintf("https://q.%s.amazonaws.com/", name)
+}
+
+// TokenEndpoint 返回区域的 OIDC Token 端点（配置了 upstream.oidc_endpoint 时始终使用该地址）
+// 参数 name 为区域名称
+// 返回端点 URL
+func TokenEndpoint(name string) string {
+	base := fmt.Sprintf("https://oidc.%s.amazonaws.com", name)
+	if endpoint := config.Current().Upstream.OIDCEndpoint; endpoint != "" {
+		base = endpoint
+	}
+	return strings.TrimRight(base, "/") + "/token"
+}
+
+// Candidates 返回请求 Amazon Q 时依次尝试的区域
+// 凭据所在区域优先，其后按 upstream.fallback_regions 的顺序回退（去重）
+// 参数 primary 为凭据所在区域
+// 返回区域列表
+func Candidates(primary string) []string {
+	regions := []string{primary}
+	seen := map[string]bool{primary: true}
+	for _, name := range config.Current().Upstream.FallbackRegions {
+		if !seen[name] {
+			seen[name] = true
+			regions = append(regions, name)
+		}
+	}
+	return regions
+}
@@ -0,0 +1,305 @@
+package reload
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"amazonq-proxy/internal/breaker"
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/httpclient"
+	"amazonq-proxy/internal/imaging"
+	"amazonq-proxy/internal/license"
+	"amazonq-proxy/internal/logging"
+	"amazonq-proxy/internal/prompt"
+	"amazonq-proxy/internal/promptcache"
+	"amazonq-proxy/internal/responsecache"
+	"amazonq-proxy/internal/tracing"
+	"amazonq-proxy/internal/usage"
+)
+
+// Status 配置重载状态和累计计数
+type Status struct {
+	Successes   int64      `json:"successes"`
+	Failures    int64      `json:"failures"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Files       []string   `json:"watched_files"`
+}
+
+// fileState 被监视文件的修改时间和大小
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader 监视配置文件、凭据文件和模板目录，在变化或收到 SIGHUP 时重新加载配置
+type Reloader struct {
+	flags *config.Flags
+
+	reloadMutex sync.Mutex // 保证同一时间只有一次重载
+
+	statusMutex sync.Mutex
+	status      Status
+	files       map[string]fileState
+}
+
+// active 当前运行的重载器（未启动时为 nil）
+var active atomic.Pointer[Reloader]
+
+// Start 启动重载器：监听 SIGHUP 并按 server.watch_interval 检查文件变化
+// 参数 flags 为启动时的命令行参数，重载时使用相同的参数
+// 返回重载器
+func Start(flags *config.Flags) *Reloader {
+	r := &Reloader{flags: flags}
+	r.files = r.snapshotFiles(config.Current())
+	active.Store(r)
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go r.watch(hangup)
+	return r
+}
+
+// Current 返回当前运行的重载器，未启动时返回 nil
+func Current() *Reloader {
+	return active.Load()
+}
+
+// watch 等待 SIGHUP 或文件变化并触发重载
+// 参数 hangup 为 SIGHUP 信号通道
+func (r *Reloader) watch(hangup <-chan os.Signal) {
+	for {
+		// 每轮重新读取间隔，重载后立即生效
+		interval := config.Current().Server.WatchInterval.Std()
+		var tick <-chan time.Time
+		if interval > 0 {
+			tick = time.After(interval)
+		}
+
+		select {
+		case <-hangup:
+			r.Reload("SIGHUP")
+		case <-tick:
+			if changed := r.changedFiles(); len(changed) > 0 {
+				r.Reload("file change: " + strings.Join(changed, ", "))
+			}
+		}
+	}
+}
+
+// Reload 重新加载配置并原子地替换各模块的状态
+// 校验失败时保留当前配置，进行中的请求继续使用原有的配置和客户端
+// 参数 reason 为触发原因，用于日志
+// 返回可能的加载或校验错误
+func (r *Reloader) Reload(reason string) error {
+	r.reloadMutex.Lock()
+	defer r.reloadMutex.Unlock()
+
+	slog.Info("reloading configuration", "component", "reload", "reason", reason)
+	// 加载前记录文件状态，加载期间再次保存的文件在下一轮检查时仍会触发重载
+	before := r.snapshotFiles(config.Current())
+	next, err := config.Load(r.flags)
+	if err == nil {
+		err = Apply(config.Current(), next)
+	}
+
+	// 新配置新增的文件在加载后才知道路径，使用此时的状态
+	files := r.snapshotFiles(config.Current())
+	for path := range files {
+		if state, ok := before[path]; ok {
+			files[path] = state
+		}
+	}
+
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+	// 失败时同样记录文件状态，文件再次变化后才会重试
+	r.files = files
+	now := time.Now()
+	r.status.LastAttempt = &now
+	if err != nil {
+		r.status.Failures++
+		r.status.LastError = err.Error()
+		slog.Error("configuration reload failed, keeping current configuration", "component", "reload", "error", err)
+		return err
+	}
+	r.status.Successes++
+	r.status.LastSuccess = r.status.LastAttempt
+	r.status.LastError = ""
+	return nil
+}
+
+// Status 返回重载状态
+func (r *Reloader) Status() Status {
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+	status := r.status
+	status.Files = make([]string, 0, len(r.files))
+	for path := range r.files {
+		status.Files = append(status.Files, path)
+	}
+	sort.Strings(status.Files)
+	return status
+}
+
+// Apply 将新配置应用到各模块
+// 先构建所有可能失败的部分（模板、响应缓存、用量账本），全部成功后再依次替换，任一失败时不做任何修改
+// 参数 prev 为当前配置
+// 参数 next 为已校验的新配置
+// 返回可能的构建错误
+func Apply(prev, next *config.Config) error {
+	registry, err := prompt.Build(next)
+	if err != nil {
+		return fmt.Errorf("prompt templates: %w", err)
+	}
+
+	cacheChanged := !reflect.DeepEqual(prev.ResponseCache, next.ResponseCache)
+	var cache *responsecache.Cache
+	if cacheChanged {
+		if cache, err = responsecache.Build(next.ResponseCache); err != nil {
+			return fmt.Errorf("response cache: %w", err)
+		}
+	}
+
+	// 配额和写入间隔直接读取当前配置，只有存储位置或时区变化时才需要重建账本
+	usageChanged := !reflect.DeepEqual(prev.Usage, next.Usage)
+	ledgerChanged := prev.Usage.Enabled != next.Usage.Enabled || prev.Usage.Dir != next.Usage.Dir || prev.Usage.Timezone != next.Usage.Timezone
+	var ledger *usage.Ledger
+	if ledgerChanged {
+		if ledger, err = usage.Build(next.Usage); err != nil {
+			return fmt.Errorf("usage: %w", err)
+		}
+	}
+
+	config.Set(next)
+	prompt.Set(registry)
+	// 日志级别、格式和需要隐藏的配置密钥可能都已变化
+	logging.Init()
+
+	var changed []string
+	if cacheChanged {
+		responsecache.Set(cache)
+		changed = append(changed, "response_cache")
+	}
+	if ledgerChanged {
+		// 原账本写入未保存的用量后关闭
+		usage.Set(ledger)
+	}
+	if usageChanged {
+		changed = append(changed, "usage")
+	}
+	if !reflect.DeepEqual(prev.Upstream, next.Upstream) {
+		// 新客户端只用于之后的请求，进行中的流继续使用原客户端的连接
+		httpclient.Init()
+		imaging.Init()
+		changed = append(changed, "upstream")
+	}
+	if !reflect.DeepEqual(prev.Breaker, next.Breaker) {
+		breaker.Reconfigure()
+		changed = append(changed, "breaker")
+	}
+	if !reflect.DeepEqual(prev.Citations, next.Citations) {
+		license.Init()
+		changed = append(changed, "citations")
+	}
+	if !reflect.DeepEqual(prev.PromptCache, next.PromptCache) {
+		promptcache.Init()
+		changed = append(changed, "prompt_cache")
+	}
+	if !reflect.DeepEqual(prev.Tracing, next.Tracing) {
+		// 原有导出器在后台导出剩余的 span
+		tracing.Init()
+		changed = append(changed, "tracing")
+	}
+	for name, section := range map[string][2]interface{}{
+		"server":      {prev.Server, next.Server},
+		"models":      {prev.Models, next.Models},
+		"logging":     {prev.Logging, next.Logging},
+		"credentials": {prev.Credentials, next.Credentials},
+		"limits":      {prev.Limits, next.Limits},
+		"context":     {prev.Context, next.Context},
+		"prompt":      {prev.Prompt, next.Prompt},
+	} {
+		if !reflect.DeepEqual(section[0], section[1]) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	if prev.Server.Listen != next.Server.Listen {
+		slog.Warn("server.listen changed, restart required to take effect", "component", "reload", "listen", next.Server.Listen)
+	}
+	if len(changed) == 0 {
+		slog.Info("configuration reloaded, no changes", "component", "reload")
+	} else {
+		slog.Info("configuration reloaded", "component", "reload", "changed", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// watchedFiles 返回需要监视的文件：配置文件、凭据文件和模板目录中的模板
+// 参数 cfg 为当前配置
+// 返回文件路径列表
+func (r *Reloader) watchedFiles(cfg *config.Config) []string {
+	var files []string
+	if r.flags != nil && r.flags.ConfigFile != "" {
+		files = append(files, r.flags.ConfigFile)
+	}
+	for _, path := range cfg.Credentials.Files {
+		files = append(files, path)
+	}
+	if cfg.Prompt.Dir != "" {
+		templates, _ := filepath.Glob(filepath.Join(cfg.Prompt.Dir, "*.tmpl"))
+		files = append(files, templates...)
+	}
+	return files
+}
+
+// snapshotFiles 记录被监视文件的当前状态（不存在的文件记为零值）
+// 参数 cfg 为当前配置
+// 返回文件路径 -> 状态
+func (r *Reloader) snapshotFiles(cfg *config.Config) map[string]fileState {
+	states := make(map[string]fileState)
+	for _, path := range r.watchedFiles(cfg) {
+		var state fileState
+		if info, err := os.Stat(path); err == nil {
+			state = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		states[path] = state
+	}
+	return states
+}
+
+// changedFiles 返回自上次成功加载后发生变化、新增或删除的文件
+func (r *Reloader) changedFiles() []string {
+	current := r.snapshotFiles(config.Current())
+
+	r.statusMutex.Lock()
+	previous := r.files
+	r.statusMutex.Unlock()
+
+	var changed []string
+	for path, state := range current {
+		if old, ok := previous[path]; !ok || !old.modTime.Equal(state.modTime) || old.size != state.size {
+			changed = append(changed, path)
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,216 @@
+package responsecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"amazonq-proxy/internal/anthropic"
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/core"
+)
+
+// Entry 缓存的响应
+type Entry struct {
+	Message   anthropic.Message `json:"message"`    // 完整的助手消息
+	CreatedAt time.Time         `json:"created_at"` // 写入时间
+	ExpiresAt time.Time         `json:"expires_at"` // 过期时间
+}
+
+// Size 返回条目占用的字节数（按消息序列化后的长度计算）
+func (e *Entry) Size() int {
+	data, _ := json.Marshal(e.Message)
+	return len(data)
+}
+
+// Expired 判断条目在指定时间是否已过期
+func (e *Entry) Expired(now time.Time) bool {
+	return !now.Before(e.ExpiresAt)
+}
+
+// Backend 响应缓存存储后端
+type Backend interface {
+	// Get 获取未过期的条目
+	Get(key string) (*Entry, bool)
+	// Set 写入条目
+	Set(key string, entry *Entry)
+	// Purge 清空所有条目并返回清除的数量
+	Purge() int
+	// Usage 返回当前的条目数和总字节数
+	Usage() (entries int, bytes int64)
+}
+
+// Cache 响应缓存
+type Cache struct {
+	backend       Backend
+	ttl           time.Duration
+	maxEntryBytes int
+	hits          atomic.Int64
+	misses        atomic.Int64
+}
+
+// Stats 响应缓存统计
+type Stats struct {
+	Entries int   `json:"entries"` // 当前条目数
+	Bytes   int64 `json:"bytes"`   // 当前总字节数
+	Hits    int64 `json:"hits"`    // 累计命中次数
+	Misses  int64 `json:"misses"`  // 累计未命中次数
+}
+
+// current 当前生效的响应缓存（未启用时为 nil）
+var current atomic.Pointer[Cache]
+
+// New 创建响应缓存
+// 参数 backend 为存储后端
+// 参数 ttl 为条目有效期
+// 参数 maxEntryBytes 为单条响应的最大字节数（<= 0 表示不限制）
+// 返回缓存实例
+func New(backend Backend, ttl time.Duration, maxEntryBytes int) *Cache {
+	return &Cache{backend: backend, ttl: ttl, maxEntryBytes: maxEntryBytes}
+}
+
+// Init 根据配置创建响应缓存（未启用时清除当前缓存）
+// 返回可能的初始化错误
+func Init() error {
+	cache, err := Build(config.Current().ResponseCache)
+	if err != nil {
+		return err
+	}
+	current.Store(cache)
+	return nil
+}
+
+// Build 根据响应缓存配置创建缓存，不替换当前缓存
+// 参数 cfg 为响应缓存配置
+// 返回缓存（未启用时为 nil）和可能的初始化错误
+func Build(cfg config.ResponseCacheConfig) (*Cache, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	var backend Backend
+	switch cfg.Backend {
+	case "memory":
+		backend = NewMemoryBackend(cfg.MaxEntries, cfg.MaxBytes)
+	case "disk":
+		disk, err := NewDiskBackend(cfg.Dir, cfg.MaxEntries, cfg.MaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		backend = disk
+	default:
+		return nil, fmt.Errorf("unknown response cache backend %q: expected memory or disk", cfg.Backend)
+	}
+
+	return New(backend, cfg.TTL.Std(), cfg.MaxEntryBytes), nil
+}
+
+// Set 替换当前生效的响应缓存
+// 参数 cache 为新的缓存，nil 表示停用
+func Set(cache *Cache) {
+	current.Store(cache)
+}
+
+// Current 返回当前生效的响应缓存，未启用时返回 nil
+func Current() *Cache {
+	return current.Load()
+}
+
+// Get 获取缓存的响应
+// 参数 key 为缓存键
+// 返回缓存条目和是否命中
+func (c *Cache) Get(key string) (*Entry, bool) {
+	entry, ok := c.backend.Get(key)
+	if !ok || entry.Expired(time.Now()) {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return entry, true
+}
+
+// Set 缓存完整的响应消息，超出单条大小限制时忽略
+// 参数 key 为缓存键
+// 参数 message 为完整的助手消息
+func (c *Cache) Set(key string, message anthropic.Message) {
+	now := time.Now()
+	entry := &Entry{Message: message, CreatedAt: now, ExpiresAt: now.Add(c.ttl)}
+	if c.maxEntryBytes > 0 && entry.Size() > c.maxEntryBytes {
+		return
+	}
+	c.backend.Set(key, entry)
+}
+
+// Purge 清空缓存
+// 返回清除的条目数量
+func (c *Cache) Purge() int {
+	return c.backend.Purge()
+}
+
+// Stats 返回缓存的条目数、字节数和累计命中统计
+func (c *Cache) Stats() Stats {
+	entries, bytes := c.backend.Usage()
+	return Stats{
+		Entries: entries,
+		Bytes:   bytes,
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+	}
+}
+
+// Cacheable 判断请求是否适合缓存（未配置为仅缓存 temperature 为 0 的请求时始终可缓存）
+// 参数 req 为 Claude 请求
+// 返回是否可缓存
+func Cacheable(req core.ClaudeRequest) bool {
+	if !config.Current().ResponseCache.ZeroTemperatureOnly {
+		return true
+	}
+	return req.Temperature != nil && *req.Temperature == 0
+}
+
+// Key 计算请求的缓存键
+// 流式和非流式请求共用缓存，metadata.user_id 和 cache_control 不影响缓存键
+// 参数 req 为 Claude 请求（已合并消息上下文请求头并选择模板）
+// 参数 keyHash 为 API key 的 SHA256 哈希，用于隔离不同 key 的缓存
+// 返回十六进制缓存键
+func Key(req core.ClaudeRequest, keyHash string) string {
+	req.Stream = false
+	if req.Metadata != nil {
+		metadata := *req.Metadata
+		metadata.UserID = ""
+		req.Metadata = &metadata
+	}
+
+	// 经由通用结构重新序列化，使对象键有序并去除 cache_control
+	var normalized interface{}
+	data, _ := json.Marshal(req)
+	json.Unmarshal(data, &normalized)
+	normalized = stripCacheControl(normalized)
+	data, _ = json.Marshal(map[string]interface{}{
+		"key":      keyHash,
+		"template": req.PromptTemplate,
+		"request":  normalized,
+	})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// stripCacheControl 递归删除 cache_control 字段
+func stripCacheControl(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		delete(v, "cache_control")
+		for k, item := range v {
+			v[k] = stripCacheControl(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stripCacheControl(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,217 @@
+package responsecache
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskFileExt 缓存文件扩展名
+const diskFileExt = ".json"
+
+// DiskBackend 磁盘后端，每个条目保存为一个 JSON 文件，按修改时间近似 LRU 淘汰
+type DiskBackend struct {
+	mu         sync.Mutex
+	dir        string
+	maxEntries int
+	maxBytes   int
+	expires    map[string]time.Time // 缓存文件的过期时间（按文件名），淘汰时避免重复读取文件
+}
+
+// NewDiskBackend 创建磁盘后端
+// 参数 dir 为缓存目录（不存在时自动创建）
+// 参数 maxEntries 为最大文件数（<= 0 表示不限制）
+// 参数 maxBytes 为缓存文件总字节数上限（<= 0 表示不限制）
+// 返回磁盘后端和可能的错误
+func NewDiskBackend(dir string, maxEntries int, maxBytes int) (*DiskBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create response cache dir %s: %w", dir, err)
+	}
+	return &DiskBackend{dir: dir, maxEntries: maxEntries, maxBytes: maxBytes, expires: make(map[string]time.Time)}, nil
+}
+
+// path 返回缓存键对应的文件路径
+func (d *DiskBackend) path(key string) string {
+	return filepath.Join(d.dir, key+diskFileExt)
+}
+
+// Get 读取未过期的条目，命中时更新文件修改时间
+func (d *DiskBackend) Get(key string) (*Entry, bool) {
+	path := d.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		os.Remove(path)
+		return nil, false
+	}
+	now := time.Now()
+	if entry.Expired(now) {
+		os.Remove(path)
+		return nil, false
+	}
+
+	os.Chtimes(path, now, now)
+	return &entry, true
+}
+
+// Set 写入条目（先写临时文件再重命名），超出容量时淘汰最久未使用的文件
+func (d *DiskBackend) Set(key string, entry *Entry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tmp, err := os.CreateTemp(d.dir, key+".*.tmp")
+	if err != nil {
+		slog.Warn("failed to write cache entry", "component", "response_cache", "error", err)
+		return
+	}
+	_, writeErr := tmp.Write(data)
+	closeErr := tmp.Close()
+	if writeErr != nil || closeErr != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), d.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	d.expires[key+diskFileExt] = entry.ExpiresAt
+
+	d.evict()
+}
+
+// expiresAt 返回缓存文件的过期时间，未记录时读取文件，调用方需持有锁
+// 参数 name 为文件名
+// 返回过期时间，文件无法解析时返回 false
+func (d *DiskBackend) expiresAt(name string) (time.Time, bool) {
+	if expires, ok := d.expires[name]; ok {
+		return expires, true
+	}
+	data, err := os.ReadFile(filepath.Join(d.dir, name))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return time.Time{}, false
+	}
+	return entry.ExpiresAt, true
+}
+
+// evict 删除过期和无法解析的文件，并在文件数或总大小超限时按修改时间从旧到新删除，调用方需持有锁
+func (d *DiskBackend) evict() {
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	expires := make(map[string]time.Time, len(entries))
+	var files []cacheFile
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), diskFileExt) {
+			continue
+		}
+		path := filepath.Join(d.dir, e.Name())
+		expiresAt, ok := d.expiresAt(e.Name())
+		if !ok || !now.Before(expiresAt) {
+			os.Remove(path)
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		expires[e.Name()] = expiresAt
+		files = append(files, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	d.expires = expires
+
+	overLimit := func() bool {
+		return (d.maxEntries > 0 && len(files) > d.maxEntries) || (d.maxBytes > 0 && total > int64(d.maxBytes))
+	}
+	if !overLimit() {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for len(files) > 0 && overLimit() {
+		f := files[0]
+		files = files[1:]
+		if os.Remove(f.path) == nil {
+			total -= f.size
+			delete(d.expires, filepath.Base(f.path))
+		}
+	}
+}
+
+// Purge 删除所有缓存文件
+func (d *DiskBackend) Purge() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return 0
+	}
+	d.expires = make(map[string]time.Time)
+	count := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), diskFileExt) {
+			continue
+		}
+		if os.Remove(filepath.Join(d.dir, e.Name())) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Usage 返回缓存文件的数量和总字节数
+func (d *DiskBackend) Usage() (int, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return 0, 0
+	}
+	count := 0
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), diskFileExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		count++
+		total += info.Size()
+	}
+	return count, total
+}
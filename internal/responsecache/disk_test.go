@@ -0,0 +1,53 @@
+package responsecache
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"amazonq-proxy/internal/anthropic"
+)
+
+// diskEntry 构造指定过期时间的条目
+func diskEntry(expiresAt time.Time) *Entry {
+	return &Entry{Message: anthropic.Message{ID: "msg_test"}, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+}
+
+func TestDiskEvictsExpiredFiles(t *testing.T) {
+	d, err := NewDiskBackend(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set("expired", diskEntry(time.Now().Add(-time.Minute)))
+	d.Set("fresh", diskEntry(time.Now().Add(time.Hour)))
+
+	if count, _ := d.Usage(); count != 1 {
+		t.Errorf("files = %d, want 1", count)
+	}
+	if _, ok := d.Get("fresh"); !ok {
+		t.Error("fresh entry was evicted")
+	}
+}
+
+func TestDiskEnforcesMaxEntries(t *testing.T) {
+	d, err := NewDiskBackend(t.TempDir(), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	for i, key := range []string{"a", "b", "c"} {
+		d.Set(key, diskEntry(expires))
+		// 保证修改时间按写入顺序递增
+		modTime := time.Now().Add(time.Duration(i-3) * time.Minute)
+		if err := os.Chtimes(d.path(key), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count, _ := d.Usage(); count != 2 {
+		t.Errorf("files = %d, want 2", count)
+	}
+	if _, ok := d.Get("a"); ok {
+		t.Error("oldest entry was not evicted")
+	}
+}
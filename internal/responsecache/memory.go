@@ -0,0 +1,102 @@
+package responsecache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// memoryItem LRU 链表中的元素
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int
+}
+
+// MemoryBackend 内存 LRU 后端
+type MemoryBackend struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	order      *list.List // 表头为最近使用
+	totalBytes int
+	maxEntries int
+	maxBytes   int
+}
+
+// NewMemoryBackend 创建内存 LRU 后端
+// 参数 maxEntries 为最大条目数（<= 0 表示不限制）
+// 参数 maxBytes 为总字节数上限（<= 0 表示不限制）
+// 返回内存后端
+func NewMemoryBackend(maxEntries int, maxBytes int) *MemoryBackend {
+	return &MemoryBackend{
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+// Get 获取未过期的条目并标记为最近使用
+func (m *MemoryBackend) Get(key string) (*Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*memoryItem)
+	if item.entry.Expired(time.Now()) {
+		m.remove(elem)
+		return nil, false
+	}
+	m.order.MoveToFront(elem)
+	return item.entry, true
+}
+
+// Set 写入条目，超出容量时淘汰最久未使用的条目
+func (m *MemoryBackend) Set(key string, entry *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.items[key]; ok {
+		m.remove(elem)
+	}
+
+	item := &memoryItem{key: key, entry: entry, size: entry.Size()}
+	if m.maxBytes > 0 && item.size > m.maxBytes {
+		return
+	}
+	m.items[key] = m.order.PushFront(item)
+	m.totalBytes += item.size
+
+	for (m.maxEntries > 0 && m.order.Len() > m.maxEntries) || (m.maxBytes > 0 && m.totalBytes > m.maxBytes) {
+		m.remove(m.order.Back())
+	}
+}
+
+// Purge 清空所有条目
+func (m *MemoryBackend) Purge() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := len(m.items)
+	m.items = make(map[string]*list.Element)
+	m.order.Init()
+	m.totalBytes = 0
+	return count
+}
+
+// Usage 返回当前的条目数和总字节数
+func (m *MemoryBackend) Usage() (int, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items), int64(m.totalBytes)
+}
+
+// remove 移除链表元素，调用方需持有锁
+func (m *MemoryBackend) remove(elem *list.Element) {
+	item := m.order.Remove(elem).(*memoryItem)
+	delete(m.items, item.key)
+	m.totalBytes -= item.size
+}
@@ -0,0 +1,334 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/logging"
+)
+
+const (
+	// queueSize 等待导出的 span 队列长度，队列满时丢弃新的 span
+	queueSize = 2048
+	// batchSize 每批导出的最大 span 数量
+	batchSize = 512
+	// flushInterval 定时导出的间隔
+	flushInterval = 5 * time.Second
+	// exportTimeout 单次导出的超时时间
+	exportTimeout = 10 * time.Second
+)
+
+// exporter 将一批 span 编码后的 OTLP JSON 发送到目的地
+type exporter interface {
+	export(ctx context.Context, body []byte) error
+}
+
+// provider 采样设置和后台批量导出
+type provider struct {
+	cfg      config.TracingConfig
+	exporter exporter
+	queue    chan *Span
+	flush    chan chan struct{}
+	stopped  sync.Once
+	dropped  atomic.Int64
+}
+
+// current 当前生效的 provider（未启用追踪时为 nil）
+var current atomic.Pointer[provider]
+
+// Init 按 tracing 配置重建导出器，exporter 为 none 时关闭追踪
+// 原有的导出器在后台导出剩余的 span 后停止
+func Init() {
+	cfg := config.Current().Tracing
+
+	var next *provider
+	switch cfg.Exporter {
+	case "otlp":
+		next = newProvider(cfg, &otlpExporter{endpoint: cfg.Endpoint, headers: cfg.Headers, client: &http.Client{Timeout: exportTimeout}})
+	case "stdout":
+		next = newProvider(cfg, &writerExporter{w: os.Stdout})
+	}
+
+	if prev := current.Swap(next); prev != nil {
+		go prev.shutdown(context.Background())
+	}
+}
+
+// Shutdown 导出所有剩余的 span 并停止追踪，用于停机
+// 参数 ctx 为等待导出的上下文
+func Shutdown(ctx context.Context) {
+	if p := current.Swap(nil); p != nil {
+		p.shutdown(ctx)
+	}
+}
+
+// newProvider 创建 provider 并启动后台导出
+func newProvider(cfg config.TracingConfig, e exporter) *provider {
+	p := &provider{
+		cfg:      cfg,
+		exporter: e,
+		queue:    make(chan *Span, queueSize),
+		flush:    make(chan chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// sample 判断新 trace 是否采样
+func (p *provider) sample(id TraceID) bool {
+	return traceRatio(id) < p.cfg.SampleRatio
+}
+
+// enqueue 将结束的 span 放入导出队列，队列满时丢弃
+func (p *provider) enqueue(s *Span) {
+	select {
+	case p.queue <- s:
+	default:
+		if p.dropped.Add(1) == 1 {
+			slog.Warn("span queue is full, dropping spans", "component", "tracing")
+		}
+	}
+}
+
+// run 后台按批量和间隔导出 span
+func (p *provider) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		p.export(batch)
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-p.queue:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-p.flush:
+			// 取出队列中剩余的 span 后导出
+			for drained := false; !drained; {
+				select {
+				case s := <-p.queue:
+					batch = append(batch, s)
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(flushed)
+			return
+		}
+	}
+}
+
+// shutdown 导出剩余的 span 并停止后台导出
+func (p *provider) shutdown(ctx context.Context) {
+	p.stopped.Do(func() {
+		flushed := make(chan struct{})
+		select {
+		case p.flush <- flushed:
+			select {
+			case <-flushed:
+			case <-ctx.Done():
+			}
+		case <-ctx.Done():
+		}
+	})
+}
+
+// export 编码并发送一批 span
+func (p *provider) export(batch []*Span) {
+	body, err := json.Marshal(p.encode(batch))
+	if err != nil {
+		slog.Error("failed to encode spans", "component", "tracing", "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	if err := p.exporter.export(ctx, body); err != nil {
+		slog.Warn("failed to export spans", "component", "tracing", "spans", len(batch), "error", err)
+	}
+}
+
+// otlpExporter 以 OTLP/HTTP JSON 发送到 collector
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// export 发送一批 span
+func (e *otlpExporter) export(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("collector returned %d: %s", resp.StatusCode, message)
+	}
+	return nil
+}
+
+// writerExporter 每批输出一行 OTLP JSON，用于本地测试
+type writerExporter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// export 输出一批 span
+func (e *writerExporter) export(ctx context.Context, body []byte) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, err := e.w.Write(append(body, '\n'))
+	return err
+}
+
+// OTLP JSON 编码（opentelemetry-proto 的 JSON 映射，ID 使用十六进制，64 位整数使用字符串）
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpStatus struct {
+		Code    StatusCode `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+// encode 将一批 span 转换为 OTLP 导出请求
+func (p *provider) encode(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mutex.Lock()
+		span := otlpSpan{
+			TraceID:           s.context.TraceID.String(),
+			SpanID:            s.context.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        encodeAttributes(s.attributes),
+			Status:            otlpStatus{Code: s.status, Message: logging.Redact(s.message)},
+		}
+		if !s.parent.IsZero() {
+			span.ParentSpanID = s.parent.String()
+		}
+		for _, event := range s.events {
+			span.Events = append(span.Events, otlpEvent{
+				TimeUnixNano: unixNano(event.Time),
+				Name:         event.Name,
+				Attributes:   encodeAttributes(event.Attributes),
+			})
+		}
+		s.mutex.Unlock()
+		spans = append(spans, span)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: encodeAttributes([]Attribute{
+			String("service.name", p.cfg.ServiceName),
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "amazonq-proxy"},
+			Spans: spans,
+		}},
+	}}}
+}
+
+// encodeAttributes 转换属性，同名属性保留最后一个；字符串值与日志一样经过脱敏
+func encodeAttributes(attributes []Attribute) []otlpKeyValue {
+	index := make(map[string]int, len(attributes))
+	var encoded []otlpKeyValue
+	for _, attr := range attributes {
+		var value map[string]interface{}
+		switch v := attr.Value.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": logging.Redact(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": logging.Redact(fmt.Sprint(v))}
+		}
+		if i, ok := index[attr.Key]; ok {
+			encoded[i].Value = value
+			continue
+		}
+		index[attr.Key] = len(encoded)
+		encoded = append(encoded, otlpKeyValue{Key: attr.Key, Value: value})
+	}
+	return encoded
+}
+
+// unixNano 返回字符串形式的 Unix 纳秒时间戳
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
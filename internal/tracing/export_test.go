@@ -0,0 +1,198 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"amazonq-proxy/internal/config"
+	"amazonq-proxy/internal/logging"
+)
+
+// captureExporter 记录导出的请求体
+type captureExporter struct {
+	mutex  sync.Mutex
+	bodies [][]byte
+}
+
+func (e *captureExporter) export(ctx context.Context, body []byte) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.bodies = append(e.bodies, body)
+	return nil
+}
+
+// encodeJSON 编码一批 span 并解析为通用 JSON，检查字段的 JSON 类型
+func encodeJSON(t *testing.T, p *provider, batch []*Span) map[string]interface{} {
+	t.Helper()
+	body, err := json.Marshal(p.encode(batch))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return decoded
+}
+
+// path 按键和下标取出嵌套的 JSON 值
+func path(t *testing.T, value interface{}, keys ...interface{}) interface{} {
+	t.Helper()
+	for _, key := range keys {
+		switch k := key.(type) {
+		case string:
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				t.Fatalf("%v is not an object", value)
+			}
+			value = m[k]
+		case int:
+			a, ok := value.([]interface{})
+			if !ok || k >= len(a) {
+				t.Fatalf("%v has no index %d", value, k)
+			}
+			value = a[k]
+		}
+	}
+	return value
+}
+
+func TestEncodeAttributes(t *testing.T) {
+	encoded := encodeAttributes([]Attribute{
+		Int("http.status_code", 200),
+		String("model", "claude-sonnet-4.5"),
+		Float("ratio", 0.5),
+		Bool("cached", false),
+		Int64("tokens", 1<<40),
+		Int("http.status_code", 502),
+	})
+
+	want := []struct {
+		key   string
+		field string
+		value interface{}
+	}{
+		{"http.status_code", "intValue", "502"},
+		{"model", "stringValue", "claude-sonnet-4.5"},
+		{"ratio", "doubleValue", 0.5},
+		{"cached", "boolValue", false},
+		{"tokens", "intValue", strconv.FormatInt(1<<40, 10)},
+	}
+	if len(encoded) != len(want) {
+		t.Fatalf("encoded %d attributes, want %d: %+v", len(encoded), len(want), encoded)
+	}
+	// 同名属性保留最后一个值和第一次出现的位置
+	for i, w := range want {
+		if encoded[i].Key != w.key || len(encoded[i].Value) != 1 || encoded[i].Value[w.field] != w.value {
+			t.Errorf("attribute %d = %s %v, want %s {%s: %v}", i, encoded[i].Key, encoded[i].Value, w.key, w.field, w.value)
+		}
+	}
+}
+
+func TestEncodeSpan(t *testing.T) {
+	secret := "sk-tracing-secret-value-0123456789"
+	logging.RegisterSecret(secret)
+	defer logging.ForgetSecret(secret)
+
+	start := time.Unix(1700000000, 123456789)
+	parent := SpanContext{TraceID: traceIDWithRatio(7), SpanID: newSpanID(), Sampled: true}
+	root := &Span{name: "root", kind: KindServer, context: SpanContext{TraceID: parent.TraceID, SpanID: parent.SpanID}, start: start, end: start.Add(time.Second)}
+	child := &Span{
+		name:       "amazonq.request",
+		kind:       KindClient,
+		context:    SpanContext{TraceID: parent.TraceID, SpanID: newSpanID()},
+		parent:     parent.SpanID,
+		start:      start,
+		end:        start.Add(250 * time.Millisecond),
+		attributes: []Attribute{Int("attempt", 1), String("auth", "Bearer "+secret), Int("attempt", 2)},
+		events:     []Event{{Name: "retry", Time: start.Add(time.Millisecond), Attributes: []Attribute{Int("status", 503)}}},
+		status:     StatusError,
+		message:    "upstream rejected " + secret,
+	}
+
+	p := &provider{cfg: config.TracingConfig{ServiceName: "amazonq-proxy-test"}}
+	decoded := encodeJSON(t, p, []*Span{root, child})
+
+	resource := path(t, decoded, "resourceSpans", 0, "resource", "attributes", 0)
+	if path(t, resource, "key") != "service.name" || path(t, resource, "value", "stringValue") != "amazonq-proxy-test" {
+		t.Errorf("resource attribute = %v, want service.name", resource)
+	}
+
+	spans := path(t, decoded, "resourceSpans", 0, "scopeSpans", 0, "spans").([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want 2", len(spans))
+	}
+	if _, ok := spans[0].(map[string]interface{})["parentSpanId"]; ok {
+		t.Error("root span has a parentSpanId")
+	}
+
+	span := spans[1]
+	checks := map[string]interface{}{
+		"traceId":           parent.TraceID.String(),
+		"spanId":            child.context.SpanID.String(),
+		"parentSpanId":      parent.SpanID.String(),
+		"kind":              float64(KindClient),
+		"startTimeUnixNano": "1700000000123456789",
+		"endTimeUnixNano":   "1700000000373456789",
+	}
+	for key, want := range checks {
+		if got := path(t, span, key); got != want {
+			t.Errorf("%s = %#v, want %#v", key, got, want)
+		}
+	}
+
+	// 64 位整数和时间戳编码为字符串，同名属性只保留最后一个
+	attributes := path(t, span, "attributes").([]interface{})
+	if len(attributes) != 2 || path(t, attributes[0], "value", "intValue") != "2" {
+		t.Errorf("attributes = %v, want attempt=2 once", attributes)
+	}
+	if got := path(t, attributes[1], "value", "stringValue"); got != "Bearer [REDACTED]" {
+		t.Errorf("auth attribute = %v, want the secret redacted", got)
+	}
+	event := path(t, span, "events", 0)
+	if path(t, event, "timeUnixNano") != "1700000000124456789" || path(t, event, "attributes", 0, "value", "intValue") != "503" {
+		t.Errorf("event = %v", event)
+	}
+	if path(t, span, "status", "code") != float64(StatusError) || path(t, span, "status", "message") != "upstream rejected [REDACTED]" {
+		t.Errorf("status = %v, want the error with the secret redacted", path(t, span, "status"))
+	}
+}
+
+func TestProviderExportsSampledSpansOnShutdown(t *testing.T) {
+	exporter := &captureExporter{}
+	p := newProvider(config.TracingConfig{SampleRatio: 1, ServiceName: "test"}, exporter)
+	current.Store(p)
+	defer current.Store(nil)
+
+	ctx, server := Start(context.Background(), "server", KindServer)
+	_, client := Start(ctx, "client", KindClient)
+	client.RecordError(errors.New("boom"))
+	client.End()
+	server.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p.shutdown(shutdownCtx)
+
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	if len(exporter.bodies) != 1 {
+		t.Fatalf("exported %d batches, want 1", len(exporter.bodies))
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(exporter.bodies[0], &decoded); err != nil {
+		t.Fatalf("exported body is not JSON: %v", err)
+	}
+	spans := path(t, decoded, "resourceSpans", 0, "scopeSpans", 0, "spans").([]interface{})
+	if len(spans) != 2 || path(t, spans[0], "name") != "client" || path(t, spans[1], "name") != "server" {
+		t.Fatalf("spans = %v, want client then server", spans)
+	}
+	if path(t, spans[0], "events", 0, "name") != "exception" {
+		t.Errorf("client span events = %v, want the recorded exception", path(t, spans[0], "events"))
+	}
+}
@@ -0,0 +1,325 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanKind span 类型（取值与 OTLP 一致）
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1 // 进程内部操作
+	KindServer   SpanKind = 2 // 处理收到的请求
+	KindClient   SpanKind = 3 // 发出的请求
+)
+
+// StatusCode span 状态（取值与 OTLP 一致）
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// TraceID 16 字节的 trace ID
+type TraceID [16]byte
+
+// SpanID 8 字节的 span ID
+type SpanID [8]byte
+
+// String 返回十六进制编码
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// String 返回十六进制编码
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsZero 判断是否为全零（无效）ID
+func (id TraceID) IsZero() bool { return id == TraceID{} }
+
+// IsZero 判断是否为全零（无效）ID
+func (id SpanID) IsZero() bool { return id == SpanID{} }
+
+// SpanContext 跨进程传播的 span 标识
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Valid 判断 trace ID 和 span ID 是否都有效
+func (sc SpanContext) Valid() bool {
+	return !sc.TraceID.IsZero() && !sc.SpanID.IsZero()
+}
+
+// Attribute span 属性，值为 string、int64、float64 或 bool
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String 创建字符串属性
+func String(key, value string) Attribute { return Attribute{key, value} }
+
+// Int 创建整数属性
+func Int(key string, value int) Attribute { return Attribute{key, int64(value)} }
+
+// Int64 创建整数属性
+func Int64(key string, value int64) Attribute { return Attribute{key, value} }
+
+// Float 创建浮点数属性
+func Float(key string, value float64) Attribute { return Attribute{key, value} }
+
+// Bool 创建布尔属性
+func Bool(key string, value bool) Attribute { return Attribute{key, value} }
+
+// Event span 中的时间点事件
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// Span 一次操作的耗时和属性
+// 未启用追踪时 Start 返回 nil，所有方法对 nil 安全
+type Span struct {
+	mutex      sync.Mutex
+	name       string
+	kind       SpanKind
+	context    SpanContext
+	parent     SpanID
+	start      time.Time
+	end        time.Time
+	attributes []Attribute
+	events     []Event
+	status     StatusCode
+	message    string
+	ended      bool
+	provider   *provider
+}
+
+// spanKey 上下文中当前 span 的键
+type spanKey struct{}
+
+// remoteKey 上下文中远端父 span（来自 traceparent）的键
+type remoteKey struct{}
+
+// Start 创建子 span 并返回携带该 span 的上下文
+// 上下文中有 span 时作为父 span，否则使用 ContextWithRemote 设置的远端父 span，都没有时开始新的 trace
+// 参数 ctx 为父上下文
+// 参数 name 为 span 名称
+// 参数 kind 为 span 类型
+// 参数 attributes 为初始属性
+// 返回新的上下文和 span（未启用追踪时 span 为 nil，上下文原样返回）
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	p := current.Load()
+	if p == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: attributes,
+		provider:   p,
+	}
+	if parent := SpanContextFromContext(ctx); parent.Valid() {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		span.context.TraceID = newTraceID()
+		span.context.Sampled = p.sample(span.context.TraceID)
+	}
+	span.context.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanFromContext 返回上下文中的当前 span，没有时返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan 返回携带指定 span 的上下文，用于在不同来源的上下文之间传递 span
+// 参数 ctx 为父上下文
+// 参数 span 为 span（为 nil 时原样返回）
+// 返回新的上下文
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanContextFromContext 返回上下文中当前 span 或远端父 span 的标识
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context
+	}
+	if ctx == nil {
+		return SpanContext{}
+	}
+	remote, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return remote
+}
+
+// ContextWithRemote 返回携带远端父 span 的上下文，之后创建的 span 加入该 trace
+// 参数 ctx 为父上下文
+// 参数 sc 为远端 span 标识（无效时原样返回）
+// 返回新的上下文
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.Valid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// ParseTraceparent 解析 W3C traceparent 请求头（version-traceid-spanid-flags）
+// 参数 header 为请求头的值
+// 返回 span 标识和是否有效
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	// 版本 00 必须恰好有四段
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	// 全零的 trace ID 或 span ID 无效
+	if !sc.Valid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Traceparent 返回 span 标识对应的 W3C traceparent 请求头
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// Context 返回 span 标识（nil span 返回零值）
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttributes 设置属性，同名属性以最后一次为准
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// AddEvent 记录时间点事件
+// 参数 name 为事件名称
+// 参数 attributes 为事件属性
+func (s *Span) AddEvent(name string, attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, Event{Name: name, Time: time.Now(), Attributes: attributes})
+}
+
+// RecordError 记录错误并将状态设为 Error（err 为 nil 时不做任何事）
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, Event{Name: "exception", Time: time.Now(), Attributes: []Attribute{String("exception.message", err.Error())}})
+	s.status = StatusError
+	s.message = err.Error()
+}
+
+// SetStatus 设置状态
+// 参数 code 为状态码
+// 参数 message 为状态说明（仅 Error 时有意义）
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status = code
+	s.message = message
+}
+
+// End 结束 span，采样的 span 交给导出器；重复调用无效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mutex.Unlock()
+
+	if s.context.Sampled {
+		s.provider.enqueue(s)
+	}
+}
+
+// newTraceID 生成随机 trace ID
+func newTraceID() TraceID {
+	var id TraceID
+	for id.IsZero() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// newSpanID 生成随机 span ID
+func newSpanID() SpanID {
+	var id SpanID
+	for id.IsZero() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// traceRatio 返回 trace ID 低 8 字节对应的 [0, 1) 区间的值，用于按比例采样
+func traceRatio(id TraceID) float64 {
+	return float64(binary.BigEndian.Uint64(id[8:])>>11) / (1 << 53)
+}
@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"amazonq-proxy/internal/config"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-" + testTraceID + "-" + testSpanID + "-01", true, true},
+		{"not sampled", "00-" + testTraceID + "-" + testSpanID + "-00", true, false},
+		{"other flags", "00-" + testTraceID + "-" + testSpanID + "-03", true, true},
+		{"surrounding whitespace", " 00-" + testTraceID + "-" + testSpanID + "-01 ", true, true},
+		{"future version", "01-" + testTraceID + "-" + testSpanID + "-01", true, true},
+		{"future version with extra fields", "cc-" + testTraceID + "-" + testSpanID + "-01-what-the-future-holds", true, true},
+		{"version ff", "ff-" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"version 00 with extra fields", "00-" + testTraceID + "-" + testSpanID + "-01-extra", false, false},
+		{"zero trace id", "00-" + strings.Repeat("0", 32) + "-" + testSpanID + "-01", false, false},
+		{"zero span id", "00-" + testTraceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+		{"short trace id", "00-" + testTraceID[:30] + "-" + testSpanID + "-01", false, false},
+		{"non-hex span id", "00-" + testTraceID + "-00f067aa0ba902bz-01", false, false},
+		{"non-hex flags", "00-" + testTraceID + "-" + testSpanID + "-0g", false, false},
+		{"three-digit version", "000-" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"missing flags", "00-" + testTraceID + "-" + testSpanID, false, false},
+		{"empty", "", false, false},
+	}
+	for _, tc := range cases {
+		sc, ok := ParseTraceparent(tc.header)
+		if ok != tc.valid {
+			t.Errorf("%s: ParseTraceparent(%q) valid = %v, want %v", tc.name, tc.header, ok, tc.valid)
+			continue
+		}
+		if !ok {
+			if sc != (SpanContext{}) {
+				t.Errorf("%s: invalid header returned %+v, want the zero value", tc.name, sc)
+			}
+			continue
+		}
+		if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID || sc.Sampled != tc.sampled {
+			t.Errorf("%s: ParseTraceparent = %s/%s sampled=%v", tc.name, sc.TraceID, sc.SpanID, sc.Sampled)
+		}
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: sampled}
+		header := sc.Traceparent()
+		parsed, ok := ParseTraceparent(header)
+		if !ok || parsed != sc {
+			t.Errorf("ParseTraceparent(%q) = %+v, %v, want %+v", header, parsed, ok, sc)
+		}
+	}
+
+	// 其他版本的请求头按版本 00 转发
+	sc, _ := ParseTraceparent("01-" + testTraceID + "-" + testSpanID + "-01-extra")
+	if got, want := sc.Traceparent(), "00-"+testTraceID+"-"+testSpanID+"-01"; got != want {
+		t.Errorf("Traceparent() = %q, want %q", got, want)
+	}
+}
+
+// traceIDWithRatio 构造低 8 字节为指定值的 trace ID
+func traceIDWithRatio(low uint64) TraceID {
+	id := TraceID{0: 1}
+	for i := 0; i < 8; i++ {
+		id[15-i] = byte(low >> (8 * i))
+	}
+	return id
+}
+
+func TestSampleRatio(t *testing.T) {
+	low, mid, high := traceIDWithRatio(0), traceIDWithRatio(1<<63), traceIDWithRatio(^uint64(0))
+	cases := []struct {
+		ratio float64
+		want  [3]bool // low、mid、high 是否采样
+	}{
+		{0, [3]bool{false, false, false}},
+		{0.25, [3]bool{true, false, false}},
+		{0.75, [3]bool{true, true, false}},
+		{1, [3]bool{true, true, true}},
+	}
+	for _, tc := range cases {
+		p := &provider{cfg: config.TracingConfig{SampleRatio: tc.ratio}}
+		got := [3]bool{p.sample(low), p.sample(mid), p.sample(high)}
+		if got != tc.want {
+			t.Errorf("ratio %v: sampled = %v, want %v", tc.ratio, got, tc.want)
+		}
+	}
+
+	// 随机 trace ID 的采样比例接近配置值
+	p := &provider{cfg: config.TracingConfig{SampleRatio: 0.3}}
+	sampled := 0
+	for i := 0; i < 20000; i++ {
+		if p.sample(newTraceID()) {
+			sampled++
+		}
+	}
+	if ratio := float64(sampled) / 20000; ratio < 0.27 || ratio > 0.33 {
+		t.Errorf("sampled ratio = %.3f, want about 0.3", ratio)
+	}
+}
+
+func TestStartInheritsRemoteSampling(t *testing.T) {
+	p := &provider{cfg: config.TracingConfig{SampleRatio: 0}, queue: make(chan *Span, 4)}
+	current.Store(p)
+	defer current.Store(nil)
+
+	// 没有父 span 时按比例采样
+	_, root := Start(context.Background(), "root", KindServer)
+	if root.Context().Sampled {
+		t.Error("root span sampled with ratio 0")
+	}
+
+	// 远端父 span 的采样标记优先于比例
+	remote, _ := ParseTraceparent("00-" + testTraceID + "-" + testSpanID + "-01")
+	ctx, span := Start(ContextWithRemote(context.Background(), remote), "server", KindServer)
+	if sc := span.Context(); sc.TraceID != remote.TraceID || !sc.Sampled || sc.SpanID == remote.SpanID {
+		t.Errorf("span context = %+v, want the remote trace, sampled, with a new span id", sc)
+	}
+	if span.parent != remote.SpanID {
+		t.Errorf("parent = %s, want the remote span %s", span.parent, remote.SpanID)
+	}
+
+	_, child := Start(ctx, "child", KindClient)
+	if child.parent != span.Context().SpanID || child.Context().TraceID != remote.TraceID {
+		t.Error("child span is not linked to its parent")
+	}
+
+	child.End()
+	child.End()
+	root.End()
+	if len(p.queue) != 1 {
+		t.Errorf("queued spans = %d, want only the sampled child once", len(p.queue))
+	}
+}
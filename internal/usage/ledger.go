@@ -0,0 +1,431 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"amazonq-proxy/internal/config"
+)
+
+const (
+	// dateLayout 用量文件名和查询参数使用的日期格式
+	dateLayout = "2006-01-02"
+	// fileExt 用量文件扩展名（每行一个 Row 的 JSON）
+	fileExt = ".jsonl"
+	// minFlushInterval 写入用量文件的最小间隔
+	minFlushInterval = time.Second
+)
+
+// errCorrupt 用量文件内容无法解析
+var errCorrupt = errors.New("usage file is corrupt")
+
+// Counts 用量计数
+type Counts struct {
+	Requests                 int64 `json:"requests"`                    // 请求数
+	InputTokens              int64 `json:"input_tokens"`                // 输入 token 数（不含缓存部分）
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"` // 写入提示词缓存的 token 数
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`     // 命中提示词缓存的 token 数
+	OutputTokens             int64 `json:"output_tokens"`               // 输出 token 数
+	Images                   int64 `json:"images"`                      // 发送给上游的图片数
+	ToolCalls                int64 `json:"tool_calls"`                  // 模型发起的工具调用数
+}
+
+// Add 累加另一组计数
+func (c *Counts) Add(other Counts) {
+	c.Requests += other.Requests
+	c.InputTokens += other.InputTokens
+	c.CacheCreationInputTokens += other.CacheCreationInputTokens
+	c.CacheReadInputTokens += other.CacheReadInputTokens
+	c.OutputTokens += other.OutputTokens
+	c.Images += other.Images
+	c.ToolCalls += other.ToolCalls
+}
+
+// Tokens 返回计入配额的 token 总数（输入、缓存读写和输出）
+func (c Counts) Tokens() int64 {
+	return c.InputTokens + c.CacheCreationInputTokens + c.CacheReadInputTokens + c.OutputTokens
+}
+
+// Dimensions 用量的统计维度
+type Dimensions struct {
+	Key     string `json:"key,omitempty"`     // 代理 API key 名称
+	Account string `json:"account,omitempty"` // 上游 Amazon Q 凭据（SHA256 哈希前缀）
+	Model   string `json:"model,omitempty"`   // 模型
+}
+
+// Row 一天内一组维度的用量
+type Row struct {
+	Date string `json:"date,omitempty"` // 日期（YYYY-MM-DD，按 usage.timezone）
+	Dimensions
+	Counts
+}
+
+// Ledger 用量账本：内存中按天汇总，定期写入用量目录，每天一个文件
+type Ledger struct {
+	mutex    sync.Mutex
+	dir      string
+	location *time.Location
+	days     map[string]map[Dimensions]*Counts // 已加载的日期 -> 各维度的用量
+	pending  map[string]map[Dimensions]*Counts // 文件读取失败的日期 -> 暂存的用量，读取成功后合并
+	dirty    map[string]bool                   // 有未写入变更的日期
+	closed   bool
+}
+
+// current 当前生效的账本（未启用时为 nil）
+var current atomic.Pointer[Ledger]
+
+// Build 根据用量配置创建账本，不替换当前账本
+// 参数 cfg 为用量配置
+// 返回账本（未启用时为 nil）和可能的错误
+func Build(cfg config.UsageConfig) (*Ledger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	location := time.Local
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid usage timezone %q: %w", cfg.Timezone, err)
+		}
+		location = loc
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create usage dir %s: %w", cfg.Dir, err)
+	}
+
+	return &Ledger{
+		dir:      cfg.Dir,
+		location: location,
+		days:     make(map[string]map[Dimensions]*Counts),
+		pending:  make(map[string]map[Dimensions]*Counts),
+		dirty:    make(map[string]bool),
+	}, nil
+}
+
+// Init 根据配置创建账本并替换当前账本
+// 返回可能的初始化错误
+func Init() error {
+	ledger, err := Build(config.Current().Usage)
+	if err != nil {
+		return err
+	}
+	Set(ledger)
+	return nil
+}
+
+// Set 替换当前账本，原账本写入未保存的用量后关闭
+// 参数 ledger 为新的账本，nil 表示停用
+func Set(ledger *Ledger) {
+	if prev := current.Swap(ledger); prev != nil && prev != ledger {
+		prev.close()
+	}
+}
+
+// Current 返回当前账本，未启用时返回 nil
+func Current() *Ledger {
+	return current.Load()
+}
+
+// Start 启动后台定期写入用量文件
+// 返回停止函数：停止写入，保存剩余的用量并停用账本
+func Start() (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			interval := config.Current().Usage.FlushInterval.Std()
+			if interval < minFlushInterval {
+				interval = minFlushInterval
+			}
+			select {
+			case <-time.After(interval):
+				if ledger := Current(); ledger != nil {
+					ledger.Flush()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+			Set(nil)
+		})
+	}
+}
+
+// Record 将一次请求的用量记入当前账本（未启用时忽略）
+// 参数 dims 为统计维度
+// 参数 counts 为本次请求的用量
+func Record(dims Dimensions, counts Counts) {
+	if ledger := Current(); ledger != nil {
+		ledger.Record(dims, counts, time.Now())
+	}
+}
+
+// Record 记录用量
+// 账本已被替换时转交给当前账本，避免重载配置期间丢失用量；
+// 当天的用量文件暂时无法读取时先暂存在内存中，读取成功后合并，不覆盖文件中已有的用量
+// 参数 dims 为统计维度
+// 参数 counts 为用量
+// 参数 now 为记录时间，决定计入的日期
+func (l *Ledger) Record(dims Dimensions, counts Counts, now time.Time) {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		if next := Current(); next != nil && next != l {
+			next.Record(dims, counts, now)
+		}
+		return
+	}
+	defer l.mutex.Unlock()
+
+	date := l.Date(now)
+	rows, err := l.load(date)
+	if err != nil {
+		slog.Error("failed to read usage file, keeping usage in memory", "component", "usage", "date", date, "error", err)
+		rows = l.pending[date]
+		if rows == nil {
+			rows = make(map[Dimensions]*Counts)
+			l.pending[date] = rows
+		}
+	}
+	total, ok := rows[dims]
+	if !ok {
+		total = &Counts{}
+		rows[dims] = total
+	}
+	total.Add(counts)
+	if err == nil {
+		l.dirty[date] = true
+	}
+}
+
+// Date 返回时间在账本时区中的日期
+func (l *Ledger) Date(t time.Time) string {
+	return t.In(l.location).Format(dateLayout)
+}
+
+// Location 返回账本按天和按月统计使用的时区
+func (l *Ledger) Location() *time.Location {
+	return l.location
+}
+
+// Flush 将有变更的日期写入用量文件，并释放上个月之前的已保存日期
+// 返回最后一个写入错误
+func (l *Ledger) Flush() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.flush()
+}
+
+// flush 写入有变更的日期，并重试读取有暂存用量的日期（调用方需持有锁）
+func (l *Ledger) flush() error {
+	var lastErr error
+	for date := range l.pending {
+		if _, err := l.load(date); err != nil {
+			slog.Error("failed to read usage file", "component", "usage", "date", date, "error", err)
+			lastErr = err
+		}
+	}
+	for date := range l.dirty {
+		if err := l.write(date, l.days[date]); err != nil {
+			slog.Error("failed to write usage file", "component", "usage", "date", date, "error", err)
+			lastErr = err
+			continue
+		}
+		delete(l.dirty, date)
+	}
+
+	// 配额只需要本月的数据，保留上个月以便跨月时无需重新读取
+	now := time.Now().In(l.location)
+	oldest := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, l.location).Format(dateLayout)
+	for date := range l.days {
+		if date < oldest && !l.dirty[date] {
+			delete(l.days, date)
+		}
+	}
+	return lastErr
+}
+
+// close 写入剩余的用量并拒绝新的记录
+func (l *Ledger) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return
+	}
+	l.flush()
+	for date := range l.pending {
+		slog.Error("usage file is unreadable, discarding usage kept in memory", "component", "usage", "date", date)
+	}
+	l.closed = true
+}
+
+// load 返回日期的用量，未加载时从文件读取并缓存，同时合并该日期暂存的用量（调用方需持有锁）
+// 文件内容无法解析时移到一旁并从空用量开始；其他读取错误（如权限、I/O 错误）不缓存，返回给调用方
+// 返回用量和可能的读取错误
+func (l *Ledger) load(date string) (map[Dimensions]*Counts, error) {
+	if rows, ok := l.days[date]; ok {
+		return rows, nil
+	}
+	rows, err := l.read(date)
+	if errors.Is(err, errCorrupt) {
+		// 文件损坏时移到一旁，避免被新的用量覆盖
+		path := l.path(date)
+		backup := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+		if renameErr := os.Rename(path, backup); renameErr != nil {
+			return nil, fmt.Errorf("%w; failed to move it aside: %v", err, renameErr)
+		}
+		slog.Error("usage file is corrupt, moved aside", "component", "usage", "path", path, "backup", backup, "error", err)
+		rows, err = make(map[Dimensions]*Counts), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if pending, ok := l.pending[date]; ok {
+		for dims, counts := range pending {
+			if total, ok := rows[dims]; ok {
+				total.Add(*counts)
+			} else {
+				rows[dims] = counts
+			}
+		}
+		delete(l.pending, date)
+		l.dirty[date] = true
+	}
+	l.days[date] = rows
+	return rows, nil
+}
+
+// rows 返回日期的用量副本：已加载的日期直接复制，否则读取文件但不缓存（调用方需持有锁）
+func (l *Ledger) rows(date string) (map[Dimensions]*Counts, error) {
+	rows, ok := l.days[date]
+	if !ok {
+		return l.read(date)
+	}
+	copied := make(map[Dimensions]*Counts, len(rows))
+	for dims, counts := range rows {
+		c := *counts
+		copied[dims] = &c
+	}
+	return copied, nil
+}
+
+// path 返回日期对应的用量文件路径
+func (l *Ledger) path(date string) string {
+	return filepath.Join(l.dir, date+fileExt)
+}
+
+// read 读取日期的用量文件，文件不存在时返回空用量
+// 内容无法解析时返回包装 errCorrupt 的错误
+func (l *Ledger) read(date string) (map[Dimensions]*Counts, error) {
+	rows := make(map[Dimensions]*Counts)
+	data, err := os.ReadFile(l.path(date))
+	if os.IsNotExist(err) {
+		return rows, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var row Row
+		if err := json.Unmarshal(line, &row); err != nil {
+			return nil, fmt.Errorf("%w: %v", errCorrupt, err)
+		}
+		counts := row.Counts
+		if existing, ok := rows[row.Dimensions]; ok {
+			existing.Add(counts)
+		} else {
+			rows[row.Dimensions] = &counts
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("%w: %v", errCorrupt, err)
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+// write 将日期的用量写入文件（先写临时文件再重命名）
+func (l *Ledger) write(date string, rows map[Dimensions]*Counts) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, row := range sortRows(date, rows) {
+		row.Date = ""
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(l.dir, date+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, writeErr := tmp.Write(buf.Bytes())
+	closeErr := tmp.Close()
+	if writeErr != nil || closeErr != nil {
+		os.Remove(tmp.Name())
+		if writeErr != nil {
+			return writeErr
+		}
+		return closeErr
+	}
+	if err := os.Rename(tmp.Name(), l.path(date)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// sortRows 将一天的用量转换为按维度排序的行
+func sortRows(date string, rows map[Dimensions]*Counts) []Row {
+	sorted := make([]Row, 0, len(rows))
+	for dims, counts := range rows {
+		sorted = append(sorted, Row{Date: date, Dimensions: dims, Counts: *counts})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return lessRow(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// lessRow 按日期、key、上游凭据、模型排序
+func lessRow(a, b Row) bool {
+	if a.Date != b.Date {
+		return a.Date < b.Date
+	}
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	if a.Account != b.Account {
+		return a.Account < b.Account
+	}
+	return a.Model < b.Model
+}
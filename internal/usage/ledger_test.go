@@ -0,0 +1,194 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"amazonq-proxy/internal/config"
+)
+
+// newTestLedger 创建按 Asia/Tokyo（UTC+9）统计的账本
+func newTestLedger(t *testing.T) *Ledger {
+	t.Helper()
+	ledger, err := Build(config.UsageConfig{Enabled: true, Dir: t.TempDir(), Timezone: "Asia/Tokyo"})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	return ledger
+}
+
+// utc 返回 UTC 时间
+func utc(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestTotalsUseConfiguredTimezone(t *testing.T) {
+	l := newTestLedger(t)
+	dims := Dimensions{Key: "team", Model: "claude-sonnet-4.5"}
+
+	// 东京时间 1 月 31 日 23:30 和 2 月 1 日 00:30，按 UTC 同属 1 月 31 日
+	l.Record(dims, Counts{Requests: 1, OutputTokens: 10}, utc(2025, 1, 31, 14, 30))
+	l.Record(dims, Counts{Requests: 1, OutputTokens: 20}, utc(2025, 1, 31, 15, 30))
+
+	cases := []struct {
+		name  string
+		now   time.Time
+		day   int64
+		month int64
+	}{
+		{"last day of january", utc(2025, 1, 31, 14, 45), 1, 1},
+		{"first day of february", utc(2025, 1, 31, 16, 0), 1, 1},
+		{"later in february", utc(2025, 2, 10, 0, 0), 0, 1},
+		{"march", utc(2025, 3, 1, 0, 0), 0, 0},
+	}
+	for _, tc := range cases {
+		totals, err := l.Totals("team", tc.now)
+		if err != nil {
+			t.Fatalf("%s: Totals: %v", tc.name, err)
+		}
+		if totals.Day.Requests != tc.day || totals.Month.Requests != tc.month {
+			t.Errorf("%s: requests day=%d month=%d, want day=%d month=%d", tc.name, totals.Day.Requests, totals.Month.Requests, tc.day, tc.month)
+		}
+	}
+
+	if totals, _ := l.Totals("other", utc(2025, 1, 31, 16, 0)); totals.Month.Requests != 0 {
+		t.Errorf("usage of another key counted: %+v", totals)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	// 东京时间 2025-02-01 05:00
+	now := utc(2025, 1, 31, 20, 0)
+	tomorrow := time.Date(2025, 2, 2, 0, 0, 0, 0, tokyo)
+	nextMonth := time.Date(2025, 3, 1, 0, 0, 0, 0, tokyo)
+
+	cases := []struct {
+		name    string
+		quota   config.QuotaConfig
+		limit   string
+		resetAt time.Time
+	}{
+		{"unlimited", config.QuotaConfig{}, "", time.Time{}},
+		{"under every limit", config.QuotaConfig{DailyRequests: 3, DailyTokens: 1000, MonthlyRequests: 10, MonthlyTokens: 10000}, "", time.Time{}},
+		{"daily requests", config.QuotaConfig{DailyRequests: 2, MonthlyRequests: 2}, "daily_requests", tomorrow},
+		{"daily tokens include cache tokens", config.QuotaConfig{DailyTokens: 150}, "daily_tokens", tomorrow},
+		{"monthly requests", config.QuotaConfig{DailyRequests: 3, MonthlyRequests: 2}, "monthly_requests", nextMonth},
+		{"monthly tokens", config.QuotaConfig{MonthlyTokens: 100}, "monthly_tokens", nextMonth},
+	}
+	for _, tc := range cases {
+		l := newTestLedger(t)
+		dims := Dimensions{Key: "team"}
+		l.Record(dims, Counts{Requests: 1, InputTokens: 40, CacheReadInputTokens: 30, OutputTokens: 5}, now.Add(-time.Hour))
+		l.Record(dims, Counts{Requests: 1, CacheCreationInputTokens: 50, OutputTokens: 25}, now)
+
+		quotaErr, err := l.CheckQuota("team", tc.quota, now)
+		if err != nil {
+			t.Fatalf("%s: CheckQuota: %v", tc.name, err)
+		}
+		if tc.limit == "" {
+			if quotaErr != nil {
+				t.Errorf("%s: CheckQuota = %v, want nil", tc.name, quotaErr)
+			}
+			continue
+		}
+		if quotaErr == nil || quotaErr.Limit != tc.limit || !quotaErr.ResetAt.Equal(tc.resetAt) {
+			t.Errorf("%s: CheckQuota = %+v, want %s resetting at %s", tc.name, quotaErr, tc.limit, tc.resetAt)
+		}
+	}
+}
+
+func TestCheckQuotaResetsAtYearEnd(t *testing.T) {
+	l := newTestLedger(t)
+	tokyo := l.Location()
+	// 东京时间 2025-12-31 12:00
+	now := utc(2025, 12, 31, 3, 0)
+	l.Record(Dimensions{Key: "team"}, Counts{Requests: 1}, now)
+
+	quotaErr, err := l.CheckQuota("team", config.QuotaConfig{MonthlyRequests: 1}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 1, 1, 0, 0, 0, 0, tokyo); quotaErr == nil || !quotaErr.ResetAt.Equal(want) {
+		t.Errorf("CheckQuota = %+v, want reset at %s", quotaErr, want)
+	}
+}
+
+func TestLoadMovesCorruptFileAside(t *testing.T) {
+	l := newTestLedger(t)
+	path := l.path("2025-02-01")
+	if err := os.WriteFile(path, []byte("{not json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	now := utc(2025, 2, 1, 3, 0)
+	l.Record(Dimensions{Key: "team"}, Counts{Requests: 1}, now)
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	backups, _ := filepath.Glob(path + ".corrupt-*")
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want the corrupt file moved aside", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "{not json\n" {
+		t.Errorf("backup content = %q, want the original file", data)
+	}
+	totals, err := l.Totals("team", now)
+	if err != nil || totals.Day.Requests != 1 {
+		t.Errorf("Totals = %+v, %v, want 1 request", totals, err)
+	}
+}
+
+func TestPendingUsageMergedAfterReadError(t *testing.T) {
+	l := newTestLedger(t)
+	path := l.path("2025-02-01")
+	// 同名目录使读取失败，但不是内容损坏
+	if err := os.Mkdir(path, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	now := utc(2025, 2, 1, 3, 0)
+	dims := Dimensions{Key: "team", Model: "claude-sonnet-4.5"}
+	l.Record(dims, Counts{Requests: 1, OutputTokens: 10}, now)
+	l.Record(dims, Counts{Requests: 1, OutputTokens: 5}, now)
+	if err := l.Flush(); err == nil {
+		t.Fatal("Flush succeeded while the usage file is unreadable")
+	}
+	if _, err := l.Totals("team", now); err == nil {
+		t.Fatal("Totals succeeded while the usage file is unreadable")
+	}
+	if backups, _ := filepath.Glob(path + ".corrupt-*"); len(backups) != 0 {
+		t.Fatalf("unreadable file was moved aside as corrupt: %v", backups)
+	}
+
+	// 文件恢复后合并暂存的用量，不覆盖文件中已有的用量
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	existing := `{"key":"team","model":"claude-sonnet-4.5","requests":3,"output_tokens":100}` + "\n"
+	if err := os.WriteFile(path, []byte(existing), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Flush after recovery: %v", err)
+	}
+
+	totals, err := l.Totals("team", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.Day.Requests != 5 || totals.Day.OutputTokens != 115 {
+		t.Errorf("totals = %+v, want 5 requests and 115 output tokens", totals.Day)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"requests":5`) {
+		t.Errorf("usage file = %s, want the merged usage", data)
+	}
+}
@@ -0,0 +1,146 @@
+package usage
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxQueryDays 单次查询最多包含的天数
+const maxQueryDays = 366
+
+// GroupFields 可用于分组的字段
+var GroupFields = []string{"date", "key", "account", "model"}
+
+// ParseDate 解析查询参数中的日期（YYYY-MM-DD）
+// 参数 value 为日期字符串
+// 返回账本时区中当天零点和可能的错误
+func (l *Ledger) ParseDate(value string) (time.Time, error) {
+	return time.ParseInLocation(dateLayout, value, l.location)
+}
+
+// Query 查询日期范围内的用量
+// 参数 from 和 to 为起止日期（包含两端，按账本时区）
+// 参数 filter 为过滤条件，为空的字段不过滤
+// 参数 groupBy 为分组字段（date、key、account、model），未分组的字段在结果中为空
+// 返回按分组字段排序的用量和可能的错误
+func (l *Ledger) Query(from, to time.Time, filter Dimensions, groupBy []string) ([]Row, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("to must not be before from")
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxQueryDays {
+		return nil, fmt.Errorf("date range must not exceed %d days", maxQueryDays)
+	}
+	group := make(map[string]bool, len(groupBy))
+	for _, field := range groupBy {
+		switch field {
+		case "date", "key", "account", "model":
+			group[field] = true
+		default:
+			return nil, fmt.Errorf("unsupported group_by field %q", field)
+		}
+	}
+
+	type groupKey struct {
+		date string
+		dims Dimensions
+	}
+	totals := make(map[groupKey]*Counts)
+	last := to.Format(dateLayout)
+	for day := from; day.Format(dateLayout) <= last; day = day.AddDate(0, 0, 1) {
+		date := day.Format(dateLayout)
+		l.mutex.Lock()
+		rows, err := l.rows(date)
+		l.mutex.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read usage for %s: %w", date, err)
+		}
+
+		for dims, counts := range rows {
+			if (filter.Key != "" && dims.Key != filter.Key) ||
+				(filter.Account != "" && dims.Account != filter.Account) ||
+				(filter.Model != "" && dims.Model != filter.Model) {
+				continue
+			}
+			key := groupKey{}
+			if group["date"] {
+				key.date = date
+			}
+			if group["key"] {
+				key.dims.Key = dims.Key
+			}
+			if group["account"] {
+				key.dims.Account = dims.Account
+			}
+			if group["model"] {
+				key.dims.Model = dims.Model
+			}
+			total, ok := totals[key]
+			if !ok {
+				total = &Counts{}
+				totals[key] = total
+			}
+			total.Add(*counts)
+		}
+	}
+
+	result := make([]Row, 0, len(totals))
+	for key, counts := range totals {
+		result = append(result, Row{Date: key.date, Dimensions: key.dims, Counts: *counts})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return lessRow(result[i], result[j])
+	})
+	return result, nil
+}
+
+// WriteCSV 以 CSV 格式写出用量，第一行为表头
+// 参数 w 为输出
+// 参数 rows 为用量
+// 参数 groupBy 为分组字段，决定输出哪些维度列（按 date、key、account、model 顺序）
+// 返回可能的写入错误
+func WriteCSV(w io.Writer, rows []Row, groupBy []string) error {
+	group := make(map[string]bool, len(groupBy))
+	for _, field := range groupBy {
+		group[field] = true
+	}
+	var columns []string
+	for _, field := range GroupFields {
+		if group[field] {
+			columns = append(columns, field)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	header := append(append([]string{}, columns...),
+		"requests", "input_tokens", "cache_creation_input_tokens", "cache_read_input_tokens", "output_tokens", "images", "tool_calls")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, 0, len(header))
+		for _, column := range columns {
+			switch column {
+			case "date":
+				record = append(record, row.Date)
+			case "key":
+				record = append(record, row.Key)
+			case "account":
+				record = append(record, row.Account)
+			case "model":
+				record = append(record, row.Model)
+			}
+		}
+		for _, value := range []int64{row.Requests, row.InputTokens, row.CacheCreationInputTokens, row.CacheReadInputTokens, row.OutputTokens, row.Images, row.ToolCalls} {
+			record = append(record, strconv.FormatInt(value, 10))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,153 @@
+package usage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"amazonq-proxy/internal/config"
+)
+
+// QuotaError API key 的用量超出配额
+type QuotaError struct {
+	Limit   string    // 超出的配额（daily_requests、daily_tokens、monthly_requests 或 monthly_tokens）
+	Used    int64     // 当前周期已用量
+	Max     int       // 配额
+	ResetAt time.Time // 配额重置时间
+}
+
+// Error 实现 error 接口
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("usage quota exceeded: %s %d/%d, resets at %s", e.Limit, e.Used, e.Max, e.ResetAt.Format(time.RFC3339))
+}
+
+// Totals API key 当天和当月的用量
+type Totals struct {
+	Day   Counts `json:"day"`   // 当天
+	Month Counts `json:"month"` // 当月（包括当天）
+}
+
+// KeyName 返回 API key 在用量统计中的名称
+// 参数 key 为配置中的代理 API key，客户端直接使用 Amazon Q 凭据时为 nil
+// 参数 apiKeyHash 为客户端 API key 的 SHA256 哈希
+// 返回配置的名称，没有时返回 key- 加哈希前缀
+func KeyName(key *config.APIKeyConfig, apiKeyHash string) string {
+	if key != nil && key.Name != "" {
+		return key.Name
+	}
+	if len(apiKeyHash) > 12 {
+		apiKeyHash = apiKeyHash[:12]
+	}
+	return "key-" + apiKeyHash
+}
+
+// ConfiguredKeyName 返回配置中代理 API key 在用量统计中的名称
+// 参数 key 为配置中的代理 API key
+func ConfiguredKeyName(key config.APIKeyConfig) string {
+	hash := sha256.Sum256([]byte(key.Key))
+	return KeyName(&key, hex.EncodeToString(hash[:]))
+}
+
+// QuotaFor 返回 API key 的配额：单独配置时使用其配额，否则使用 usage.default_quota
+// 参数 key 为配置中的代理 API key，客户端直接使用 Amazon Q 凭据时为 nil
+func QuotaFor(key *config.APIKeyConfig) config.QuotaConfig {
+	if key != nil && key.Quota != nil {
+		return *key.Quota
+	}
+	return config.Current().Usage.DefaultQuota
+}
+
+// Totals 返回 key 当天和当月的用量
+// 参数 key 为 API key 名称
+// 参数 now 为当前时间
+// 返回用量和可能的用量文件读取错误
+func (l *Ledger) Totals(key string, now time.Time) (Totals, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now = now.In(l.location)
+	today := now.Format(dateLayout)
+	var totals Totals
+	for day := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, l.location); day.Format(dateLayout) <= today; day = day.AddDate(0, 0, 1) {
+		date := day.Format(dateLayout)
+		rows, err := l.load(date)
+		if err != nil {
+			return Totals{}, err
+		}
+		for dims, counts := range rows {
+			if dims.Key != key {
+				continue
+			}
+			totals.Month.Add(*counts)
+			if date == today {
+				totals.Day.Add(*counts)
+			}
+		}
+	}
+	return totals, nil
+}
+
+// MonthKeys 返回当月有用量的 API key 名称
+// 参数 now 为当前时间
+// 返回 key 名称和可能的用量文件读取错误
+func (l *Ledger) MonthKeys(now time.Time) ([]string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now = now.In(l.location)
+	today := now.Format(dateLayout)
+	seen := make(map[string]bool)
+	var keys []string
+	for day := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, l.location); day.Format(dateLayout) <= today; day = day.AddDate(0, 0, 1) {
+		rows, err := l.load(day.Format(dateLayout))
+		if err != nil {
+			return nil, err
+		}
+		for dims := range rows {
+			if !seen[dims.Key] {
+				seen[dims.Key] = true
+				keys = append(keys, dims.Key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// CheckQuota 检查 key 的用量是否已达到配额
+// 参数 key 为 API key 名称
+// 参数 quota 为配额
+// 参数 now 为当前时间
+// 返回达到的第一个配额（依次检查当天请求数、当天 token 数、当月请求数、当月 token 数，未达到时为 nil）
+// 和可能的用量文件读取错误
+func (l *Ledger) CheckQuota(key string, quota config.QuotaConfig, now time.Time) (*QuotaError, error) {
+	if !quota.Limited() {
+		return nil, nil
+	}
+
+	totals, err := l.Totals(key, now)
+	if err != nil {
+		return nil, err
+	}
+	local := now.In(l.location)
+	tomorrow := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, l.location)
+	nextMonth := time.Date(local.Year(), local.Month()+1, 1, 0, 0, 0, 0, l.location)
+
+	limits := []struct {
+		name    string
+		used    int64
+		max     int
+		resetAt time.Time
+	}{
+		{"daily_requests", totals.Day.Requests, quota.DailyRequests, tomorrow},
+		{"daily_tokens", totals.Day.Tokens(), quota.DailyTokens, tomorrow},
+		{"monthly_requests", totals.Month.Requests, quota.MonthlyRequests, nextMonth},
+		{"monthly_tokens", totals.Month.Tokens(), quota.MonthlyTokens, nextMonth},
+	}
+	for _, limit := range limits {
+		if limit.max > 0 && limit.used >= int64(limit.max) {
+			return &QuotaError{Limit: limit.name, Used: limit.used, Max: limit.max, ResetAt: limit.resetAt}, nil
+		}
+	}
+	return nil, nil
+}
@@ -3,39 +3,56 @@ package utils
 import (
 	"net/http"
 	"net/url"
-	"os"
 	"time"
+
+	"amazonq-proxy/internal/config"
+
+	"golang.org/x/net/http/httpproxy"
 )
 
-// GetProxy 从环境变量获取代理配置
-// 优先使用 HTTP_PROXY，其次使用 HTTPS_PROXY
+// GetProxy 获取代理配置（upstream.proxy，环境变量 HTTP_PROXY 优先于 HTTPS_PROXY）
 // 返回代理 URL 字符串，如果未配置则返回空字符串
 func GetProxy() string {
-	proxy := os.Getenv("HTTP_PROXY")
+	return config.Current().Upstream.Proxy
+}
+
+// GetNoProxy 获取不使用代理的主机列表（upstream.no_proxy，环境变量 NO_PROXY 优先于 no_proxy）
+// 返回逗号分隔的主机、域名后缀或 CIDR，未配置时返回空字符串
+func GetNoProxy() string {
+	return config.Current().Upstream.NoProxy
+}
+
+// ProxyFunc 创建按代理配置为请求选择代理的函数
+// 代理地址支持 http、https 和 socks5 协议，NO_PROXY 中的主机和本地回环地址直连
+// 返回可用于 http.Transport.Proxy 的函数，未配置代理时返回 nil
+func ProxyFunc() func(*http.Request) (*url.URL, error) {
+	proxy := GetProxy()
 	if proxy == "" {
-		proxy = os.Getenv("HTTPS_PROXY")
+		return nil
+	}
+
+	proxyConfig := &httpproxy.Config{
+		HTTPProxy:  proxy,
+		HTTPSProxy: proxy,
+		NoProxy:    GetNoProxy(),
+	}
+	proxyForURL := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyForURL(req.URL)
 	}
-	return proxy
 }
 
 // CreateProxyTransport 创建配置了代理的 HTTP Transport
 // 如果环境变量中配置了代理则使用代理，否则返回默认配置的 Transport
 // 返回配置完成的 HTTP Transport 实例
 func CreateProxyTransport() *http.Transport {
-	proxy := GetProxy()
 	transport := &http.Transport{
+		Proxy:               ProxyFunc(),
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 10,
 		IdleConnTimeout:     90 * time.Second,
 		DisableKeepAlives:   false,
 	}
 
-	if proxy != "" {
-		proxyURL, err := url.Parse(proxy)
-		if err == nil {
-			transport.Proxy = http.ProxyURL(proxyURL)
-		}
-	}
-
 	return transport
 }
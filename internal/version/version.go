@@ -0,0 +1,55 @@
+package version
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+// versionFile 记录项目名称和版本号的文件（project_name=... / version=...）
+const versionFile = ".version"
+
+// Version 构建时通过 -ldflags "-X amazonq-proxy/internal/version.Version=<版本号>" 注入（取自 .version）
+var Version string
+
+var (
+	// resolved 最终使用的版本号
+	resolved string
+	// resolveOnce 只解析一次版本号
+	resolveOnce sync.Once
+)
+
+// Get 返回程序版本号
+// 优先使用构建时注入的版本号，否则读取工作目录下的 .version（go run 时），都没有时返回 dev
+func Get() string {
+	resolveOnce.Do(func() {
+		resolved = Version
+		if resolved == "" {
+			resolved = readVersionFile(versionFile)
+		}
+		if resolved == "" {
+			resolved = "dev"
+		}
+	})
+	return resolved
+}
+
+// readVersionFile 读取 .version 文件中的 version 字段
+// 参数 path 为文件路径
+// 返回版本号，文件不存在或没有 version 字段时返回空字符串
+func readVersionFile(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "version="); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}